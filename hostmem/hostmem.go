@@ -0,0 +1,189 @@
+// Package hostmem wraps the mmap/madvise tuning knobs guest memory
+// backing can use: huge pages, prefaulting, and same-page merging. It
+// has no knowledge of guest physical addresses or KVM memory slots —
+// vmm.MemoryLayout uses it purely as a source of backing []byte.
+package hostmem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Options configures how Allocate backs a mapping.
+type Options struct {
+	// Hugepages requests the mapping be backed by 2MB huge pages
+	// (MAP_HUGETLB), reducing TLB pressure for large guests. If the host
+	// has no huge pages reserved (or hugetlbfs isn't configured at all),
+	// Allocate falls back to a normal anonymous mapping instead of
+	// failing outright, and reports the fallback in its warnings.
+	Hugepages bool
+
+	// Prealloc asks the kernel to fault in every page at mmap time
+	// (MAP_POPULATE), so guest memory accesses never take a first-touch
+	// page fault at runtime.
+	Prealloc bool
+
+	// MergeablePages marks the mapping MADV_MERGEABLE, letting the
+	// kernel's same-page merging (KSM) deduplicate identical pages
+	// across VMs. A madvise failure here is reported as a warning, not
+	// an error: it costs memory density, not correctness.
+	MergeablePages bool
+}
+
+// mmapFunc and madviseFunc are indirected through package vars, rather
+// than called directly, so tests can inject a failing huge-page mmap (or
+// a failing madvise) without needing real hugetlbfs pages reserved on
+// the test host. Production code never reassigns them.
+var (
+	mmapFunc    = syscall.Mmap
+	munmapFunc  = syscall.Munmap
+	madviseFunc = syscall.Madvise
+)
+
+// Region is one host-memory mapping obtained from Allocate. Callers must
+// call Close when done with it to munmap the backing.
+type Region struct {
+	data      []byte
+	hugepages bool
+}
+
+// Bytes returns the mapped memory.
+func (r *Region) Bytes() []byte {
+	return r.data
+}
+
+// Hugepages reports whether this Region is actually backed by huge
+// pages. It can be false even with Options.Hugepages set, if Allocate
+// had to fall back — see its returned warnings for why.
+func (r *Region) Hugepages() bool {
+	return r.hugepages
+}
+
+// Close munmaps the Region's backing. Safe to call once; a second call
+// returns an error rather than double-munmapping.
+func (r *Region) Close() error {
+	if r.data == nil {
+		return fmt.Errorf("hostmem: Region already closed")
+	}
+	err := munmapFunc(r.data)
+	r.data = nil
+	return err
+}
+
+// Allocate maps size bytes of anonymous memory according to opts. The
+// returned error is non-nil only if no usable mapping could be made at
+// all; every other problem (huge pages unavailable, madvise refused) is
+// downgraded to a message in warnings, on the theory that a slower or
+// non-hugepage-backed guest is better than no guest.
+func Allocate(size int, opts Options) (region *Region, warnings []string, err error) {
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("hostmem: size must be positive, got %d", size)
+	}
+
+	const baseFlags = syscall.MAP_PRIVATE | syscall.MAP_ANON
+
+	if opts.Hugepages {
+		flags := baseFlags | syscall.MAP_HUGETLB
+		if opts.Prealloc {
+			flags |= syscall.MAP_POPULATE
+		}
+		data, mmapErr := mmapFunc(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+		if mmapErr == nil {
+			region = &Region{data: data, hugepages: true}
+		} else {
+			warnings = append(warnings, fmt.Sprintf("hostmem: MAP_HUGETLB mmap of %d bytes failed (%v), falling back to normal pages", size, mmapErr))
+		}
+	}
+
+	if region == nil {
+		flags := baseFlags
+		if opts.Prealloc {
+			flags |= syscall.MAP_POPULATE
+		}
+		data, mmapErr := mmapFunc(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+		if mmapErr != nil {
+			return nil, warnings, fmt.Errorf("hostmem: mmap %d bytes: %w", size, mmapErr)
+		}
+		region = &Region{data: data}
+	}
+
+	if opts.MergeablePages {
+		if err := madviseFunc(region.data, syscall.MADV_MERGEABLE); err != nil {
+			warnings = append(warnings, fmt.Sprintf("hostmem: madvise(MADV_MERGEABLE) on %d bytes failed: %v", size, err))
+		}
+	}
+
+	return region, warnings, nil
+}
+
+// DontNeed hints the kernel to immediately free the pages backing b via
+// MADV_DONTNEED, without unmapping the region: b remains a valid mapping
+// of the same length, but reading from it afterwards faults in fresh
+// zero pages, exactly as if it had just been mmap'd. It's a no-op for an
+// empty b.
+func DontNeed(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := madviseFunc(b, syscall.MADV_DONTNEED); err != nil {
+		return fmt.Errorf("hostmem: madvise(MADV_DONTNEED) on %d bytes: %w", len(b), err)
+	}
+	return nil
+}
+
+// WillNeed hints the kernel to fault the pages backing b in now via
+// MADV_WILLNEED, rather than lazily on first access — the inverse of
+// DontNeed, used to prefault a range back in before resuming
+// latency-sensitive work. It's a no-op for an empty b.
+func WillNeed(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := madviseFunc(b, syscall.MADV_WILLNEED); err != nil {
+		return fmt.Errorf("hostmem: madvise(MADV_WILLNEED) on %d bytes: %w", len(b), err)
+	}
+	return nil
+}
+
+// Capabilities reports what this host currently supports for Allocate's
+// options.
+type Capabilities struct {
+	// HugePagesFree is the number of free 2MB huge pages the kernel
+	// currently has reserved (/proc/meminfo's HugePages_Free), or 0 if
+	// hugetlbfs isn't configured on this host at all.
+	HugePagesFree int
+}
+
+// ProbeCapabilities reads /proc/meminfo so a caller can decide whether
+// Options.Hugepages is worth trying, or warn an operator up front rather
+// than only after Allocate's fallback kicks in.
+func ProbeCapabilities() (Capabilities, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("hostmem: reading /proc/meminfo: %w", err)
+	}
+	defer f.Close()
+
+	var caps Capabilities
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "HugePages_Free:" {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return Capabilities{}, fmt.Errorf("hostmem: parsing HugePages_Free: %w", err)
+		}
+		caps.HugePagesFree = n
+		break
+	}
+	if err := scanner.Err(); err != nil {
+		return Capabilities{}, fmt.Errorf("hostmem: reading /proc/meminfo: %w", err)
+	}
+	return caps, nil
+}
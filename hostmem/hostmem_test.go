@@ -0,0 +1,174 @@
+package hostmem
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// withFakeMmap replaces mmapFunc/munmapFunc/madviseFunc for the duration
+// of a test, so option plumbing and fallback logic can be exercised
+// without needing real hugetlbfs pages reserved on the test host.
+func withFakeMmap(t *testing.T, mmap func(fd int, offset int64, length int, prot int, flags int) ([]byte, error)) {
+	t.Helper()
+	origMmap, origMunmap := mmapFunc, munmapFunc
+	mmapFunc = mmap
+	munmapFunc = func(b []byte) error { return nil }
+	t.Cleanup(func() {
+		mmapFunc = origMmap
+		munmapFunc = origMunmap
+	})
+}
+
+func TestAllocatePlainMapping(t *testing.T) {
+	var gotFlags int
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		gotFlags = flags
+		return make([]byte, length), nil
+	})
+
+	region, warnings, err := Allocate(4096, Options{})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none", warnings)
+	}
+	if len(region.Bytes()) != 4096 {
+		t.Errorf("Bytes() len = %d, want 4096", len(region.Bytes()))
+	}
+	if region.Hugepages() {
+		t.Error("Hugepages() = true, want false")
+	}
+	if gotFlags&syscall.MAP_HUGETLB != 0 {
+		t.Errorf("flags = %#x, should not include MAP_HUGETLB", gotFlags)
+	}
+	if gotFlags&syscall.MAP_POPULATE != 0 {
+		t.Errorf("flags = %#x, should not include MAP_POPULATE without Prealloc", gotFlags)
+	}
+}
+
+func TestAllocatePreallocSetsPopulateFlag(t *testing.T) {
+	var gotFlags int
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		gotFlags = flags
+		return make([]byte, length), nil
+	})
+
+	if _, _, err := Allocate(4096, Options{Prealloc: true}); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if gotFlags&syscall.MAP_POPULATE == 0 {
+		t.Errorf("flags = %#x, want MAP_POPULATE set", gotFlags)
+	}
+}
+
+func TestAllocateHugepagesSuccess(t *testing.T) {
+	var gotFlags int
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		gotFlags = flags
+		return make([]byte, length), nil
+	})
+
+	region, warnings, err := Allocate(2<<20, Options{Hugepages: true})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none", warnings)
+	}
+	if !region.Hugepages() {
+		t.Error("Hugepages() = false, want true")
+	}
+	if gotFlags&syscall.MAP_HUGETLB == 0 {
+		t.Errorf("flags = %#x, want MAP_HUGETLB set", gotFlags)
+	}
+}
+
+func TestAllocateHugepagesFallsBackOnFailure(t *testing.T) {
+	calls := 0
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		calls++
+		if flags&syscall.MAP_HUGETLB != 0 {
+			return nil, syscall.ENOMEM
+		}
+		return make([]byte, length), nil
+	})
+
+	region, warnings, err := Allocate(2<<20, Options{Hugepages: true})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("mmap called %d times, want 2 (hugetlb attempt, then fallback)", calls)
+	}
+	if region.Hugepages() {
+		t.Error("Hugepages() = true, want false after a hugetlb failure")
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "MAP_HUGETLB") {
+		t.Errorf("warnings = %v, want one mentioning MAP_HUGETLB", warnings)
+	}
+}
+
+func TestAllocateFallbackMmapFailureIsAnError(t *testing.T) {
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		return nil, syscall.ENOMEM
+	})
+
+	if _, _, err := Allocate(4096, Options{}); err == nil {
+		t.Error("Allocate with a failing mmap: got nil error, want one")
+	}
+}
+
+func TestAllocateMergeablePagesMadviseFailureIsWarningNotError(t *testing.T) {
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		return make([]byte, length), nil
+	})
+	orig := madviseFunc
+	madviseFunc = func(b []byte, advice int) error { return syscall.EINVAL }
+	t.Cleanup(func() { madviseFunc = orig })
+
+	region, warnings, err := Allocate(4096, Options{MergeablePages: true})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if region == nil {
+		t.Fatal("region is nil despite madvise-only failure")
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "madvise") {
+		t.Errorf("warnings = %v, want one mentioning madvise", warnings)
+	}
+}
+
+func TestAllocateRejectsNonPositiveSize(t *testing.T) {
+	if _, _, err := Allocate(0, Options{}); err == nil {
+		t.Error("Allocate(0, ...): got nil error, want one")
+	}
+}
+
+func TestRegionCloseIsNotReentrant(t *testing.T) {
+	withFakeMmap(t, func(fd int, offset int64, length int, prot int, flags int) ([]byte, error) {
+		return make([]byte, length), nil
+	})
+
+	region, _, err := Allocate(4096, Options{})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if err := region.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := region.Close(); err == nil {
+		t.Error("second Close(): got nil error, want one")
+	}
+}
+
+func TestProbeCapabilitiesReadsMemInfo(t *testing.T) {
+	caps, err := ProbeCapabilities()
+	if err != nil {
+		t.Fatalf("ProbeCapabilities: %v (is /proc/meminfo available on this host?)", err)
+	}
+	if caps.HugePagesFree < 0 {
+		t.Errorf("HugePagesFree = %d, want >= 0", caps.HugePagesFree)
+	}
+}
@@ -0,0 +1,120 @@
+package hypervisor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Breakpoints manages up to maxHardwareBreakpoints hardware breakpoints
+// on one VCPU, reprogramming KVM_SET_GUEST_DEBUG every time a breakpoint
+// is set or cleared. It exists alongside the stateless SetGuestDebug
+// because a caller setting and clearing breakpoints one at a time needs
+// something to remember which DR0-3 slot each address landed in — KVM
+// itself only ever sees the full set installed by the most recent ioctl.
+//
+// Its own KVM_SET_GUEST_DEBUG calls don't request single-stepping, and
+// don't know about any StepInstruction call interleaved with them; the
+// two features share one underlying ioctl, so a caller using both on the
+// same VCPU is responsible for not letting one clobber the other's
+// state (e.g. by calling Breakpoints.reprogram — indirectly, via
+// SetBreakpoint/ClearBreakpoint — again right after StepInstruction).
+type Breakpoints struct {
+	vcpuFile *os.File
+
+	addrs [maxHardwareBreakpoints]uint64
+	used  [maxHardwareBreakpoints]bool
+
+	// OnHit, if set, is called by HandleDebugExit with the ids of every
+	// breakpoint that triggered the KVM_EXIT_DEBUG it was passed.
+	OnHit func(ids []int)
+}
+
+// NewBreakpoints returns a Breakpoints manager for vcpuFile with no
+// breakpoints installed.
+func NewBreakpoints(vcpuFile *os.File) *Breakpoints {
+	return &Breakpoints{vcpuFile: vcpuFile}
+}
+
+// SetBreakpoint installs a hardware breakpoint at addr and returns an id
+// ClearBreakpoint can later use to remove it. It fails once all
+// maxHardwareBreakpoints slots are in use.
+func (b *Breakpoints) SetBreakpoint(addr uint64) (id int, err error) {
+	for i, used := range b.used {
+		if used {
+			continue
+		}
+		b.addrs[i] = addr
+		b.used[i] = true
+		if err := b.reprogram(); err != nil {
+			b.used[i] = false
+			return 0, err
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("hypervisor: all %d hardware breakpoint slots are in use", maxHardwareBreakpoints)
+}
+
+// ClearBreakpoint removes the breakpoint installed by an earlier
+// SetBreakpoint call. Clearing an id that isn't currently set is a no-op.
+func (b *Breakpoints) ClearBreakpoint(id int) error {
+	if id < 0 || id >= maxHardwareBreakpoints {
+		return fmt.Errorf("hypervisor: breakpoint id %d out of range [0,%d)", id, maxHardwareBreakpoints)
+	}
+	if !b.used[id] {
+		return nil
+	}
+	b.used[id] = false
+	b.addrs[id] = 0
+	return b.reprogram()
+}
+
+// reprogram issues KVM_SET_GUEST_DEBUG with every currently-set
+// breakpoint address in its own DR slot (rather than compacting them,
+// the way SetGuestDebug's slice-based API does), so a breakpoint's id
+// always matches the DR0-3 register — and DR6 bit — it occupies.
+func (b *Breakpoints) reprogram() error {
+	debug := KvmGuestDebug{Control: KvmGuestDebugEnable}
+	anyUsed := false
+	for i, used := range b.used {
+		if !used {
+			continue
+		}
+		debug.Arch.DebugReg[i] = b.addrs[i]
+		debug.Arch.DebugReg[7] |= 1 << uint(i*2+1) // Gi: globally enable slot i
+		anyUsed = true
+	}
+	if anyUsed {
+		debug.Control |= KvmGuestDebugUseHWBP
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, b.vcpuFile.Fd(), KVM_SET_GUEST_DEBUG, uintptr(unsafe.Pointer(&debug)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_SET_GUEST_DEBUG", Errno: errno}
+	}
+	return nil
+}
+
+// HandleDebugExit decodes a KVM_EXIT_DEBUG event from run and reports
+// which of this Breakpoints' installed ids caused it (DR6's low 4 bits
+// name DR0-3 by position, which is exactly how SetBreakpoint/reprogram
+// place addresses). It calls OnHit, if set, with the same ids before
+// returning them.
+func (b *Breakpoints) HandleDebugExit(run []byte) ([]int, error) {
+	exit, err := DecodeDebugExit(run)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for id := 0; id < maxHardwareBreakpoints; id++ {
+		if b.used[id] && exit.DR6&(1<<uint(id)) != 0 {
+			ids = append(ids, id)
+		}
+	}
+	if b.OnHit != nil {
+		b.OnHit(ids)
+	}
+	return ids, nil
+}
@@ -0,0 +1,200 @@
+package hypervisor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// KvmCpuidEntry2 mirrors struct kvm_cpuid_entry2: one CPUID leaf's input
+// (function, and index for leaves where it's significant) and the four
+// output registers KVM should hand the guest for it.
+type KvmCpuidEntry2 struct {
+	Function uint32
+	Index    uint32
+	Flags    uint32
+	EAX      uint32
+	EBX      uint32
+	ECX      uint32
+	EDX      uint32
+	_        [3]uint32 // padding, matches struct kvm_cpuid_entry2
+}
+
+// kvmCpuidEntry2Size is KvmCpuidEntry2's on-the-wire size: 10 uint32
+// fields, matching the kernel struct it mirrors field-for-field.
+const kvmCpuidEntry2Size = 10 * 4
+
+// KvmCpuidFlagSignificantIndex, set in KvmCpuidEntry2.Flags, tells KVM
+// this leaf's Index field distinguishes ECX subleaves (as with function 4
+// or 7), rather than being ignored.
+const KvmCpuidFlagSignificantIndex uint32 = 1 << 0
+
+// SetCPUID2 issues KVM_SET_CPUID2 on vcpuFile (a fd returned by
+// CreateVCPU), replacing its CPUID table with entries. It must be called
+// before the VCPU is first run, since KVM only consults this table when
+// emulating a guest CPUID instruction.
+//
+// struct kvm_cpuid2 is variable-length (a fixed nent/padding header
+// followed by nent kvm_cpuid_entry2 records), so the request is built
+// into a raw byte buffer sized for this call rather than as a fixed Go
+// struct.
+func SetCPUID2(vcpuFile *os.File, entries []KvmCpuidEntry2) error {
+	buf := buildCpuidEntriesBuffer(entries)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_SET_CPUID2, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_SET_CPUID2", Errno: errno}
+	}
+	return nil
+}
+
+// buildCpuidEntriesBuffer lays out a struct kvm_cpuid2 (the shared
+// count/padding header, then one kvm_cpuid_entry2 per entry) for
+// SetCPUID2 to issue an ioctl against.
+func buildCpuidEntriesBuffer(entries []KvmCpuidEntry2) []byte {
+	buf := make([]byte, int(kvmCountHeaderSize)+len(entries)*kvmCpuidEntry2Size)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(entries)))
+
+	for i, e := range entries {
+		off := int(kvmCountHeaderSize) + i*kvmCpuidEntry2Size
+		binary.LittleEndian.PutUint32(buf[off+0:], e.Function)
+		binary.LittleEndian.PutUint32(buf[off+4:], e.Index)
+		binary.LittleEndian.PutUint32(buf[off+8:], e.Flags)
+		binary.LittleEndian.PutUint32(buf[off+12:], e.EAX)
+		binary.LittleEndian.PutUint32(buf[off+16:], e.EBX)
+		binary.LittleEndian.PutUint32(buf[off+20:], e.ECX)
+		binary.LittleEndian.PutUint32(buf[off+24:], e.EDX)
+	}
+	return buf
+}
+
+// GetSupportedCPUID issues KVM_GET_SUPPORTED_CPUID on k's /dev/kvm fd,
+// returning every CPUID leaf this kernel/CPU combination can back for a
+// guest. The kernel doesn't say up front how many leaves it has, so
+// this grows the buffer and retries on E2BIG rather than guessing a
+// single fixed capacity.
+func (k *KVM) GetSupportedCPUID() ([]KvmCpuidEntry2, error) {
+	for maxEntries := 32; maxEntries <= 512; maxEntries *= 2 {
+		buf := make([]byte, int(kvmCountHeaderSize)+maxEntries*kvmCpuidEntry2Size)
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(maxEntries))
+
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, k.file.Fd(), KVM_GET_SUPPORTED_CPUID, uintptr(unsafe.Pointer(&buf[0])))
+		if errno == syscall.E2BIG {
+			continue
+		}
+		if errno != 0 {
+			return nil, &IoctlError{Name: "KVM_GET_SUPPORTED_CPUID", Errno: errno}
+		}
+		return readCpuidEntriesBuffer(buf, int(binary.LittleEndian.Uint32(buf[0:4]))), nil
+	}
+	return nil, fmt.Errorf("hypervisor: KVM_GET_SUPPORTED_CPUID: host reports more than 512 CPUID leaves")
+}
+
+// readCpuidEntriesBuffer parses n kvm_cpuid_entry2 records back out of a
+// struct kvm_cpuid2 buffer laid out by SetCPUID2/GetSupportedCPUID.
+func readCpuidEntriesBuffer(buf []byte, n int) []KvmCpuidEntry2 {
+	entries := make([]KvmCpuidEntry2, n)
+	for i := range entries {
+		off := int(kvmCountHeaderSize) + i*kvmCpuidEntry2Size
+		entries[i] = KvmCpuidEntry2{
+			Function: binary.LittleEndian.Uint32(buf[off+0:]),
+			Index:    binary.LittleEndian.Uint32(buf[off+4:]),
+			Flags:    binary.LittleEndian.Uint32(buf[off+8:]),
+			EAX:      binary.LittleEndian.Uint32(buf[off+12:]),
+			EBX:      binary.LittleEndian.Uint32(buf[off+16:]),
+			ECX:      binary.LittleEndian.Uint32(buf[off+20:]),
+			EDX:      binary.LittleEndian.Uint32(buf[off+24:]),
+		}
+	}
+	return entries
+}
+
+// CPUID feature bits this package advertises in the leaf 1 EDX register.
+// FPU and TSC are the minimum a guest OS expects to find set; every other
+// EDX/ECX bit is left clear rather than advertising hardware features
+// (APIC, SSE, virtualization extensions, ...) the emulator doesn't back.
+const (
+	cpuidLeaf1EDXFPU = 1 << 0
+	cpuidLeaf1EDXTSC = 1 << 4
+)
+
+// DefaultCPUIDEntries returns a minimal, sane CPUID table for a freshly
+// created VCPU: leaf 0 reports the highest leaf this table defines and a
+// 12-character vendor string, and leaf 1 reports a family/model/stepping
+// and only the feature bits (FPU, TSC) this emulator actually backs. A
+// guest that queries any other leaf sees KVM's all-zero default, which is
+// indistinguishable from "not supported" — exactly what an emulator with
+// no hardware acceleration to advertise should report.
+func DefaultCPUIDEntries() []KvmCpuidEntry2 {
+	// "VDATABProVMM" split into the EBX,EDX,ECX register order x86 CPUID
+	// leaf 0 requires (not alphabetical — this is the same ordering
+	// "GenuineIntel"/"AuthenticAMD" use).
+	vendor := "VDATABProVMM"
+	ebx := binary.LittleEndian.Uint32([]byte(vendor[0:4]))
+	edx := binary.LittleEndian.Uint32([]byte(vendor[4:8]))
+	ecx := binary.LittleEndian.Uint32([]byte(vendor[8:12]))
+
+	return []KvmCpuidEntry2{
+		{Function: 0, EAX: 1, EBX: ebx, ECX: ecx, EDX: edx},
+		{Function: 1, EAX: 0x000006c0, EDX: cpuidLeaf1EDXFPU | cpuidLeaf1EDXTSC}, // family 6, model 0xc, stepping 0
+	}
+}
+
+// cpuidLeaf1ECXHypervisorPresent is CPUID leaf 1 ECX bit 31. Real
+// hardware never sets it; a guest OS checks it to decide whether to
+// look for a hypervisor information leaf instead of assuming bare
+// metal.
+const cpuidLeaf1ECXHypervisorPresent = 1 << 31
+
+// cpuidHypervisorLeaf is the base of the hypervisor information leaves,
+// the convention KVM and Xen both use for a guest to identify which
+// hypervisor it's running under once it's seen
+// cpuidLeaf1ECXHypervisorPresent.
+const cpuidHypervisorLeaf = 0x40000000
+
+// cpuidHypervisorSignature is the 12-byte signature this hypervisor
+// reports at cpuidHypervisorLeaf, split EBX/ECX/EDX in the order the
+// KVM/Xen hypervisor leaf convention uses (leaf 0's vendor string uses
+// EBX/EDX/ECX instead — an unrelated, older x86 convention).
+const cpuidHypervisorSignature = "VDATABProKVM"
+
+// CPUIDEditFunc edits a VCPU's CPUID table before it's installed with
+// SetCPUID2, e.g. to mask an advertised feature bit or pin a specific
+// family/model/stepping. BuildCPUIDProfile calls it last, after
+// populating the table from the host's supported leaves, so an edit
+// always wins over the defaults it's overriding.
+type CPUIDEditFunc func(entries []KvmCpuidEntry2) []KvmCpuidEntry2
+
+// BuildCPUIDProfile assembles the CPUID table a freshly created VCPU
+// should install: every leaf hostEntries reports (typically
+// KVM.GetSupportedCPUID's result), with the hypervisor-present bit
+// forced into leaf 1's ECX and a hypervisor information leaf appended
+// at cpuidHypervisorLeaf advertising cpuidHypervisorSignature. edit, if
+// non-nil, runs last so a caller can mask a feature or pin an
+// identifying field before the table is installed with SetCPUID2.
+func BuildCPUIDProfile(hostEntries []KvmCpuidEntry2, edit CPUIDEditFunc) []KvmCpuidEntry2 {
+	entries := make([]KvmCpuidEntry2, len(hostEntries))
+	copy(entries, hostEntries)
+
+	for i := range entries {
+		if entries[i].Function == 1 {
+			entries[i].ECX |= cpuidLeaf1ECXHypervisorPresent
+		}
+	}
+
+	sig := cpuidHypervisorSignature
+	entries = append(entries, KvmCpuidEntry2{
+		Function: cpuidHypervisorLeaf,
+		EAX:      cpuidHypervisorLeaf, // highest hypervisor leaf this table defines
+		EBX:      binary.LittleEndian.Uint32([]byte(sig[0:4])),
+		ECX:      binary.LittleEndian.Uint32([]byte(sig[4:8])),
+		EDX:      binary.LittleEndian.Uint32([]byte(sig[8:12])),
+	})
+
+	if edit != nil {
+		entries = edit(entries)
+	}
+	return entries
+}
@@ -0,0 +1,195 @@
+package hypervisor
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// TestSetCPUID2InstallsVendorString exercises SetCPUID2 against a real
+// VCPU: it installs DefaultCPUIDEntries and confirms KVM accepts the
+// table. Actually executing a guest CPUID instruction and reading back
+// the vendor string it reports would need a minimal guest-code-loading
+// harness this package doesn't have yet (every other VCPU test in this
+// repo drives hypervisor.VCPU through FakeVCPU rather than running real
+// guest instructions) — that's tracked separately from this ioctl
+// binding.
+func TestSetCPUID2InstallsVendorString(t *testing.T) {
+	kvm, err := NewKVM()
+	if err != nil {
+		t.Skipf("/dev/kvm unavailable or capabilities missing: %v", err)
+	}
+	defer kvm.Close()
+
+	vmFd, err := kvm.CreateVM()
+	if err != nil {
+		t.Fatalf("CreateVM: %v", err)
+	}
+	defer vmFd.Close()
+
+	vcpuFd, err := CreateVCPU(vmFd, 0)
+	if err != nil {
+		t.Fatalf("CreateVCPU: %v", err)
+	}
+	defer vcpuFd.Close()
+
+	if err := SetCPUID2(vcpuFd, DefaultCPUIDEntries()); err != nil {
+		t.Fatalf("SetCPUID2: %v", err)
+	}
+}
+
+// TestGetSupportedCPUIDReturnsUsableProfile exercises GetSupportedCPUID
+// against a real /dev/kvm and feeds its result straight through
+// BuildCPUIDProfile and SetCPUID2, confirming the host-reported leaves
+// round-trip into a table KVM accepts.
+func TestGetSupportedCPUIDReturnsUsableProfile(t *testing.T) {
+	kvm, err := NewKVM()
+	if err != nil {
+		t.Skipf("/dev/kvm unavailable or capabilities missing: %v", err)
+	}
+	defer kvm.Close()
+
+	hostEntries, err := kvm.GetSupportedCPUID()
+	if err != nil {
+		t.Fatalf("GetSupportedCPUID: %v", err)
+	}
+	if len(hostEntries) == 0 {
+		t.Fatal("GetSupportedCPUID returned no leaves")
+	}
+
+	vmFd, err := kvm.CreateVM()
+	if err != nil {
+		t.Fatalf("CreateVM: %v", err)
+	}
+	defer vmFd.Close()
+
+	vcpuFd, err := CreateVCPU(vmFd, 0)
+	if err != nil {
+		t.Fatalf("CreateVCPU: %v", err)
+	}
+	defer vcpuFd.Close()
+
+	if err := SetCPUID2(vcpuFd, BuildCPUIDProfile(hostEntries, nil)); err != nil {
+		t.Fatalf("SetCPUID2: %v", err)
+	}
+}
+
+func TestBuildCpuidEntriesBufferLayout(t *testing.T) {
+	entries := []KvmCpuidEntry2{
+		{Function: 0, EAX: 2, EBX: 0x11111111, ECX: 0x22222222, EDX: 0x33333333},
+		{Function: 7, Index: 1, Flags: KvmCpuidFlagSignificantIndex, EAX: 0x44444444},
+	}
+	buf := buildCpuidEntriesBuffer(entries)
+
+	wantLen := int(kvmCountHeaderSize) + len(entries)*kvmCpuidEntry2Size
+	if len(buf) != wantLen {
+		t.Fatalf("buffer length = %d, want %d", len(buf), wantLen)
+	}
+	if got := binary.LittleEndian.Uint32(buf[0:4]); got != uint32(len(entries)) {
+		t.Errorf("header count = %d, want %d", got, len(entries))
+	}
+
+	for i, want := range entries {
+		off := int(kvmCountHeaderSize) + i*kvmCpuidEntry2Size
+		got := KvmCpuidEntry2{
+			Function: binary.LittleEndian.Uint32(buf[off+0:]),
+			Index:    binary.LittleEndian.Uint32(buf[off+4:]),
+			Flags:    binary.LittleEndian.Uint32(buf[off+8:]),
+			EAX:      binary.LittleEndian.Uint32(buf[off+12:]),
+			EBX:      binary.LittleEndian.Uint32(buf[off+16:]),
+			ECX:      binary.LittleEndian.Uint32(buf[off+20:]),
+			EDX:      binary.LittleEndian.Uint32(buf[off+24:]),
+		}
+		if got != (KvmCpuidEntry2{Function: want.Function, Index: want.Index, Flags: want.Flags, EAX: want.EAX, EBX: want.EBX, ECX: want.ECX, EDX: want.EDX}) {
+			t.Errorf("entry %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestBuildCPUIDProfileForcesHypervisorPresentAndSignature(t *testing.T) {
+	host := []KvmCpuidEntry2{
+		{Function: 0, EAX: 0x16},
+		{Function: 1, EAX: 0x000906ea, ECX: 0x1, EDX: cpuidLeaf1EDXFPU},
+	}
+
+	got := BuildCPUIDProfile(host, nil)
+	if len(got) != len(host)+1 {
+		t.Fatalf("len(profile) = %d, want %d (host leaves plus hypervisor leaf)", len(got), len(host)+1)
+	}
+
+	leaf1 := got[1]
+	if leaf1.ECX&cpuidLeaf1ECXHypervisorPresent == 0 {
+		t.Errorf("leaf 1 ECX = %#x, want hypervisor-present bit set", leaf1.ECX)
+	}
+	if leaf1.EAX != host[1].EAX {
+		t.Errorf("leaf 1 EAX = %#x, want unchanged host value %#x", leaf1.EAX, host[1].EAX)
+	}
+
+	hvLeaf := got[len(got)-1]
+	if hvLeaf.Function != cpuidHypervisorLeaf {
+		t.Fatalf("hypervisor leaf Function = %#x, want %#x", hvLeaf.Function, cpuidHypervisorLeaf)
+	}
+	var sig [12]byte
+	binary.LittleEndian.PutUint32(sig[0:4], hvLeaf.EBX)
+	binary.LittleEndian.PutUint32(sig[4:8], hvLeaf.ECX)
+	binary.LittleEndian.PutUint32(sig[8:12], hvLeaf.EDX)
+	if got, want := string(sig[:]), cpuidHypervisorSignature; got != want {
+		t.Errorf("hypervisor signature = %q, want %q", got, want)
+	}
+}
+
+// TestBuildCPUIDProfileEditHookRunsLast checks that a caller-supplied
+// edit overrides both the host-copied leaves and the defaults
+// BuildCPUIDProfile installs (the hypervisor-present bit and signature
+// leaf), rather than being applied before them and getting clobbered.
+func TestBuildCPUIDProfileEditHookRunsLast(t *testing.T) {
+	host := []KvmCpuidEntry2{
+		{Function: 1, EAX: 0x000906ea, ECX: 0x1, EDX: cpuidLeaf1EDXFPU | cpuidLeaf1EDXTSC},
+	}
+
+	edit := func(entries []KvmCpuidEntry2) []KvmCpuidEntry2 {
+		for i := range entries {
+			if entries[i].Function == 1 {
+				// Pin family/model/stepping and mask the TSC bit, and
+				// clear the hypervisor-present bit BuildCPUIDProfile
+				// just set, simulating a caller that wants to hide
+				// virtualization from the guest.
+				entries[i].EAX = 0x00000663
+				entries[i].EDX &^= cpuidLeaf1EDXTSC
+				entries[i].ECX &^= cpuidLeaf1ECXHypervisorPresent
+			}
+		}
+		return entries
+	}
+
+	got := BuildCPUIDProfile(host, edit)
+	leaf1 := got[0]
+	if leaf1.EAX != 0x00000663 {
+		t.Errorf("leaf 1 EAX = %#x, want edit hook's pinned value %#x", leaf1.EAX, 0x00000663)
+	}
+	if leaf1.EDX&cpuidLeaf1EDXTSC != 0 {
+		t.Errorf("leaf 1 EDX = %#x, want TSC bit masked by edit hook", leaf1.EDX)
+	}
+	if leaf1.ECX&cpuidLeaf1ECXHypervisorPresent != 0 {
+		t.Errorf("leaf 1 ECX = %#x, want hypervisor-present bit cleared by edit hook", leaf1.ECX)
+	}
+}
+
+func TestDefaultCPUIDEntriesReportsVendorString(t *testing.T) {
+	entries := DefaultCPUIDEntries()
+	if len(entries) == 0 {
+		t.Fatal("DefaultCPUIDEntries returned no entries")
+	}
+
+	leaf0 := entries[0]
+	if leaf0.Function != 0 {
+		t.Fatalf("entries[0].Function = %d, want 0", leaf0.Function)
+	}
+
+	var vendor [12]byte
+	binary.LittleEndian.PutUint32(vendor[0:4], leaf0.EBX)
+	binary.LittleEndian.PutUint32(vendor[4:8], leaf0.EDX)
+	binary.LittleEndian.PutUint32(vendor[8:12], leaf0.ECX)
+	if got, want := string(vendor[:]), "VDATABProVMM"; got != want {
+		t.Errorf("vendor string = %q, want %q", got, want)
+	}
+}
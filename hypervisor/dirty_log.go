@@ -0,0 +1,82 @@
+package hypervisor
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// KvmDirtyLog mirrors the kernel's struct kvm_dirty_log: it names a slot
+// and points at a userspace bitmap buffer (one bit per guest page,
+// LSB-first within each word) for KVM_GET_DIRTY_LOG to fill in.
+type KvmDirtyLog struct {
+	Slot        uint32
+	_           uint32 // padding
+	DirtyBitmap uint64 // userspace pointer to the bitmap buffer
+}
+
+// GetDirtyLog issues KVM_GET_DIRTY_LOG on vmFile for slot, filling bitmap
+// with the pages dirtied since the slot was installed with
+// KvmMemLogDirtyPages or last queried. Like the real ioctl, a successful
+// call also clears the log, so a later call only reports pages dirtied
+// since this one. bitmap must be sized to cover the slot (see
+// vmm.MemoryLayout, which owns that arithmetic).
+func GetDirtyLog(vmFile *os.File, slot uint32, bitmap []uint64) error {
+	if len(bitmap) == 0 {
+		return nil
+	}
+	log := KvmDirtyLog{Slot: slot, DirtyBitmap: uint64(uintptr(unsafe.Pointer(&bitmap[0])))}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vmFile.Fd(), KVM_GET_DIRTY_LOG, uintptr(unsafe.Pointer(&log)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_GET_DIRTY_LOG", Errno: errno}
+	}
+	return nil
+}
+
+// FakeDirtyLog simulates KVM's per-slot dirty-page tracking for tests
+// that don't have a real /dev/kvm file descriptor: MarkPageDirty flags a
+// guest page the way a real vCPU write to that page would, and
+// GetDirtyLog reports (and clears) the pages dirtied since the last call,
+// exactly like the real ioctl.
+type FakeDirtyLog struct {
+	mu    sync.Mutex
+	dirty map[uint32]map[uint64]bool // slot -> page index -> dirty
+}
+
+// NewFakeDirtyLog returns a FakeDirtyLog with nothing marked dirty.
+func NewFakeDirtyLog() *FakeDirtyLog {
+	return &FakeDirtyLog{dirty: make(map[uint32]map[uint64]bool)}
+}
+
+// MarkPageDirty flags page as dirty within slot.
+func (f *FakeDirtyLog) MarkPageDirty(slot uint32, page uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pages := f.dirty[slot]
+	if pages == nil {
+		pages = make(map[uint64]bool)
+		f.dirty[slot] = pages
+	}
+	pages[page] = true
+}
+
+// GetDirtyLog implements the same contract as the package-level
+// GetDirtyLog function, against the simulated state instead of a real
+// vmFile: it fills bitmap (sized in 64-bit words by the caller) with
+// slot's dirty pages and clears them.
+func (f *FakeDirtyLog) GetDirtyLog(slot uint32, bitmap []uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range bitmap {
+		bitmap[i] = 0
+	}
+	for page := range f.dirty[slot] {
+		word, bit := page/64, page%64
+		if int(word) < len(bitmap) {
+			bitmap[word] |= 1 << bit
+		}
+	}
+	delete(f.dirty, slot)
+	return nil
+}
@@ -0,0 +1,29 @@
+package hypervisor
+
+import "testing"
+
+func TestFakeDirtyLogReportsAndClearsMarkedPages(t *testing.T) {
+	f := NewFakeDirtyLog()
+	f.MarkPageDirty(0, 2)
+	f.MarkPageDirty(0, 65) // second word
+	f.MarkPageDirty(1, 0)  // a different slot, must not leak into slot 0's log
+
+	bitmap := make([]uint64, 2)
+	if err := f.GetDirtyLog(0, bitmap); err != nil {
+		t.Fatalf("GetDirtyLog: %v", err)
+	}
+	if want := uint64(1 << 2); bitmap[0] != want {
+		t.Errorf("bitmap[0] = %#x, want %#x", bitmap[0], want)
+	}
+	if want := uint64(1 << 1); bitmap[1] != want {
+		t.Errorf("bitmap[1] = %#x, want %#x", bitmap[1], want)
+	}
+
+	// A second call finds nothing: the first call cleared the log.
+	if err := f.GetDirtyLog(0, bitmap); err != nil {
+		t.Fatalf("GetDirtyLog: %v", err)
+	}
+	if bitmap[0] != 0 || bitmap[1] != 0 {
+		t.Errorf("bitmap after second GetDirtyLog = %#x %#x, want all clear", bitmap[0], bitmap[1])
+	}
+}
@@ -0,0 +1,99 @@
+package hypervisor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrKVMUnavailable indicates /dev/kvm could not be opened because KVM
+// support is missing on this host.
+var ErrKVMUnavailable = errors.New("hypervisor: /dev/kvm unavailable")
+
+// ErrPermissionDenied indicates /dev/kvm exists but this process lacks
+// permission to open it.
+var ErrPermissionDenied = errors.New("hypervisor: permission denied opening /dev/kvm")
+
+// ErrCapabilityMissing indicates a required KVM capability is not
+// supported by the running kernel.
+type ErrCapabilityMissing struct {
+	Cap uintptr
+}
+
+func (e *ErrCapabilityMissing) Error() string {
+	return fmt.Sprintf("hypervisor: required capability %d not supported by this kernel", e.Cap)
+}
+
+// IoctlError wraps a failing ioctl with the request's name so error
+// messages name the call that failed. It unwraps to the underlying
+// errno, so callers can still errors.Is against syscall values like
+// syscall.EINTR or syscall.EACCES.
+type IoctlError struct {
+	Name  string
+	Errno syscall.Errno
+}
+
+func (e *IoctlError) Error() string {
+	return fmt.Sprintf("hypervisor: %s: %v", e.Name, e.Errno)
+}
+
+func (e *IoctlError) Unwrap() error { return e.Errno }
+
+// classifyOpenError maps an open("/dev/kvm") failure onto one of this
+// package's typed sentinels, preserving the original error via %w.
+func classifyOpenError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: %v", ErrKVMUnavailable, err)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	default:
+		return err
+	}
+}
+
+// Preflight checks that /dev/kvm can be opened and that this package's
+// required API version and capabilities are present, aggregating every
+// problem it finds into a single, human-readable error rather than
+// stopping at the first. It returns nil if the host is fully usable.
+func Preflight() error {
+	f, err := OpenKVM()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return preflight(f)
+}
+
+func preflight(f *os.File) error {
+	var problems []string
+
+	version, err := APIVersion(f)
+	switch {
+	case err != nil:
+		problems = append(problems, err.Error())
+	case version != wantAPIVersion:
+		problems = append(problems, fmt.Sprintf("hypervisor: KVM API version %d, want %d", version, wantAPIVersion))
+	}
+
+	for _, cap := range []uintptr{KVM_CAP_USER_MEMORY, KVM_CAP_SET_TSS_ADDR} {
+		supported, err := CheckExtension(f, cap)
+		switch {
+		case err != nil:
+			problems = append(problems, err.Error())
+		case supported == 0:
+			problems = append(problems, (&ErrCapabilityMissing{Cap: cap}).Error())
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msg := "hypervisor: preflight failed:"
+	for _, p := range problems {
+		msg += "\n  - " + p
+	}
+	return errors.New(msg)
+}
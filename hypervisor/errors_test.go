@@ -0,0 +1,60 @@
+package hypervisor
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestIoctlErrorUnwrapsToErrno(t *testing.T) {
+	err := &IoctlError{Name: "KVM_TEST", Errno: syscall.EINVAL}
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("errors.Is(err, syscall.EINVAL) = false, want true")
+	}
+	if errors.Is(err, syscall.EACCES) {
+		t.Errorf("errors.Is(err, syscall.EACCES) = true, want false")
+	}
+}
+
+func TestErrCapabilityMissingAs(t *testing.T) {
+	var err error = &ErrCapabilityMissing{Cap: KVM_CAP_SET_TSS_ADDR}
+	var target *ErrCapabilityMissing
+	if !errors.As(err, &target) {
+		t.Fatalf("errors.As failed to match *ErrCapabilityMissing")
+	}
+	if target.Cap != KVM_CAP_SET_TSS_ADDR {
+		t.Errorf("Cap = %d, want %d", target.Cap, KVM_CAP_SET_TSS_ADDR)
+	}
+}
+
+func TestClassifyOpenErrorMapsNotExist(t *testing.T) {
+	_, statErr := os.Open("/nonexistent-path-for-hypervisor-tests")
+	err := classifyOpenError(statErr)
+	if !errors.Is(err, ErrKVMUnavailable) {
+		t.Errorf("classifyOpenError(ENOENT) not ErrKVMUnavailable: %v", err)
+	}
+}
+
+func TestPreflightAggregatesInjectedFailures(t *testing.T) {
+	// /dev/null answers KVM ioctls with ENOTTY, so every check in
+	// preflight fails; this exercises the aggregation path without
+	// requiring real KVM capability gaps.
+	f, err := os.Open("/dev/null")
+	if err != nil {
+		t.Skipf("/dev/null unavailable: %v", err)
+	}
+	defer f.Close()
+
+	err = preflight(f)
+	if err == nil {
+		t.Fatalf("preflight(/dev/null) = nil, want aggregated error")
+	}
+	if !strings.Contains(err.Error(), "KVM_GET_API_VERSION") {
+		t.Errorf("preflight error missing API version failure: %v", err)
+	}
+	if !strings.Contains(err.Error(), "KVM_CHECK_EXTENSION") {
+		t.Errorf("preflight error missing capability check failure: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package hypervisor
+
+import "sync"
+
+// FakeVCPU is an in-memory VCPU double for tests that don't need a real
+// /dev/kvm file descriptor.
+type FakeVCPU struct {
+	Regs  KvmRegs
+	Sregs KvmSregs
+
+	// MSRs holds every MSR index this fake VCPU recognizes and its
+	// current value. GetMSR against an index missing from this map
+	// returns ErrMSRNotSupported, simulating KVM rejecting an MSR this
+	// kernel/CPU doesn't implement. It's nil, not empty, until either
+	// SetMSR or a test populates it directly.
+	MSRs map[uint32]uint64
+}
+
+// GetRegs implements VCPU.
+func (f *FakeVCPU) GetRegs() (KvmRegs, error) {
+	return f.Regs, nil
+}
+
+// SetRegs implements VCPU.
+func (f *FakeVCPU) SetRegs(r KvmRegs) error {
+	f.Regs = r
+	return nil
+}
+
+// GetSregs implements VCPU.
+func (f *FakeVCPU) GetSregs() (KvmSregs, error) {
+	return f.Sregs, nil
+}
+
+// SetSregs implements VCPU.
+func (f *FakeVCPU) SetSregs(s KvmSregs) error {
+	f.Sregs = s
+	return nil
+}
+
+// GetMSR implements VCPU.
+func (f *FakeVCPU) GetMSR(index uint32) (uint64, error) {
+	value, ok := f.MSRs[index]
+	if !ok {
+		return 0, &ErrMSRNotSupported{Index: index}
+	}
+	return value, nil
+}
+
+// SetMSR implements VCPU.
+func (f *FakeVCPU) SetMSR(index uint32, value uint64) error {
+	if f.MSRs == nil {
+		f.MSRs = make(map[uint32]uint64)
+	}
+	f.MSRs[index] = value
+	return nil
+}
+
+// FakeIrqChip simulates the in-kernel irqchip ioctls (CreateIrqChip,
+// CreatePIT2, SetIrqLine) for tests that don't have a real /dev/kvm VM
+// file descriptor: it just records what it was asked to do instead of
+// touching real hardware.
+type FakeIrqChip struct {
+	mu sync.Mutex
+
+	Created      bool
+	PITConfig    *KvmPitConfig
+	IrqLineCalls []KvmIrqLevel
+}
+
+// NewFakeIrqChip returns a FakeIrqChip with nothing recorded yet.
+func NewFakeIrqChip() *FakeIrqChip {
+	return &FakeIrqChip{}
+}
+
+// CreateIrqChip implements the same contract as CreateIrqChip, against
+// the recorded state instead of a real vmFile.
+func (f *FakeIrqChip) CreateIrqChip() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Created = true
+	return nil
+}
+
+// CreatePIT2 implements the same contract as CreatePIT2, against the
+// recorded state instead of a real vmFile.
+func (f *FakeIrqChip) CreatePIT2(config KvmPitConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.PITConfig = &config
+	return nil
+}
+
+// SetIrqLine implements the same contract as SetIrqLine, appending level
+// to IrqLineCalls instead of issuing a real ioctl.
+func (f *FakeIrqChip) SetIrqLine(level KvmIrqLevel) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.IrqLineCalls = append(f.IrqLineCalls, level)
+	return nil
+}
@@ -0,0 +1,103 @@
+package hypervisor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// KvmGuestDebugArch mirrors the x86 struct kvm_guest_debug_arch: the DR0-3
+// hardware breakpoint addresses and DR6/DR7 debug-status/control
+// registers KVM should load into the VCPU alongside Control.
+type KvmGuestDebugArch struct {
+	DebugReg [8]uint64
+}
+
+// KvmGuestDebug mirrors struct kvm_guest_debug, passed to
+// KVM_SET_GUEST_DEBUG to enable single-stepping and/or hardware
+// breakpoints on a VCPU.
+type KvmGuestDebug struct {
+	Control uint32
+	_       uint32 // padding
+	Arch    KvmGuestDebugArch
+}
+
+// Control bits for KvmGuestDebug.Control.
+const (
+	// KvmGuestDebugEnable must be set for any of KVM_SET_GUEST_DEBUG's
+	// other fields to take effect; clearing it (with all other fields
+	// zeroed) turns guest debugging back off.
+	KvmGuestDebugEnable uint32 = 1 << 0
+	// KvmGuestDebugSingleStep makes the VCPU trap back out with
+	// KVM_EXIT_DEBUG after executing exactly one instruction.
+	KvmGuestDebugSingleStep uint32 = 1 << 1
+	// KvmGuestDebugUseHWBP tells KVM to load Arch.DebugReg into the
+	// VCPU's hardware debug registers.
+	KvmGuestDebugUseHWBP uint32 = 1 << 17
+)
+
+// maxHardwareBreakpoints is the number of address breakpoint registers
+// (DR0-DR3) x86 provides.
+const maxHardwareBreakpoints = 4
+
+// dr7GlobalEnable builds a DR7 value with the global-enable bit set for
+// each of the first n breakpoint slots, the simplest DR7
+// configuration that unconditionally traps on any of them regardless of
+// privilege level.
+func dr7GlobalEnable(n int) uint64 {
+	var dr7 uint64
+	for i := 0; i < n; i++ {
+		dr7 |= 1 << uint(i*2+1) // G0, G1, G2, G3
+	}
+	return dr7
+}
+
+// SetGuestDebug issues KVM_SET_GUEST_DEBUG on vcpuFile. enableSingleStep
+// makes the next KVM_RUN stop after one instruction with KVM_EXIT_DEBUG;
+// breakpoints (at most 4, since x86 has four hardware breakpoint
+// registers) installs each address as an unconditional hardware
+// breakpoint. Passing both is legal: the VCPU steps once and would also
+// trap on any of the installed addresses.
+func SetGuestDebug(vcpuFile *os.File, enableSingleStep bool, breakpoints []uint64) error {
+	if len(breakpoints) > maxHardwareBreakpoints {
+		return fmt.Errorf("hypervisor: %d breakpoints requested, x86 only has %d hardware breakpoint registers", len(breakpoints), maxHardwareBreakpoints)
+	}
+
+	debug := KvmGuestDebug{Control: KvmGuestDebugEnable}
+	if enableSingleStep {
+		debug.Control |= KvmGuestDebugSingleStep
+	}
+	if len(breakpoints) > 0 {
+		debug.Control |= KvmGuestDebugUseHWBP
+		for i, addr := range breakpoints {
+			debug.Arch.DebugReg[i] = addr
+		}
+		debug.Arch.DebugReg[7] = dr7GlobalEnable(len(breakpoints))
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_SET_GUEST_DEBUG, uintptr(unsafe.Pointer(&debug)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_SET_GUEST_DEBUG", Errno: errno}
+	}
+	return nil
+}
+
+// StepInstruction enables single-stepping on vcpuFile, runs the guest
+// for exactly one instruction, and returns the registers it stopped
+// with. The VCPU exits KVM_RUN with KVM_EXIT_DEBUG after the step; the
+// caller is responsible for recognizing that exit reason the same way it
+// would any other (see ExitReason) before calling StepInstruction again.
+func StepInstruction(vcpuFile *os.File) (*KvmRegs, error) {
+	if err := SetGuestDebug(vcpuFile, true, nil); err != nil {
+		return nil, err
+	}
+	if err := RunVCPU(vcpuFile); err != nil {
+		return nil, err
+	}
+	regs, err := GetRegs(vcpuFile)
+	if err != nil {
+		return nil, err
+	}
+	return &regs, nil
+}
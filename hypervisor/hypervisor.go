@@ -0,0 +1,12 @@
+package hypervisor
+
+// VCPU is the per-vCPU handle the vmm package drives: general-purpose,
+// special and model-specific register access.
+type VCPU interface {
+	GetRegs() (KvmRegs, error)
+	SetRegs(KvmRegs) error
+	GetSregs() (KvmSregs, error)
+	SetSregs(KvmSregs) error
+	GetMSR(index uint32) (uint64, error)
+	SetMSR(index uint32, value uint64) error
+}
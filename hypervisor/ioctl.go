@@ -0,0 +1,102 @@
+package hypervisor
+
+import "unsafe"
+
+// Linux ioctl request encoding (asm-generic/ioctl.h). KVM's ioctls all
+// use type 0xae ('\xAE').
+const (
+	iocNone  = 0
+	iocWrite = 1
+	iocRead  = 2
+
+	iocNrBits   = 8
+	iocTypeBits = 8
+	iocSizeBits = 14
+
+	iocNrShift   = 0
+	iocTypeShift = iocNrShift + iocNrBits
+	iocSizeShift = iocTypeShift + iocTypeBits
+	iocDirShift  = iocSizeShift + iocSizeBits
+
+	kvmIocType = 0xae
+)
+
+// ioc computes a Linux ioctl request number for the given direction, nr,
+// and payload size, matching the kernel's _IOC/_IO/_IOR/_IOW/_IOWR
+// macros.
+func ioc(dir, nr uintptr, size uintptr) uintptr {
+	return (dir << iocDirShift) | (kvmIocType << iocTypeShift) | (nr << iocNrShift) | (size << iocSizeShift)
+}
+
+func io(nr uintptr) uintptr         { return ioc(iocNone, nr, 0) }
+func ior(nr, size uintptr) uintptr  { return ioc(iocRead, nr, size) }
+func iow(nr, size uintptr) uintptr  { return ioc(iocWrite, nr, size) }
+func iowr(nr, size uintptr) uintptr { return ioc(iocRead|iocWrite, nr, size) }
+
+// KVM ioctl request numbers, computed from the kernel's
+// include/uapi/linux/kvm.h nr assignments rather than hardcoded magic
+// numbers, so a change in payload struct size is caught by go vet's
+// unsafe.Sizeof usage below rather than silently producing the wrong
+// ioctl.
+var (
+	KVM_GET_API_VERSION        = io(0x00)
+	KVM_CREATE_VM              = io(0x01)
+	KVM_CHECK_EXTENSION        = io(0x03)
+	KVM_GET_VCPU_MMAP_SIZE     = io(0x04)
+	KVM_CREATE_VCPU            = io(0x41)
+	KVM_SET_USER_MEMORY_REGION = iow(0x46, kvmUserspaceMemoryRegionSize)
+	KVM_RUN                    = io(0x80)
+	KVM_GET_REGS               = ior(0x81, kvmRegsSize)
+	KVM_SET_REGS               = iow(0x82, kvmRegsSize)
+	KVM_GET_SREGS              = ior(0x83, kvmSregsSize)
+	KVM_SET_SREGS              = iow(0x84, kvmSregsSize)
+	KVM_GET_DIRTY_LOG          = iow(0x42, kvmDirtyLogSize)
+	KVM_CREATE_IRQCHIP         = io(0x60)
+	KVM_IRQ_LINE               = iow(0x61, kvmIrqLevelSize)
+	KVM_CREATE_PIT2            = iow(0x77, kvmPitConfigSize)
+	// KVM_SET_CPUID2's request number is sized from struct kvm_cpuid2's
+	// fixed header only (nent, padding); the trailing kvm_cpuid_entry2
+	// array is variable-length and isn't reflected in the ioctl number,
+	// matching the kernel's own _IOW(KVMIO, 0x90, struct kvm_cpuid2).
+	KVM_SET_CPUID2 = iow(0x90, kvmCountHeaderSize)
+	// KVM_GET_SUPPORTED_CPUID is sized the same way but is IOWR: the
+	// kernel both reads the caller's Count (the entries buffer's
+	// capacity) and overwrites it with however many leaves it actually
+	// returned, matching _IOWR(KVMIO, 0x05, struct kvm_cpuid2).
+	KVM_GET_SUPPORTED_CPUID = iowr(0x05, kvmCountHeaderSize)
+	// KVM_GET_MSRS/KVM_SET_MSRS are sized the same way, from struct
+	// kvm_msrs's fixed nmsrs/padding header: _IOWR/_IOW(KVMIO, 0x88/0x89,
+	// struct kvm_msrs). KVM_GET_MSRS is IOWR because the kernel fills the
+	// caller's entries in place, rather than returning a separate buffer.
+	KVM_GET_MSRS = iowr(0x88, kvmCountHeaderSize)
+	KVM_SET_MSRS = iow(0x89, kvmCountHeaderSize)
+	// KVM_SET_GUEST_DEBUG's request number is sized from struct
+	// kvm_guest_debug's full, fixed layout (it has no variable-length
+	// tail), matching the kernel's own
+	// _IOW(KVMIO, 0x9b, struct kvm_guest_debug).
+	KVM_SET_GUEST_DEBUG = iow(0x9b, kvmGuestDebugSize)
+)
+
+// Struct sizes used above to size the ioctl request numbers, each sized
+// from its real struct definition.
+var kvmRegsSize = unsafe.Sizeof(KvmRegs{})
+
+var kvmSregsSize = unsafe.Sizeof(KvmSregs{})
+
+var kvmDirtyLogSize = unsafe.Sizeof(KvmDirtyLog{})
+
+var kvmIrqLevelSize = unsafe.Sizeof(KvmIrqLevel{})
+
+var kvmPitConfigSize = unsafe.Sizeof(KvmPitConfig{})
+
+var kvmGuestDebugSize = unsafe.Sizeof(KvmGuestDebug{})
+
+// kvmCountHeader mirrors the fixed leading header shared by every KVM
+// variable-length ioctl struct this package uses (struct kvm_cpuid2 and
+// struct kvm_msrs): a count field, some padding, then a flexible array.
+type kvmCountHeader struct {
+	Count   uint32
+	Padding uint32
+}
+
+var kvmCountHeaderSize = unsafe.Sizeof(kvmCountHeader{})
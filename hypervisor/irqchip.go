@@ -0,0 +1,57 @@
+package hypervisor
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// KvmPitConfig mirrors the kernel's struct kvm_pit_config, passed to
+// KVM_CREATE_PIT2 to create an in-kernel i8254 PIT.
+type KvmPitConfig struct {
+	Flags uint32
+	_     [15]uint32 // reserved
+}
+
+// KvmPitSpeakerDummy, set in KvmPitConfig.Flags, tells KVM to also drive
+// the PC speaker's gate/output wiring to channel 2, matching real
+// hardware's default in-kernel PIT behavior.
+const KvmPitSpeakerDummy uint32 = 1 << 0
+
+// KvmIrqLevel mirrors the kernel's struct kvm_irq_level: the IRQ line and
+// its new level, for KVM_IRQ_LINE to assert or deassert.
+type KvmIrqLevel struct {
+	IRQ   uint32
+	Level uint32
+}
+
+// CreateIrqChip issues KVM_CREATE_IRQCHIP on vmFile, giving the VM an
+// in-kernel PIC pair. It must be called before any VCPU is created.
+func CreateIrqChip(vmFile *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vmFile.Fd(), KVM_CREATE_IRQCHIP, 0)
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_CREATE_IRQCHIP", Errno: errno}
+	}
+	return nil
+}
+
+// CreatePIT2 issues KVM_CREATE_PIT2 on vmFile, giving the VM an in-kernel
+// i8254 PIT. It requires an irqchip to already exist (see CreateIrqChip),
+// since the PIT's timer interrupt is delivered through it.
+func CreatePIT2(vmFile *os.File, config KvmPitConfig) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vmFile.Fd(), KVM_CREATE_PIT2, uintptr(unsafe.Pointer(&config)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_CREATE_PIT2", Errno: errno}
+	}
+	return nil
+}
+
+// SetIrqLine issues KVM_IRQ_LINE on vmFile, asserting or deasserting
+// level.IRQ on the in-kernel irqchip created by CreateIrqChip.
+func SetIrqLine(vmFile *os.File, level KvmIrqLevel) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vmFile.Fd(), KVM_IRQ_LINE, uintptr(unsafe.Pointer(&level)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_IRQ_LINE", Errno: errno}
+	}
+	return nil
+}
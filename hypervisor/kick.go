@@ -0,0 +1,93 @@
+package hypervisor
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// VCPUKickSignal is the dedicated signal used to interrupt a thread
+// blocked in KVM_RUN. It has no meaning to KVM itself; a real vmexit
+// never produces it. What matters is that it isn't SA_RESTART-flagged, so
+// a blocking syscall like ioctl(KVM_RUN) returns EINTR instead of being
+// transparently retried by the kernel.
+const VCPUKickSignal = syscall.SIGUSR1
+
+// EnableVCPUKickSignal installs a no-op handler for VCPUKickSignal,
+// overriding its default disposition (which is to terminate the
+// process). Call it once at startup, before arming any VCPUKicker; it is
+// safe to call more than once.
+func EnableVCPUKickSignal() {
+	kickSignalOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, VCPUKickSignal)
+		go func() {
+			for range ch {
+				// Nothing to do: the point of this handler is only to
+				// stop the runtime from taking the default (terminate)
+				// action so the signal is left free to interrupt a
+				// thread's blocking KVM_RUN call with EINTR.
+			}
+		}()
+	})
+}
+
+var kickSignalOnce sync.Once
+
+// VCPUKicker interrupts a specific OS thread out of a blocking KVM_RUN
+// call. A run loop calls Arm once it has locked itself to an OS thread
+// (via runtime.LockOSThread) and is about to start calling RunVCPU, and
+// Disarm when it stops. Kick is safe to call concurrently from any
+// goroutine, including one that never calls Arm/Disarm itself.
+type VCPUKicker struct {
+	mu  sync.Mutex
+	tid int32  // 0 when disarmed
+	run []byte // the armed thread's mmap'd kvm_run page, or nil
+}
+
+// NewVCPUKicker returns a VCPUKicker that is initially disarmed, so Kick
+// is a no-op until Arm is called.
+func NewVCPUKicker() *VCPUKicker {
+	return &VCPUKicker{}
+}
+
+// Arm records the calling goroutine's OS thread id and its VCPU's mmap'd
+// kvm_run page, so a later Kick can reach it. The caller must have
+// already called runtime.LockOSThread, and must call it from the same
+// goroutine that will call RunVCPU.
+func (k *VCPUKicker) Arm(run []byte) {
+	k.mu.Lock()
+	k.tid = int32(syscall.Gettid())
+	k.run = run
+	k.mu.Unlock()
+}
+
+// Disarm forgets the armed thread, so a subsequent Kick becomes a no-op
+// until Arm is called again.
+func (k *VCPUKicker) Disarm() {
+	k.mu.Lock()
+	k.tid = 0
+	k.run = nil
+	k.mu.Unlock()
+}
+
+// Kick interrupts the thread most recently passed to Arm, if any. It
+// first sets immediate_exit on that thread's kvm_run page, so a KVM_RUN
+// call the thread is about to make (rather than already blocked in)
+// returns immediately instead of running the guest; it then sends
+// VCPUKickSignal to unblock a KVM_RUN already in progress with EINTR.
+// Calling Kick before any Arm, or after Disarm, is a harmless no-op.
+func (k *VCPUKicker) Kick() error {
+	k.mu.Lock()
+	tid, run := k.tid, k.run
+	k.mu.Unlock()
+
+	if tid == 0 {
+		return nil
+	}
+	if run != nil {
+		SetImmediateExit(run, true)
+	}
+	return syscall.Tgkill(syscall.Getpid(), int(tid), VCPUKickSignal)
+}
@@ -0,0 +1,75 @@
+// Package hypervisor provides the low-level bindings to Linux KVM: the
+// ioctl-shaped register structs the kernel expects and the Hypervisor
+// interface that the vmm package drives.
+package hypervisor
+
+// KvmSegment mirrors struct kvm_segment.
+type KvmSegment struct {
+	Base     uint64
+	Limit    uint32
+	Selector uint16
+	Type     uint8
+	Present  uint8
+	DPL      uint8
+	DB       uint8
+	S        uint8
+	L        uint8
+	G        uint8
+	AVL      uint8
+	Unusable uint8
+	_        uint8 // padding
+}
+
+// KvmDTable mirrors struct kvm_dtable (used for GDT/IDT).
+type KvmDTable struct {
+	Base  uint64
+	Limit uint16
+	_     [3]uint16 // padding
+}
+
+// KvmRegs mirrors struct kvm_regs field-for-field and in order, so that it
+// can be laid directly over the buffer the KVM_GET_REGS/KVM_SET_REGS
+// ioctls read and write.
+type KvmRegs struct {
+	RAX, RBX, RCX, RDX uint64
+	RSI, RDI, RSP, RBP uint64
+	R8, R9, R10, R11   uint64
+	R12, R13, R14, R15 uint64
+	RIP, RFLAGS        uint64
+}
+
+// KvmSregs mirrors struct kvm_sregs field-for-field and in order, so that
+// it can be laid directly over the buffer the KVM_GET_SREGS/KVM_SET_SREGS
+// ioctls read and write.
+type KvmSregs struct {
+	CS, DS, ES, FS, GS, SS KvmSegment
+	TR, LDT                KvmSegment
+	GDT, IDT               KvmDTable
+	CR0                    uint64
+	CR2                    uint64
+	CR3                    uint64
+	CR4                    uint64
+	CR8                    uint64
+	EFER                   uint64
+	ApicBase               uint64
+	InterruptBitmap        [(256 + 63) / 64]uint64
+}
+
+// EFER bits relevant to enabling long mode.
+const (
+	EFER_SCE = 1 << 0  // syscall enable
+	EFER_LME = 1 << 8  // long mode enable
+	EFER_LMA = 1 << 10 // long mode active (read-only, set by CPU on activation)
+	EFER_NXE = 1 << 11 // no-execute enable
+)
+
+// CR0 bits used during protected/paged-mode bring-up.
+const (
+	CR0_PE = 1 << 0 // protected mode enable
+	CR0_PG = 1 << 31
+)
+
+// CR4 bits used to enable PAE, required for long mode paging.
+const (
+	CR4_PAE = 1 << 5
+)
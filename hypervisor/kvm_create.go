@@ -0,0 +1,83 @@
+package hypervisor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const wantAPIVersion = 12
+
+// Capabilities required by the vmm package's device model and boot path.
+// KVM_CAP_USER_MEMORY lets us hand it arbitrary guest RAM slices;
+// KVM_CAP_SET_TSS_ADDR is needed to run guests in a VM without an
+// in-kernel APIC/irqchip's default TSS handling.
+const (
+	KVM_CAP_USER_MEMORY = 3
+	KVM_CAP_SET_TSS_ADDR = 4
+)
+
+// KVM is a handle to an opened /dev/kvm, validated to speak the API
+// version and capabilities this package requires.
+type KVM struct {
+	file *os.File
+}
+
+// NewKVM opens /dev/kvm, checks KVM_GET_API_VERSION, and verifies the
+// capabilities the vmm package depends on.
+func NewKVM() (*KVM, error) {
+	f, err := OpenKVM()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := APIVersion(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if version != wantAPIVersion {
+		f.Close()
+		return nil, fmt.Errorf("hypervisor: KVM API version %d, want %d", version, wantAPIVersion)
+	}
+
+	for _, cap := range []uintptr{KVM_CAP_USER_MEMORY, KVM_CAP_SET_TSS_ADDR} {
+		supported, err := CheckExtension(f, cap)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if supported == 0 {
+			f.Close()
+			return nil, &ErrCapabilityMissing{Cap: cap}
+		}
+	}
+
+	return &KVM{file: f}, nil
+}
+
+// CreateVM issues KVM_CREATE_VM and returns the resulting VM file
+// descriptor.
+func (k *KVM) CreateVM() (*os.File, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, k.file.Fd(), KVM_CREATE_VM, 0)
+	if errno != 0 {
+		return nil, &IoctlError{Name: "KVM_CREATE_VM", Errno: errno}
+	}
+	return os.NewFile(r, "kvm-vm"), nil
+}
+
+// Close closes the underlying /dev/kvm file descriptor.
+func (k *KVM) Close() error {
+	return k.file.Close()
+}
+
+// CreateVCPU issues KVM_CREATE_VCPU on vmFile (the fd returned by
+// CreateVM) and returns the resulting per-VCPU file descriptor. id is
+// the VCPU's index within the VM, starting at 0 for the boot VCPU.
+func CreateVCPU(vmFile *os.File, id int) (*os.File, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vmFile.Fd(), KVM_CREATE_VCPU, uintptr(id))
+	if errno != 0 {
+		return nil, &IoctlError{Name: "KVM_CREATE_VCPU", Errno: errno}
+	}
+	return os.NewFile(r, "kvm-vcpu"), nil
+}
@@ -0,0 +1,17 @@
+package hypervisor
+
+import "testing"
+
+func TestNewKVMCreatesVM(t *testing.T) {
+	kvm, err := NewKVM()
+	if err != nil {
+		t.Skipf("/dev/kvm unavailable or capabilities missing: %v", err)
+	}
+	defer kvm.Close()
+
+	vmFd, err := kvm.CreateVM()
+	if err != nil {
+		t.Fatalf("CreateVM: %v", err)
+	}
+	defer vmFd.Close()
+}
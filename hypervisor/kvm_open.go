@@ -0,0 +1,39 @@
+package hypervisor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const kvmDevicePath = "/dev/kvm"
+
+// OpenKVM opens /dev/kvm and returns its file descriptor, ready for
+// KVM_GET_API_VERSION/KVM_CREATE_VM ioctls. On failure it returns
+// ErrKVMUnavailable or ErrPermissionDenied, checkable with errors.Is.
+func OpenKVM() (*os.File, error) {
+	f, err := os.OpenFile(kvmDevicePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, classifyOpenError(fmt.Errorf("hypervisor: open %s: %w", kvmDevicePath, err))
+	}
+	return f, nil
+}
+
+// APIVersion issues KVM_GET_API_VERSION on f.
+func APIVersion(f *os.File) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), KVM_GET_API_VERSION, 0)
+	if errno != 0 {
+		return 0, &IoctlError{Name: "KVM_GET_API_VERSION", Errno: errno}
+	}
+	return int(r), nil
+}
+
+// CheckExtension issues KVM_CHECK_EXTENSION for the given capability
+// number on f.
+func CheckExtension(f *os.File, cap uintptr) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), KVM_CHECK_EXTENSION, cap)
+	if errno != 0 {
+		return 0, &IoctlError{Name: "KVM_CHECK_EXTENSION", Errno: errno}
+	}
+	return int(r), nil
+}
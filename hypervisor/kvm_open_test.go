@@ -0,0 +1,19 @@
+package hypervisor
+
+import "testing"
+
+func TestAPIVersionMatchesKernelExpectation(t *testing.T) {
+	f, err := OpenKVM()
+	if err != nil {
+		t.Skipf("/dev/kvm unavailable: %v", err)
+	}
+	defer f.Close()
+
+	version, err := APIVersion(f)
+	if err != nil {
+		t.Fatalf("APIVersion: %v", err)
+	}
+	if version != 12 {
+		t.Errorf("KVM_GET_API_VERSION = %d, want 12", version)
+	}
+}
@@ -0,0 +1,124 @@
+package hypervisor
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// KVM exit reasons relevant to the vmm package's exit loop.
+const (
+	KVM_EXIT_IO       = 2
+	KVM_EXIT_DEBUG    = 4
+	KVM_EXIT_HLT      = 5
+	KVM_EXIT_SHUTDOWN = 8
+)
+
+// IO directions as reported in the kvm_run.io union.
+const (
+	KVM_EXIT_IO_IN  = 0
+	KVM_EXIT_IO_OUT = 1
+)
+
+// Layout of the leading, fixed portion of struct kvm_run, up to and
+// including the io sub-struct. Everything after that (the exit_reason
+// union) varies in size across kernel versions, which is exactly why the
+// io data buffer must be located via data_offset rather than a
+// hand-picked constant: the padding between the union and the following
+// page-aligned data area is not part of the stable ABI.
+const (
+	offRequestInterruptWindow = 0
+	offImmediateExit          = 1
+	offExitReason             = 4
+	offReady                  = 8
+	// offIO is exit_reason union offset 8, aligned to 8 bytes on amd64.
+	offIO = 8
+
+	ioDirection = offIO + 0
+	ioSize      = offIO + 1
+	ioPort      = offIO + 2
+	ioCount     = offIO + 4
+	ioDataOff   = offIO + 8 // __u64 data_offset, relative to the start of kvm_run
+
+	// debugPC/DR6/DR7 are struct kvm_debug_exit_arch's fields, another
+	// member of the same exit_reason union as io, hence sharing offIO:
+	// a 4-byte exception code and 4 bytes of padding, then pc, dr6, dr7.
+	debugPC  = offIO + 8
+	debugDR6 = offIO + 16
+	debugDR7 = offIO + 24
+)
+
+// SetImmediateExit sets or clears kvm_run.immediate_exit on run (the
+// mmap'd kvm_run page for one VCPU). KVM checks it right before entering
+// guest mode and, if set, returns from KVM_RUN immediately instead of
+// running the guest — see VCPUKicker.Kick, which sets it to close the
+// race between a caller about to call KVM_RUN and a kick arriving just
+// before that call, too late for the signal alone to interrupt anything.
+func SetImmediateExit(run []byte, on bool) {
+	if on {
+		run[offImmediateExit] = 1
+	} else {
+		run[offImmediateExit] = 0
+	}
+}
+
+// IOExit describes one KVM_EXIT_IO event, decoded from the mmap'd
+// kvm_run page.
+type IOExit struct {
+	Direction uint8
+	Size      uint8
+	Port      uint16
+	Count     uint32
+	Data      []byte // one Size-byte element per iteration; len == Size*Count
+}
+
+// DecodeIOExit reads the io sub-struct out of run (the raw mmap'd
+// kvm_run page for one VCPU) and slices out its data buffer using the
+// kernel-supplied data_offset, rather than assuming any fixed layout for
+// where the buffer starts.
+func DecodeIOExit(run []byte) (IOExit, error) {
+	if len(run) < ioDataOff+8 {
+		return IOExit{}, fmt.Errorf("hypervisor: kvm_run page too small for io exit (%d bytes)", len(run))
+	}
+
+	exit := IOExit{
+		Direction: run[ioDirection],
+		Size:      run[ioSize],
+		Port:      binary.LittleEndian.Uint16(run[ioPort:]),
+		Count:     binary.LittleEndian.Uint32(run[ioCount:]),
+	}
+
+	dataOffset := binary.LittleEndian.Uint64(run[ioDataOff:])
+	n := int(exit.Size) * int(exit.Count)
+	start := int(dataOffset)
+	if start < 0 || start+n > len(run) {
+		return IOExit{}, fmt.Errorf("hypervisor: io data_offset %#x size %d out of range (page %d bytes)", dataOffset, n, len(run))
+	}
+	exit.Data = run[start : start+n]
+	return exit, nil
+}
+
+// KvmDebugExit describes one KVM_EXIT_DEBUG event, decoded from the
+// mmap'd kvm_run page's arch.debug sub-struct.
+type KvmDebugExit struct {
+	PC  uint64 // guest RIP where the trap occurred
+	DR6 uint64 // debug status: bits 0-3 name which of DR0-3 matched
+	DR7 uint64 // debug control, as last programmed by SetGuestDebug
+}
+
+// DecodeDebugExit reads the debug sub-struct out of run (the raw mmap'd
+// kvm_run page for one VCPU).
+func DecodeDebugExit(run []byte) (KvmDebugExit, error) {
+	if len(run) < debugDR7+8 {
+		return KvmDebugExit{}, fmt.Errorf("hypervisor: kvm_run page too small for debug exit (%d bytes)", len(run))
+	}
+	return KvmDebugExit{
+		PC:  binary.LittleEndian.Uint64(run[debugPC:]),
+		DR6: binary.LittleEndian.Uint64(run[debugDR6:]),
+		DR7: binary.LittleEndian.Uint64(run[debugDR7:]),
+	}, nil
+}
+
+// ExitReason reads exit_reason out of run.
+func ExitReason(run []byte) uint32 {
+	return binary.LittleEndian.Uint32(run[offExitReason:])
+}
@@ -0,0 +1,34 @@
+package hypervisor
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeIOExitUsesDataOffsetNotFixedLayout(t *testing.T) {
+	page := make([]byte, 256)
+	binary.LittleEndian.PutUint32(page[offExitReason:], KVM_EXIT_IO)
+	page[ioDirection] = KVM_EXIT_IO_OUT
+	page[ioSize] = 1
+	binary.LittleEndian.PutUint16(page[ioPort:], 0x3f8)
+	binary.LittleEndian.PutUint32(page[ioCount:], 2)
+
+	// Place the data buffer somewhere other than the naive
+	// "right after the io struct" offset, to prove decoding follows
+	// data_offset rather than a hardcoded constant.
+	const dataOffset = 128
+	binary.LittleEndian.PutUint64(page[ioDataOff:], dataOffset)
+	page[dataOffset] = 'h'
+	page[dataOffset+1] = 'i'
+
+	exit, err := DecodeIOExit(page)
+	if err != nil {
+		t.Fatalf("DecodeIOExit: %v", err)
+	}
+	if exit.Port != 0x3f8 || exit.Direction != KVM_EXIT_IO_OUT || exit.Size != 1 || exit.Count != 2 {
+		t.Fatalf("decoded fields = %+v", exit)
+	}
+	if string(exit.Data) != "hi" {
+		t.Errorf("Data = %q, want %q", exit.Data, "hi")
+	}
+}
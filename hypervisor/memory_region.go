@@ -0,0 +1,35 @@
+package hypervisor
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// KvmUserspaceMemoryRegion mirrors the kernel's
+// struct kvm_userspace_memory_region: a single slot mapping a range of
+// guest physical addresses onto a userspace-owned backing buffer.
+type KvmUserspaceMemoryRegion struct {
+	Slot          uint32
+	Flags         uint32
+	GuestPhysAddr uint64
+	MemorySize    uint64
+	UserspaceAddr uint64
+}
+
+var kvmUserspaceMemoryRegionSize = unsafe.Sizeof(KvmUserspaceMemoryRegion{})
+
+// KvmMemLogDirtyPages, set in KvmUserspaceMemoryRegion.Flags, asks KVM to
+// track writes to the slot so GetDirtyLog can report them.
+const KvmMemLogDirtyPages uint32 = 1 << 0
+
+// SetUserMemoryRegion issues KVM_SET_USER_MEMORY_REGION on vmFile,
+// installing or updating the memory slot described by region. Passing a
+// region with MemorySize 0 removes that slot.
+func SetUserMemoryRegion(vmFile *os.File, region KvmUserspaceMemoryRegion) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vmFile.Fd(), KVM_SET_USER_MEMORY_REGION, uintptr(unsafe.Pointer(&region)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_SET_USER_MEMORY_REGION", Errno: errno}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package hypervisor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// KvmMsrEntry mirrors struct kvm_msr_entry: one MSR's index and its
+// 64-bit value.
+type KvmMsrEntry struct {
+	Index uint32
+	_     uint32 // reserved, matches struct kvm_msr_entry
+	Data  uint64
+}
+
+// kvmMsrEntrySize is KvmMsrEntry's on-the-wire size: index uint32,
+// reserved uint32, data uint64, matching the kernel struct it mirrors
+// field-for-field.
+const kvmMsrEntrySize = 4 + 4 + 8
+
+// buildMsrsBuffer lays out a struct kvm_msrs (the shared count/padding
+// header, then one kvm_msr_entry per index in indices) for
+// GetMSRs/SetMSRs to issue an ioctl against. data supplies each entry's
+// initial Data field (ignored by KVM_GET_MSRS, which overwrites it in
+// place; the caller for KVM_SET_MSRS instead).
+func buildMsrsBuffer(indices []uint32, data []uint64) []byte {
+	buf := make([]byte, int(kvmCountHeaderSize)+len(indices)*kvmMsrEntrySize)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(indices)))
+	for i, index := range indices {
+		off := int(kvmCountHeaderSize) + i*kvmMsrEntrySize
+		binary.LittleEndian.PutUint32(buf[off:], index)
+		if data != nil {
+			binary.LittleEndian.PutUint64(buf[off+8:], data[i])
+		}
+	}
+	return buf
+}
+
+// readMsrsBuffer parses n entries back out of a struct kvm_msrs buffer
+// built by buildMsrsBuffer, after an ioctl has filled in their Data
+// fields.
+func readMsrsBuffer(buf []byte, n int) []KvmMsrEntry {
+	entries := make([]KvmMsrEntry, n)
+	for i := range entries {
+		off := int(kvmCountHeaderSize) + i*kvmMsrEntrySize
+		entries[i] = KvmMsrEntry{
+			Index: binary.LittleEndian.Uint32(buf[off:]),
+			Data:  binary.LittleEndian.Uint64(buf[off+8:]),
+		}
+	}
+	return entries
+}
+
+// GetMSRs issues KVM_GET_MSRS on vcpuFile, reading back the current value
+// of each MSR named in indices. As with the real ioctl, an index KVM
+// doesn't recognize simply isn't included in the result rather than
+// failing the whole call — check len(result) against len(indices) if
+// that distinction matters to the caller.
+func GetMSRs(vcpuFile *os.File, indices []uint32) ([]KvmMsrEntry, error) {
+	if len(indices) == 0 {
+		return nil, nil
+	}
+	buf := buildMsrsBuffer(indices, nil)
+
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_GET_MSRS, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return nil, &IoctlError{Name: "KVM_GET_MSRS", Errno: errno}
+	}
+	return readMsrsBuffer(buf, int(r)), nil
+}
+
+// SetMSRs issues KVM_SET_MSRS on vcpuFile, writing every entry in msrs.
+func SetMSRs(vcpuFile *os.File, msrs []KvmMsrEntry) error {
+	if len(msrs) == 0 {
+		return nil
+	}
+	indices := make([]uint32, len(msrs))
+	data := make([]uint64, len(msrs))
+	for i, m := range msrs {
+		indices[i] = m.Index
+		data[i] = m.Data
+	}
+	buf := buildMsrsBuffer(indices, data)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_SET_MSRS, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_SET_MSRS", Errno: errno}
+	}
+	return nil
+}
+
+// ReadMSR is GetMSRs for a single MSR, the common case of a device or
+// boot-path helper wanting one register's value.
+func ReadMSR(vcpuFile *os.File, index uint32) (uint64, error) {
+	entries, err := GetMSRs(vcpuFile, []uint32{index})
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, &ErrMSRNotSupported{Index: index}
+	}
+	return entries[0].Data, nil
+}
+
+// WriteMSR is SetMSRs for a single MSR.
+func WriteMSR(vcpuFile *os.File, index uint32, value uint64) error {
+	return SetMSRs(vcpuFile, []KvmMsrEntry{{Index: index, Data: value}})
+}
+
+// ErrMSRNotSupported is returned by ReadMSR when KVM_GET_MSRS didn't
+// return an entry for the requested index, meaning this kernel/CPU
+// doesn't recognize it.
+type ErrMSRNotSupported struct {
+	Index uint32
+}
+
+func (e *ErrMSRNotSupported) Error() string {
+	return fmt.Sprintf("hypervisor: MSR %#x not supported by this kernel", e.Index)
+}
+
+// MsrIA32TSCAux is the IA32_TSC_AUX MSR index, a benign per-CPU scratch
+// register (no side effects on read or write) commonly used to sanity-check
+// MSR plumbing.
+const MsrIA32TSCAux = 0xC0000103
+
+// MsrIA32TSC is the time-stamp counter MSR. Writing it rebases RDTSC's
+// count, letting a freshly created VCPU start from zero (or a
+// caller-chosen offset) instead of wherever KVM happened to initialize
+// it.
+const MsrIA32TSC = 0x00000010
+
+// MsrIA32MiscEnable is IA32_MISC_ENABLE, a model-specific control
+// register real firmware programs during early boot (enabling
+// fast-strings, disabling unsupported prefetchers, and so on). This
+// emulator doesn't back any of its bits, so vmm's initMSRs sets it to 0
+// purely so a guest that reads it during boot sees a defined,
+// all-features-off value instead of whatever KVM's uninitialized
+// default happens to be.
+const MsrIA32MiscEnable = 0x000001a0
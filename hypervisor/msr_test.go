@@ -0,0 +1,64 @@
+package hypervisor
+
+import "testing"
+
+// TestMSRReadWriteRoundTrips writes a benign MSR (IA32_TSC_AUX, which has
+// no side effects on read or write) to a real VCPU and confirms
+// ReadMSR reports the value back.
+func TestMSRReadWriteRoundTrips(t *testing.T) {
+	kvm, err := NewKVM()
+	if err != nil {
+		t.Skipf("/dev/kvm unavailable or capabilities missing: %v", err)
+	}
+	defer kvm.Close()
+
+	vmFd, err := kvm.CreateVM()
+	if err != nil {
+		t.Fatalf("CreateVM: %v", err)
+	}
+	defer vmFd.Close()
+
+	vcpuFd, err := CreateVCPU(vmFd, 0)
+	if err != nil {
+		t.Fatalf("CreateVCPU: %v", err)
+	}
+	defer vcpuFd.Close()
+
+	const want = 0x1234
+	if err := WriteMSR(vcpuFd, MsrIA32TSCAux, want); err != nil {
+		t.Fatalf("WriteMSR: %v", err)
+	}
+
+	got, err := ReadMSR(vcpuFd, MsrIA32TSCAux)
+	if err != nil {
+		t.Fatalf("ReadMSR: %v", err)
+	}
+	if got != want {
+		t.Errorf("ReadMSR(IA32_TSC_AUX) = %#x, want %#x", got, want)
+	}
+}
+
+func TestReadMSRUnsupportedIndexReturnsError(t *testing.T) {
+	kvm, err := NewKVM()
+	if err != nil {
+		t.Skipf("/dev/kvm unavailable or capabilities missing: %v", err)
+	}
+	defer kvm.Close()
+
+	vmFd, err := kvm.CreateVM()
+	if err != nil {
+		t.Fatalf("CreateVM: %v", err)
+	}
+	defer vmFd.Close()
+
+	vcpuFd, err := CreateVCPU(vmFd, 0)
+	if err != nil {
+		t.Fatalf("CreateVCPU: %v", err)
+	}
+	defer vcpuFd.Close()
+
+	const bogusIndex = 0xffffffff
+	if _, err := ReadMSR(vcpuFd, bogusIndex); err == nil {
+		t.Error("ReadMSR of a bogus MSR index returned no error")
+	}
+}
@@ -0,0 +1,28 @@
+package hypervisor
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// GetRegs issues KVM_GET_REGS on vcpuFile, reading back its general
+// purpose registers.
+func GetRegs(vcpuFile *os.File) (KvmRegs, error) {
+	var regs KvmRegs
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_GET_REGS, uintptr(unsafe.Pointer(&regs)))
+	if errno != 0 {
+		return KvmRegs{}, &IoctlError{Name: "KVM_GET_REGS", Errno: errno}
+	}
+	return regs, nil
+}
+
+// SetRegs issues KVM_SET_REGS on vcpuFile, replacing its general purpose
+// registers with regs.
+func SetRegs(vcpuFile *os.File, regs KvmRegs) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_SET_REGS, uintptr(unsafe.Pointer(&regs)))
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_SET_REGS", Errno: errno}
+	}
+	return nil
+}
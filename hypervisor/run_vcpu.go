@@ -0,0 +1,28 @@
+package hypervisor
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ErrInterrupted is returned by RunVCPU when KVM_RUN exits with EINTR
+// rather than a real vmexit — e.g. because a VCPUKicker.Kick woke the
+// thread out of a busy-looping guest. Callers should treat it as benign:
+// re-check pending state changes (a requested reset, poweroff, or
+// interrupt injection) and call RunVCPU again.
+var ErrInterrupted = errors.New("hypervisor: KVM_RUN interrupted")
+
+// RunVCPU issues KVM_RUN on vcpuFile, the fd returned by
+// KVM_CREATE_VCPU. On return, the caller decodes the exit reason out of
+// the VCPU's mmap'd kvm_run page (see ExitReason/DecodeIOExit).
+func RunVCPU(vcpuFile *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vcpuFile.Fd(), KVM_RUN, 0)
+	if errno == syscall.EINTR {
+		return ErrInterrupted
+	}
+	if errno != 0 {
+		return &IoctlError{Name: "KVM_RUN", Errno: errno}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package network provides in-tree, dependency-free implementations of
+// vmm.HostNetInterface for use outside the vmm package's own tests.
+package network
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/BigBossBoolingB/VDATABPro/vmm"
+)
+
+// errClosed is returned by WritePacket/ReadPacket once Close has been
+// called.
+var errClosed = errors.New("network: LoopbackNet is closed")
+
+// queuedPacket is one packet waiting in a LoopbackNet's queue, not yet
+// visible to ReadPacket until clock.Now() reaches readyAt.
+type queuedPacket struct {
+	data    []byte
+	readyAt time.Time
+}
+
+// LoopbackNet is an in-memory vmm.HostNetInterface: every WritePacket is
+// queued to be handed back by a later ReadPacket, instead of touching a
+// real TAP interface or the host network stack. It exists so users
+// exercising an NE2000Device's full TX -> RX path — or writing their own
+// tests against this module — have an in-tree fake to bridge it to,
+// without needing a real TAP interface or CAP_NET_ADMIN.
+//
+// SetDelay and SetDropProbability let a caller simulate an imperfect
+// link; both default to off (no delay, no loss).
+type LoopbackNet struct {
+	mu sync.Mutex
+
+	clock vmm.Clock
+	rand  func() float64 // see SetRandSource
+
+	closed bool
+	queue  []queuedPacket
+
+	delay time.Duration
+	dropP float64
+}
+
+// NewLoopbackNet returns a LoopbackNet with no delay and no packet loss.
+// clock times the optional delay configured with SetDelay; pass
+// vmm.RealClock{} outside of tests.
+func NewLoopbackNet(clock vmm.Clock) *LoopbackNet {
+	return &LoopbackNet{clock: clock, rand: rand.Float64}
+}
+
+// SetDelay configures how long a packet sits in the queue, as measured
+// by clock, before ReadPacket will return it.
+func (l *LoopbackNet) SetDelay(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.delay = d
+}
+
+// SetDropProbability configures the chance, in [0,1], that a packet
+// handed to WritePacket is silently discarded instead of queued.
+func (l *LoopbackNet) SetDropProbability(p float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dropP = p
+}
+
+// SetRandSource overrides the source of randomness SetDropProbability
+// samples from; tests use it to make drop decisions deterministic
+// instead of depending on math/rand's global generator.
+func (l *LoopbackNet) SetRandSource(f func() float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rand = f
+}
+
+// WritePacket implements vmm.HostNetInterface. It queues a copy of pkt
+// to be returned by a later ReadPacket, unless the configured drop
+// probability discards it first.
+func (l *LoopbackNet) WritePacket(pkt []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return errClosed
+	}
+	if l.dropP > 0 && l.rand() < l.dropP {
+		return nil
+	}
+
+	cp := append([]byte(nil), pkt...)
+	l.queue = append(l.queue, queuedPacket{data: cp, readyAt: l.clock.Now().Add(l.delay)})
+	return nil
+}
+
+// ReadPacket returns the oldest queued packet whose delay has elapsed,
+// or (nil, nil) if the queue is empty or the next packet isn't ready
+// yet — callers poll rather than block, unlike vmm.TapDevice's
+// ReadPacket, which blocks until a frame arrives.
+func (l *LoopbackNet) ReadPacket() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, errClosed
+	}
+	if len(l.queue) == 0 || l.clock.Now().Before(l.queue[0].readyAt) {
+		return nil, nil
+	}
+
+	pkt := l.queue[0].data
+	l.queue = l.queue[1:]
+	return pkt, nil
+}
+
+// Close marks the loopback closed and discards any still-queued
+// packets. Further WritePacket/ReadPacket calls return an error.
+func (l *LoopbackNet) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.queue = nil
+	return nil
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BigBossBoolingB/VDATABPro/vmm"
+)
+
+func TestLoopbackNetEchoesWrittenPackets(t *testing.T) {
+	l := NewLoopbackNet(vmm.NewManualClock(time.Unix(0, 0)))
+
+	want := []byte{0xde, 0xad, 0xbe, 0xef}
+	if err := l.WritePacket(want); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	got, err := l.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("ReadPacket = %x, want %x", got, want)
+	}
+
+	if got, err := l.ReadPacket(); err != nil || got != nil {
+		t.Errorf("second ReadPacket = (%x, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestLoopbackNetDelayHoldsPacketsUntilReady(t *testing.T) {
+	clock := vmm.NewManualClock(time.Unix(0, 0))
+	l := NewLoopbackNet(clock)
+	l.SetDelay(10 * time.Millisecond)
+
+	if err := l.WritePacket([]byte{0x01}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if got, err := l.ReadPacket(); err != nil || got != nil {
+		t.Fatalf("ReadPacket before the delay elapsed = (%x, %v), want (nil, nil)", got, err)
+	}
+
+	clock.Advance(10 * time.Millisecond)
+	got, err := l.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if len(got) != 1 || got[0] != 0x01 {
+		t.Errorf("ReadPacket = %x, want [0x01]", got)
+	}
+}
+
+func TestLoopbackNetDropProbabilityDiscardsPackets(t *testing.T) {
+	l := NewLoopbackNet(vmm.NewManualClock(time.Unix(0, 0)))
+	l.SetDropProbability(0.5)
+
+	l.SetRandSource(func() float64 { return 0.1 }) // below the threshold: dropped
+	if err := l.WritePacket([]byte{0x01}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if got, err := l.ReadPacket(); err != nil || got != nil {
+		t.Errorf("ReadPacket after a dropped write = (%x, %v), want (nil, nil)", got, err)
+	}
+
+	l.SetRandSource(func() float64 { return 0.9 }) // above the threshold: kept
+	if err := l.WritePacket([]byte{0x02}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	got, err := l.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if len(got) != 1 || got[0] != 0x02 {
+		t.Errorf("ReadPacket = %x, want [0x02]", got)
+	}
+}
+
+func TestLoopbackNetCloseRejectsFurtherIO(t *testing.T) {
+	l := NewLoopbackNet(vmm.NewManualClock(time.Unix(0, 0)))
+	if err := l.WritePacket([]byte{0x01}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if err := l.WritePacket([]byte{0x02}); !errors.Is(err, errClosed) {
+		t.Errorf("WritePacket after Close = %v, want errClosed", err)
+	}
+	if _, err := l.ReadPacket(); !errors.Is(err, errClosed) {
+		t.Errorf("ReadPacket after Close = %v, want errClosed", err)
+	}
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+)
+
+// GenerateMAC deterministically derives a 6-byte Ethernet address from
+// seed (e.g. a VM's name), for callers that need every guest NIC on a
+// shared bridge to get its own stable address without an operator
+// having to assign one by hand. The same seed always yields the same
+// address, and different seeds are vanishingly unlikely to collide,
+// since the address is taken from a hash of seed.
+//
+// The result always has the locally-administered bit set and the
+// multicast bit clear, so it never collides with a hardware vendor's
+// OUI-assigned range and is always valid as a station address.
+func GenerateMAC(seed []byte) [6]byte {
+	sum := sha256.Sum256(seed)
+	var mac [6]byte
+	copy(mac[:], sum[:6])
+	mac[0] |= 0x02  // locally administered
+	mac[0] &^= 0x01 // unicast
+	return mac
+}
+
+// ValidateMAC rejects a MAC address unsuitable for use as a NIC's
+// station address: the all-zero address, and any multicast/broadcast
+// address (the LSB of the first octet set).
+func ValidateMAC(mac [6]byte) error {
+	if mac == ([6]byte{}) {
+		return fmt.Errorf("network: MAC address is all-zero")
+	}
+	if mac[0]&0x01 != 0 {
+		return fmt.Errorf("network: MAC address %s is multicast, want a unicast station address", net.HardwareAddr(mac[:]))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package network
+
+import "testing"
+
+func TestGenerateMACIsDeterministic(t *testing.T) {
+	a := GenerateMAC([]byte("vm-1"))
+	b := GenerateMAC([]byte("vm-1"))
+	if a != b {
+		t.Errorf("GenerateMAC(%q) = %x and %x, want identical results for the same seed", "vm-1", a, b)
+	}
+
+	if c := GenerateMAC([]byte("vm-2")); c == a {
+		t.Errorf("GenerateMAC(%q) and GenerateMAC(%q) collided at %x", "vm-1", "vm-2", a)
+	}
+}
+
+func TestGenerateMACSetsLocallyAdministeredUnicastBits(t *testing.T) {
+	mac := GenerateMAC([]byte("some-vm-name"))
+	if mac[0]&0x02 == 0 {
+		t.Errorf("GenerateMAC() = %x, want the locally-administered bit set", mac)
+	}
+	if mac[0]&0x01 != 0 {
+		t.Errorf("GenerateMAC() = %x, want the multicast bit clear", mac)
+	}
+}
+
+func TestValidateMACRejectsAllZero(t *testing.T) {
+	if err := ValidateMAC([6]byte{}); err == nil {
+		t.Error("ValidateMAC(all-zero) = nil, want an error")
+	}
+}
+
+func TestValidateMACRejectsMulticast(t *testing.T) {
+	multicast := [6]byte{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}
+	if err := ValidateMAC(multicast); err == nil {
+		t.Error("ValidateMAC(multicast) = nil, want an error")
+	}
+}
+
+func TestValidateMACAcceptsGeneratedAddress(t *testing.T) {
+	mac := GenerateMAC([]byte("vm-1"))
+	if err := ValidateMAC(mac); err != nil {
+		t.Errorf("ValidateMAC(GenerateMAC(...)) = %v, want nil", err)
+	}
+}
+
+func TestValidateMACAcceptsOUIAssignedUnicast(t *testing.T) {
+	mac := [6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	if err := ValidateMAC(mac); err != nil {
+		t.Errorf("ValidateMAC(%x) = %v, want nil", mac, err)
+	}
+}
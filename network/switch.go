@@ -0,0 +1,183 @@
+package network
+
+import (
+	"sync"
+
+	"github.com/BigBossBoolingB/VDATABPro/vmm"
+)
+
+// macAddr is a 6-byte Ethernet address, used as a VirtualSwitch's learning
+// table key.
+type macAddr [6]byte
+
+// defaultPortQueueDepth bounds how many undelivered frames a
+// VirtualSwitchPort will hold for a slow or stalled reader before it
+// starts dropping them. This keeps one VM that's stopped calling
+// ReadPacket from growing the switch's memory use without bound.
+const defaultPortQueueDepth = 64
+
+// VirtualSwitch is an in-memory Ethernet hub/switch: every attached port
+// is a vmm.HostNetInterface, and a frame written to one is delivered to
+// whichever other port(s) it needs to reach. It learns each frame's
+// source MAC against the port it arrived on; a frame whose destination
+// hasn't been learned yet (including broadcast/multicast destinations) is
+// flooded to every other port like a hub, while a frame to a learned
+// unicast destination goes only to that port, like a switch. This lets
+// two NE2000Device instances (or more) exchange frames in-process,
+// without a host TAP interface.
+type VirtualSwitch struct {
+	mu      sync.Mutex
+	ports   []*VirtualSwitchPort
+	learned map[macAddr]*VirtualSwitchPort
+}
+
+// NewVirtualSwitch returns a switch with no ports attached and an empty
+// learning table.
+func NewVirtualSwitch() *VirtualSwitch {
+	return &VirtualSwitch{learned: make(map[macAddr]*VirtualSwitchPort)}
+}
+
+// CreatePort adds a new named port to the switch and returns it. Bridge a
+// NIC's host-side transport to it the same way you would a TapDevice or
+// LoopbackNet. name is only used to identify the port in diagnostics; it
+// doesn't need to be unique.
+func (sw *VirtualSwitch) CreatePort(name string) *VirtualSwitchPort {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	p := &VirtualSwitchPort{sw: sw, name: name, queueDepth: defaultPortQueueDepth}
+	sw.ports = append(sw.ports, p)
+	return p
+}
+
+// Attach adds a new unnamed port to the switch. It's equivalent to
+// CreatePort("").
+func (sw *VirtualSwitch) Attach() *VirtualSwitchPort {
+	return sw.CreatePort("")
+}
+
+// detach removes p from the switch: it stops receiving flooded or
+// unicast frames, and any learning-table entry pointing at it is
+// forgotten so a future frame to its MAC is flooded again instead of
+// vanishing into a closed port.
+func (sw *VirtualSwitch) detach(p *VirtualSwitchPort) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for i, port := range sw.ports {
+		if port == p {
+			sw.ports = append(sw.ports[:i], sw.ports[i+1:]...)
+			break
+		}
+	}
+	for mac, port := range sw.learned {
+		if port == p {
+			delete(sw.learned, mac)
+		}
+	}
+}
+
+// dispatch learns pkt's source MAC against from, then delivers pkt to the
+// learned destination port if its destination MAC is a known unicast
+// address, or floods it to every other port otherwise.
+func (sw *VirtualSwitch) dispatch(from *VirtualSwitchPort, pkt []byte) {
+	sw.mu.Lock()
+	if len(pkt) >= 12 {
+		var src macAddr
+		copy(src[:], pkt[6:12])
+		sw.learned[src] = from
+	}
+
+	var dst *VirtualSwitchPort
+	if len(pkt) >= 6 {
+		var dstMAC macAddr
+		copy(dstMAC[:], pkt[0:6])
+		if dstMAC[0]&0x01 == 0 { // low bit of the first octet marks multicast/broadcast
+			dst = sw.learned[dstMAC]
+		}
+	}
+
+	var targets []*VirtualSwitchPort
+	if dst != nil {
+		targets = []*VirtualSwitchPort{dst}
+	} else {
+		for _, p := range sw.ports {
+			if p != from {
+				targets = append(targets, p)
+			}
+		}
+	}
+	sw.mu.Unlock()
+
+	for _, p := range targets {
+		p.deliver(pkt)
+	}
+}
+
+// VirtualSwitchPort is one attachment point on a VirtualSwitch. It
+// implements vmm.HostNetInterface for the transmit side; ReadPacket polls
+// for frames the switch has delivered to it, the same non-blocking
+// contract as LoopbackNet.ReadPacket (vmm.TapDevice.ReadPacket, by
+// contrast, blocks).
+type VirtualSwitchPort struct {
+	sw   *VirtualSwitch
+	name string
+
+	mu         sync.Mutex
+	queue      [][]byte
+	queueDepth int
+	dropped    uint64
+	closed     bool
+}
+
+var _ vmm.HostNetInterface = (*VirtualSwitchPort)(nil)
+
+// WritePacket implements vmm.HostNetInterface. It hands pkt to the switch
+// for delivery to whichever other port(s) it's addressed to.
+func (p *VirtualSwitchPort) WritePacket(pkt []byte) error {
+	p.sw.dispatch(p, append([]byte(nil), pkt...))
+	return nil
+}
+
+// ReadPacket returns the oldest frame the switch has delivered to this
+// port, or (nil, nil) if none is queued.
+func (p *VirtualSwitchPort) ReadPacket() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		return nil, nil
+	}
+	pkt := p.queue[0]
+	p.queue = p.queue[1:]
+	return pkt, nil
+}
+
+// Dropped returns the number of frames this port has discarded because
+// its queue was full, e.g. because the VM behind it stopped calling
+// ReadPacket.
+func (p *VirtualSwitchPort) Dropped() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// Close removes this port from its switch: it stops receiving further
+// frames and its learned MAC, if any, is forgotten. Frames already queued
+// remain available from ReadPacket.
+func (p *VirtualSwitchPort) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.sw.detach(p)
+	return nil
+}
+
+// deliver queues pkt for a later ReadPacket, dropping it and counting the
+// drop if the port is closed or its queue is already at queueDepth.
+func (p *VirtualSwitchPort) deliver(pkt []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.queue) >= p.queueDepth {
+		p.dropped++
+		return
+	}
+	p.queue = append(p.queue, append([]byte(nil), pkt...))
+}
@@ -0,0 +1,148 @@
+package network
+
+import "testing"
+
+// ethFrame builds a minimal Ethernet II frame: 6-byte destination, 6-byte
+// source, a 2-byte EtherType, and no payload — enough for VirtualSwitch's
+// address-based forwarding.
+func ethFrame(dst, src [6]byte) []byte {
+	frame := make([]byte, 14)
+	copy(frame[0:6], dst[:])
+	copy(frame[6:12], src[:])
+	frame[12], frame[13] = 0x08, 0x00 // EtherType: IPv4
+	return frame
+}
+
+func TestVirtualSwitchFloodsUnknownDestination(t *testing.T) {
+	sw := NewVirtualSwitch()
+	portA := sw.Attach()
+	portB := sw.Attach()
+	portC := sw.Attach()
+
+	macA := [6]byte{0, 0, 0, 0, 0, 0xaa}
+	macB := [6]byte{0, 0, 0, 0, 0, 0xbb}
+
+	if err := portA.WritePacket(ethFrame(macB, macA)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	if got, err := portB.ReadPacket(); err != nil || got == nil {
+		t.Fatalf("portB ReadPacket = (%x, %v), want the flooded frame", got, err)
+	}
+	if got, err := portC.ReadPacket(); err != nil || got == nil {
+		t.Fatalf("portC ReadPacket = (%x, %v), want the flooded frame (destination not yet learned)", got, err)
+	}
+	if got, err := portA.ReadPacket(); err != nil || got != nil {
+		t.Errorf("portA (the sender) ReadPacket = (%x, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestVirtualSwitchUnicastsAfterLearning(t *testing.T) {
+	sw := NewVirtualSwitch()
+	portA := sw.Attach()
+	portB := sw.Attach()
+	portC := sw.Attach()
+
+	macA := [6]byte{0, 0, 0, 0, 0, 0xaa}
+	macB := [6]byte{0, 0, 0, 0, 0, 0xbb}
+
+	// A's first frame teaches the switch that macA lives on portA.
+	if err := portA.WritePacket(ethFrame(macB, macA)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if _, err := portB.ReadPacket(); err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if _, err := portC.ReadPacket(); err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+
+	// B replies to A. macA is now learned, so this should reach only portA.
+	if err := portB.WritePacket(ethFrame(macA, macB)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	got, err := portA.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if got == nil {
+		t.Fatal("portA did not receive the unicast reply")
+	}
+
+	if got, err := portC.ReadPacket(); err != nil || got != nil {
+		t.Errorf("portC ReadPacket = (%x, %v), want (nil, nil): the reply should be unicast, not flooded", got, err)
+	}
+}
+
+func TestVirtualSwitchPortDropsFramesWhenQueueFull(t *testing.T) {
+	sw := NewVirtualSwitch()
+	portA := sw.CreatePort("a")
+	portB := sw.CreatePort("b")
+
+	macA := [6]byte{0, 0, 0, 0, 0, 0xaa}
+	macB := [6]byte{0, 0, 0, 0, 0, 0xbb}
+
+	// Flood portB's queue past its depth without ever reading from it.
+	for i := 0; i < defaultPortQueueDepth+5; i++ {
+		if err := portA.WritePacket(ethFrame(macB, macA)); err != nil {
+			t.Fatalf("WritePacket: %v", err)
+		}
+	}
+
+	if got, want := portB.Dropped(), uint64(5); got != want {
+		t.Errorf("portB.Dropped() = %d, want %d", got, want)
+	}
+
+	drained := 0
+	for {
+		pkt, err := portB.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket: %v", err)
+		}
+		if pkt == nil {
+			break
+		}
+		drained++
+	}
+	if drained != defaultPortQueueDepth {
+		t.Errorf("drained %d frames, want %d (the queue depth)", drained, defaultPortQueueDepth)
+	}
+}
+
+func TestVirtualSwitchPortCloseStopsDeliveryAndForgetsLearning(t *testing.T) {
+	sw := NewVirtualSwitch()
+	portA := sw.CreatePort("a")
+	portB := sw.CreatePort("b")
+	portC := sw.CreatePort("c")
+
+	macA := [6]byte{0, 0, 0, 0, 0, 0xaa}
+	macB := [6]byte{0, 0, 0, 0, 0, 0xbb}
+
+	// Learn macB against portB, then close it.
+	if err := portB.WritePacket(ethFrame(macA, macB)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if _, err := portA.ReadPacket(); err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if _, err := portC.ReadPacket(); err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+
+	if err := portB.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A frame to the now-closed port's MAC should flood instead of
+	// vanishing, since the learning-table entry was forgotten on Close.
+	if err := portA.WritePacket(ethFrame(macB, macA)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if got, err := portC.ReadPacket(); err != nil || got == nil {
+		t.Fatalf("portC ReadPacket = (%x, %v), want the flooded frame", got, err)
+	}
+	if got, err := portB.ReadPacket(); err != nil || got != nil {
+		t.Errorf("closed portB ReadPacket = (%x, %v), want (nil, nil)", got, err)
+	}
+}
@@ -0,0 +1,111 @@
+package vmm
+
+import "sync"
+
+// systemControlPort is the "fast A20/reset" port found on nearly every
+// PC chipset since the PS/2.
+const systemControlPort = 0x92
+
+// Port 0x92 bits.
+const (
+	sysCtrlFastReset = 1 << 0
+	sysCtrlA20Enable = 1 << 1
+)
+
+// a20Mask masks a guest physical address as if the A20 line were
+// deasserted: bit 20 is forced to 0, wrapping any access above 1MB back
+// into the first megabyte.
+const a20Mask = ^uint64(1 << 20)
+
+// SystemControlPortDevice emulates port 0x92: bit 1 enables the A20 gate,
+// bit 0 triggers a fast CPU reset.
+type SystemControlPortDevice struct {
+	mu      sync.Mutex
+	a20     *A20Gate
+	onReset func()
+}
+
+// NewSystemControlPortDevice returns a device wired to gate's A20 state
+// and to onReset for fast-reset requests.
+func NewSystemControlPortDevice(gate *A20Gate, onReset func()) *SystemControlPortDevice {
+	return &SystemControlPortDevice{a20: gate, onReset: onReset}
+}
+
+// Ports implements PioDevice.
+func (s *SystemControlPortDevice) Ports() []uint16 {
+	return []uint16{systemControlPort}
+}
+
+// HandleIO implements PioDevice.
+func (s *SystemControlPortDevice) HandleIO(port uint16, data []byte, write bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if write {
+		if len(data) == 0 {
+			return nil
+		}
+		s.a20.SetEnabled(data[0]&sysCtrlA20Enable != 0)
+		if data[0]&sysCtrlFastReset != 0 && s.onReset != nil {
+			s.onReset()
+		}
+		return nil
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	var v uint8
+	if s.a20.Enabled() {
+		v |= sysCtrlA20Enable
+	}
+	data[0] = v
+	return nil
+}
+
+// Reset implements PioDevice. Real hardware defaults A20 to enabled on
+// most BIOSes' behalf by the time the guest runs, so we mirror that.
+func (s *SystemControlPortDevice) Reset() {
+	s.a20.SetEnabled(true)
+}
+
+// Name implements PioDevice.
+func (s *SystemControlPortDevice) Name() string { return "a20" }
+
+// A20Gate tracks whether the guest has the A20 address line enabled. It is
+// shared between the devices that can toggle it (port 0x92, the keyboard
+// controller's output port) and the guest memory accessor, which must
+// wrap addresses above 1MB while A20 is disabled.
+type A20Gate struct {
+	mu      sync.RWMutex
+	enabled bool
+}
+
+// NewA20Gate returns a gate with A20 enabled, matching real firmware
+// defaults.
+func NewA20Gate() *A20Gate {
+	return &A20Gate{enabled: true}
+}
+
+// Enabled reports whether A20 is currently enabled.
+func (g *A20Gate) Enabled() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.enabled
+}
+
+// SetEnabled sets the A20 state.
+func (g *A20Gate) SetEnabled(enabled bool) {
+	g.mu.Lock()
+	g.enabled = enabled
+	g.mu.Unlock()
+}
+
+// Mask applies A20 wrapping to a guest physical address: when A20 is
+// disabled, bit 20 is forced low.
+func (g *A20Gate) Mask(addr uint64) uint64 {
+	if g.Enabled() {
+		return addr
+	}
+	return addr & a20Mask
+}
@@ -0,0 +1,76 @@
+package vmm
+
+import "testing"
+
+func TestSystemControlPortTogglesA20(t *testing.T) {
+	gate := NewA20Gate()
+	dev := NewSystemControlPortDevice(gate, nil)
+
+	if err := dev.HandleIO(systemControlPort, []byte{0x00}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if gate.Enabled() {
+		t.Fatalf("A20 still enabled after clearing bit 1")
+	}
+
+	buf := make([]byte, 1)
+	if err := dev.HandleIO(systemControlPort, buf, false); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if buf[0]&sysCtrlA20Enable != 0 {
+		t.Errorf("read-back reported A20 enabled")
+	}
+
+	if err := dev.HandleIO(systemControlPort, []byte{sysCtrlA20Enable}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if !gate.Enabled() {
+		t.Fatalf("A20 not enabled after setting bit 1")
+	}
+}
+
+func TestSystemControlPortWrite0x02EnablesA20(t *testing.T) {
+	gate := NewA20Gate()
+	gate.SetEnabled(false)
+	dev := NewSystemControlPortDevice(gate, nil)
+
+	if err := dev.HandleIO(systemControlPort, []byte{0x02}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if !gate.Enabled() {
+		t.Fatalf("A20 not enabled after writing 0x02 to port 0x92")
+	}
+}
+
+func TestKeyboardControllerCommand0xD1TogglesA20(t *testing.T) {
+	gate := NewA20Gate()
+	kbc := NewKeyboardControllerDevice(nil, gate)
+
+	if err := kbc.HandleIO(kbcPortStatus, []byte{kbcCmdWriteOutputPort}, true); err != nil {
+		t.Fatalf("write command: %v", err)
+	}
+	if err := kbc.HandleIO(kbcPortData, []byte{kbcOutputReset}, true); err != nil { // A20 bit clear
+		t.Fatalf("write output port: %v", err)
+	}
+	if gate.Enabled() {
+		t.Fatalf("A20 still enabled after output port write with bit 1 clear")
+	}
+}
+
+func TestA20GateMasksAddressesWhenDisabled(t *testing.T) {
+	gate := NewA20Gate()
+	gate.SetEnabled(false)
+
+	// 0x10_0000 (1MB) with bit 20 cleared wraps to 0.
+	if got, want := gate.Mask(0x100000), uint64(0); got != want {
+		t.Errorf("Mask(0x100000) = %#x, want %#x", got, want)
+	}
+	if got, want := gate.Mask(0x100010), uint64(0x10); got != want {
+		t.Errorf("Mask(0x100010) = %#x, want %#x", got, want)
+	}
+
+	gate.SetEnabled(true)
+	if got, want := gate.Mask(0x100010), uint64(0x100010); got != want {
+		t.Errorf("Mask with A20 enabled = %#x, want %#x (no wrap)", got, want)
+	}
+}
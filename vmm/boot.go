@@ -0,0 +1,283 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// Boot memory layout constants for the identity-mapped page tables built
+// by initRegisters. These are the defaults; BootConfig.PageTableBase
+// moves all three.
+const (
+	pml4Base = 0x9000
+	pdptBase = 0xa000
+	pdBase   = 0xb000
+)
+
+// Long-mode boot memory layout: a separate range from the 32-bit tables
+// above, since initLongModeRegisters builds a full 4-level tree instead
+// of reserving room for one. lmPDBase is followed by one 4KB PD table
+// per GB of configured RAM, allocated contiguously by
+// buildLongModePageTables.
+const (
+	lmPML4Base = 0xc000
+	lmPDPTBase = 0xd000
+	lmPDBase   = 0xe000
+)
+
+const (
+	pageSize2MB = 2 << 20
+	pageSize1GB = 1 << 30
+
+	pageTableEntries = 512 // entries per 4KB PML4/PDPT/PD table
+
+	pageFlagPresent = 1 << 0
+	pageFlagWrite   = 1 << 1
+	pageFlagPS      = 1 << 7 // leaf entry: maps a 2MB (PD) or 1GB (PDPT) page directly
+)
+
+// BootConfig configures where initRegisters places the identity-mapped
+// page-table structures a 32-bit protected-mode boot reserves room for.
+// The zero value reproduces this package's long-standing defaults
+// (pml4Base/pdptBase/pdBase), so existing callers that never construct a
+// BootConfig see no change.
+//
+// initRegisters never builds an in-memory GDT for the CPU to walk — CS,
+// DS and friends are programmed directly via KVM_SET_SREGS, bypassing
+// the descriptor-table lookup a real boot loader would go through — so
+// there is no separate GDT base address for this type to hold.
+type BootConfig struct {
+	// PageTableBase is where the PML4 table is reserved; the PDPT and PD
+	// tables follow it at PageTableBase+0x1000 and PageTableBase+0x2000,
+	// the same 4KB spacing pml4Base/pdptBase/pdBase have always used.
+	// Zero means pml4Base.
+	PageTableBase uint64
+
+	// LongModePageTableBase is initLongModeRegisters' equivalent of
+	// PageTableBase: where its PML4 table is written, with the PDPT at
+	// LongModePageTableBase+0x1000 and the first PD table at
+	// LongModePageTableBase+0x2000 (see buildLongModePageTables for how
+	// many PD tables follow it). Zero means lmPML4Base.
+	LongModePageTableBase uint64
+}
+
+// pageTableBase returns cfg's configured PML4 base, or pml4Base if unset.
+func (cfg BootConfig) pageTableBase() uint64 {
+	if cfg.PageTableBase == 0 {
+		return pml4Base
+	}
+	return cfg.PageTableBase
+}
+
+// pdptBase and pdBase return cfg's configured PDPT/PD bases, following
+// pageTableBase at the fixed 4KB spacing described on BootConfig.
+func (cfg BootConfig) pdptBase() uint64 { return cfg.pageTableBase() + 0x1000 }
+func (cfg BootConfig) pdBase() uint64   { return cfg.pageTableBase() + 0x2000 }
+
+// longModePageTableBase returns cfg's configured long-mode PML4 base, or
+// lmPML4Base if unset.
+func (cfg BootConfig) longModePageTableBase() uint64 {
+	if cfg.LongModePageTableBase == 0 {
+		return lmPML4Base
+	}
+	return cfg.LongModePageTableBase
+}
+
+// longModePDPTBase returns cfg's configured long-mode PDPT base,
+// following longModePageTableBase at the fixed 4KB spacing described on
+// BootConfig.
+func (cfg BootConfig) longModePDPTBase() uint64 { return cfg.longModePageTableBase() + 0x1000 }
+
+// longModePDBase returns cfg's configured long-mode PD base: the first
+// of the (possibly several) PD tables buildLongModePageTables writes,
+// one per gigabyte of RAM.
+func (cfg BootConfig) longModePDBase() uint64 { return cfg.longModePageTableBase() + 0x2000 }
+
+// ValidateLongModeBootConfig returns an error if cfg's long-mode
+// page-table region -- the PML4/PDPT plus one 4KB PD table per gigabyte
+// of ramSize, starting at cfg.longModePageTableBase() -- overlaps the
+// boot image occupying [imageStart, imageStart+imageLen).
+func ValidateLongModeBootConfig(cfg BootConfig, imageStart, imageLen, ramSize uint64) error {
+	numPDs := (ramSize + pageSize1GB - 1) / pageSize1GB
+	if numPDs == 0 {
+		numPDs = 1
+	}
+	tableStart := cfg.longModePageTableBase()
+	tableEnd := cfg.longModePDBase() + numPDs*dirtyPageSize
+	imageEnd := imageStart + imageLen
+	if imageStart < tableEnd && tableStart < imageEnd {
+		return fmt.Errorf("vmm: BootConfig: long-mode page tables at [%#x,%#x) overlap the boot image at [%#x,%#x)",
+			tableStart, tableEnd, imageStart, imageEnd)
+	}
+	return nil
+}
+
+// ValidateBootConfig returns an error if cfg's page-table region,
+// [cfg.pageTableBase(), pdBase()+dirtyPageSize), overlaps the boot image
+// occupying [imageStart, imageStart+imageLen) — the case a default
+// PageTableBase of 0x9000 handles today only because a boot image is
+// assumed to fit below it, which a large image or a custom load address
+// could violate silently.
+func ValidateBootConfig(cfg BootConfig, imageStart, imageLen uint64) error {
+	tableStart, tableEnd := cfg.pageTableBase(), cfg.pdBase()+dirtyPageSize
+	imageEnd := imageStart + imageLen
+	if imageStart < tableEnd && tableStart < imageEnd {
+		return fmt.Errorf("vmm: BootConfig: page tables at [%#x,%#x) overlap the boot image at [%#x,%#x)",
+			tableStart, tableEnd, imageStart, imageEnd)
+	}
+	return nil
+}
+
+// initRegisters populates vcpu's special registers for guest entry: flat
+// segments, CR0 with protected mode enabled, and CR3 pointing at the
+// identity-mapped page-directory structures reserved at cfg's configured
+// base (see BootConfig).
+func initRegisters(vcpu hypervisor.VCPU, cfg BootConfig) error {
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		return err
+	}
+
+	flatCode := hypervisor.KvmSegment{Base: 0, Limit: 0xffffffff, Selector: 1 << 3, Type: 0xb, Present: 1, DPL: 0, DB: 1, S: 1, L: 0, G: 1}
+	flatData := hypervisor.KvmSegment{Base: 0, Limit: 0xffffffff, Selector: 2 << 3, Type: 0x3, Present: 1, DPL: 0, DB: 1, S: 1, L: 0, G: 1}
+
+	sregs.CS = flatCode
+	sregs.DS = flatData
+	sregs.ES = flatData
+	sregs.FS = flatData
+	sregs.GS = flatData
+	sregs.SS = flatData
+
+	sregs.CR0 |= hypervisor.CR0_PE
+	sregs.CR3 = cfg.pageTableBase()
+
+	return vcpu.SetSregs(sregs)
+}
+
+// initMSRs programs the handful of MSRs real firmware sets up before a
+// guest OS ever runs: IA32_MISC_ENABLE, cleared to an all-features-off
+// value since this emulator doesn't back any of its bits, and
+// IA32_TSC, rebased to tscOffset so the guest's RDTSC starts counting
+// from a known point (0 for a normal boot) rather than wherever KVM
+// happened to initialize it.
+func initMSRs(vcpu hypervisor.VCPU, tscOffset uint64) error {
+	if err := vcpu.SetMSR(hypervisor.MsrIA32MiscEnable, 0); err != nil {
+		return err
+	}
+	return vcpu.SetMSR(hypervisor.MsrIA32TSC, tscOffset)
+}
+
+// initLongModeRegisters brings a VCPU up directly in 64-bit long mode,
+// as an alternative to initRegisters' 32-bit protected-mode entry: it
+// builds an identity-mapped 4-level page-table tree covering ramSize
+// bytes of guest RAM, installs a 64-bit code segment alongside flat data
+// segments, and enables PAE, long mode, and paging before pointing RIP
+// at entryPoint. This mirrors what the Linux 64-bit boot protocol
+// expects a loader to have already done by the time the kernel's entry
+// point runs, so a guest built this way needs no real-mode or 32-bit
+// bootstrap stage of its own. cfg's LongModePageTableBase (see
+// BootConfig) selects where the page tables land; the zero value
+// reproduces this package's long-standing lmPML4Base default.
+func initLongModeRegisters(vcpu hypervisor.VCPU, mem GuestMemoryAccessor, ramSize, entryPoint uint64, cfg BootConfig) error {
+	if err := buildLongModePageTables(mem, ramSize, cfg); err != nil {
+		return err
+	}
+
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		return err
+	}
+
+	longCode := hypervisor.KvmSegment{Base: 0, Limit: 0xffffffff, Selector: 1 << 3, Type: 0xb, Present: 1, DPL: 0, DB: 0, S: 1, L: 1, G: 1}
+	flatData := hypervisor.KvmSegment{Base: 0, Limit: 0xffffffff, Selector: 2 << 3, Type: 0x3, Present: 1, DPL: 0, DB: 1, S: 1, L: 0, G: 1}
+
+	sregs.CS = longCode
+	sregs.DS = flatData
+	sregs.ES = flatData
+	sregs.FS = flatData
+	sregs.GS = flatData
+	sregs.SS = flatData
+
+	sregs.CR3 = cfg.longModePageTableBase()
+	sregs.CR4 |= hypervisor.CR4_PAE
+	sregs.EFER |= hypervisor.EFER_LME
+	sregs.CR0 |= hypervisor.CR0_PE | hypervisor.CR0_PG
+
+	if err := vcpu.SetSregs(sregs); err != nil {
+		return err
+	}
+
+	regs, err := vcpu.GetRegs()
+	if err != nil {
+		return err
+	}
+	regs.RIP = entryPoint
+	return vcpu.SetRegs(regs)
+}
+
+// buildLongModePageTables writes an identity-mapped 4-level page-table
+// tree into guest memory at cfg's configured long-mode base (lmPML4Base
+// by default): one PML4 table with a single entry, one PDPT table with
+// one entry per gigabyte of ramSize, and one PD table per PDPT entry
+// mapping that gigabyte with 512 2MB pages. 2MB pages mean no
+// fourth-level PT is needed.
+func buildLongModePageTables(mem GuestMemoryAccessor, ramSize uint64, cfg BootConfig) error {
+	numPDs := (ramSize + pageSize1GB - 1) / pageSize1GB
+	if numPDs == 0 {
+		numPDs = 1
+	}
+
+	lmPML4, lmPDPT, lmPD := cfg.longModePageTableBase(), cfg.longModePDPTBase(), cfg.longModePDBase()
+
+	pml4, err := mem.GuestSlice(lmPML4, dirtyPageSize)
+	if err != nil {
+		return err
+	}
+	clearPageTable(pml4)
+	writePageTableEntry(pml4, 0, lmPDPT, pageFlagPresent|pageFlagWrite)
+
+	pdpt, err := mem.GuestSlice(lmPDPT, dirtyPageSize)
+	if err != nil {
+		return err
+	}
+	clearPageTable(pdpt)
+
+	remaining := ramSize
+	for i := uint64(0); i < numPDs; i++ {
+		thisPDBase := lmPD + i*dirtyPageSize
+		writePageTableEntry(pdpt, i, thisPDBase, pageFlagPresent|pageFlagWrite)
+
+		pd, err := mem.GuestSlice(thisPDBase, dirtyPageSize)
+		if err != nil {
+			return err
+		}
+		clearPageTable(pd)
+
+		for e := uint64(0); e < pageTableEntries && remaining > 0; e++ {
+			phys := i*pageSize1GB + e*pageSize2MB
+			writePageTableEntry(pd, e, phys, pageFlagPresent|pageFlagWrite|pageFlagPS)
+			if remaining < pageSize2MB {
+				remaining = 0
+			} else {
+				remaining -= pageSize2MB
+			}
+		}
+	}
+	return nil
+}
+
+// clearPageTable zeroes every entry so unused slots read back
+// not-present.
+func clearPageTable(table []byte) {
+	for i := range table {
+		table[i] = 0
+	}
+}
+
+// writePageTableEntry writes a PML4/PDPT/PD entry: addr masked down to
+// its natural alignment, ORed with flags.
+func writePageTableEntry(table []byte, index, addr, flags uint64) {
+	binary.LittleEndian.PutUint64(table[index*8:index*8+8], (addr&^0xfff)|flags)
+}
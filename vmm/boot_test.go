@@ -0,0 +1,196 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+func TestInitRegistersSetsCR3(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+
+	if err := initRegisters(vcpu, BootConfig{}); err != nil {
+		t.Fatalf("initRegisters: %v", err)
+	}
+
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		t.Fatalf("GetSregs: %v", err)
+	}
+	if got, want := sregs.CR3, uint64(pml4Base); got != want {
+		t.Errorf("CR3 = %#x, want %#x", got, want)
+	}
+	if sregs.CR0&hypervisor.CR0_PE == 0 {
+		t.Errorf("CR0.PE not set")
+	}
+}
+
+// TestInitRegistersHonorsCustomPageTableBase checks that a non-default
+// BootConfig.PageTableBase is threaded through to CR3, and that it
+// passes ValidateBootConfig against a boot image that would have
+// collided with the default 0x9000 base.
+func TestInitRegistersHonorsCustomPageTableBase(t *testing.T) {
+	cfg := BootConfig{PageTableBase: 0x20000}
+
+	// A 0x9500-byte image loaded at 0 would run right into the default
+	// page-table base (0x9000); it must not collide with the custom one.
+	if err := ValidateBootConfig(cfg, 0, 0x9500); err != nil {
+		t.Fatalf("ValidateBootConfig: %v", err)
+	}
+
+	vcpu := &hypervisor.FakeVCPU{}
+	if err := initRegisters(vcpu, cfg); err != nil {
+		t.Fatalf("initRegisters: %v", err)
+	}
+
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		t.Fatalf("GetSregs: %v", err)
+	}
+	if got, want := sregs.CR3, uint64(0x20000); got != want {
+		t.Errorf("CR3 = %#x, want %#x", got, want)
+	}
+	if sregs.CR0&hypervisor.CR0_PE == 0 {
+		t.Errorf("CR0.PE not set")
+	}
+}
+
+// TestValidateBootConfigRejectsOverlappingImage checks the default
+// BootConfig against an image large enough to run into pml4Base, the
+// scenario that silently corrupted the reserved region before this
+// existed.
+func TestValidateBootConfigRejectsOverlappingImage(t *testing.T) {
+	if err := ValidateBootConfig(BootConfig{}, 0, pml4Base+0x500); err == nil {
+		t.Fatal("ValidateBootConfig: got nil error, want one for an image reaching into pml4Base")
+	}
+	if err := ValidateBootConfig(BootConfig{}, 0, pml4Base); err != nil {
+		t.Errorf("ValidateBootConfig: %v, want nil for an image that fits below pml4Base", err)
+	}
+}
+
+func TestInitMSRsSetsMiscEnableAndTSCOffset(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+
+	if err := initMSRs(vcpu, 0x1234); err != nil {
+		t.Fatalf("initMSRs: %v", err)
+	}
+
+	if got, err := vcpu.GetMSR(hypervisor.MsrIA32MiscEnable); err != nil || got != 0 {
+		t.Errorf("IA32_MISC_ENABLE = %#x, %v; want 0, nil", got, err)
+	}
+	if got, err := vcpu.GetMSR(hypervisor.MsrIA32TSC); err != nil || got != 0x1234 {
+		t.Errorf("IA32_TSC = %#x, %v; want %#x, nil", got, err, 0x1234)
+	}
+}
+
+func TestBuildLongModePageTablesIdentityMaps1GBGuest(t *testing.T) {
+	mem := &fakeGuestMemoryAccessor{mem: make([]byte, lmPDBase+dirtyPageSize)}
+
+	if err := buildLongModePageTables(mem, pageSize1GB, BootConfig{}); err != nil {
+		t.Fatalf("buildLongModePageTables: %v", err)
+	}
+
+	pml4Entry := binary.LittleEndian.Uint64(mem.mem[lmPML4Base : lmPML4Base+8])
+	if got, want := pml4Entry&^0xfff, uint64(lmPDPTBase); got != want {
+		t.Errorf("PML4[0] address = %#x, want %#x", got, want)
+	}
+	if pml4Entry&(pageFlagPresent|pageFlagWrite) != pageFlagPresent|pageFlagWrite {
+		t.Errorf("PML4[0] flags = %#x, want present|write set", pml4Entry)
+	}
+	if pml4Entry&pageFlagPS != 0 {
+		t.Errorf("PML4[0] has PS set, want a table pointer, not a leaf")
+	}
+
+	pdptEntry := binary.LittleEndian.Uint64(mem.mem[lmPDPTBase : lmPDPTBase+8])
+	if got, want := pdptEntry&^0xfff, uint64(lmPDBase); got != want {
+		t.Errorf("PDPT[0] address = %#x, want %#x", got, want)
+	}
+	for i := 1; i < pageTableEntries; i++ {
+		off := lmPDPTBase + i*8
+		if e := binary.LittleEndian.Uint64(mem.mem[off : off+8]); e != 0 {
+			t.Fatalf("PDPT[%d] = %#x, want 0 (only one GB of RAM configured)", i, e)
+		}
+	}
+
+	for i := 0; i < pageTableEntries; i++ {
+		off := lmPDBase + i*8
+		entry := binary.LittleEndian.Uint64(mem.mem[off : off+8])
+		if entry&(pageFlagPresent|pageFlagWrite|pageFlagPS) != pageFlagPresent|pageFlagWrite|pageFlagPS {
+			t.Fatalf("PD[%d] flags = %#x, want present|write|PS set", i, entry)
+		}
+		if got, want := entry&^0xfff, uint64(i)*pageSize2MB; got != want {
+			t.Fatalf("PD[%d] address = %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+// TestBuildLongModePageTablesHonorsCustomLongModePageTableBase checks that
+// a non-default BootConfig.LongModePageTableBase is threaded through: the
+// PML4 entry lands at the custom base and points at the correspondingly
+// offset PDPT, rather than the lmPML4Base/lmPDPTBase defaults.
+func TestBuildLongModePageTablesHonorsCustomLongModePageTableBase(t *testing.T) {
+	cfg := BootConfig{LongModePageTableBase: 0x200000}
+	mem := &fakeGuestMemoryAccessor{mem: make([]byte, cfg.longModePDBase()+dirtyPageSize)}
+
+	if err := buildLongModePageTables(mem, pageSize1GB, cfg); err != nil {
+		t.Fatalf("buildLongModePageTables: %v", err)
+	}
+
+	pml4Base, pdptBase := cfg.longModePageTableBase(), cfg.longModePDPTBase()
+	pml4Entry := binary.LittleEndian.Uint64(mem.mem[pml4Base : pml4Base+8])
+	if got, want := pml4Entry&^0xfff, pdptBase; got != want {
+		t.Errorf("PML4[0] address = %#x, want %#x", got, want)
+	}
+	if pml4Entry&(pageFlagPresent|pageFlagWrite) != pageFlagPresent|pageFlagWrite {
+		t.Errorf("PML4[0] flags = %#x, want present|write set", pml4Entry)
+	}
+}
+
+// TestValidateLongModeBootConfigRejectsOverlappingImage mirrors
+// TestValidateBootConfigRejectsOverlappingImage for the long-mode path.
+func TestValidateLongModeBootConfigRejectsOverlappingImage(t *testing.T) {
+	if err := ValidateLongModeBootConfig(BootConfig{}, 0, lmPML4Base+0x500, pageSize1GB); err == nil {
+		t.Fatal("ValidateLongModeBootConfig: got nil error, want one for an image reaching into lmPML4Base")
+	}
+	if err := ValidateLongModeBootConfig(BootConfig{}, 0, lmPML4Base, pageSize1GB); err != nil {
+		t.Errorf("ValidateLongModeBootConfig: %v, want nil for an image that fits below lmPML4Base", err)
+	}
+}
+
+func TestInitLongModeRegistersEnablesPagingAndSetsEntryPoint(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+	mem := &fakeGuestMemoryAccessor{mem: make([]byte, lmPDBase+dirtyPageSize)}
+
+	if err := initLongModeRegisters(vcpu, mem, pageSize1GB, 0x100000, BootConfig{}); err != nil {
+		t.Fatalf("initLongModeRegisters: %v", err)
+	}
+
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		t.Fatalf("GetSregs: %v", err)
+	}
+	if got, want := sregs.CR3, uint64(lmPML4Base); got != want {
+		t.Errorf("CR3 = %#x, want %#x", got, want)
+	}
+	if sregs.CR4&hypervisor.CR4_PAE == 0 {
+		t.Errorf("CR4.PAE not set")
+	}
+	if sregs.EFER&hypervisor.EFER_LME == 0 {
+		t.Errorf("EFER.LME not set")
+	}
+	if sregs.CR0&(hypervisor.CR0_PE|hypervisor.CR0_PG) != hypervisor.CR0_PE|hypervisor.CR0_PG {
+		t.Errorf("CR0 = %#x, want PE and PG both set", sregs.CR0)
+	}
+	if sregs.CS.L != 1 || sregs.CS.DB != 0 {
+		t.Errorf("CS.L = %d, CS.DB = %d, want L=1, DB=0 for a 64-bit code segment", sregs.CS.L, sregs.CS.DB)
+	}
+
+	regs, err := vcpu.GetRegs()
+	if err != nil {
+		t.Fatalf("GetRegs: %v", err)
+	}
+	if got, want := regs.RIP, uint64(0x100000); got != want {
+		t.Errorf("RIP = %#x, want %#x", got, want)
+	}
+}
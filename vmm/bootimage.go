@@ -0,0 +1,51 @@
+package vmm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrBootImageNotFound is returned by LoadBootImage when path doesn't
+// exist.
+var ErrBootImageNotFound = errors.New("vmm: boot image not found")
+
+// ErrBootImageTooLarge is returned by LoadBootImage when the file at
+// path is larger than the maxSize it was given.
+var ErrBootImageTooLarge = errors.New("vmm: boot image too large")
+
+// LoadBootImage reads the file at path into guest memory at start, the
+// same as LoadMemory, except it stats the file first so an oversized
+// image is rejected before any of it is written, and a missing file is
+// reported as ErrBootImageNotFound instead of a bare os.ErrNotExist a
+// caller would otherwise have to know to unwrap. maxSize is typically
+// the size of the reserved RAM region the boot image is expected to fit
+// within; callers that don't need that check can call LoadMemory
+// directly.
+func (vm *VirtualMachine) LoadBootImage(path string, start, maxSize uint64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrBootImageNotFound, path)
+		}
+		return fmt.Errorf("vmm: LoadBootImage: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("vmm: LoadBootImage: %w", err)
+	}
+	if uint64(info.Size()) > maxSize {
+		return fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrBootImageTooLarge, path, info.Size(), maxSize)
+	}
+	// A size check alone doesn't catch an image that fits within
+	// maxSize but still runs into the identity-mapped page tables (or
+	// any other fixed structure initRegisters/InstallGuestParams
+	// depends on) sitting somewhere inside that range.
+	if err := checkNotReserved(start, uint64(info.Size())); err != nil {
+		return fmt.Errorf("vmm: LoadBootImage: %w", err)
+	}
+
+	return vm.LoadMemory(f, start)
+}
@@ -0,0 +1,93 @@
+package vmm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadBootImageWritesFileIntoGuestMemory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "boot_pm.bin")
+	content := []byte{0xde, 0xad, 0xbe, 0xef}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	mem := NewMemoryLayout()
+	if err := mem.AddSlot(0x1000, make([]byte, 0x1000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	vm := &VirtualMachine{}
+	vm.SetMemoryLayout(mem)
+
+	if err := vm.LoadBootImage(path, 0x1000, uint64(len(content))); err != nil {
+		t.Fatalf("LoadBootImage: %v", err)
+	}
+
+	got := make([]byte, len(content))
+	if err := mem.ReadAt(got, 0x1000); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("guest memory = % x, want % x", got, content)
+	}
+}
+
+func TestLoadBootImageMissingFileIsErrBootImageNotFound(t *testing.T) {
+	vm := &VirtualMachine{}
+	vm.SetMemoryLayout(NewMemoryLayout())
+
+	err := vm.LoadBootImage(filepath.Join(t.TempDir(), "does-not-exist.bin"), 0, 0x1000)
+	if !errors.Is(err, ErrBootImageNotFound) {
+		t.Errorf("LoadBootImage error = %v, want ErrBootImageNotFound", err)
+	}
+}
+
+func TestLoadBootImageTooLargeIsErrBootImageTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "boot_pm.bin")
+	if err := os.WriteFile(path, make([]byte, 0x2000), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	vm := &VirtualMachine{}
+	vm.SetMemoryLayout(NewMemoryLayout())
+
+	err := vm.LoadBootImage(path, 0, 0x1000)
+	if !errors.Is(err, ErrBootImageTooLarge) {
+		t.Errorf("LoadBootImage error = %v, want ErrBootImageTooLarge", err)
+	}
+}
+
+// TestLoadBootImageOverlappingPageTablesErrors checks that an image
+// small enough to pass the maxSize check, but positioned so it still
+// runs into the identity-mapped page tables initRegisters depends on,
+// is rejected rather than silently corrupting them.
+func TestLoadBootImageOverlappingPageTablesErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "boot_pm.bin")
+	// Starting at 0x8000 and running 0x1500 bytes reaches 0x9500,
+	// well inside the reserved [pml4Base, pml4Base+0x3000) range.
+	content := make([]byte, 0x1500)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	mem := NewMemoryLayout()
+	if err := mem.AddSlot(0x8000, make([]byte, 0x4000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	vm := &VirtualMachine{}
+	vm.SetMemoryLayout(mem)
+
+	err := vm.LoadBootImage(path, 0x8000, uint64(len(content))*2)
+	if err == nil {
+		t.Fatal("LoadBootImage: got nil error, want one for overlapping the page tables")
+	}
+	if !strings.Contains(err.Error(), "identity-mapped page tables") {
+		t.Errorf("LoadBootImage error = %q, want it to name the identity-mapped page tables", err.Error())
+	}
+}
@@ -0,0 +1,226 @@
+package vmm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source shared by devices with real-time behavior:
+// the RTC's date/time registers, the PIT's counters, serial FIFO pacing,
+// and NE2000's interrupt-moderation deadline. Production code uses
+// RealClock; tests use ManualClock so device timing becomes a
+// deterministic Advance() call rather than a real sleep.
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc schedules fn to run once, after d has elapsed. Unlike
+	// Now(), which every device polls lazily, this is for the rare case
+	// where a device must notice an elapsed deadline with nothing else
+	// prompting it to check (e.g. no further register access), and so
+	// needs an actual callback rather than a value to compare against.
+	AfterFunc(d time.Duration, fn func())
+}
+
+// RealClock is the default Clock, backed by time.Now and time.AfterFunc.
+type RealClock struct{}
+
+// Now returns the current wall-clock time.
+func (RealClock) Now() time.Time { return time.Now() }
+
+// AfterFunc schedules fn on a real timer, as time.AfterFunc.
+func (RealClock) AfterFunc(d time.Duration, fn func()) {
+	time.AfterFunc(d, fn)
+}
+
+// manualEvent is a callback ManualClock will run once its scheduled time
+// has been reached.
+type manualEvent struct {
+	at   time.Time
+	fn   func()
+	done bool
+}
+
+// ManualClock is a Clock that only advances when told to. AfterFunc lets
+// devices (e.g. the PIT's countdown, the RTC's simulated update cycle)
+// schedule a callback relative to the clock's current time; Advance runs
+// every callback whose time has come, in chronological order, before
+// returning.
+type ManualClock struct {
+	now    time.Time
+	events []*manualEvent
+}
+
+// NewManualClock returns a ManualClock starting at start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the clock's current, manually-set time.
+func (c *ManualClock) Now() time.Time { return c.now }
+
+// AfterFunc schedules fn to run at Now()+d, the next time Advance
+// reaches or passes that point.
+func (c *ManualClock) AfterFunc(d time.Duration, fn func()) {
+	c.events = append(c.events, &manualEvent{at: c.now.Add(d), fn: fn})
+}
+
+// Advance moves the clock forward by d, running every pending AfterFunc
+// callback whose scheduled time falls at or before the new time, in
+// chronological order.
+func (c *ManualClock) Advance(d time.Duration) {
+	target := c.now.Add(d)
+
+	var due []*manualEvent
+	for _, e := range c.events {
+		if !e.done && !e.at.After(target) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+
+	for _, e := range due {
+		e.done = true
+		c.now = e.at
+		e.fn()
+	}
+	c.now = target
+
+	remaining := c.events[:0]
+	for _, e := range c.events {
+		if !e.done {
+			remaining = append(remaining, e)
+		}
+	}
+	c.events = remaining
+}
+
+// CatchUpPolicy controls how a CatchUpTimer handles a callback that fires
+// more than one period late, mirroring KVM's per-timer catchup modes.
+type CatchUpPolicy int
+
+const (
+	// CatchUpBurst delivers the missed periods as a tight burst of
+	// individual callbacks (each reporting one elapsed period), capped
+	// at maxBurst, for consumers where each period is meant to look like
+	// its own event to the guest (e.g. a PIT channel wired to IRQ0,
+	// where a driver counts ticks).
+	CatchUpBurst CatchUpPolicy = iota
+
+	// CatchUpSkip delivers a single callback reporting every period that
+	// elapsed since the last one, rather than invoking the callback once
+	// per period, for consumers that only need their counter advanced by
+	// the right amount, not to be woken once per missed period.
+	CatchUpSkip
+)
+
+// CatchUpTimer is a periodic timer built on Clock.AfterFunc that tracks
+// an absolute next deadline instead of scheduling each period relative
+// to when the previous callback happened to actually run. That way a
+// host that delays delivery (a GC pause, scheduler jitter) never lets
+// the timer drift behind wall-clock time: however late a callback fires,
+// the timer knows exactly how many periods have elapsed and folds that
+// into fn's periods argument rather than silently losing them.
+//
+// fn is called with periods (1 in the common on-time case, more when
+// catching up) and lateness, how far past the ideal deadline this firing
+// is running.
+type CatchUpTimer struct {
+	clock    Clock
+	period   time.Duration
+	policy   CatchUpPolicy
+	maxBurst int
+	fn       func(periods int, lateness time.Duration)
+	stats    *Stats
+
+	mu      sync.Mutex
+	next    time.Time
+	stopped bool
+}
+
+// NewCatchUpTimer returns a timer that calls fn every period, using
+// clock as its time source and policy (with maxBurst, meaningful only
+// for CatchUpBurst; maxBurst < 1 means an unbounded burst) to decide how
+// to catch up after a late callback. Start arms it.
+func NewCatchUpTimer(clock Clock, period time.Duration, policy CatchUpPolicy, maxBurst int, fn func(periods int, lateness time.Duration)) *CatchUpTimer {
+	return &CatchUpTimer{clock: clock, period: period, policy: policy, maxBurst: maxBurst, fn: fn}
+}
+
+// SetStats wires stats to receive this timer's drift observations (max
+// lateness, periods coalesced into a single callback or dropped by the
+// burst cap) via RecordTimerDrift. Passing nil (the default) disables it.
+func (t *CatchUpTimer) SetStats(stats *Stats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = stats
+}
+
+// Start arms the timer's first deadline, one period from clock.Now().
+func (t *CatchUpTimer) Start() {
+	t.mu.Lock()
+	t.next = t.clock.Now().Add(t.period)
+	deadline := t.period
+	t.mu.Unlock()
+	t.clock.AfterFunc(deadline, t.fire)
+}
+
+// Stop prevents any further rescheduling once the in-flight deadline (if
+// any) fires; it does not cancel a callback already scheduled with the
+// underlying Clock.
+func (t *CatchUpTimer) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+}
+
+// fire is the AfterFunc callback: it computes how many periods have
+// actually elapsed since the last deadline, delivers them per policy,
+// and reschedules from the deadline grid (never from clock.Now()), so a
+// late firing doesn't also push every subsequent deadline later.
+func (t *CatchUpTimer) fire() {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	now := t.clock.Now()
+	lateness := now.Sub(t.next)
+	if lateness < 0 {
+		lateness = 0
+	}
+	periods := 1 + int(lateness/t.period)
+
+	policy, maxBurst, fn, stats := t.policy, t.maxBurst, t.fn, t.stats
+	t.next = t.next.Add(time.Duration(periods) * t.period)
+	next := t.next
+	t.mu.Unlock()
+
+	var coalesced int
+	switch policy {
+	case CatchUpBurst:
+		deliver := periods
+		if maxBurst > 0 && deliver > maxBurst {
+			deliver = maxBurst
+		}
+		coalesced = periods - deliver
+		for i := 0; i < deliver; i++ {
+			fn(1, lateness)
+		}
+	default: // CatchUpSkip
+		coalesced = periods - 1
+		fn(periods, lateness)
+	}
+	stats.RecordTimerDrift(lateness, int64(coalesced))
+
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return
+	}
+	d := next.Sub(t.clock.Now())
+	if d < 0 {
+		d = 0
+	}
+	t.clock.AfterFunc(d, t.fire)
+}
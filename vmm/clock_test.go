@@ -0,0 +1,128 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCatchUpTimerFiresOnTimeUnderNormalScheduling checks the ordinary,
+// no-drift case: Start arms the first deadline, and a ManualClock Advance
+// of exactly one period fires it with periods == 1 and zero lateness.
+func TestCatchUpTimerFiresOnTimeUnderNormalScheduling(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	const period = 10 * time.Millisecond
+
+	var gotPeriods int
+	var gotLateness time.Duration
+	timer := NewCatchUpTimer(clock, period, CatchUpBurst, 0, func(periods int, lateness time.Duration) {
+		gotPeriods = periods
+		gotLateness = lateness
+	})
+	timer.Start()
+
+	clock.Advance(period)
+
+	if gotPeriods != 1 {
+		t.Errorf("periods = %d, want 1", gotPeriods)
+	}
+	if gotLateness != 0 {
+		t.Errorf("lateness = %v, want 0", gotLateness)
+	}
+}
+
+// TestCatchUpTimerBurstPolicyCapsDeliveredPeriodsOnStall simulates a
+// callback that ran 500ms late against a 10ms period (as if the host had
+// stalled the AfterFunc delivery), and checks CatchUpBurst delivers only
+// maxBurst individual callbacks, coalescing (dropping, in burst mode) the
+// rest, while still reporting the full elapsed period count via drift
+// stats.
+func TestCatchUpTimerBurstPolicyCapsDeliveredPeriodsOnStall(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	const period = 10 * time.Millisecond
+	const maxBurst = 3
+
+	var delivered []int
+	timer := NewCatchUpTimer(clock, period, CatchUpBurst, maxBurst, func(periods int, lateness time.Duration) {
+		delivered = append(delivered, periods)
+	})
+	stats := NewStats()
+	timer.SetStats(stats)
+
+	// Arm the first deadline, then advance the clock 500ms past it
+	// without letting ManualClock invoke the callback on schedule, so
+	// fire() sees the same lateness a real stalled host callback would.
+	timer.next = clock.Now().Add(period)
+	clock.Advance(500 * time.Millisecond)
+	timer.fire()
+
+	if len(delivered) != maxBurst {
+		t.Fatalf("delivered %d callbacks, want %d (capped burst)", len(delivered), maxBurst)
+	}
+	for _, p := range delivered {
+		if p != 1 {
+			t.Errorf("burst callback reported periods = %d, want 1 each", p)
+		}
+	}
+
+	snap := stats.Snapshot()
+	if want := 490 * time.Millisecond; snap.MaxTimerLateness != want {
+		t.Errorf("MaxTimerLateness = %v, want %v", snap.MaxTimerLateness, want)
+	}
+	// 50 periods elapsed (490ms late plus the one on-time period), 3
+	// delivered individually, the remaining 47 coalesced away.
+	if want := int64(47); snap.TicksCoalesced != want {
+		t.Errorf("TicksCoalesced = %d, want %d", snap.TicksCoalesced, want)
+	}
+}
+
+// TestCatchUpTimerSkipPolicyReportsElapsedPeriodsInOneCallback checks
+// that CatchUpSkip, given the same 500ms stall, invokes fn exactly once
+// with the full elapsed period count, so a counter-based consumer can
+// stay mathematically correct without being woken 50 times.
+func TestCatchUpTimerSkipPolicyReportsElapsedPeriodsInOneCallback(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	const period = 10 * time.Millisecond
+
+	var calls int
+	var gotPeriods int
+	timer := NewCatchUpTimer(clock, period, CatchUpSkip, 0, func(periods int, lateness time.Duration) {
+		calls++
+		gotPeriods = periods
+	})
+	stats := NewStats()
+	timer.SetStats(stats)
+
+	timer.next = clock.Now().Add(period)
+	clock.Advance(500 * time.Millisecond)
+	timer.fire()
+
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+	if gotPeriods != 50 {
+		t.Errorf("periods = %d, want 50", gotPeriods)
+	}
+	if want := int64(49); stats.Snapshot().TicksCoalesced != want {
+		t.Errorf("TicksCoalesced = %d, want %d", stats.Snapshot().TicksCoalesced, want)
+	}
+}
+
+// TestCatchUpTimerStopPreventsFurtherCallbacks checks that Stop, called
+// before a pending deadline fires, suppresses that callback.
+func TestCatchUpTimerStopPreventsFurtherCallbacks(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	const period = 10 * time.Millisecond
+
+	called := false
+	timer := NewCatchUpTimer(clock, period, CatchUpBurst, 0, func(periods int, lateness time.Duration) {
+		called = true
+	})
+	timer.Start()
+	timer.Stop()
+
+	clock.Advance(period)
+
+	if called {
+		t.Error("fn called after Stop, want no call")
+	}
+}
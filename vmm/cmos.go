@@ -0,0 +1,34 @@
+package vmm
+
+import "os"
+
+// SaveCMOS writes the RTC's general-purpose CMOS bytes to path, so that
+// BIOS settings (boot order, equipment flags, and the like) survive
+// across host restarts of the VMM process.
+func (r *RTCDevice) SaveCMOS(path string) error {
+	r.mu.Lock()
+	snapshot := r.cmos
+	r.mu.Unlock()
+	return os.WriteFile(path, snapshot[:], 0o600)
+}
+
+// LoadCMOS restores the RTC's general-purpose CMOS bytes from a file
+// previously written by SaveCMOS. A missing file is not an error; the
+// RTC keeps its power-on-default (zeroed) CMOS bytes.
+func (r *RTCDevice) LoadCMOS(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := copy(r.cmos[:], raw)
+	for i := n; i < len(r.cmos); i++ {
+		r.cmos[i] = 0
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package vmm
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCMOSPersistsAcrossLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmos.bin")
+
+	r1 := NewRTCDevice(newFakeClock())
+	rtcWrite(t, r1, 0x2e, 0x42) // boot-order byte, arbitrary offset
+	if err := r1.SaveCMOS(path); err != nil {
+		t.Fatalf("SaveCMOS: %v", err)
+	}
+
+	r2 := NewRTCDevice(newFakeClock())
+	if err := r2.LoadCMOS(path); err != nil {
+		t.Fatalf("LoadCMOS: %v", err)
+	}
+	if got := rtcRead(t, r2, 0x2e); got != 0x42 {
+		t.Errorf("restored CMOS byte = %#x, want 0x42", got)
+	}
+}
@@ -0,0 +1,379 @@
+package vmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// dumpChunkSize bounds how much guest memory DumpMemory/LoadMemory buffer
+// at once, so dumping a multi-gigabyte guest doesn't require allocating a
+// second copy of all of RAM.
+const dumpChunkSize = 1 << 20 // 1MiB
+
+// DumpMemory writes length bytes of guest physical memory starting at
+// start to w, streaming in dumpChunkSize chunks rather than allocating a
+// copy of the whole range up front. Bytes that land in a gap between
+// memory slots (such as the sub-1MB video BIOS hole) are written as
+// zeroes, so offsets in the dump stay aligned with guest physical
+// addresses.
+//
+// The VM is paused for the duration of the dump (see pauseMu on
+// VirtualMachine), so a guest whose VCPUs are still running on another
+// goroutine can't leave the snapshot inconsistent partway through.
+func (vm *VirtualMachine) DumpMemory(w io.Writer, start, length uint64) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: DumpMemory: no memory layout installed (call SetMemoryLayout first)")
+	}
+
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+	return vm.dumpMemoryLocked(w, start, length)
+}
+
+// dumpMemoryLocked is DumpMemory's body, split out so Snapshot can stream
+// the memory image while already holding pauseMu itself (pauseMu is a
+// plain sync.Mutex, and thus not safely re-lockable from the same
+// goroutine). Callers must hold pauseMu and must have already checked
+// vm.memory != nil.
+func (vm *VirtualMachine) dumpMemoryLocked(w io.Writer, start, length uint64) error {
+	buf := make([]byte, dumpChunkSize)
+	for length > 0 {
+		n := uint64(len(buf))
+		if length < n {
+			n = length
+		}
+		chunk := buf[:n]
+		vm.memory.ReadAtLossy(chunk, start)
+		if _, err := w.Write(chunk); err != nil {
+			return fmt.Errorf("vmm: DumpMemory: %w", err)
+		}
+		start += n
+		length -= n
+	}
+	return nil
+}
+
+// LoadMemory reads from r and writes it into guest physical memory
+// starting at start, streaming in dumpChunkSize chunks rather than
+// buffering all of r up front. Bytes that land in a gap between memory
+// slots are discarded rather than raising an error, mirroring the
+// zero-filled gaps DumpMemory produces.
+func (vm *VirtualMachine) LoadMemory(r io.Reader, start uint64) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: LoadMemory: no memory layout installed (call SetMemoryLayout first)")
+	}
+
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+	return vm.loadMemoryLocked(r, start)
+}
+
+// loadMemoryLocked is LoadMemory's body, split out so RestoreSnapshot can
+// stream the memory image while already holding pauseMu itself. Callers
+// must hold pauseMu and must have already checked vm.memory != nil.
+func (vm *VirtualMachine) loadMemoryLocked(r io.Reader, start uint64) error {
+	buf := make([]byte, dumpChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			vm.memory.WriteAtLossy(buf[:n], start)
+			start += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("vmm: LoadMemory: %w", err)
+		}
+	}
+}
+
+// ELF64 constants DumpCore needs; only the handful of values an ELF core
+// file actually uses.
+const (
+	etCore  = 4
+	emX8664 = 62
+
+	ptLoad = 1
+	ptNote = 4
+
+	pfX = 1
+	pfW = 2
+	pfR = 4
+
+	ntPrstatus = 1 // NT_PRSTATUS: general-purpose register state
+)
+
+// elfHeader mirrors the ELF64 file header (Elf64_Ehdr). Fields are
+// written in order with encoding/binary, so this struct's field order and
+// widths are load-bearing, not just documentation.
+type elfHeader struct {
+	Ident     [16]byte
+	Type      uint16
+	Machine   uint16
+	Version   uint32
+	Entry     uint64
+	Phoff     uint64
+	Shoff     uint64
+	Flags     uint32
+	Ehsize    uint16
+	Phentsize uint16
+	Phnum     uint16
+	Shentsize uint16
+	Shnum     uint16
+	Shstrndx  uint16
+}
+
+// elfProgramHeader mirrors Elf64_Phdr.
+type elfProgramHeader struct {
+	Type   uint32
+	Flags  uint32
+	Offset uint64
+	VAddr  uint64
+	PAddr  uint64
+	Filesz uint64
+	Memsz  uint64
+	Align  uint64
+}
+
+// elfNoteHeader mirrors Elf64_Nhdr: a name and type tag followed by
+// namesz bytes of name and descsz bytes of description, each padded out
+// to a 4-byte boundary.
+type elfNoteHeader struct {
+	Namesz uint32
+	Descsz uint32
+	Type   uint32
+}
+
+// elfPrstatus mirrors Linux's struct elf_prstatus for x86_64, the layout
+// gdb expects an NT_PRSTATUS note's description to hold. Only Reg (the
+// general-purpose registers, in elf_gregset_t order) is populated by
+// DumpCore; the process-identity and signal-state fields are left zero
+// since a guest VCPU isn't a host process.
+type elfPrstatus struct {
+	Signo, Code, Errno    int32
+	Cursig                int16
+	Pad0                  int16
+	Sigpend               uint64
+	Sighold               uint64
+	Pid, Ppid, Pgrp, Sid  int32
+	UtimeSec, UtimeUsec   int64
+	StimeSec, StimeUsec   int64
+	CutimeSec, CutimeUsec int64
+	CstimeSec, CstimeUsec int64
+	Reg                   [27]uint64
+	Fpvalid               int32
+	Pad1                  int32
+}
+
+// SetCoreDumpMSRs configures which MSR indices DumpCore reads out of
+// every VCPU and includes in the core file as an additional note per
+// VCPU, beyond the standard NT_PRSTATUS register note. Leaving it unset
+// (the default) means DumpCore's output is unchanged from before this
+// existed.
+func (vm *VirtualMachine) SetCoreDumpMSRs(indices []uint32) {
+	vm.coreDumpMSRs = append([]uint32(nil), indices...)
+}
+
+// ntMSRState is this package's own PT_NOTE type for the MSR note
+// SetCoreDumpMSRs opts a core dump into. There's no standard NT_* type
+// for an arbitrary MSR list the way NT_PRSTATUS covers general-purpose
+// registers, so gdb won't know what to do with it; it's meant for this
+// project's own tooling to read back, the same way it wrote it.
+const ntMSRState = 0x1000
+
+// DumpCore writes an ELF64 core-style file to path: a PT_LOAD program
+// header per populated memory slot, so gdb's "core-file" command (used
+// against the guest's own ELF binary) can read guest memory at its actual
+// physical addresses, plus a PT_NOTE segment holding one NT_PRSTATUS note
+// per VCPU built from GetRegs/GetSregs. The VM is paused for the
+// duration, the same as DumpMemory.
+func (vm *VirtualMachine) DumpCore(path string) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: DumpCore: no memory layout installed (call SetMemoryLayout first)")
+	}
+
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+
+	notes, err := vm.buildPrstatusNotesLocked()
+	if err != nil {
+		return fmt.Errorf("vmm: DumpCore: %w", err)
+	}
+
+	slots := vm.memory.Slots()
+	phnum := 1 + len(slots) // one PT_NOTE, plus one PT_LOAD per slot
+	const ehsize, phentsize = 64, 56
+	phoff := uint64(ehsize)
+	noteOff := phoff + uint64(phnum)*phentsize
+	loadOff := noteOff + uint64(len(notes))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("vmm: DumpCore: %w", err)
+	}
+	defer f.Close()
+
+	if err := writeElfCoreHeader(f, uint16(phnum)); err != nil {
+		return fmt.Errorf("vmm: DumpCore: %w", err)
+	}
+	if err := writeElfProgramHeader(f, elfProgramHeader{
+		Type:   ptNote,
+		Offset: noteOff,
+		Filesz: uint64(len(notes)),
+		Memsz:  uint64(len(notes)),
+		Align:  4,
+	}); err != nil {
+		return fmt.Errorf("vmm: DumpCore: %w", err)
+	}
+
+	off := loadOff
+	for _, s := range slots {
+		if err := writeElfProgramHeader(f, elfProgramHeader{
+			Type:   ptLoad,
+			Flags:  pfR | pfW | pfX,
+			Offset: off,
+			VAddr:  s.GuestPhysAddr,
+			PAddr:  s.GuestPhysAddr,
+			Filesz: uint64(len(s.Backing)),
+			Memsz:  uint64(len(s.Backing)),
+			Align:  4096,
+		}); err != nil {
+			return fmt.Errorf("vmm: DumpCore: %w", err)
+		}
+		off += uint64(len(s.Backing))
+	}
+
+	if _, err := f.Write(notes); err != nil {
+		return fmt.Errorf("vmm: DumpCore: %w", err)
+	}
+	for _, s := range slots {
+		if _, err := f.Write(s.Backing); err != nil {
+			return fmt.Errorf("vmm: DumpCore: %w", err)
+		}
+	}
+	return nil
+}
+
+// buildPrstatusNotesLocked returns the concatenated NT_PRSTATUS notes for
+// every VCPU, in vm.vcpus order. Callers must hold pauseMu.
+func (vm *VirtualMachine) buildPrstatusNotesLocked() ([]byte, error) {
+	var buf bytes.Buffer
+	for i, vcpu := range vm.vcpus {
+		regs, err := vcpu.GetRegs()
+		if err != nil {
+			return nil, fmt.Errorf("vcpu %d GetRegs: %w", i, err)
+		}
+		sregs, err := vcpu.GetSregs()
+		if err != nil {
+			return nil, fmt.Errorf("vcpu %d GetSregs: %w", i, err)
+		}
+		if err := writePrstatusNote(&buf, regs, sregs); err != nil {
+			return nil, fmt.Errorf("vcpu %d: %w", i, err)
+		}
+		if len(vm.coreDumpMSRs) > 0 {
+			if err := writeMSRNote(&buf, vcpu, vm.coreDumpMSRs); err != nil {
+				return nil, fmt.Errorf("vcpu %d: %w", i, err)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeMSRNote appends one note of type ntMSRState, holding one
+// (index, value) pair per entry in indices in order, read fresh from
+// vcpu via GetMSR.
+func writeMSRNote(buf *bytes.Buffer, vcpu hypervisor.VCPU, indices []uint32) error {
+	var desc bytes.Buffer
+	for _, index := range indices {
+		value, err := vcpu.GetMSR(index)
+		if err != nil {
+			return fmt.Errorf("GetMSR(%#x): %w", index, err)
+		}
+		if err := binary.Write(&desc, binary.LittleEndian, struct {
+			Index uint32
+			_     uint32
+			Value uint64
+		}{Index: index, Value: value}); err != nil {
+			return err
+		}
+	}
+
+	const noteName = "CORE\x00"
+	if err := binary.Write(buf, binary.LittleEndian, elfNoteHeader{
+		Namesz: uint32(len(noteName)),
+		Descsz: uint32(desc.Len()),
+		Type:   ntMSRState,
+	}); err != nil {
+		return err
+	}
+	buf.WriteString(noteName)
+	if pad := (4 - len(noteName)%4) % 4; pad > 0 {
+		buf.Write(make([]byte, pad))
+	}
+	buf.Write(desc.Bytes()) // already a multiple of 4 bytes (8-byte entries)
+	return nil
+}
+
+// writePrstatusNote appends one ELF note of type NT_PRSTATUS, describing
+// regs/sregs, to buf.
+func writePrstatusNote(buf *bytes.Buffer, regs hypervisor.KvmRegs, sregs hypervisor.KvmSregs) error {
+	status := elfPrstatus{
+		Reg: [27]uint64{
+			regs.R15, regs.R14, regs.R13, regs.R12,
+			regs.RBP, regs.RBX, regs.R11, regs.R10,
+			regs.R9, regs.R8, regs.RAX, regs.RCX,
+			regs.RDX, regs.RSI, regs.RDI,
+			regs.RAX, // orig_rax: KVM has no separate orig-rax register, so reuse rax
+			regs.RIP, uint64(sregs.CS.Selector), regs.RFLAGS, regs.RSP,
+			uint64(sregs.SS.Selector), sregs.FS.Base, sregs.GS.Base,
+			uint64(sregs.DS.Selector), uint64(sregs.ES.Selector),
+			uint64(sregs.FS.Selector), uint64(sregs.GS.Selector),
+		},
+	}
+
+	var desc bytes.Buffer
+	if err := binary.Write(&desc, binary.LittleEndian, status); err != nil {
+		return err
+	}
+
+	const noteName = "CORE\x00" // the name Linux's own core notes use
+	if err := binary.Write(buf, binary.LittleEndian, elfNoteHeader{
+		Namesz: uint32(len(noteName)),
+		Descsz: uint32(desc.Len()),
+		Type:   ntPrstatus,
+	}); err != nil {
+		return err
+	}
+	buf.WriteString(noteName)
+	if pad := (4 - len(noteName)%4) % 4; pad > 0 {
+		buf.Write(make([]byte, pad))
+	}
+	buf.Write(desc.Bytes()) // already a multiple of 4 bytes
+	return nil
+}
+
+func writeElfCoreHeader(w io.Writer, phnum uint16) error {
+	var h elfHeader
+	h.Ident[0], h.Ident[1], h.Ident[2], h.Ident[3] = 0x7f, 'E', 'L', 'F'
+	h.Ident[4] = 2 // ELFCLASS64
+	h.Ident[5] = 1 // ELFDATA2LSB
+	h.Ident[6] = 1 // EV_CURRENT
+	h.Type = etCore
+	h.Machine = emX8664
+	h.Version = 1
+	h.Phoff = 64
+	h.Ehsize = 64
+	h.Phentsize = 56
+	h.Phnum = phnum
+	return binary.Write(w, binary.LittleEndian, h)
+}
+
+func writeElfProgramHeader(w io.Writer, ph elfProgramHeader) error {
+	return binary.Write(w, binary.LittleEndian, ph)
+}
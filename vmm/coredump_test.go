@@ -0,0 +1,258 @@
+package vmm
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+func TestDumpMemoryZeroFillsGapsBetweenSlots(t *testing.T) {
+	mem := NewMemoryLayout()
+	if err := mem.AddSlot(0x0, []byte{0x11, 0x22}); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	if err := mem.AddSlot(0x10, []byte{0x33, 0x44}); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	vm := &VirtualMachine{}
+	vm.SetMemoryLayout(mem)
+
+	var out bytes.Buffer
+	if err := vm.DumpMemory(&out, 0, 0x12); err != nil {
+		t.Fatalf("DumpMemory: %v", err)
+	}
+
+	want := make([]byte, 0x12)
+	want[0], want[1] = 0x11, 0x22
+	want[0x10], want[0x11] = 0x33, 0x44
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("DumpMemory = %x, want %x", out.Bytes(), want)
+	}
+}
+
+func TestDumpMemoryRequiresMemoryLayout(t *testing.T) {
+	vm := &VirtualMachine{}
+	if err := vm.DumpMemory(&bytes.Buffer{}, 0, 1); err == nil {
+		t.Error("DumpMemory with no memory layout installed = nil error, want one")
+	}
+	if err := vm.LoadMemory(bytes.NewReader(nil), 0); err == nil {
+		t.Error("LoadMemory with no memory layout installed = nil error, want one")
+	}
+}
+
+func TestDumpMemoryAndLoadMemoryRoundTrip(t *testing.T) {
+	src := NewMemoryLayout()
+	lowRAM := make([]byte, 0x1000)
+	highRAM := make([]byte, 0x1000)
+	if err := src.AddSlot(0x0, lowRAM); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	if err := src.AddSlot(0x2000, highRAM); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	pattern := map[uint64]byte{
+		0x000:  0xde,
+		0x0ff:  0xad,
+		0xfff:  0xbe,
+		0x2000: 0xef,
+		0x2fff: 0x42,
+	}
+	for addr, b := range pattern {
+		if err := src.WriteAt([]byte{b}, addr); err != nil {
+			t.Fatalf("WriteAt(%#x): %v", addr, err)
+		}
+	}
+
+	vm1 := &VirtualMachine{}
+	vm1.SetMemoryLayout(src)
+
+	var dump bytes.Buffer
+	const totalLen = 0x3000 // covers both slots and the gap between them
+	if err := vm1.DumpMemory(&dump, 0, totalLen); err != nil {
+		t.Fatalf("DumpMemory: %v", err)
+	}
+	if dump.Len() != totalLen {
+		t.Fatalf("dump length = %d, want %d", dump.Len(), totalLen)
+	}
+
+	dst := NewMemoryLayout()
+	if err := dst.AddSlot(0x0, make([]byte, 0x1000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	if err := dst.AddSlot(0x2000, make([]byte, 0x1000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	vm2 := &VirtualMachine{}
+	vm2.SetMemoryLayout(dst)
+	if err := vm2.LoadMemory(&dump, 0); err != nil {
+		t.Fatalf("LoadMemory: %v", err)
+	}
+
+	for addr, want := range pattern {
+		var got [1]byte
+		if err := dst.ReadAt(got[:], addr); err != nil {
+			t.Fatalf("ReadAt(%#x): %v", addr, err)
+		}
+		if got[0] != want {
+			t.Errorf("byte at %#x = %#x, want %#x", addr, got[0], want)
+		}
+	}
+	if !bytes.Equal(lowRAM, dst.slots[0].Backing) || !bytes.Equal(highRAM, dst.slots[1].Backing) {
+		t.Error("reloaded memory does not match the original slot contents")
+	}
+}
+
+func TestDumpCoreWritesLoadableElfWithRegisterState(t *testing.T) {
+	mem := NewMemoryLayout()
+	backing := bytes.Repeat([]byte{0xaa}, 0x1000)
+	if err := mem.AddSlot(0x1000, backing); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	vcpu := &hypervisor.FakeVCPU{
+		Regs: hypervisor.KvmRegs{RAX: 0x1111, RIP: 0xdeadbeef, RSP: 0x7000, RFLAGS: 0x246},
+	}
+	vcpu.Sregs.CS.Selector = 0x08
+	vcpu.Sregs.SS.Selector = 0x10
+
+	vm := &VirtualMachine{vcpus: []hypervisor.VCPU{vcpu}}
+	vm.SetMemoryLayout(mem)
+
+	path := filepath.Join(t.TempDir(), "guest.core")
+	if err := vm.DumpCore(path); err != nil {
+		t.Fatalf("DumpCore: %v", err)
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatalf("elf.Open: %v", err)
+	}
+	defer f.Close()
+
+	if f.Type != elf.ET_CORE {
+		t.Errorf("ELF type = %v, want ET_CORE", f.Type)
+	}
+	if f.Machine != elf.EM_X86_64 {
+		t.Errorf("ELF machine = %v, want EM_X86_64", f.Machine)
+	}
+
+	var loads []*elf.Prog
+	var noteData []byte
+	for _, p := range f.Progs {
+		switch p.Type {
+		case elf.PT_LOAD:
+			loads = append(loads, p)
+		case elf.PT_NOTE:
+			buf := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(buf, 0); err != nil {
+				t.Fatalf("reading PT_NOTE: %v", err)
+			}
+			noteData = buf
+		}
+	}
+
+	if len(loads) != 1 {
+		t.Fatalf("PT_LOAD count = %d, want 1", len(loads))
+	}
+	if loads[0].Vaddr != 0x1000 || loads[0].Filesz != uint64(len(backing)) {
+		t.Errorf("PT_LOAD = {Vaddr:%#x Filesz:%#x}, want {Vaddr:0x1000 Filesz:%#x}", loads[0].Vaddr, loads[0].Filesz, len(backing))
+	}
+	loadedMem := make([]byte, len(backing))
+	if _, err := loads[0].ReadAt(loadedMem, 0); err != nil {
+		t.Fatalf("reading PT_LOAD contents: %v", err)
+	}
+	if !bytes.Equal(loadedMem, backing) {
+		t.Error("PT_LOAD contents don't match the memory slot's backing bytes")
+	}
+
+	if len(noteData) < 12 {
+		t.Fatalf("note data too short: %d bytes", len(noteData))
+	}
+	namesz := binary.LittleEndian.Uint32(noteData[0:4])
+	descsz := binary.LittleEndian.Uint32(noteData[4:8])
+	noteType := binary.LittleEndian.Uint32(noteData[8:12])
+	if noteType != ntPrstatus {
+		t.Errorf("note type = %d, want NT_PRSTATUS (%d)", noteType, ntPrstatus)
+	}
+	descOff := 12 + (int(namesz)+3)/4*4
+	desc := noteData[descOff : descOff+int(descsz)]
+
+	var status elfPrstatus
+	if err := binary.Read(bytes.NewReader(desc), binary.LittleEndian, &status); err != nil {
+		t.Fatalf("decoding elf_prstatus: %v", err)
+	}
+	if status.Reg[10] != 0x1111 { // rax
+		t.Errorf("rax = %#x, want 0x1111", status.Reg[10])
+	}
+	if status.Reg[16] != 0xdeadbeef { // rip
+		t.Errorf("rip = %#x, want 0xdeadbeef", status.Reg[16])
+	}
+	if status.Reg[19] != 0x7000 { // rsp
+		t.Errorf("rsp = %#x, want 0x7000", status.Reg[19])
+	}
+}
+
+func TestDumpCoreIncludesConfiguredMSRs(t *testing.T) {
+	mem := NewMemoryLayout()
+	if err := mem.AddSlot(0x1000, bytes.Repeat([]byte{0xaa}, 0x1000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	vcpu := &hypervisor.FakeVCPU{MSRs: map[uint32]uint64{
+		hypervisor.MsrIA32TSC:        0x1234,
+		hypervisor.MsrIA32MiscEnable: 0,
+	}}
+
+	vm := &VirtualMachine{vcpus: []hypervisor.VCPU{vcpu}}
+	vm.SetMemoryLayout(mem)
+	vm.SetCoreDumpMSRs([]uint32{hypervisor.MsrIA32TSC, hypervisor.MsrIA32MiscEnable})
+
+	path := filepath.Join(t.TempDir(), "guest.core")
+	if err := vm.DumpCore(path); err != nil {
+		t.Fatalf("DumpCore: %v", err)
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatalf("elf.Open: %v", err)
+	}
+	defer f.Close()
+
+	var noteData []byte
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_NOTE {
+			buf := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(buf, 0); err != nil {
+				t.Fatalf("reading PT_NOTE: %v", err)
+			}
+			noteData = buf
+		}
+	}
+
+	// Skip past the NT_PRSTATUS note to reach the MSR note that follows
+	// it: 12-byte note header, "CORE\x00" padded to a 4-byte boundary (8
+	// bytes), then the elf_prstatus description.
+	const paddedNoteName = 8 // len("CORE\x00") == 5, rounded up to 8
+	prstatusDescsz := binary.LittleEndian.Uint32(noteData[4:8])
+	msrNoteOff := 12 + paddedNoteName + int(prstatusDescsz)
+
+	if noteType := binary.LittleEndian.Uint32(noteData[msrNoteOff+8 : msrNoteOff+12]); noteType != ntMSRState {
+		t.Fatalf("second note type = %d, want ntMSRState (%d)", noteType, ntMSRState)
+	}
+	descOff := msrNoteOff + 12 + paddedNoteName
+	desc := noteData[descOff : descOff+16]
+
+	if got := binary.LittleEndian.Uint32(desc[0:4]); got != hypervisor.MsrIA32TSC {
+		t.Errorf("first MSR entry index = %#x, want %#x", got, hypervisor.MsrIA32TSC)
+	}
+	if got := binary.LittleEndian.Uint64(desc[8:16]); got != 0x1234 {
+		t.Errorf("first MSR entry value = %#x, want %#x", got, 0x1234)
+	}
+}
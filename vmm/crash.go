@@ -0,0 +1,164 @@
+package vmm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// crashDumpRadius is how many bytes on either side of RIP
+// BuildCrashReport captures into GuestCrashReport.MemoryAroundRIP.
+const crashDumpRadius = 16
+
+// idtGateSize is the size, in bytes, of a 32-bit IDT gate descriptor.
+// idtVectorPresent doesn't attempt to decode a 64-bit guest's 16-byte
+// gates.
+const idtGateSize = 8
+
+// GuestCrashReport captures everything BuildCrashReport could learn
+// about a VCPU at the moment of a KVM_EXIT_SHUTDOWN exit: its registers,
+// a small hex dump of guest memory around RIP, whether protected mode
+// and paging were enabled, whether the faulting vector's IDT descriptor
+// was present (a common triple-fault cause when it isn't), and the I/O
+// accesses that led up to it.
+type GuestCrashReport struct {
+	VCPUIndex int
+	Regs      hypervisor.KvmRegs
+	Sregs     hypervisor.KvmSregs
+
+	// MemoryAroundRIP is a best-effort hex dump of guest memory
+	// centered on RIP; empty if no memory layout was installed or the
+	// dump range couldn't be read.
+	MemoryAroundRIP     []byte
+	MemoryAroundRIPBase uint64
+
+	ProtectedModeEnabled bool
+	PagingEnabled        bool
+
+	// IDTVectorPresent is nil if the faulting vector is unknown, or the
+	// IDT wasn't loaded, or its descriptor couldn't be read; otherwise
+	// it reports whether that vector's gate descriptor had its present
+	// bit set.
+	IDTVectorPresent *bool
+
+	// IOHistory is the IOBus's most recent port accesses, oldest first;
+	// empty unless IOBus.SetHistorySize was called.
+	IOHistory []IOAccessRecord
+}
+
+// String renders report as a multi-line human-readable crash summary,
+// suitable for wrapping a Run error.
+func (r GuestCrashReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "guest crash on vcpu %d: RIP=%#x CS=%#x protected-mode=%t paging=%t",
+		r.VCPUIndex, r.Regs.RIP, r.Sregs.CS.Selector, r.ProtectedModeEnabled, r.PagingEnabled)
+	if r.IDTVectorPresent != nil {
+		fmt.Fprintf(&b, " idt-vector-present=%t", *r.IDTVectorPresent)
+	}
+	if len(r.MemoryAroundRIP) > 0 {
+		fmt.Fprintf(&b, "\nmemory at %#x: % x", r.MemoryAroundRIPBase, r.MemoryAroundRIP)
+	}
+	if len(r.IOHistory) > 0 {
+		fmt.Fprintf(&b, "\nlast %d I/O accesses:", len(r.IOHistory))
+		for _, rec := range r.IOHistory {
+			dir := "IN "
+			if rec.Write {
+				dir = "OUT"
+			}
+			fmt.Fprintf(&b, "\n  %s port=%#x data=% x", dir, rec.Port, rec.Data)
+		}
+	}
+	return b.String()
+}
+
+// GuestCrashError wraps a GuestCrashReport as an error, so a step
+// function can return it directly from NoteShutdownExit and have
+// VirtualMachine.Run's RunResult.Err carry the full report, stringified,
+// instead of a bare "shutdown" reason.
+type GuestCrashError struct {
+	Report GuestCrashReport
+}
+
+func (e *GuestCrashError) Error() string {
+	return e.Report.String()
+}
+
+// BuildCrashReport gathers crash forensics for vcpuIndex/vcpu: registers,
+// a hex dump of guest memory around RIP, CR0-derived protected-mode and
+// paging state, and, if vector is non-negative and the IDT is loaded,
+// whether that vector's descriptor was present. A nil memory layout, or
+// a RIP too close to the edge of guest memory, just leaves
+// MemoryAroundRIP empty rather than failing the whole report. Pass
+// vector -1 when the faulting vector isn't known.
+func (vm *VirtualMachine) BuildCrashReport(vcpuIndex int, vcpu hypervisor.VCPU, vector int) (GuestCrashReport, error) {
+	regs, err := vcpu.GetRegs()
+	if err != nil {
+		return GuestCrashReport{}, fmt.Errorf("vmm: crash report: GetRegs: %w", err)
+	}
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		return GuestCrashReport{}, fmt.Errorf("vmm: crash report: GetSregs: %w", err)
+	}
+
+	report := GuestCrashReport{
+		VCPUIndex:            vcpuIndex,
+		Regs:                 regs,
+		Sregs:                sregs,
+		ProtectedModeEnabled: sregs.CR0&hypervisor.CR0_PE != 0,
+		PagingEnabled:        sregs.CR0&hypervisor.CR0_PG != 0,
+		IOHistory:            vm.IOBus.History(),
+	}
+
+	start := regs.RIP - crashDumpRadius
+	if regs.RIP < crashDumpRadius {
+		start = 0
+	}
+	if dump, err := vm.GuestSlice(start, 2*crashDumpRadius); err == nil {
+		report.MemoryAroundRIP = append([]byte(nil), dump...)
+		report.MemoryAroundRIPBase = start
+	}
+
+	if vector >= 0 && sregs.IDT.Limit >= uint16((vector+1)*idtGateSize-1) {
+		if present, err := vm.idtVectorPresent(sregs, uint8(vector)); err == nil {
+			report.IDTVectorPresent = &present
+		}
+	}
+
+	return report, nil
+}
+
+// idtVectorPresent reads vector's gate descriptor out of the guest's IDT
+// (loaded at sregs.IDT.Base) and reports whether its present bit —
+// bit 7 of the descriptor's 6th byte — is set.
+func (vm *VirtualMachine) idtVectorPresent(sregs hypervisor.KvmSregs, vector uint8) (bool, error) {
+	off := sregs.IDT.Base + uint64(vector)*idtGateSize
+	gate, err := vm.GuestSlice(off, idtGateSize)
+	if err != nil {
+		return false, err
+	}
+	return gate[5]&0x80 != 0, nil
+}
+
+// NoteShutdownExit records vcpuIndex's KVM_EXIT_SHUTDOWN exit the same
+// way NoteVCPUExit records any other exit, builds a GuestCrashReport for
+// it, logs the report, and returns it wrapped in a GuestCrashError so
+// the caller's step function can return the result directly:
+//
+//	if reason == hypervisor.KVM_EXIT_SHUTDOWN {
+//	        return vm.NoteShutdownExit(idx, vcpu, faultingVector)
+//	}
+//
+// vector should be -1 when the faulting vector isn't known.
+func (vm *VirtualMachine) NoteShutdownExit(vcpuIndex int, vcpu hypervisor.VCPU, vector int) error {
+	vm.NoteVCPUExit(vcpuIndex, hypervisor.KVM_EXIT_SHUTDOWN, true, vcpu)
+
+	report, err := vm.BuildCrashReport(vcpuIndex, vcpu, vector)
+	if err != nil {
+		return fmt.Errorf("vmm: vcpu %d: KVM_EXIT_SHUTDOWN, and building a crash report failed: %w", vcpuIndex, err)
+	}
+	if vm.logger != nil {
+		vm.logger.Errorf("%s", report.String())
+	}
+	return &GuestCrashError{Report: report}
+}
@@ -0,0 +1,120 @@
+package vmm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// dummyPioDevice is a minimal PioDevice that always succeeds, used to
+// generate I/O history entries without pulling in a real device.
+type dummyPioDevice struct {
+	ports []uint16
+}
+
+func (d *dummyPioDevice) Ports() []uint16                     { return d.ports }
+func (d *dummyPioDevice) HandleIO(uint16, []byte, bool) error { return nil }
+func (d *dummyPioDevice) Reset()                              {}
+func (d *dummyPioDevice) Name() string                        { return "dummy" }
+
+func TestBuildCrashReportDecodesStateAndHistory(t *testing.T) {
+	mem := NewMemoryLayout()
+	// One slot spanning both the IDT and the RIP dump window so
+	// GuestSlice can serve both reads.
+	if err := mem.AddSlot(0x1000, make([]byte, 0x3000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	vm := &VirtualMachine{IOBus: NewIOBus()}
+	vm.SetMemoryLayout(mem)
+
+	idtBase := uint64(0x1000)
+	gateOff := idtBase + 13*idtGateSize
+	gate, err := vm.GuestSlice(gateOff, idtGateSize)
+	if err != nil {
+		t.Fatalf("GuestSlice(idt gate): %v", err)
+	}
+	gate[5] = 0x80 // present, DPL 0, type 0
+
+	if err := vm.IOBus.Register(&dummyPioDevice{ports: []uint16{0x60}}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	vm.IOBus.SetHistorySize(2)
+	if err := vm.IOBus.Dispatch(0x60, []byte{0x11}, true); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if err := vm.IOBus.Dispatch(0x60, []byte{0x22}, true); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	vcpu := &hypervisor.FakeVCPU{
+		Regs: hypervisor.KvmRegs{RIP: 0x2000},
+		Sregs: hypervisor.KvmSregs{
+			CR0: hypervisor.CR0_PE,
+			IDT: hypervisor.KvmDTable{Base: idtBase, Limit: 0xff},
+		},
+	}
+
+	report, err := vm.BuildCrashReport(0, vcpu, 13)
+	if err != nil {
+		t.Fatalf("BuildCrashReport: %v", err)
+	}
+
+	if !report.ProtectedModeEnabled {
+		t.Error("ProtectedModeEnabled = false, want true (CR0.PE set)")
+	}
+	if report.PagingEnabled {
+		t.Error("PagingEnabled = true, want false (CR0.PG clear)")
+	}
+	if report.IDTVectorPresent == nil || !*report.IDTVectorPresent {
+		t.Errorf("IDTVectorPresent = %v, want true", report.IDTVectorPresent)
+	}
+	if got, want := report.MemoryAroundRIPBase, uint64(0x2000-crashDumpRadius); got != want {
+		t.Errorf("MemoryAroundRIPBase = %#x, want %#x", got, want)
+	}
+	if got, want := len(report.MemoryAroundRIP), 2*crashDumpRadius; got != want {
+		t.Errorf("len(MemoryAroundRIP) = %d, want %d", got, want)
+	}
+	if len(report.IOHistory) != 2 {
+		t.Fatalf("len(IOHistory) = %d, want 2", len(report.IOHistory))
+	}
+	if got, want := report.IOHistory[1].Data[0], byte(0x22); got != want {
+		t.Errorf("IOHistory[1].Data[0] = %#x, want %#x (most recent access last)", got, want)
+	}
+}
+
+func TestBuildCrashReportWithoutIDTLeavesVectorPresentNil(t *testing.T) {
+	vm := &VirtualMachine{IOBus: NewIOBus()}
+	vcpu := &hypervisor.FakeVCPU{}
+
+	report, err := vm.BuildCrashReport(0, vcpu, -1)
+	if err != nil {
+		t.Fatalf("BuildCrashReport: %v", err)
+	}
+	if report.IDTVectorPresent != nil {
+		t.Errorf("IDTVectorPresent = %v, want nil (no vector given)", report.IDTVectorPresent)
+	}
+	if report.MemoryAroundRIP != nil {
+		t.Errorf("MemoryAroundRIP = %v, want nil (no memory layout installed)", report.MemoryAroundRIP)
+	}
+}
+
+func TestNoteShutdownExitReturnsGuestCrashError(t *testing.T) {
+	vm := &VirtualMachine{IOBus: NewIOBus(), stats: NewStats()}
+	vcpu := &hypervisor.FakeVCPU{Regs: hypervisor.KvmRegs{RIP: 0xabc}}
+
+	err := vm.NoteShutdownExit(0, vcpu, -1)
+
+	var crashErr *GuestCrashError
+	if !errors.As(err, &crashErr) {
+		t.Fatalf("NoteShutdownExit error = %v (%T), want *GuestCrashError", err, err)
+	}
+	if crashErr.Report.Regs.RIP != 0xabc {
+		t.Errorf("Report.Regs.RIP = %#x, want %#x", crashErr.Report.Regs.RIP, 0xabc)
+	}
+	if !strings.Contains(err.Error(), "0xabc") {
+		t.Errorf("Error() = %q, want it to mention RIP 0xabc", err.Error())
+	}
+}
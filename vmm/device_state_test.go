@@ -0,0 +1,272 @@
+package vmm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestPICStateRoundTrips checks that a PICDevice's SaveState/LoadState
+// round trip preserves an in-progress acknowledge: IRR/ISR, the vector
+// offset programmed by ICW2, and the interrupt mask.
+func TestPICStateRoundTrips(t *testing.T) {
+	src := NewMasterPIC()
+	// Unmask IRQ5 via OCW1 (all lines start masked out of reset), so
+	// RaiseIRQ actually latches a vector instead of being filtered out.
+	if err := src.HandleIO(picMasterDataPort, []byte{^uint8(1 << 5)}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	src.RaiseIRQ(5)
+	if _, ok := src.GetInterruptVector(); !ok {
+		t.Fatal("GetInterruptVector: no vector latched")
+	}
+	src.RaiseIRQ(3) // pending, not yet acknowledged
+
+	var buf bytes.Buffer
+	if err := src.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	dst := NewMasterPIC()
+	if err := dst.LoadState(&buf, src.StateVersion()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if dst.irr != src.irr || dst.isr != src.isr || dst.imr != src.imr || dst.vectorOffset != src.vectorOffset {
+		t.Errorf("restored irr/isr/imr/vectorOffset = %#x/%#x/%#x/%#x, want %#x/%#x/%#x/%#x",
+			dst.irr, dst.isr, dst.imr, dst.vectorOffset, src.irr, src.isr, src.imr, src.vectorOffset)
+	}
+	if dst.HasPendingInterrupt() != src.HasPendingInterrupt() {
+		t.Errorf("HasPendingInterrupt = %v, want %v", dst.HasPendingInterrupt(), src.HasPendingInterrupt())
+	}
+}
+
+// TestPITStateRoundTrips checks that a PITDevice's SaveState/LoadState
+// round trip preserves a programmed channel's countdown, replayed against
+// an identical clock.
+func TestPITStateRoundTrips(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	src := NewPITDevice(clock)
+	programPITChannel0(t, src, 1234)
+	clock.Advance(100 * pitTickDuration)
+
+	var buf bytes.Buffer
+	if err := src.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	dst := NewPITDevice(clock)
+	if err := dst.LoadState(&buf, src.StateVersion()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if got, want := readPITChannel0(t, dst), readPITChannel0(t, src); got != want {
+		t.Errorf("restored channel 0 count = %d, want %d", got, want)
+	}
+}
+
+// TestRTCStateRoundTrips checks that an RTCDevice's SaveState/LoadState
+// round trip preserves a guest-set time offset.
+func TestRTCStateRoundTrips(t *testing.T) {
+	clock := newFakeClock() // 2026-01-01 00:00:00 UTC
+	src := NewRTCDevice(clock)
+
+	rtcWrite(t, src, regB, regBSet|regBHour24|regBDM)
+	rtcWrite(t, src, regDay, 4)
+	rtcWrite(t, src, regMonth, 1)
+	rtcWrite(t, src, regYear, 26)
+	rtcWrite(t, src, regB, regBHour24|regBDM)
+	clock.Advance(5 * time.Millisecond) // let the update cycle elapse
+
+	var buf bytes.Buffer
+	if err := src.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	dst := NewRTCDevice(clock)
+	if err := dst.LoadState(&buf, src.StateVersion()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if got, want := rtcRead(t, dst, regDay), rtcRead(t, src, regDay); got != want {
+		t.Errorf("restored day = %d, want %d", got, want)
+	}
+	if got, want := rtcRead(t, dst, regMonth), rtcRead(t, src, regMonth); got != want {
+		t.Errorf("restored month = %d, want %d", got, want)
+	}
+}
+
+// TestSerialStateRoundTrips checks that a SerialPortDevice's
+// SaveState/LoadState round trip preserves the programmed line control,
+// modem control, scratch register, and baud divisor.
+func TestSerialStateRoundTrips(t *testing.T) {
+	src := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), nil)
+
+	serialWriteReg(t, src, uartRegLCR, lcrDLAB)
+	serialWriteReg(t, src, uartRegDataOrDivisorLo, 0x17)
+	serialWriteReg(t, src, uartRegIERorDivisorHi, 0x02)
+	serialWriteReg(t, src, uartRegLCR, 0x03) // clear DLAB, 8N1
+	serialWriteReg(t, src, uartRegMCR, 0x0b)
+	serialWriteReg(t, src, uartRegSCR, 0x5a)
+
+	var buf bytes.Buffer
+	if err := src.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	dst := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), nil)
+	if err := dst.LoadState(&buf, src.StateVersion()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if got, want := serialReadReg(t, dst, uartRegMCR), serialReadReg(t, src, uartRegMCR); got != want {
+		t.Errorf("restored MCR = %#x, want %#x", got, want)
+	}
+	if got, want := serialReadReg(t, dst, uartRegSCR), serialReadReg(t, src, uartRegSCR); got != want {
+		t.Errorf("restored SCR = %#x, want %#x", got, want)
+	}
+
+	serialWriteReg(t, dst, uartRegLCR, lcrDLAB)
+	serialWriteReg(t, src, uartRegLCR, lcrDLAB)
+	if got, want := serialReadReg(t, dst, uartRegDataOrDivisorLo), serialReadReg(t, src, uartRegDataOrDivisorLo); got != want {
+		t.Errorf("restored divisor lo = %#x, want %#x", got, want)
+	}
+	if got, want := serialReadReg(t, dst, uartRegIERorDivisorHi), serialReadReg(t, src, uartRegIERorDivisorHi); got != want {
+		t.Errorf("restored divisor hi = %#x, want %#x", got, want)
+	}
+}
+
+// TestKeyboardControllerStateRoundTrips checks that a
+// KeyboardControllerDevice's SaveState/LoadState round trip preserves a
+// pending multi-byte command (0xd1, write output port) issued but not yet
+// completed at save time.
+func TestKeyboardControllerStateRoundTrips(t *testing.T) {
+	src := NewKeyboardControllerDevice(nil, NewA20Gate())
+	if err := src.HandleIO(kbcPortStatus, []byte{kbcCmdWriteOutputPort}, true); err != nil {
+		t.Fatalf("write command: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	var resetCalled bool
+	gate := NewA20Gate()
+	dst := NewKeyboardControllerDevice(func() { resetCalled = true }, gate)
+	if err := dst.LoadState(&buf, src.StateVersion()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	// The pending 0xd1 must have survived the round trip: this data-port
+	// write should be interpreted as the output-port byte, not ignored.
+	if err := dst.HandleIO(kbcPortData, []byte{kbcOutputA20 | kbcOutputReset}, true); err != nil {
+		t.Fatalf("write data: %v", err)
+	}
+	if !gate.Enabled() {
+		t.Error("A20 gate not enabled: restored device did not treat the data write as the pending output-port command")
+	}
+	if resetCalled {
+		t.Error("onReset called: output-port reset bit was set, should not have pulsed reset")
+	}
+}
+
+// TestNE2000StateRoundTrips checks that an NE2000Device's
+// SaveState/LoadState round trip preserves a programmed station address
+// (PAR), read back via the page-1 register window.
+func TestNE2000StateRoundTrips(t *testing.T) {
+	src := NewNE2000Device([6]byte{}, nil)
+	base := src.base
+
+	if err := src.HandleIO(base+ne2000RegCR, []byte{crSTP | crPS0}, true); err != nil {
+		t.Fatalf("select page 1: %v", err)
+	}
+	newMAC := [6]byte{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc}
+	for i, b := range newMAC {
+		if err := src.HandleIO(base+ne2000RegPAR0+uint16(i), []byte{b}, true); err != nil {
+			t.Fatalf("write PAR%d: %v", i, err)
+		}
+	}
+	if err := src.HandleIO(base+ne2000RegCR, []byte{crSTP}, true); err != nil {
+		t.Fatalf("return to page 0: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	dst := NewNE2000Device([6]byte{}, nil)
+	if err := dst.LoadState(&buf, src.StateVersion()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if err := dst.HandleIO(base+ne2000RegCR, []byte{crSTP | crPS0}, true); err != nil {
+		t.Fatalf("select page 1: %v", err)
+	}
+	for i, want := range newMAC {
+		buf := []byte{0}
+		if err := dst.HandleIO(base+ne2000RegPAR0+uint16(i), buf, false); err != nil {
+			t.Fatalf("read PAR%d: %v", i, err)
+		}
+		if buf[0] != want {
+			t.Errorf("restored PAR%d = %#x, want %#x", i, buf[0], want)
+		}
+	}
+}
+
+// TestSaveDeviceStateLoadDeviceStateRoundTrip checks the aggregate
+// bundler: SaveDeviceState/LoadDeviceState across every registered
+// StatefulDevice, without touching VCPU registers or guest memory (so it
+// works even though this VM has no memory layout installed).
+func TestSaveDeviceStateLoadDeviceStateRoundTrip(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	vm := NewVirtualMachine(nil, nil)
+
+	pic := NewMasterPIC()
+	pic.RaiseIRQ(2)
+	pit := NewPITDevice(clock)
+	programPITChannel0(t, pit, 999)
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+
+	for _, dev := range []PioDevice{pic, pit, kbc} {
+		if err := vm.RegisterDevice(dev); err != nil {
+			t.Fatalf("RegisterDevice(%s): %v", dev.Name(), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := vm.SaveDeviceState(&buf); err != nil {
+		t.Fatalf("SaveDeviceState: %v", err)
+	}
+
+	// Mutate every device so LoadDeviceState has something to undo.
+	pic.RaiseIRQ(6)
+	clock.Advance(500 * pitTickDuration)
+	if err := kbc.HandleIO(kbcPortStatus, []byte{kbcCmdWriteOutputPort}, true); err != nil {
+		t.Fatalf("write command: %v", err)
+	}
+
+	if err := vm.LoadDeviceState(&buf); err != nil {
+		t.Fatalf("LoadDeviceState: %v", err)
+	}
+
+	if pic.irr&(1<<6) != 0 {
+		t.Error("restored PIC still has IRQ6 raised, want it reverted to the saved state")
+	}
+	if got, want := readPITChannel0(t, pit), uint16(999); got != want {
+		t.Errorf("restored PIT count = %d, want %d", got, want)
+	}
+	if kbc.pendingCmd != 0 {
+		t.Errorf("restored kbc.pendingCmd = %#x, want 0 (no command was pending when saved)", kbc.pendingCmd)
+	}
+}
+
+// TestLoadDeviceStateRejectsBadMagic checks LoadDeviceState refuses a
+// stream that isn't one SaveDeviceState wrote.
+func TestLoadDeviceStateRejectsBadMagic(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	if err := vm.LoadDeviceState(bytes.NewReader([]byte("not a device state stream"))); err == nil {
+		t.Fatal("LoadDeviceState with garbage input = nil error, want one")
+	}
+}
@@ -0,0 +1,59 @@
+package vmm
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// DirtyLogReader reads back a memory slot's dirty-page bitmap, abstracting
+// over KVM_GET_DIRTY_LOG so GetDirtyPages and SnapshotIncremental are
+// testable without a real /dev/kvm file descriptor: production code wires
+// in NewKVMDirtyLogReader, tests wire in a hypervisor.FakeDirtyLog.
+type DirtyLogReader interface {
+	// GetDirtyLog fills bitmap with slot's dirty-page bitmap (one bit per
+	// guest page, LSB-first within each word) and clears it, the same as
+	// the underlying KVM_GET_DIRTY_LOG ioctl.
+	GetDirtyLog(slot uint32, bitmap []uint64) error
+}
+
+// kvmDirtyLogReader adapts hypervisor.GetDirtyLog, which operates on a raw
+// VM file descriptor, to DirtyLogReader.
+type kvmDirtyLogReader struct {
+	vmFile *os.File
+}
+
+// NewKVMDirtyLogReader returns a DirtyLogReader backed by a real KVM VM
+// file descriptor, for use with SetDirtyLogReader outside of tests.
+func NewKVMDirtyLogReader(vmFile *os.File) DirtyLogReader {
+	return &kvmDirtyLogReader{vmFile: vmFile}
+}
+
+// GetDirtyLog implements DirtyLogReader.
+func (r *kvmDirtyLogReader) GetDirtyLog(slot uint32, bitmap []uint64) error {
+	return hypervisor.GetDirtyLog(r.vmFile, slot, bitmap)
+}
+
+// GetDirtyPages returns the dirty-page bitmap for the memory slot at
+// index slot (in MemoryLayout registration order), one bit per guest
+// page, LSB-first within each word. Like the underlying KVM_GET_DIRTY_LOG
+// ioctl, a successful call clears the log, so a later call only reports
+// pages dirtied since this one.
+func (vm *VirtualMachine) GetDirtyPages(slot uint32) (bitmap []uint64, err error) {
+	if vm.dirtyLog == nil {
+		return nil, fmt.Errorf("vmm: GetDirtyPages: no dirty log reader installed (call SetDirtyLogReader first)")
+	}
+	if vm.memory == nil {
+		return nil, fmt.Errorf("vmm: GetDirtyPages: no memory layout installed (call SetMemoryLayout first)")
+	}
+	slots := vm.memory.Slots()
+	if int(slot) >= len(slots) {
+		return nil, fmt.Errorf("vmm: GetDirtyPages: slot %d out of range (%d slots registered)", slot, len(slots))
+	}
+	bitmap = make([]uint64, dirtyBitmapWords(len(slots[slot].Backing)))
+	if err := vm.dirtyLog.GetDirtyLog(slot, bitmap); err != nil {
+		return nil, fmt.Errorf("vmm: GetDirtyPages: slot %d: %w", slot, err)
+	}
+	return bitmap, nil
+}
@@ -0,0 +1,121 @@
+package vmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// newDirtyLogTestVM builds a VM with one FakeVCPU and a single
+// dirty-tracked RAM slot of size ramSize, wired to a FakeDirtyLog.
+func newDirtyLogTestVM(t *testing.T, ramSize int) (*VirtualMachine, *MemoryLayout, *hypervisor.FakeDirtyLog) {
+	t.Helper()
+
+	mem := NewMemoryLayout()
+	if err := mem.AddSlotWithFlags(0, make([]byte, ramSize), true); err != nil {
+		t.Fatalf("AddSlotWithFlags: %v", err)
+	}
+
+	vcpu := &hypervisor.FakeVCPU{}
+	vm := NewVirtualMachine([]hypervisor.VCPU{vcpu}, nil)
+	vm.SetMemoryLayout(mem)
+
+	dirty := hypervisor.NewFakeDirtyLog()
+	vm.SetDirtyLogReader(dirty)
+
+	return vm, mem, dirty
+}
+
+// TestGetDirtyPagesReportsOnlyMarkedPages checks GetDirtyPages reflects
+// exactly the pages MarkPageDirty flagged, and that reading the log
+// clears it.
+func TestGetDirtyPagesReportsOnlyMarkedPages(t *testing.T) {
+	vm, _, dirty := newDirtyLogTestVM(t, 3*dirtyPageSize)
+
+	dirty.MarkPageDirty(0, 1)
+
+	bitmap, err := vm.GetDirtyPages(0)
+	if err != nil {
+		t.Fatalf("GetDirtyPages: %v", err)
+	}
+	if want := uint64(1 << 1); bitmap[0] != want {
+		t.Errorf("bitmap[0] = %#x, want %#x", bitmap[0], want)
+	}
+
+	bitmap, err = vm.GetDirtyPages(0)
+	if err != nil {
+		t.Fatalf("GetDirtyPages: %v", err)
+	}
+	if bitmap[0] != 0 {
+		t.Errorf("bitmap[0] after second call = %#x, want 0 (log should have cleared)", bitmap[0])
+	}
+}
+
+// TestSnapshotIncrementalRoundTrip takes a base snapshot, dirties three
+// specific pages, takes an incremental snapshot, checks only those three
+// pages appear in it, and checks that restoring the base followed by the
+// incremental into a fresh VM reproduces the final memory image.
+func TestSnapshotIncrementalRoundTrip(t *testing.T) {
+	const ramSize = 8 * dirtyPageSize
+	vm1, mem1, dirty1 := newDirtyLogTestVM(t, ramSize)
+
+	backing := mem1.Slots()[0].Backing
+	for i := range backing {
+		backing[i] = byte(i)
+	}
+
+	var base bytes.Buffer
+	if err := vm1.Snapshot(&base); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	dirtyPages := []uint64{2, 5, 7}
+	for _, p := range dirtyPages {
+		off := p * dirtyPageSize
+		for i := uint64(0); i < dirtyPageSize; i++ {
+			backing[off+i] = 0xaa
+		}
+		dirty1.MarkPageDirty(0, p)
+	}
+
+	var incr bytes.Buffer
+	if err := vm1.SnapshotIncremental(&incr, 0); err != nil {
+		t.Fatalf("SnapshotIncremental: %v", err)
+	}
+
+	data := incr.Bytes()
+	const headerSize = 8 + 4 + 4 + 4 // magic + formatVersion + slot + pageCount
+	pageCount := binary.LittleEndian.Uint32(data[16:20])
+	if int(pageCount) != len(dirtyPages) {
+		t.Fatalf("incremental snapshot has %d pages, want %d", pageCount, len(dirtyPages))
+	}
+	gotPages := make(map[uint64]bool)
+	off := headerSize
+	for i := 0; i < int(pageCount); i++ {
+		idx := binary.LittleEndian.Uint64(data[off : off+8])
+		gotPages[idx] = true
+		off += 8 + dirtyPageSize
+	}
+	for _, p := range dirtyPages {
+		if !gotPages[p] {
+			t.Errorf("incremental snapshot missing dirtied page %d", p)
+		}
+	}
+	if len(gotPages) != len(dirtyPages) {
+		t.Errorf("incremental snapshot holds %d distinct pages, want exactly %d", len(gotPages), len(dirtyPages))
+	}
+
+	vm2, mem2, _ := newDirtyLogTestVM(t, ramSize)
+	if err := vm2.RestoreSnapshot(bytes.NewReader(base.Bytes())); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if err := vm2.RestoreIncremental(bytes.NewReader(incr.Bytes())); err != nil {
+		t.Fatalf("RestoreIncremental: %v", err)
+	}
+
+	if !bytes.Equal(mem2.Slots()[0].Backing, backing) {
+		t.Errorf("restored memory does not match source after base snapshot + incremental")
+	}
+}
@@ -0,0 +1,57 @@
+package vmm
+
+import "testing"
+
+func TestDMAControllerProgramsChannel2Address(t *testing.T) {
+	d := NewDMAControllerDevice()
+
+	must := func(err error) {
+		if err != nil {
+			t.Fatalf("HandleIO: %v", err)
+		}
+	}
+	must(d.HandleIO(dma1AddrPort[2], []byte{0x34}, true))
+	must(d.HandleIO(dma1AddrPort[2], []byte{0x12}, true))
+	must(d.HandleIO(dma1PagePort[2], []byte{0x01}, true))
+	must(d.HandleIO(dmaPortMask, []byte{0x02}, true)) // unmask channel 2
+
+	ch := d.Channel(2)
+	if ch.Address != 0x1234 {
+		t.Errorf("Address = %#x, want 0x1234", ch.Address)
+	}
+	if ch.BaseAddress() != 0x011234 {
+		t.Errorf("BaseAddress = %#x, want 0x011234", ch.BaseAddress())
+	}
+	if ch.Masked {
+		t.Errorf("channel 2 still masked")
+	}
+}
+
+func TestDMATransferToMemoryHonorsBaseAddressAndCount(t *testing.T) {
+	d := NewDMAControllerDevice()
+	must := func(err error) {
+		if err != nil {
+			t.Fatalf("HandleIO: %v", err)
+		}
+	}
+	must(d.HandleIO(dma1AddrPort[1], []byte{0x00}, true))
+	must(d.HandleIO(dma1AddrPort[1], []byte{0x00}, true))
+	must(d.HandleIO(dma1PagePort[1], []byte{0x00}, true))
+	must(d.HandleIO(dmaPortFlipFlop, []byte{0}, true))
+	must(d.HandleIO(dma1CountPort[1], []byte{0x01}, true)) // count-1 => 2 bytes
+	must(d.HandleIO(dma1CountPort[1], []byte{0x00}, true))
+	must(d.HandleIO(dmaPortMask, []byte{0x01}, true)) // unmask channel 1
+
+	mem := NewGuestMemory(make([]byte, 16), nil)
+	if err := d.TransferToMemory(1, mem, []byte{0xaa, 0xbb, 0xcc}); err != nil {
+		t.Fatalf("TransferToMemory: %v", err)
+	}
+
+	got := make([]byte, 3)
+	if err := mem.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if got[0] != 0xaa || got[1] != 0xbb || got[2] != 0x00 {
+		t.Errorf("got %v, want [aa bb 00] (transfer capped at count+1=2 bytes)", got)
+	}
+}
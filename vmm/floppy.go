@@ -0,0 +1,288 @@
+package vmm
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Standard PC floppy controller (82077-compatible) primary ports.
+const (
+	fdcPortDOR    = 0x3f2 // digital output register
+	fdcPortStatus = 0x3f4 // main status register
+	fdcPortData   = 0x3f5 // data register (command/parameter/result FIFO)
+)
+
+// Main status register bits.
+const (
+	fdcMSRRQM  = 1 << 7 // request for master: FIFO ready
+	fdcMSRDIO  = 1 << 6 // 1 = controller->CPU (read), 0 = CPU->controller
+	fdcMSRBusy = 1 << 4
+)
+
+// Commands this controller understands.
+const (
+	fdcCmdSpecify        = 0x03
+	fdcCmdReadData       = 0xe6 // MT|MFM|SK bits set, base command 0x06
+	fdcCmdWriteData      = 0xc5 // MT|MFM bits set, base command 0x05
+	fdcCmdRecalibrate    = 0x07
+	fdcCmdSenseIntStatus = 0x08
+)
+
+const (
+	fdcBytesPerSector  = 512
+	fdcSectorsPerTrack = 18
+	fdcHeads           = 2
+)
+
+// FloppyControllerDevice emulates enough of an 82077 floppy controller
+// (PIO, no DMA) to read a 1.44MB image: RECALIBRATE, SPECIFY, SENSE
+// INTERRUPT STATUS, and READ DATA in CHS addressing.
+type FloppyControllerDevice struct {
+	mu sync.Mutex
+
+	image io.ReaderAt
+	// writer is non-nil when the backing image accepts WRITE DATA; nil
+	// makes the drive behave as write-protected media.
+	writer io.WriterAt
+	size   int64
+
+	dor uint8
+
+	cmd     []uint8 // command bytes accumulated so far
+	cmdWant int
+	result  []uint8
+	resIdx  int
+
+	xferBuf   []byte
+	xferIdx   int
+	xferWrite bool
+	xferLBA   int64
+	xferCount int64
+}
+
+// NewFloppyControllerDevice returns a controller that reads sectors from
+// image, a flat 1.44MB (or compatible) disk image. writer may be nil to
+// model write-protected media.
+func NewFloppyControllerDevice(image io.ReaderAt, writer io.WriterAt, size int64) *FloppyControllerDevice {
+	return &FloppyControllerDevice{image: image, writer: writer, size: size}
+}
+
+// NewFloppyControllerDeviceFromFile opens path as a flat floppy image.
+func NewFloppyControllerDeviceFromFile(path string, writable bool) (*FloppyControllerDevice, error) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var writer io.WriterAt
+	if writable {
+		writer = f
+	}
+	return NewFloppyControllerDevice(f, writer, info.Size()), nil
+}
+
+// Ports implements PioDevice.
+func (f *FloppyControllerDevice) Ports() []uint16 {
+	return []uint16{fdcPortDOR, fdcPortStatus, fdcPortData}
+}
+
+// HandleIO implements PioDevice.
+func (f *FloppyControllerDevice) HandleIO(port uint16, data []byte, write bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch port {
+	case fdcPortDOR:
+		if write && len(data) > 0 {
+			f.dor = data[0]
+		} else if len(data) > 0 {
+			data[0] = f.dor
+		}
+	case fdcPortStatus:
+		if !write && len(data) > 0 {
+			data[0] = f.statusRegister()
+		}
+	case fdcPortData:
+		f.handleData(data, write)
+	}
+	return nil
+}
+
+func (f *FloppyControllerDevice) statusRegister() uint8 {
+	msr := fdcMSRRQM
+	if len(f.result) > 0 || (len(f.xferBuf) > 0 && !f.xferWrite) {
+		msr |= fdcMSRDIO
+	}
+	return uint8(msr)
+}
+
+func (f *FloppyControllerDevice) handleData(data []byte, write bool) {
+	if len(data) == 0 {
+		return
+	}
+
+	// Drain a pending result or sector-data readback first.
+	if !write {
+		if f.resIdx < len(f.result) {
+			data[0] = f.result[f.resIdx]
+			f.resIdx++
+			if f.resIdx == len(f.result) {
+				f.result = nil
+				f.resIdx = 0
+			}
+			return
+		}
+		if f.xferIdx < len(f.xferBuf) {
+			data[0] = f.xferBuf[f.xferIdx]
+			f.xferIdx++
+			if f.xferIdx == len(f.xferBuf) {
+				f.xferBuf = nil
+				f.xferIdx = 0
+			}
+			return
+		}
+		data[0] = 0
+		return
+	}
+
+	if f.xferWrite && len(f.xferBuf) > 0 {
+		f.xferBuf[f.xferIdx] = data[0]
+		f.xferIdx++
+		if f.xferIdx == len(f.xferBuf) {
+			f.flushWrite()
+		}
+		return
+	}
+
+	f.cmd = append(f.cmd, data[0])
+	if f.cmdWant == 0 {
+		f.cmdWant = commandLength(f.cmd[0])
+	}
+	if len(f.cmd) < f.cmdWant {
+		return
+	}
+	f.execute(f.cmd)
+	f.cmd = nil
+	f.cmdWant = 0
+}
+
+func (f *FloppyControllerDevice) flushWrite() {
+	if f.writer != nil {
+		if _, err := f.writer.WriteAt(f.xferBuf, f.xferLBA*fdcBytesPerSector); err != nil {
+			f.result = []uint8{0x40, 0, 0}
+		} else {
+			f.result = []uint8{0x00, 0, 0}
+		}
+	} else {
+		f.result = []uint8{0x40, 0, 0} // write-protected
+	}
+	f.xferBuf = nil
+	f.xferIdx = 0
+	f.xferWrite = false
+}
+
+func commandLength(cmd uint8) int {
+	switch cmd {
+	case fdcCmdSpecify:
+		return 3
+	case fdcCmdRecalibrate:
+		return 2
+	case fdcCmdSenseIntStatus:
+		return 1
+	case fdcCmdReadData, fdcCmdWriteData:
+		return 9
+	default:
+		return 1
+	}
+}
+
+func (f *FloppyControllerDevice) execute(cmd []uint8) {
+	switch cmd[0] {
+	case fdcCmdSpecify:
+		// No result phase.
+	case fdcCmdRecalibrate:
+		// No result phase; a real controller raises IRQ6, deferred here.
+	case fdcCmdSenseIntStatus:
+		f.result = []uint8{0x20, 0} // ST0 (seek end), present cylinder
+	case fdcCmdReadData:
+		f.doReadData(cmd)
+	case fdcCmdWriteData:
+		f.doWriteData(cmd)
+	}
+}
+
+// doReadData decodes the CHS parameters of a READ DATA command
+// (cmd[1]=head/drive, cmd[2]=cylinder, cmd[3]=head, cmd[4]=sector,
+// cmd[5]=bytes-per-sector code, cmd[6]=EOT) and stages the requested
+// sectors for readback via fdcPortData.
+func (f *FloppyControllerDevice) doReadData(cmd []uint8) {
+	cylinder := int64(cmd[2])
+	head := int64(cmd[3])
+	sector := int64(cmd[4]) // 1-based
+	eot := int64(cmd[6])
+
+	lba := (cylinder*fdcHeads+head)*fdcSectorsPerTrack + (sector - 1)
+	count := eot - sector + 1
+	if count < 1 {
+		count = 1
+	}
+
+	buf := make([]byte, count*fdcBytesPerSector)
+	if _, err := f.image.ReadAt(buf, lba*fdcBytesPerSector); err != nil && err != io.EOF {
+		f.result = []uint8{0x40, 0, 0} // ST0 abnormal termination
+		return
+	}
+	f.xferBuf = buf
+	f.xferIdx = 0
+	f.xferWrite = false
+	f.result = []uint8{0x00, 0, 0, uint8(cylinder), uint8(head), uint8(sector), 0x02}
+}
+
+// doWriteData mirrors doReadData but stages an empty buffer to be filled
+// by the guest via fdcPortData writes, flushed to the backing image once
+// full.
+func (f *FloppyControllerDevice) doWriteData(cmd []uint8) {
+	cylinder := int64(cmd[2])
+	head := int64(cmd[3])
+	sector := int64(cmd[4])
+	eot := int64(cmd[6])
+
+	lba := (cylinder*fdcHeads+head)*fdcSectorsPerTrack + (sector - 1)
+	count := eot - sector + 1
+	if count < 1 {
+		count = 1
+	}
+
+	f.xferBuf = make([]byte, count*fdcBytesPerSector)
+	f.xferIdx = 0
+	f.xferWrite = true
+	f.xferLBA = lba
+	f.xferCount = count
+}
+
+// Reset implements PioDevice.
+func (f *FloppyControllerDevice) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dor = 0
+	f.cmd = nil
+	f.cmdWant = 0
+	f.result = nil
+	f.resIdx = 0
+	f.xferBuf = nil
+	f.xferIdx = 0
+	f.xferWrite = false
+}
+
+// Name implements PioDevice.
+func (f *FloppyControllerDevice) Name() string { return "floppy" }
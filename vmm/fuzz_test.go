@@ -0,0 +1,118 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+)
+
+// This file fuzzes PioDevice.HandleIO implementations directly with
+// arbitrary port offsets, directions, sizes and data, per the standing
+// requirement that a malicious or buggy guest must never be able to
+// crash the VMM through port I/O. Every handler in this package already
+// guards data[0] behind a len(data) > 0 check and bound-checks its own
+// RAM/ring indexing before use, so these fuzz targets exist to keep that
+// property from regressing rather than to fix a currently-reachable
+// panic.
+
+// fuzzWidth clamps a fuzzer-supplied size to something a real guest could
+// issue (1, 2 or 4 bytes), so the corpus doesn't waste time on widths no
+// caller ever produces.
+func fuzzWidth(size uint8) int {
+	switch size % 4 {
+	case 0:
+		return 1
+	case 1:
+		return 2
+	default:
+		return 4
+	}
+}
+
+func FuzzNE2000HandleIO(f *testing.F) {
+	f.Add(uint16(0x00), uint8(0), false, []byte{0x01})
+	f.Add(uint16(0x10), uint8(1), true, []byte{0xaa, 0xbb})
+	f.Add(uint16(0x1f), uint8(3), false, []byte{})
+	f.Add(uint16(0x0c), uint8(0), true, []byte{0xff, 0xff, 0xff, 0xff})
+
+	d := newTestNE2000(&fakeTap{})
+	f.Fuzz(func(t *testing.T, offset uint16, size uint8, write bool, data []byte) {
+		buf := make([]byte, fuzzWidth(size))
+		copy(buf, data)
+		port := d.base + (offset % 0x20)
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("HandleIO panicked on port %#x write=%v data=%x: %v", port, write, buf, r)
+			}
+		}()
+		_ = d.HandleIO(port, buf, write)
+	})
+}
+
+func FuzzNE2000ReceiveFrame(f *testing.F) {
+	f.Add([]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{})
+	f.Add(make([]byte, 1600))
+
+	d := newTestNE2000(nil)
+	f.Fuzz(func(t *testing.T, pkt []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("ReceiveFrame panicked on a %d-byte frame: %v", len(pkt), r)
+			}
+		}()
+		_ = d.ReceiveFrame(pkt)
+	})
+}
+
+func FuzzPITHandleIO(f *testing.F) {
+	f.Add(uint16(0), uint8(0), false, []byte{0x00})
+	f.Add(uint16(3), uint8(0), true, []byte{0x36})
+
+	clock := NewManualClock(time.Unix(0, 0))
+	p := NewPITDevice(clock)
+	f.Fuzz(func(t *testing.T, portOffset uint16, size uint8, write bool, data []byte) {
+		buf := make([]byte, fuzzWidth(size))
+		copy(buf, data)
+		port := pitPortCounter0 + (portOffset % 4)
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("HandleIO panicked on port %#x write=%v data=%x: %v", port, write, buf, r)
+			}
+		}()
+		_ = p.HandleIO(port, buf, write)
+	})
+}
+
+func FuzzIOBusDispatch(f *testing.F) {
+	f.Add(uint16(0x300), uint8(0), false, []byte{0x00})
+	f.Add(uint16(0xcf9), uint8(0), true, []byte{0x06})
+
+	bus := NewIOBus()
+	if err := bus.Register(NewNE2000Device([6]byte{0x52, 0x54, 0, 0, 0, 1}, nil)); err != nil {
+		f.Fatalf("Register NE2000: %v", err)
+	}
+	if err := bus.Register(NewPITDevice(NewManualClock(time.Unix(0, 0)))); err != nil {
+		f.Fatalf("Register PIT: %v", err)
+	}
+	if err := bus.Register(NewResetControlDevice(func() {})); err != nil {
+		f.Fatalf("Register ResetControl: %v", err)
+	}
+	if err := bus.Register(NewKeyboardControllerDevice(func() {}, NewA20Gate())); err != nil {
+		f.Fatalf("Register KeyboardController: %v", err)
+	}
+
+	f.Fuzz(func(t *testing.T, port uint16, size uint8, write bool, data []byte) {
+		buf := make([]byte, fuzzWidth(size))
+		copy(buf, data)
+		before := len(buf)
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("Dispatch panicked on port %#x write=%v data=%x: %v", port, write, buf, r)
+			}
+		}()
+		_ = bus.Dispatch(port, buf, write)
+		if len(buf) != before {
+			t.Fatalf("Dispatch grew the caller's buffer from %d to %d bytes", before, len(buf))
+		}
+	})
+}
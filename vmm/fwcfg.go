@@ -0,0 +1,213 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// fw_cfg port assignments, matching QEMU's traditional (non-DMA)
+// interface: OUT a 16-bit selector to fwCfgPortSelector, then IN from
+// fwCfgPortData to read the selected item's bytes sequentially.
+const (
+	fwCfgPortSelector uint16 = 0x510
+	fwCfgPortData     uint16 = 0x511
+)
+
+// Well-known fw_cfg selectors (see QEMU's docs/specs/fw_cfg.txt). Named
+// files registered with AddFile are assigned selectors starting at
+// fwCfgFileFirst, in registration order.
+const (
+	fwCfgSignature uint16 = 0x0000
+	fwCfgID        uint16 = 0x0001
+	fwCfgFileDir   uint16 = 0x0019
+	fwCfgFileFirst uint16 = 0x0020
+)
+
+// fwCfgFileNameSize is the fixed width of a file directory entry's name
+// field, including its NUL terminator.
+const fwCfgFileNameSize = 56
+
+// Conventional fw_cfg file names SeaBIOS's direct-kernel-boot loader
+// looks for, for InstallKernelBootFiles to register under.
+const (
+	FwCfgFileKernel  = "kernel"
+	FwCfgFileInitrd  = "initrd"
+	FwCfgFileCmdline = "cmdline"
+)
+
+type fwCfgFile struct {
+	name string
+	data []byte
+}
+
+// FwCfgDevice implements the read-only, traditional half of QEMU's
+// fw_cfg interface: the architectural signature/id/file-directory
+// selectors, plus AddFile for registering arbitrary named blobs (a
+// kernel image, an initrd, a command line, or anything else), well
+// enough for an unmodified SeaBIOS or similar boot ROM to enumerate and
+// fetch them exactly as it would from real QEMU. It deliberately doesn't
+// implement the newer DMA interface (FW_CFG_DMA_SIGNATURE and its MMIO
+// register): the request this device was built for only needs firmware
+// to be able to boot, and the selector/data port pair is sufficient for
+// that.
+type FwCfgDevice struct {
+	mu sync.Mutex
+
+	files    []fwCfgFile
+	selected uint16
+	cursor   int
+}
+
+// NewFwCfgDevice returns an FwCfgDevice with no named files registered
+// yet, selecting FW_CFG_SIGNATURE (the power-on default).
+func NewFwCfgDevice() *FwCfgDevice {
+	return &FwCfgDevice{}
+}
+
+// AddFile registers data under name (e.g. "kernel", "opt/guestparams"),
+// assigning it the next selector in registration order. It returns an
+// error if name is already registered or too long to fit the file
+// directory's fixed-width name field.
+func (f *FwCfgDevice) AddFile(name string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(name)+1 > fwCfgFileNameSize {
+		return fmt.Errorf("vmm: fwcfg: file name %q is longer than %d bytes", name, fwCfgFileNameSize-1)
+	}
+	for _, existing := range f.files {
+		if existing.name == name {
+			return fmt.Errorf("vmm: fwcfg: file %q already registered", name)
+		}
+	}
+	f.files = append(f.files, fwCfgFile{name: name, data: data})
+	return nil
+}
+
+// InstallKernelBootFiles registers kernel, initrd, and cmdline under the
+// conventional names FwCfgFileKernel/FwCfgFileInitrd/FwCfgFileCmdline, so
+// a boot ROM doing a direct kernel boot can find them. kernel, initrd,
+// and cmdline are each skipped (left unregistered) when empty, so a VM
+// that only supplies a kernel doesn't need to pass an empty initrd.
+func InstallKernelBootFiles(dev *FwCfgDevice, kernel, initrd []byte, cmdline string) error {
+	if len(kernel) > 0 {
+		if err := dev.AddFile(FwCfgFileKernel, kernel); err != nil {
+			return fmt.Errorf("vmm: InstallKernelBootFiles: %w", err)
+		}
+	}
+	if len(initrd) > 0 {
+		if err := dev.AddFile(FwCfgFileInitrd, initrd); err != nil {
+			return fmt.Errorf("vmm: InstallKernelBootFiles: %w", err)
+		}
+	}
+	if cmdline != "" {
+		// A NUL terminator, since SeaBIOS's loader treats the command
+		// line as a C string rather than using the file's declared size.
+		if err := dev.AddFile(FwCfgFileCmdline, append([]byte(cmdline), 0)); err != nil {
+			return fmt.Errorf("vmm: InstallKernelBootFiles: %w", err)
+		}
+	}
+	return nil
+}
+
+// Ports implements PioDevice.
+func (f *FwCfgDevice) Ports() []uint16 { return []uint16{fwCfgPortSelector, fwCfgPortData} }
+
+// Name implements PioDevice.
+func (f *FwCfgDevice) Name() string { return "fwcfg" }
+
+// Reset implements PioDevice. It reselects FW_CFG_SIGNATURE and rewinds
+// the read cursor, the power-on state a real fw_cfg device starts in.
+func (f *FwCfgDevice) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.selected = fwCfgSignature
+	f.cursor = 0
+}
+
+// HandleIO implements PioDevice. Selecting an item (a write to
+// fwCfgPortSelector) always resets the read cursor to 0, so a guest that
+// reselects an item mid-read starts over from the beginning; reading
+// past the end of the selected item's content returns zero bytes rather
+// than erroring.
+func (f *FwCfgDevice) HandleIO(port uint16, data []byte, write bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch port {
+	case fwCfgPortSelector:
+		if !write {
+			return fmt.Errorf("vmm: fwcfg: port %#x is write-only", port)
+		}
+		var sel uint16
+		for i := len(data) - 1; i >= 0; i-- {
+			sel = sel<<8 | uint16(data[i])
+		}
+		f.selected = sel
+		f.cursor = 0
+		return nil
+	case fwCfgPortData:
+		if write {
+			return fmt.Errorf("vmm: fwcfg: port %#x is read-only", port)
+		}
+		item := f.selectedItemLocked()
+		for i := range data {
+			if f.cursor < len(item) {
+				data[i] = item[f.cursor]
+				f.cursor++
+			} else {
+				data[i] = 0
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("vmm: fwcfg: unexpected port %#x", port)
+	}
+}
+
+// selectedItemLocked returns the byte content of the currently selected
+// item: the architectural ones are computed on the fly, and named files
+// are looked up by the selector AddFile assigned them. An unrecognized
+// selector (never assigned, or past the end of the file list) reports an
+// empty item, so a read of it just returns zero bytes. f.mu must be held.
+func (f *FwCfgDevice) selectedItemLocked() []byte {
+	switch f.selected {
+	case fwCfgSignature:
+		return []byte("QEMU")
+	case fwCfgID:
+		return []byte{0, 0, 0, 0} // no DMA interface: all feature bits clear
+	case fwCfgFileDir:
+		return f.buildFileDirLocked()
+	default:
+		if idx := int(f.selected - fwCfgFileFirst); idx >= 0 && idx < len(f.files) {
+			return f.files[idx].data
+		}
+		return nil
+	}
+}
+
+// buildFileDirLocked renders the FW_CFG_FILE_DIR item: a big-endian
+// uint32 file count followed by one 64-byte directory entry per
+// registered file. Per the fw_cfg spec, every field in this structure is
+// big-endian, unlike the rest of the interface. Each entry is:
+//
+//	offset  0: 4 bytes  size, big-endian uint32 (byte length of the file's content)
+//	offset  4: 2 bytes  select, big-endian uint16 (the selector fetching it)
+//	offset  6: 2 bytes  reserved (always zero)
+//	offset  8: 56 bytes name, NUL-padded ASCII
+//
+// f.mu must be held.
+func (f *FwCfgDevice) buildFileDirLocked() []byte {
+	const entrySize = 64
+	buf := make([]byte, 4+entrySize*len(f.files))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(f.files)))
+	for i, file := range f.files {
+		off := 4 + entrySize*i
+		binary.BigEndian.PutUint32(buf[off:off+4], uint32(len(file.data)))
+		binary.BigEndian.PutUint16(buf[off+4:off+6], fwCfgFileFirst+uint16(i))
+		// buf[off+6:off+8] (reserved) stays zero.
+		copy(buf[off+8:off+entrySize], file.name)
+	}
+	return buf
+}
@@ -0,0 +1,219 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// selectFwCfg drives the selector port the way real firmware would: a
+// 2-byte little-endian OUT to fwCfgPortSelector.
+func selectFwCfg(t *testing.T, dev *FwCfgDevice, selector uint16) {
+	t.Helper()
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, selector)
+	if err := dev.HandleIO(fwCfgPortSelector, buf, true); err != nil {
+		t.Fatalf("HandleIO(select %#x): %v", selector, err)
+	}
+}
+
+// readFwCfg reads n bytes from the currently selected item, one byte at
+// a time, the way real firmware's fw_cfg driver does.
+func readFwCfg(t *testing.T, dev *FwCfgDevice, n int) []byte {
+	t.Helper()
+	out := make([]byte, n)
+	for i := range out {
+		buf := make([]byte, 1)
+		if err := dev.HandleIO(fwCfgPortData, buf, false); err != nil {
+			t.Fatalf("HandleIO(data) at byte %d: %v", i, err)
+		}
+		out[i] = buf[0]
+	}
+	return out
+}
+
+// fwCfgDirEntry is one parsed FW_CFG_FILE_DIR entry.
+type fwCfgDirEntry struct {
+	size     uint32
+	selector uint16
+	name     string
+}
+
+// enumerateFwCfgFiles selects FW_CFG_FILE_DIR and parses it back into
+// entries, exactly as firmware scanning for a named file would.
+func enumerateFwCfgFiles(t *testing.T, dev *FwCfgDevice) []fwCfgDirEntry {
+	t.Helper()
+	selectFwCfg(t, dev, fwCfgFileDir)
+	count := binary.BigEndian.Uint32(readFwCfg(t, dev, 4))
+
+	entries := make([]fwCfgDirEntry, count)
+	for i := range entries {
+		raw := readFwCfg(t, dev, 64)
+		entries[i] = fwCfgDirEntry{
+			size:     binary.BigEndian.Uint32(raw[0:4]),
+			selector: binary.BigEndian.Uint16(raw[4:6]),
+			name:     string(bytesUntilNUL(raw[8:64])),
+		}
+	}
+	return entries
+}
+
+func bytesUntilNUL(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
+
+// fetchFwCfgFile enumerates dev's file directory, finds name, and reads
+// its full content back through the port interface.
+func fetchFwCfgFile(t *testing.T, dev *FwCfgDevice, name string) []byte {
+	t.Helper()
+	for _, e := range enumerateFwCfgFiles(t, dev) {
+		if e.name == name {
+			selectFwCfg(t, dev, e.selector)
+			return readFwCfg(t, dev, int(e.size))
+		}
+	}
+	t.Fatalf("fw_cfg file %q not found in directory", name)
+	return nil
+}
+
+func TestFwCfgDeviceSignatureAndID(t *testing.T) {
+	dev := NewFwCfgDevice()
+
+	selectFwCfg(t, dev, fwCfgSignature)
+	if got := readFwCfg(t, dev, 4); string(got) != "QEMU" {
+		t.Errorf("signature = %q, want %q", got, "QEMU")
+	}
+
+	selectFwCfg(t, dev, fwCfgID)
+	if got := readFwCfg(t, dev, 4); !reflect.DeepEqual(got, []byte{0, 0, 0, 0}) {
+		t.Errorf("id = %v, want all-zero (no DMA interface)", got)
+	}
+}
+
+// TestFwCfgDeviceEnumeratesFileDirectory registers a couple of files and
+// enumerates the directory exactly as firmware would: select
+// FW_CFG_FILE_DIR, read the big-endian count, then one 64-byte entry per
+// file.
+func TestFwCfgDeviceEnumeratesFileDirectory(t *testing.T) {
+	dev := NewFwCfgDevice()
+	if err := dev.AddFile("kernel", []byte("fake-kernel-image")); err != nil {
+		t.Fatalf("AddFile(kernel): %v", err)
+	}
+	if err := dev.AddFile("cmdline", []byte("console=ttyS0\x00")); err != nil {
+		t.Fatalf("AddFile(cmdline): %v", err)
+	}
+
+	entries := enumerateFwCfgFiles(t, dev)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].name != "kernel" || entries[0].size != uint32(len("fake-kernel-image")) {
+		t.Errorf("entries[0] = %+v, want name kernel, size %d", entries[0], len("fake-kernel-image"))
+	}
+	if entries[0].selector != fwCfgFileFirst {
+		t.Errorf("entries[0].selector = %#x, want %#x", entries[0].selector, fwCfgFileFirst)
+	}
+	if entries[1].name != "cmdline" || entries[1].selector != fwCfgFileFirst+1 {
+		t.Errorf("entries[1] = %+v, want name cmdline, selector %#x", entries[1], fwCfgFileFirst+1)
+	}
+}
+
+// TestFwCfgDeviceFetchesFileContents fetches a registered file's content
+// by name via the port interface and checks it matches what was
+// registered, and that reselecting resets the read cursor rather than
+// continuing from wherever the last read left off.
+func TestFwCfgDeviceFetchesFileContents(t *testing.T) {
+	dev := NewFwCfgDevice()
+	want := []byte("console=ttyS0 root=/dev/vda\x00")
+	if err := dev.AddFile("cmdline", want); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+
+	got := fetchFwCfgFile(t, dev, "cmdline")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched content = %q, want %q", got, want)
+	}
+
+	// Fetching it again re-reads from the start rather than picking up
+	// where the previous read's cursor stopped.
+	got2 := fetchFwCfgFile(t, dev, "cmdline")
+	if !reflect.DeepEqual(got2, want) {
+		t.Errorf("second fetch = %q, want %q", got2, want)
+	}
+}
+
+func TestFwCfgDeviceReadPastEndOfItemReturnsZero(t *testing.T) {
+	dev := NewFwCfgDevice()
+	selectFwCfg(t, dev, fwCfgSignature)
+	readFwCfg(t, dev, 4)
+
+	tail := readFwCfg(t, dev, 1)
+	if tail[0] != 0 {
+		t.Errorf("read past end of signature = %#x, want 0", tail[0])
+	}
+}
+
+func TestFwCfgDeviceRejectsWrongDirection(t *testing.T) {
+	dev := NewFwCfgDevice()
+	if err := dev.HandleIO(fwCfgPortSelector, []byte{0, 0}, false); err == nil {
+		t.Error("HandleIO read on the selector port: got nil error, want one")
+	}
+	if err := dev.HandleIO(fwCfgPortData, []byte{0}, true); err == nil {
+		t.Error("HandleIO write on the data port: got nil error, want one")
+	}
+}
+
+func TestFwCfgDeviceAddFileRejectsDuplicateName(t *testing.T) {
+	dev := NewFwCfgDevice()
+	if err := dev.AddFile("kernel", []byte("a")); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if err := dev.AddFile("kernel", []byte("b")); err == nil {
+		t.Error("AddFile with a duplicate name: got nil error, want one")
+	}
+}
+
+func TestFwCfgDeviceAddFileRejectsTooLongName(t *testing.T) {
+	dev := NewFwCfgDevice()
+	longName := make([]byte, fwCfgFileNameSize)
+	for i := range longName {
+		longName[i] = 'a'
+	}
+	if err := dev.AddFile(string(longName), []byte("x")); err == nil {
+		t.Error("AddFile with a too-long name: got nil error, want one")
+	}
+}
+
+func TestInstallKernelBootFilesRegistersOnlyNonEmptyFiles(t *testing.T) {
+	dev := NewFwCfgDevice()
+	if err := InstallKernelBootFiles(dev, []byte("kernel-bytes"), nil, "console=ttyS0"); err != nil {
+		t.Fatalf("InstallKernelBootFiles: %v", err)
+	}
+
+	entries := enumerateFwCfgFiles(t, dev)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2 (kernel and cmdline, no initrd)", len(entries))
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.name] = true
+	}
+	if !names[FwCfgFileKernel] || !names[FwCfgFileCmdline] {
+		t.Errorf("registered files = %+v, want kernel and cmdline", entries)
+	}
+	if names[FwCfgFileInitrd] {
+		t.Error("initrd was registered despite an empty initrd argument")
+	}
+}
+
+func TestFwCfgDeviceRegistersOnIOBus(t *testing.T) {
+	bus := NewIOBus()
+	if err := bus.Register(NewFwCfgDevice()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+}
@@ -0,0 +1,432 @@
+package vmm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// GDBTarget is the control surface StartGDBServer drives a VCPU through.
+// hypervisor.VCPU doesn't cover single-stepping, since that requires a
+// real KVM_SET_GUEST_DEBUG/KVM_RUN cycle against an *os.File a fake VCPU
+// has no way to honor (see hypervisor.StepInstruction); callers debugging
+// a real guest wire up their own thin adapter around
+// hypervisor.GetRegs/SetRegs/StepInstruction.
+type GDBTarget interface {
+	GetRegs() (hypervisor.KvmRegs, error)
+	SetRegs(hypervisor.KvmRegs) error
+	// Step executes exactly one guest instruction and returns the
+	// resulting registers.
+	Step() (hypervisor.KvmRegs, error)
+}
+
+// SetGDBTarget wires target as what StartGDBServer's "c"/"s" commands
+// drive, the same way SetInterruptInjector wires interrupt delivery: the
+// real ioctl plumbing (StepInstruction) lives outside this package, so
+// the caller supplies it. Call it once before StartGDBServer.
+func (vm *VirtualMachine) SetGDBTarget(target GDBTarget) {
+	vm.gdbTarget = target
+}
+
+// maxContinueSteps bounds how many single steps a "c" (continue) command
+// takes looking for a breakpoint hit, since this stub has no way to run
+// the guest freely: KVM only exposes single-step and hardware
+// breakpoints, and hardware breakpoints need a *os.File this package's
+// GDBTarget abstraction deliberately doesn't carry (see GDBTarget). A
+// real continue is therefore approximated by stepping and checking RIP
+// against the installed software breakpoints after every instruction.
+const maxContinueSteps = 1 << 20
+
+// gdbServer holds the software breakpoint set and connection loop for
+// StartGDBServer. It is unexported: callers only ever see it through
+// VirtualMachine.StartGDBServer.
+type gdbServer struct {
+	vm          *VirtualMachine
+	breakpoints map[uint64]bool
+}
+
+// StartGDBServer listens on addr and speaks the GDB remote serial
+// protocol against the target set with SetGDBTarget, letting a real gdb
+// connect with `target remote`. It accepts connections serially and
+// blocks until l.Accept fails (mirroring ServeMetrics), so callers
+// typically run it in its own goroutine. SetGDBTarget must be called
+// first.
+func (vm *VirtualMachine) StartGDBServer(addr string) error {
+	if vm.gdbTarget == nil {
+		return fmt.Errorf("vmm: StartGDBServer called before SetGDBTarget")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("vmm: gdb server listen on %s: %w", addr, err)
+	}
+	return vm.ServeGDB(l)
+}
+
+// ServeGDB is StartGDBServer's net.Listener-based form: it serves the
+// same protocol against an already-listening l instead of resolving addr
+// itself, for a caller that needs to know the real address a "127.0.0.1:0"
+// bind picked (e.g. a test) before accepting connections rather than
+// racing StartGDBServer's own internal Listen. It closes l when it
+// returns. SetGDBTarget must be called first.
+func (vm *VirtualMachine) ServeGDB(l net.Listener) error {
+	if vm.gdbTarget == nil {
+		return fmt.Errorf("vmm: ServeGDB called before SetGDBTarget")
+	}
+	defer l.Close()
+
+	s := &gdbServer{vm: vm, breakpoints: make(map[uint64]bool)}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.serve(conn)
+	}
+}
+
+// serve handles one gdb connection to completion (gdb's RSP is
+// request/response over a single connection, so this repo's stub, like
+// most, only ever debugs one client at a time).
+func (s *gdbServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		payload, ok := readGDBPacket(r)
+		if !ok {
+			return
+		}
+		if _, err := conn.Write([]byte{'+'}); err != nil {
+			return
+		}
+		reply := s.handle(payload)
+		if _, err := conn.Write(encodeGDBPacket(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// handle dispatches one decoded RSP command to its reply, per the
+// subset of the protocol StartGDBServer implements: g/G (registers),
+// m/M (memory), c/s (execution control), Z/z (breakpoints), and ?
+// (halt reason). Anything else gets gdb's standard "unsupported"
+// empty reply.
+func (s *gdbServer) handle(payload string) string {
+	if payload == "" {
+		return ""
+	}
+	switch payload[0] {
+	case '?':
+		return "S05" // SIGTRAP: this stub only ever reports a trap stop.
+	case 'g':
+		return s.readRegisters()
+	case 'G':
+		return s.writeRegisters(payload[1:])
+	case 'm':
+		return s.readMemory(payload[1:])
+	case 'M':
+		return s.writeMemory(payload[1:])
+	case 'c':
+		return s.cont()
+	case 's':
+		return s.step()
+	case 'Z':
+		return s.setBreakpoint(payload[1:])
+	case 'z':
+		return s.clearBreakpoint(payload[1:])
+	default:
+		return ""
+	}
+}
+
+// gdbRegOrder is gdb's i386:x86-64 register order for the general
+// registers and rip, each encoded as 8 raw bytes.
+var gdbRegOrder = []func(*hypervisor.KvmRegs) *uint64{
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RAX },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RBX },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RCX },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RDX },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RSI },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RDI },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RBP },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RSP },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R8 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R9 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R10 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R11 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R12 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R13 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R14 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.R15 },
+	func(r *hypervisor.KvmRegs) *uint64 { return &r.RIP },
+}
+
+// gdbTrailingSegRegs is the count of 4-byte registers gdb expects after
+// eflags (cs, ss, ds, es, fs, gs). This stub always reports them as 0:
+// KvmRegs has no segment fields (those live in KvmSregs, which
+// GDBTarget doesn't expose), and in the flat, long-mode guests this
+// hypervisor targets their bases are 0 regardless.
+const gdbTrailingSegRegs = 6
+
+// readRegisters implements "g": the full register set as one hex string,
+// each register little-endian, in gdb's fixed x86-64 order.
+func (s *gdbServer) readRegisters() string {
+	regs, err := s.vm.gdbTarget.GetRegs()
+	if err != nil {
+		return "E01"
+	}
+
+	var buf strings.Builder
+	for _, field := range gdbRegOrder {
+		writeHexLE(&buf, *field(&regs), 8)
+	}
+	writeHexLE(&buf, uint64(uint32(regs.RFLAGS)), 4)
+	for i := 0; i < gdbTrailingSegRegs; i++ {
+		writeHexLE(&buf, 0, 4)
+	}
+	return buf.String()
+}
+
+// writeRegisters implements "G": the inverse of readRegisters. Segment
+// registers in the trailing 6 slots are parsed (to keep the packet
+// length check honest) and discarded, for the same reason readRegisters
+// reports them as 0.
+func (s *gdbServer) writeRegisters(data string) string {
+	var regs hypervisor.KvmRegs
+	off := 0
+	for _, field := range gdbRegOrder {
+		v, n, ok := readHexLE(data, off, 8)
+		if !ok {
+			return "E01"
+		}
+		*field(&regs) = v
+		off = n
+	}
+	flags, n, ok := readHexLE(data, off, 4)
+	if !ok {
+		return "E01"
+	}
+	regs.RFLAGS = flags
+	off = n
+	for i := 0; i < gdbTrailingSegRegs; i++ {
+		_, n, ok := readHexLE(data, off, 4)
+		if !ok {
+			return "E01"
+		}
+		off = n
+	}
+
+	if err := s.vm.gdbTarget.SetRegs(regs); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// readMemory implements "m addr,length".
+func (s *gdbServer) readMemory(args string) string {
+	addr, length, ok := parseAddrLength(args)
+	if !ok {
+		return "E01"
+	}
+	if s.vm.memory == nil {
+		return "E01"
+	}
+	buf := make([]byte, length)
+	if err := s.vm.memory.ReadAt(buf, addr); err != nil {
+		return "E01"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// writeMemory implements "M addr,length:XX...".
+func (s *gdbServer) writeMemory(args string) string {
+	head, data, found := strings.Cut(args, ":")
+	if !found {
+		return "E01"
+	}
+	addr, length, ok := parseAddrLength(head)
+	if !ok {
+		return "E01"
+	}
+	raw, err := hex.DecodeString(data)
+	if err != nil || len(raw) != length {
+		return "E01"
+	}
+	if s.vm.memory == nil {
+		return "E01"
+	}
+	if err := s.vm.memory.WriteAt(raw, addr); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// step implements "s": one instruction.
+func (s *gdbServer) step() string {
+	if _, err := s.vm.gdbTarget.Step(); err != nil {
+		return "E01"
+	}
+	return "S05"
+}
+
+// cont implements "c": see maxContinueSteps.
+func (s *gdbServer) cont() string {
+	for i := 0; i < maxContinueSteps; i++ {
+		regs, err := s.vm.gdbTarget.Step()
+		if err != nil {
+			return "E01"
+		}
+		if s.breakpoints[regs.RIP] {
+			return "S05"
+		}
+	}
+	return "S05"
+}
+
+// setBreakpoint implements "Z0,addr,kind" (software breakpoint; the
+// kind field is accepted but unused, since this stub has only one way
+// to stop at an address: checking RIP after each single step).
+func (s *gdbServer) setBreakpoint(args string) string {
+	addr, ok := parseBreakpointAddr(args)
+	if !ok {
+		return "E01"
+	}
+	s.breakpoints[addr] = true
+	return "OK"
+}
+
+// clearBreakpoint implements "z0,addr,kind".
+func (s *gdbServer) clearBreakpoint(args string) string {
+	addr, ok := parseBreakpointAddr(args)
+	if !ok {
+		return "E01"
+	}
+	delete(s.breakpoints, addr)
+	return "OK"
+}
+
+// parseBreakpointAddr extracts addr from a "type,addr,kind" argument
+// string, ignoring type and kind: this stub treats every breakpoint
+// type (software or hardware) the same way.
+func parseBreakpointAddr(args string) (uint64, bool) {
+	parts := strings.Split(args, ",")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return addr, true
+}
+
+// parseAddrLength parses an "addr,length" argument string, both fields
+// hex-encoded per the RSP.
+func parseAddrLength(args string) (addr uint64, length int, ok bool) {
+	head, lengthStr, found := strings.Cut(args, ",")
+	if !found {
+		return 0, 0, false
+	}
+	a, err := strconv.ParseUint(head, 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	l, err := strconv.ParseUint(lengthStr, 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return a, int(l), true
+}
+
+// writeHexLE appends v, encoded as width little-endian bytes in lowercase
+// hex, to buf.
+func writeHexLE(buf *strings.Builder, v uint64, width int) {
+	raw := make([]byte, width)
+	switch width {
+	case 4:
+		binary.LittleEndian.PutUint32(raw, uint32(v))
+	case 8:
+		binary.LittleEndian.PutUint64(raw, v)
+	}
+	buf.WriteString(hex.EncodeToString(raw))
+}
+
+// readHexLE decodes width little-endian bytes of hex starting at
+// data[off:], returning the value, the offset just past it, and whether
+// decoding succeeded.
+func readHexLE(data string, off, width int) (v uint64, next int, ok bool) {
+	end := off + width*2
+	if end > len(data) {
+		return 0, 0, false
+	}
+	raw, err := hex.DecodeString(data[off:end])
+	if err != nil {
+		return 0, 0, false
+	}
+	switch width {
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(raw)), end, true
+	case 8:
+		return binary.LittleEndian.Uint64(raw), end, true
+	}
+	return 0, 0, false
+}
+
+// readGDBPacket reads one "$data#cc" packet from r, silently skipping
+// any leading ack/nack bytes ('+'/'-') a client may send between
+// packets. It returns ok=false once the connection is closed or a
+// packet's checksum doesn't match, in which case the caller should stop
+// serving that connection.
+func readGDBPacket(r *bufio.Reader) (string, bool) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", false
+		}
+		if b == '+' || b == '-' {
+			continue
+		}
+		if b != '$' {
+			return "", false
+		}
+		break
+	}
+
+	data, err := r.ReadString('#')
+	if err != nil {
+		return "", false
+	}
+	data = data[:len(data)-1] // drop the trailing '#'
+
+	checksumHex := make([]byte, 2)
+	if _, err := io.ReadFull(r, checksumHex); err != nil {
+		return "", false
+	}
+	want, err := strconv.ParseUint(string(checksumHex), 16, 8)
+	if err != nil {
+		return "", false
+	}
+	var got byte
+	for i := 0; i < len(data); i++ {
+		got += data[i]
+	}
+	if byte(want) != got {
+		return "", false
+	}
+	return data, true
+}
+
+// encodeGDBPacket wraps data as one "$data#cc" RSP packet.
+func encodeGDBPacket(data string) []byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return []byte(fmt.Sprintf("$%s#%02x", data, sum))
+}
@@ -0,0 +1,194 @@
+package vmm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// fakeGDBTarget is a GDBTarget double for tests: Step just advances RIP
+// by one, standing in for a real StepInstruction call against /dev/kvm.
+type fakeGDBTarget struct {
+	hypervisor.FakeVCPU
+}
+
+func (f *fakeGDBTarget) Step() (hypervisor.KvmRegs, error) {
+	f.Regs.RIP++
+	return f.Regs, nil
+}
+
+// rspClient is a minimal GDB remote serial protocol client, enough to
+// drive the handful of commands StartGDBServer implements.
+type rspClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRSP(t *testing.T, addr string) *rspClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rspClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *rspClient) send(t *testing.T, payload string) string {
+	t.Helper()
+	var sum byte
+	for i := 0; i < len(payload); i++ {
+		sum += payload[i]
+	}
+	if _, err := c.conn.Write([]byte("$" + payload + "#" + hexByte(sum))); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	ack, err := c.r.ReadByte()
+	if err != nil || ack != '+' {
+		t.Fatalf("ack = %q, err %v, want '+'", ack, err)
+	}
+
+	if b, err := c.r.ReadByte(); err != nil || b != '$' {
+		t.Fatalf("reply start = %q, err %v, want '$'", b, err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		t.Fatalf("ReadString: %v", err)
+	}
+	data = data[:len(data)-1]
+	checksum := make([]byte, 2)
+	if _, err := c.r.Read(checksum); err != nil {
+		t.Fatalf("read checksum: %v", err)
+	}
+	return data
+}
+
+func hexByte(b byte) string {
+	s := strconv.FormatUint(uint64(b), 16)
+	if len(s) == 1 {
+		s = "0" + s
+	}
+	return s
+}
+
+func startTestGDBServer(t *testing.T, vm *VirtualMachine) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	addr := l.Addr().String()
+
+	// Serve the listener we already have bound, rather than closing it and
+	// having StartGDBServer re-Listen on the same address in a goroutine:
+	// that left a window where a test could dial before the rebind landed.
+	go vm.ServeGDB(l)
+	return addr
+}
+
+func TestGDBServerReadRegisters(t *testing.T) {
+	target := &fakeGDBTarget{}
+	target.Regs.RAX = 0x1122334455667788
+	target.Regs.RIP = 0x400000
+
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetGDBTarget(target)
+	addr := startTestGDBServer(t, vm)
+
+	c := dialRSP(t, addr)
+	reply := c.send(t, "g")
+
+	raw, err := hex.DecodeString(reply)
+	if err != nil {
+		t.Fatalf("reply %q is not valid hex: %v", reply, err)
+	}
+	if len(raw) < 8 {
+		t.Fatalf("reply too short: %d bytes", len(raw))
+	}
+	var rax uint64
+	for i := 7; i >= 0; i-- {
+		rax = rax<<8 | uint64(raw[i])
+	}
+	if rax != target.Regs.RAX {
+		t.Errorf("decoded RAX = %#x, want %#x", rax, target.Regs.RAX)
+	}
+}
+
+func TestGDBServerSetAndClearBreakpoint(t *testing.T) {
+	target := &fakeGDBTarget{}
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetGDBTarget(target)
+	addr := startTestGDBServer(t, vm)
+
+	c := dialRSP(t, addr)
+	if got := c.send(t, "Z0,400000,1"); got != "OK" {
+		t.Errorf("set breakpoint reply = %q, want OK", got)
+	}
+	if got := c.send(t, "z0,400000,1"); got != "OK" {
+		t.Errorf("clear breakpoint reply = %q, want OK", got)
+	}
+}
+
+func TestGDBServerStepReportsTrap(t *testing.T) {
+	target := &fakeGDBTarget{}
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetGDBTarget(target)
+	addr := startTestGDBServer(t, vm)
+
+	c := dialRSP(t, addr)
+	if got := c.send(t, "s"); got != "S05" {
+		t.Errorf("step reply = %q, want S05", got)
+	}
+	if target.Regs.RIP != 1 {
+		t.Errorf("RIP after step = %d, want 1", target.Regs.RIP)
+	}
+}
+
+func TestGDBServerContinueStopsAtBreakpoint(t *testing.T) {
+	target := &fakeGDBTarget{}
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetGDBTarget(target)
+	addr := startTestGDBServer(t, vm)
+
+	c := dialRSP(t, addr)
+	c.send(t, "Z0,5,1")
+	if got := c.send(t, "c"); got != "S05" {
+		t.Errorf("continue reply = %q, want S05", got)
+	}
+	if target.Regs.RIP != 5 {
+		t.Errorf("RIP after continue = %d, want 5 (breakpoint address)", target.Regs.RIP)
+	}
+}
+
+func TestGDBServerReadWriteMemory(t *testing.T) {
+	target := &fakeGDBTarget{}
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetGDBTarget(target)
+	mem := NewMemoryLayout()
+	if err := mem.AddSlot(0, make([]byte, 0x1000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	vm.SetMemoryLayout(mem)
+	addr := startTestGDBServer(t, vm)
+
+	c := dialRSP(t, addr)
+	if got := c.send(t, "M10,2:aabb"); got != "OK" {
+		t.Errorf("write memory reply = %q, want OK", got)
+	}
+	if got, want := c.send(t, "m10,2"), "aabb"; !strings.EqualFold(got, want) {
+		t.Errorf("read memory reply = %q, want %q", got, want)
+	}
+}
+
+func TestGDBServerStartWithoutTargetErrors(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	if err := vm.StartGDBServer("127.0.0.1:0"); err == nil {
+		t.Error("StartGDBServer with no target: got nil error, want one")
+	}
+}
@@ -0,0 +1,117 @@
+package vmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+// guestParamsMagic identifies an EncodeGuestParams/DecodeGuestParams blob.
+const guestParamsMagic = "VDBGPRM1"
+
+// guestParamsFwCfgFile is the fw_cfg file name InstallGuestParamsFwCfgFile
+// registers the encoded blob under. "opt/" is the namespace real fw_cfg
+// implementations reserve for entries a specific guest/firmware pairing
+// invents for itself, as opposed to the architectural files (kernel,
+// initrd, ...) every boot ROM knows to look for.
+const guestParamsFwCfgFile = "opt/guestparams"
+
+// GuestParamsAddr is the fixed guest physical address InstallGuestParams
+// writes the encoded blob to, in the unused span of the conventional
+// sub-1MB BIOS region between the identity-mapped page tables and the MP
+// floating pointer structure. A guest that knows to look here can read
+// its configuration directly out of memory; InstallGuestParamsFwCfgFile
+// serves the identical bytes to one that doesn't, through FwCfgDevice.
+const GuestParamsAddr = 0xe0000
+
+// EncodeGuestParams serializes params into the byte layout
+// DecodeGuestParams reads back:
+//
+//	offset  0:  8 bytes  guestParamsMagic ("VDBGPRM1")
+//	offset  8:  4 bytes  length, little-endian uint32 (byte count of the entries below)
+//	offset 12:  4 bytes  checksum, little-endian uint32 crc32.ChecksumIEEE(entries)
+//	offset 16:  length bytes  entries: "key=value\n" per parameter, sorted by key
+//
+// Sorting the entries by key makes the encoding deterministic, so the
+// same params always produce the same blob (and the same checksum).
+func EncodeGuestParams(params map[string]string) []byte {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var entries bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&entries, "%s=%s\n", k, params[k])
+	}
+
+	const headerLen = 16
+	blob := make([]byte, headerLen+entries.Len())
+	copy(blob[0:8], guestParamsMagic)
+	binary.LittleEndian.PutUint32(blob[8:12], uint32(entries.Len()))
+	binary.LittleEndian.PutUint32(blob[12:16], crc32.ChecksumIEEE(entries.Bytes()))
+	copy(blob[headerLen:], entries.Bytes())
+	return blob
+}
+
+// DecodeGuestParams is the reference routine for reading back what
+// EncodeGuestParams produced: it validates the magic, refuses a blob
+// truncated shorter than its declared length, and checks the checksum
+// before splitting the entries back into a map.
+func DecodeGuestParams(blob []byte) (map[string]string, error) {
+	const headerLen = 16
+	if len(blob) < headerLen {
+		return nil, fmt.Errorf("vmm: DecodeGuestParams: blob is %d bytes, shorter than the %d-byte header", len(blob), headerLen)
+	}
+	if magic := string(blob[0:8]); magic != guestParamsMagic {
+		return nil, fmt.Errorf("vmm: DecodeGuestParams: bad magic %q, want %q", magic, guestParamsMagic)
+	}
+	length := binary.LittleEndian.Uint32(blob[8:12])
+	wantSum := binary.LittleEndian.Uint32(blob[12:16])
+	if uint32(len(blob)-headerLen) < length {
+		return nil, fmt.Errorf("vmm: DecodeGuestParams: truncated: have %d entry bytes, want %d", len(blob)-headerLen, length)
+	}
+	entries := blob[headerLen : headerLen+int(length)]
+	if gotSum := crc32.ChecksumIEEE(entries); gotSum != wantSum {
+		return nil, fmt.Errorf("vmm: DecodeGuestParams: checksum mismatch (got %#x, want %#x)", gotSum, wantSum)
+	}
+
+	params := make(map[string]string)
+	for _, line := range strings.Split(string(entries), "\n") {
+		if line == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("vmm: DecodeGuestParams: malformed entry %q, want key=value", line)
+		}
+		params[k] = v
+	}
+	return params, nil
+}
+
+// InstallGuestParams encodes params with EncodeGuestParams and writes the
+// result into mem at GuestParamsAddr. Call it once during VM setup, after
+// the memory region covering GuestParamsAddr has been added to mem.
+func InstallGuestParams(mem *MemoryLayout, params map[string]string) error {
+	if err := mem.WriteAt(EncodeGuestParams(params), GuestParamsAddr); err != nil {
+		return fmt.Errorf("vmm: InstallGuestParams: %w", err)
+	}
+	return nil
+}
+
+// InstallGuestParamsFwCfgFile encodes params with EncodeGuestParams and
+// registers the result on dev under guestParamsFwCfgFile, so a guest that
+// enumerates dev's file directory can fetch it by name instead of relying
+// on GuestParamsAddr. The two access paths serve byte-for-byte identical
+// content, since both start from the same EncodeGuestParams call.
+func InstallGuestParamsFwCfgFile(dev *FwCfgDevice, params map[string]string) error {
+	if err := dev.AddFile(guestParamsFwCfgFile, EncodeGuestParams(params)); err != nil {
+		return fmt.Errorf("vmm: InstallGuestParamsFwCfgFile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package vmm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeGuestParamsRoundTrips(t *testing.T) {
+	params := map[string]string{
+		"ip":   "10.0.2.15",
+		"name": "smoke-test",
+		"seed": "1337",
+	}
+
+	blob := EncodeGuestParams(params)
+	got, err := DecodeGuestParams(blob)
+	if err != nil {
+		t.Fatalf("DecodeGuestParams: %v", err)
+	}
+	if !reflect.DeepEqual(got, params) {
+		t.Errorf("DecodeGuestParams = %+v, want %+v", got, params)
+	}
+}
+
+func TestEncodeGuestParamsIsDeterministic(t *testing.T) {
+	params := map[string]string{"b": "2", "a": "1", "c": "3"}
+	first := EncodeGuestParams(params)
+	second := EncodeGuestParams(params)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("EncodeGuestParams produced different bytes across calls for the same input")
+	}
+}
+
+func TestDecodeGuestParamsRejectsBadMagic(t *testing.T) {
+	blob := EncodeGuestParams(map[string]string{"k": "v"})
+	blob[0] = 'X'
+	if _, err := DecodeGuestParams(blob); err == nil {
+		t.Error("DecodeGuestParams with corrupted magic: got nil error, want one")
+	}
+}
+
+func TestDecodeGuestParamsRejectsTruncatedPayload(t *testing.T) {
+	blob := EncodeGuestParams(map[string]string{"key": "value"})
+	truncated := blob[:len(blob)-2]
+	if _, err := DecodeGuestParams(truncated); err == nil {
+		t.Error("DecodeGuestParams with truncated payload: got nil error, want one")
+	}
+}
+
+func TestDecodeGuestParamsRejectsChecksumMismatch(t *testing.T) {
+	blob := EncodeGuestParams(map[string]string{"key": "value"})
+	blob[len(blob)-1] ^= 0xff // flip a bit in the last entry byte
+	if _, err := DecodeGuestParams(blob); err == nil {
+		t.Error("DecodeGuestParams with corrupted entry bytes: got nil error, want checksum mismatch")
+	}
+}
+
+func TestDecodeGuestParamsRejectsTooShortBlob(t *testing.T) {
+	if _, err := DecodeGuestParams([]byte{1, 2, 3}); err == nil {
+		t.Error("DecodeGuestParams on a blob shorter than the header: got nil error, want one")
+	}
+}
+
+func TestInstallGuestParamsWritesToGuestParamsAddr(t *testing.T) {
+	layout := NewMemoryLayout()
+	backing := make([]byte, 0x100000)
+	if err := layout.AddSlot(0, backing); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	params := map[string]string{"ip": "10.0.2.15"}
+	if err := InstallGuestParams(layout, params); err != nil {
+		t.Fatalf("InstallGuestParams: %v", err)
+	}
+
+	want := EncodeGuestParams(params)
+	got := backing[GuestParamsAddr : GuestParamsAddr+uint64(len(want))]
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("memory at GuestParamsAddr = %v, want %v", got, want)
+	}
+}
+
+// TestInstallGuestParamsFwCfgFileServesIdenticalContentToMemory checks
+// that the fw_cfg-registered copy of the blob and the memory-installed
+// copy from InstallGuestParams are byte-for-byte identical, as the
+// guest params mechanism requires of its two access paths.
+func TestInstallGuestParamsFwCfgFileServesIdenticalContentToMemory(t *testing.T) {
+	layout := NewMemoryLayout()
+	backing := make([]byte, 0x100000)
+	if err := layout.AddSlot(0, backing); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	params := map[string]string{"ip": "10.0.2.15", "seed": "42"}
+	if err := InstallGuestParams(layout, params); err != nil {
+		t.Fatalf("InstallGuestParams: %v", err)
+	}
+
+	dev := NewFwCfgDevice()
+	if err := InstallGuestParamsFwCfgFile(dev, params); err != nil {
+		t.Fatalf("InstallGuestParamsFwCfgFile: %v", err)
+	}
+
+	want := EncodeGuestParams(params)
+	inMemory := backing[GuestParamsAddr : GuestParamsAddr+uint64(len(want))]
+	if !reflect.DeepEqual(inMemory, want) {
+		t.Errorf("memory at GuestParamsAddr = %v, want %v", inMemory, want)
+	}
+
+	overFwCfg := fetchFwCfgFile(t, dev, guestParamsFwCfgFile)
+	if !reflect.DeepEqual(overFwCfg, want) {
+		t.Errorf("fw_cfg file %q = %v, want %v", guestParamsFwCfgFile, overFwCfg, want)
+	}
+}
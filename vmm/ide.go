@@ -0,0 +1,274 @@
+package vmm
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Primary ATA channel I/O ports.
+const (
+	idePortData      = 0x1f0
+	idePortError     = 0x1f1 // read: error, write: features
+	idePortSectorCnt = 0x1f2
+	idePortLBALow    = 0x1f3
+	idePortLBAMid    = 0x1f4
+	idePortLBAHigh   = 0x1f5
+	idePortDrvHead   = 0x1f6
+	idePortStatus    = 0x1f7 // read: status, write: command
+)
+
+const sectorSize = 512
+
+// ATA status register bits.
+const (
+	ataStatusErr = 1 << 0
+	ataStatusDRQ = 1 << 3
+	ataStatusRDY = 1 << 6
+	ataStatusBSY = 1 << 7
+)
+
+// ATA commands this controller understands.
+const (
+	ataCmdReadSectors  = 0x20
+	ataCmdWriteSectors = 0x30
+	ataCmdIdentify     = 0xec
+)
+
+// IDEDevice emulates a single ATA hard disk on the primary channel, PIO
+// mode only, backed by a flat (raw) disk image file.
+type IDEDevice struct {
+	mu sync.Mutex
+
+	image       io.ReaderAt
+	file        *os.File // non-nil when writable and opened by us
+	sizeSectors uint64
+
+	// programmed registers
+	sectorCount uint8
+	lba         uint32 // 28-bit LBA assembled from LBALow/Mid/High + DrvHead[3:0]
+	status      uint8
+
+	// buffer holds the sector currently being transferred via idePortData.
+	buffer   [sectorSize]byte
+	bufOff   int
+	pending  int // bytes remaining in a multi-sector transfer
+	writeCmd bool
+}
+
+// NewIDEDeviceFromFile opens path as a flat disk image. If writable is
+// false, the image is opened read-only and write commands fail silently
+// with the ERR status bit, matching a write-protected drive.
+func NewIDEDeviceFromFile(path string, writable bool) (*IDEDevice, error) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	dev := &IDEDevice{image: f, sizeSectors: uint64(info.Size()) / sectorSize}
+	if writable {
+		dev.file = f
+	}
+	return dev, nil
+}
+
+// Ports implements PioDevice.
+func (d *IDEDevice) Ports() []uint16 {
+	return []uint16{idePortData, idePortError, idePortSectorCnt, idePortLBALow, idePortLBAMid, idePortLBAHigh, idePortDrvHead, idePortStatus}
+}
+
+// HandleIO implements PioDevice.
+func (d *IDEDevice) HandleIO(port uint16, data []byte, write bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch port {
+	case idePortSectorCnt:
+		if write && len(data) > 0 {
+			d.sectorCount = data[0]
+		} else if len(data) > 0 {
+			data[0] = d.sectorCount
+		}
+	case idePortLBALow:
+		if write && len(data) > 0 {
+			d.lba = (d.lba &^ 0xff) | uint32(data[0])
+		} else if len(data) > 0 {
+			data[0] = uint8(d.lba)
+		}
+	case idePortLBAMid:
+		if write && len(data) > 0 {
+			d.lba = (d.lba &^ 0xff00) | uint32(data[0])<<8
+		} else if len(data) > 0 {
+			data[0] = uint8(d.lba >> 8)
+		}
+	case idePortLBAHigh:
+		if write && len(data) > 0 {
+			d.lba = (d.lba &^ 0xff0000) | uint32(data[0])<<16
+		} else if len(data) > 0 {
+			data[0] = uint8(d.lba >> 16)
+		}
+	case idePortDrvHead:
+		if write && len(data) > 0 {
+			d.lba = (d.lba &^ 0x0f000000) | uint32(data[0]&0x0f)<<24
+		} else if len(data) > 0 {
+			data[0] = 0xa0 | uint8(d.lba>>24)&0x0f
+		}
+	case idePortStatus:
+		if write && len(data) > 0 {
+			d.handleCommand(data[0])
+		} else if len(data) > 0 {
+			data[0] = d.status
+		}
+	case idePortError:
+		if !write && len(data) > 0 {
+			data[0] = 0
+		}
+	case idePortData:
+		d.handleData(data, write)
+	}
+	return nil
+}
+
+func (d *IDEDevice) handleCommand(cmd uint8) {
+	switch cmd {
+	case ataCmdReadSectors:
+		d.writeCmd = false
+		d.startTransfer()
+	case ataCmdWriteSectors:
+		if d.file == nil {
+			d.status = ataStatusRDY | ataStatusErr
+			return
+		}
+		d.writeCmd = true
+		d.startTransfer()
+	case ataCmdIdentify:
+		d.startIdentify()
+	default:
+		d.status = ataStatusRDY | ataStatusErr
+	}
+}
+
+func (d *IDEDevice) startTransfer() {
+	count := int(d.sectorCount)
+	if count == 0 {
+		count = 256
+	}
+	d.pending = count
+	d.bufOff = sectorSize
+
+	if uint64(d.lba)+uint64(count) > d.sizeSectors {
+		d.status = ataStatusRDY | ataStatusErr
+		d.pending = 0
+		return
+	}
+
+	if !d.writeCmd {
+		if err := d.readCurrentSector(); err != nil {
+			d.status = ataStatusRDY | ataStatusErr
+			d.pending = 0
+			return
+		}
+		d.bufOff = 0
+	} else {
+		d.bufOff = 0
+	}
+	d.status = ataStatusRDY | ataStatusDRQ
+}
+
+// startIdentify fills the sector buffer with a minimal IDENTIFY DEVICE
+// response (LBA-capable, total sector count) and stages it for readback,
+// without touching the backing image.
+func (d *IDEDevice) startIdentify() {
+	d.buffer = [sectorSize]byte{}
+	putWord := func(word int, v uint16) {
+		d.buffer[word*2] = uint8(v)
+		d.buffer[word*2+1] = uint8(v >> 8)
+	}
+	putWord(49, 1<<9) // LBA supported
+	putWord(60, uint16(d.sizeSectors))
+	putWord(61, uint16(d.sizeSectors>>16))
+
+	d.writeCmd = false
+	d.pending = 1
+	d.bufOff = 0
+	d.status = ataStatusRDY | ataStatusDRQ
+}
+
+func (d *IDEDevice) readCurrentSector() error {
+	_, err := d.image.ReadAt(d.buffer[:], int64(d.lba)*sectorSize)
+	return err
+}
+
+func (d *IDEDevice) handleData(data []byte, write bool) {
+	if d.pending == 0 || len(data) == 0 {
+		return
+	}
+
+	if write {
+		n := copy(d.buffer[d.bufOff:], data)
+		d.bufOff += n
+		if d.bufOff >= sectorSize {
+			d.flushSector()
+		}
+		return
+	}
+
+	n := copy(data, d.buffer[d.bufOff:])
+	d.bufOff += n
+	if d.bufOff >= sectorSize {
+		d.advanceSector()
+	}
+}
+
+func (d *IDEDevice) flushSector() {
+	if d.file != nil {
+		if _, err := d.file.WriteAt(d.buffer[:], int64(d.lba)*sectorSize); err != nil {
+			d.status = ataStatusRDY | ataStatusErr
+			d.pending = 0
+			return
+		}
+	}
+	d.advanceSector()
+}
+
+func (d *IDEDevice) advanceSector() {
+	d.pending--
+	d.lba++
+	d.bufOff = 0
+	if d.pending == 0 {
+		d.status = ataStatusRDY
+		return
+	}
+	if !d.writeCmd {
+		if err := d.readCurrentSector(); err != nil {
+			d.status = ataStatusRDY | ataStatusErr
+			d.pending = 0
+			return
+		}
+	}
+	d.status = ataStatusRDY | ataStatusDRQ
+}
+
+// Reset implements PioDevice.
+func (d *IDEDevice) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sectorCount = 0
+	d.lba = 0
+	d.status = ataStatusRDY
+	d.pending = 0
+	d.bufOff = sectorSize
+	d.writeCmd = false
+}
+
+// Name implements PioDevice.
+func (d *IDEDevice) Name() string { return "ide" }
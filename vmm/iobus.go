@@ -0,0 +1,279 @@
+// Package vmm implements the guest-facing device model: the port I/O bus,
+// individual PIO devices (RTC, PIC, PIT, serial, ...), and the VirtualMachine
+// that wires them to a hypervisor.Hypervisor backend.
+package vmm
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// PioDevice is implemented by any device that answers port-mapped I/O
+// accesses. Ports is the set of port addresses the device wants routed to
+// it; HandleIO is called for every IN/OUT that lands on one of those ports.
+type PioDevice interface {
+	// Ports returns the port addresses this device wants to receive.
+	Ports() []uint16
+	// HandleIO services a single port access. write is true for OUT,
+	// false for IN. data is the guest-supplied bytes for a write, or the
+	// buffer to fill for a read; its length is the access width (1, 2 or 4).
+	HandleIO(port uint16, data []byte, write bool) error
+	// Reset restores the device to its power-on state, as if the guest
+	// had just been (re)booted.
+	Reset()
+	// Name identifies the device in logs and error messages, e.g. "pit"
+	// or "rtc". It should be short, stable, and lowercase, since it's
+	// meant for a human reading a trace or an error, not the guest.
+	Name() string
+}
+
+// I/O direction passed to a tracer installed with SetTracer.
+const (
+	IODirRead  uint8 = 0
+	IODirWrite uint8 = 1
+)
+
+// IOAccessRecord is one entry in the history ring buffer SetHistorySize
+// enables: a single port access, kept in memory for crash forensics
+// (see GuestCrashReport) rather than durably recorded the way
+// EnableTrace's log is.
+type IOAccessRecord struct {
+	Port  uint16
+	Write bool
+	Data  []byte
+}
+
+// IOBus routes port I/O accesses from the VCPU exit loop to the PioDevice
+// registered for the accessed port.
+type IOBus struct {
+	devices  map[uint16]PioDevice
+	stats    *Stats
+	clock    Clock
+	tracer   *ioTraceWriter
+	tracerFn func(port uint16, dir uint8, size uint8, data []byte)
+
+	historyCap int
+	history    []IOAccessRecord
+
+	unhandled   *unhandledAccessTracker
+	unhandledFn func(port uint16, write bool, count int64)
+}
+
+// NewIOBus returns an empty IOBus with no devices registered.
+func NewIOBus() *IOBus {
+	return &IOBus{devices: make(map[uint16]PioDevice), clock: RealClock{}}
+}
+
+// SetStats wires stats to be updated on every Dispatch. Passing nil (the
+// default) disables recording.
+func (b *IOBus) SetStats(stats *Stats) {
+	b.stats = stats
+}
+
+// SetClock overrides the clock used to timestamp trace records. Tests
+// inject a ManualClock for deterministic timestamps; production code can
+// leave the RealClock default.
+func (b *IOBus) SetClock(clock Clock) {
+	b.clock = clock
+}
+
+// EnableTrace starts recording every Dispatch call to w, in the format
+// ReplayBus reads back. Call DisableTrace to stop.
+func (b *IOBus) EnableTrace(w io.Writer) {
+	b.tracer = newIOTraceWriter(w, b.clock)
+}
+
+// DisableTrace stops recording, if EnableTrace was called.
+func (b *IOBus) DisableTrace() {
+	b.tracer = nil
+}
+
+// SetTracer installs fn to be called after every port I/O this bus
+// routes to a device, with a copy of the data as left by the device (so
+// a read's fn sees the value returned to the guest, not the zeroed
+// buffer HandleIO started from). Unlike EnableTrace, which durably
+// records a session to an io.Writer for later replay, SetTracer is a
+// lightweight in-process hook meant for live debugging (e.g. printf-style
+// logging of guest device interaction) without that serialization
+// overhead; pass nil to detach it, restoring zero overhead. It is not
+// called for an unhandled port.
+func (b *IOBus) SetTracer(fn func(port uint16, dir uint8, size uint8, data []byte)) {
+	b.tracerFn = fn
+}
+
+// SetHistorySize configures how many of the most recent port I/O
+// accesses this bus retains for crash forensics (see GuestCrashReport).
+// Zero, the default, disables history-keeping entirely. Shrinking or
+// disabling history drops whatever was already recorded.
+func (b *IOBus) SetHistorySize(n int) {
+	b.historyCap = n
+	b.history = nil
+}
+
+// History returns a copy of the most recently recorded I/O accesses,
+// oldest first. It's empty unless SetHistorySize was called with a
+// positive size.
+func (b *IOBus) History() []IOAccessRecord {
+	return append([]IOAccessRecord(nil), b.history...)
+}
+
+// recordHistory appends one access to the ring buffer, if history is
+// enabled, dropping the oldest entry once historyCap is exceeded.
+func (b *IOBus) recordHistory(port uint16, write bool, data []byte) {
+	if b.historyCap == 0 {
+		return
+	}
+	b.history = append(b.history, IOAccessRecord{Port: port, Write: write, Data: append([]byte(nil), data...)})
+	if len(b.history) > b.historyCap {
+		b.history = b.history[len(b.history)-b.historyCap:]
+	}
+}
+
+// SetUnhandledLogPolicy enables rate-limited logging of accesses to ports
+// no device claims: fn is called the first time a given (port, direction)
+// is seen, and then again every logEvery further occurrences of it (0
+// disables repeats) or once per minInterval (0 disables), whichever
+// permits it sooner. Without this, an unhandled port is still counted in
+// Stats and returned as an error, but nothing calls fn or accumulates the
+// table DumpUnhandled reads. Passing fn == nil disables tracking again,
+// matching Dispatch's behavior before SetUnhandledLogPolicy was ever
+// called.
+func (b *IOBus) SetUnhandledLogPolicy(logEvery int64, minInterval time.Duration, fn func(port uint16, write bool, count int64)) {
+	if fn == nil {
+		b.unhandled = nil
+		b.unhandledFn = nil
+		return
+	}
+	b.unhandled = &unhandledAccessTracker{LogEvery: logEvery, MinInterval: minInterval, Clock: b.clock}
+	b.unhandledFn = fn
+}
+
+// DumpUnhandled returns a snapshot of every unhandled port this bus has
+// seen and how many times it's been accessed, in no particular order.
+// It's empty unless SetUnhandledLogPolicy has been called.
+func (b *IOBus) DumpUnhandled() []UnhandledAccessStats {
+	if b.unhandled == nil {
+		return nil
+	}
+	return b.unhandled.Dump()
+}
+
+// noteUnhandled records one unhandled access with Stats and, if
+// SetUnhandledLogPolicy is active, the rate-limited tracker, calling
+// unhandledFn when the tracker says this one should be logged.
+func (b *IOBus) noteUnhandled(port uint16, write bool) {
+	b.stats.RecordUnhandledIOAccess(port, write)
+	if b.unhandled == nil {
+		return
+	}
+	if count, shouldLog := b.unhandled.Note(UnhandledAccessKey{Addr: uint64(port), Write: write}); shouldLog {
+		b.unhandledFn(port, write, count)
+	}
+}
+
+// Register adds dev's ports to the bus. It returns an error if any of the
+// ports are already claimed by another device.
+func (b *IOBus) Register(dev PioDevice) error {
+	for _, port := range dev.Ports() {
+		if existing, exists := b.devices[port]; exists {
+			return fmt.Errorf("vmm: port 0x%x already registered to %s", port, existing.Name())
+		}
+	}
+	for _, port := range dev.Ports() {
+		b.devices[port] = dev
+	}
+	return nil
+}
+
+// Reregister moves dev's claim from oldPorts to whatever dev.Ports()
+// currently returns, as issued by a guest reprogramming a PCI BAR (see
+// NE2000PCIFunction/VirtioNetPCIFunction.ConfigWrite): the caller must
+// have already told dev its new base before calling this, since it's
+// dev.Ports() at call time that determines the new range. It returns an
+// error, leaving oldPorts still claimed by dev, if any of the new ports
+// are already claimed by a different device.
+func (b *IOBus) Reregister(dev PioDevice, oldPorts []uint16) error {
+	newPorts := dev.Ports()
+	for _, port := range newPorts {
+		if existing, exists := b.devices[port]; exists && existing != dev {
+			return fmt.Errorf("vmm: port 0x%x already registered to %s", port, existing.Name())
+		}
+	}
+	for _, port := range oldPorts {
+		if b.devices[port] == dev {
+			delete(b.devices, port)
+		}
+	}
+	for _, port := range newPorts {
+		b.devices[port] = dev
+	}
+	return nil
+}
+
+// Dispatch routes a single port access to its device. It returns
+// ErrUnhandledPort if no device claims the port.
+func (b *IOBus) Dispatch(port uint16, data []byte, write bool) error {
+	b.stats.RecordIOAccess(port)
+	dev, ok := b.devices[port]
+	if !ok {
+		err := &ErrUnhandledPort{Port: port, Write: write}
+		b.trace(port, data, write, "", err)
+		b.recordHistory(port, write, data)
+		b.noteUnhandled(port, write)
+		return err
+	}
+	err := dev.HandleIO(port, data, write)
+	b.trace(port, data, write, dev.Name(), err)
+	b.callTracer(port, write, data)
+	b.recordHistory(port, write, data)
+	return err
+}
+
+// callTracer invokes tracerFn, if set, with a copy of data so the caller
+// can't observe or corrupt the bus's own buffer.
+func (b *IOBus) callTracer(port uint16, write bool, data []byte) {
+	if b.tracerFn == nil {
+		return
+	}
+	dir := IODirRead
+	if write {
+		dir = IODirWrite
+	}
+	b.tracerFn(port, dir, uint8(len(data)), append([]byte(nil), data...))
+}
+
+// trace appends one record to the active trace, if any. A failing trace
+// sink is logged nowhere and never returned to the caller: tracing is a
+// debugging aid and must not be able to abort the guest.
+func (b *IOBus) trace(port uint16, data []byte, write bool, device string, err error) {
+	if b.tracer == nil {
+		return
+	}
+	rec := TraceRecord{
+		TimestampNanos: b.clock.Now().UnixNano(),
+		Port:           port,
+		Write:          write,
+		Data:           append([]byte(nil), data...),
+		Device:         device,
+	}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+	_ = b.tracer.Record(rec)
+}
+
+// ErrUnhandledPort is returned by IOBus.Dispatch when no device claims the
+// accessed port.
+type ErrUnhandledPort struct {
+	Port  uint16
+	Write bool
+}
+
+func (e *ErrUnhandledPort) Error() string {
+	dir := "IN"
+	if e.Write {
+		dir = "OUT"
+	}
+	return fmt.Sprintf("vmm: unhandled %s on port 0x%x", dir, e.Port)
+}
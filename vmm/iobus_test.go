@@ -0,0 +1,171 @@
+package vmm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPioDeviceNamesAreStableAndNonEmpty checks every device registered
+// with a VirtualMachine elsewhere in this package reports a short,
+// non-empty Name(), and that calling it twice returns the same value.
+func TestPioDeviceNamesAreStableAndNonEmpty(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+
+	ide, err := NewIDEDeviceFromFile(newTestDiskImage(t, 1), false)
+	if err != nil {
+		t.Fatalf("NewIDEDeviceFromFile: %v", err)
+	}
+
+	devices := []PioDevice{
+		NewSystemControlPortDevice(NewA20Gate(), nil),
+		NewDMAControllerDevice(),
+		ide,
+		NewKeyboardControllerDevice(nil, NewA20Gate()),
+		NewNE2000Device([6]byte{}, nil),
+		NewPCIBus(),
+		NewPITDevice(clock),
+		NewPowerManagementDevice(nil),
+		NewResetControlDevice(nil),
+		NewRTCDevice(clock),
+		NewSerialPortDevice(clock, nil),
+		NewVGATextDevice(),
+	}
+
+	for _, dev := range devices {
+		name := dev.Name()
+		if name == "" {
+			t.Errorf("%T.Name() = %q, want non-empty", dev, name)
+			continue
+		}
+		if name != dev.Name() {
+			t.Errorf("%T.Name() is not stable: %q then %q", dev, name, dev.Name())
+		}
+	}
+}
+
+// TestIOBusRegisterErrorNamesTheOwningDevice checks that registering a
+// conflicting port reports which device already owns it, not just the
+// port number.
+func TestIOBusRegisterErrorNamesTheOwningDevice(t *testing.T) {
+	bus := NewIOBus()
+	bus.SetStats(NewStats())
+
+	pit1 := NewPITDevice(NewManualClock(time.Unix(0, 0)))
+	if err := bus.Register(pit1); err != nil {
+		t.Fatalf("Register(pit1): %v", err)
+	}
+
+	pit2 := NewPITDevice(NewManualClock(time.Unix(0, 0)))
+	err := bus.Register(pit2)
+	if err == nil {
+		t.Fatal("Register with a conflicting port = nil error, want one")
+	}
+	if !strings.Contains(err.Error(), pit1.Name()) {
+		t.Errorf("Register error %q does not name the owning device %q", err, pit1.Name())
+	}
+}
+
+// tracedIOAccess is one call SetTracer's callback observed.
+type tracedIOAccess struct {
+	port uint16
+	dir  uint8
+	size uint8
+	data []byte
+}
+
+// TestIOBusSetTracerRecordsHandledAccesses checks a tracer installed with
+// SetTracer sees every handled OUT/IN with the data left by the device,
+// and that detaching it (passing nil) stops further calls.
+func TestIOBusSetTracerRecordsHandledAccesses(t *testing.T) {
+	bus := NewIOBus()
+	bus.SetStats(NewStats())
+	pit := NewPITDevice(NewManualClock(time.Unix(0, 0)))
+	if err := bus.Register(pit); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var got []tracedIOAccess
+	bus.SetTracer(func(port uint16, dir uint8, size uint8, data []byte) {
+		got = append(got, tracedIOAccess{port, dir, size, data})
+	})
+
+	// Program channel 0 (mode 2, lobyte/hibyte) with reload 100, then
+	// read it back.
+	if err := bus.Dispatch(pitPortCommand, []byte{uint8(pitAccessLoByteHiByte<<4) | uint8(2<<1)}, true); err != nil {
+		t.Fatalf("Dispatch command: %v", err)
+	}
+	if err := bus.Dispatch(pitPortCounter0, []byte{100}, true); err != nil {
+		t.Fatalf("Dispatch lo byte: %v", err)
+	}
+	if err := bus.Dispatch(pitPortCounter0, []byte{0}, true); err != nil {
+		t.Fatalf("Dispatch hi byte: %v", err)
+	}
+	readBuf := []byte{0}
+	if err := bus.Dispatch(pitPortCounter0, readBuf, false); err != nil {
+		t.Fatalf("Dispatch read: %v", err)
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("tracer saw %d accesses, want 4: %+v", len(got), got)
+	}
+	for i, want := range []tracedIOAccess{
+		{pitPortCommand, IODirWrite, 1, nil},
+		{pitPortCounter0, IODirWrite, 1, []byte{100}},
+		{pitPortCounter0, IODirWrite, 1, []byte{0}},
+		{pitPortCounter0, IODirRead, 1, []byte{100}}, // the lo byte just written
+	} {
+		if got[i].port != want.port || got[i].dir != want.dir || got[i].size != want.size {
+			t.Errorf("access %d = %+v, want port/dir/size %+v", i, got[i], want)
+		}
+		if want.data != nil && !bytes.Equal(got[i].data, want.data) {
+			t.Errorf("access %d data = % x, want % x", i, got[i].data, want.data)
+		}
+	}
+
+	// A mutation to the returned slice mustn't reach back into the bus.
+	got[0].data = append(got[0].data, 0xff)
+
+	bus.SetTracer(nil)
+	got = nil
+	if err := bus.Dispatch(pitPortCommand, []byte{0}, true); err != nil {
+		t.Fatalf("Dispatch after detaching tracer: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("tracer still called after SetTracer(nil): %+v", got)
+	}
+}
+
+// TestIOBusHistoryKeepsOnlyTheMostRecentAccesses checks SetHistorySize's
+// ring buffer drops the oldest entries once it's full, and records
+// unhandled accesses as well as ones a device serviced.
+func TestIOBusHistoryKeepsOnlyTheMostRecentAccesses(t *testing.T) {
+	bus := NewIOBus()
+	bus.SetStats(NewStats())
+	if err := bus.Register(&dummyPioDevice{ports: []uint16{0x60}}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	bus.SetHistorySize(2)
+
+	_ = bus.Dispatch(0x60, []byte{1}, true)
+	_ = bus.Dispatch(0x60, []byte{2}, true)
+	_ = bus.Dispatch(0x61, []byte{3}, false) // unhandled: no device on 0x61
+
+	history := bus.History()
+	if len(history) != 2 {
+		t.Fatalf("len(History()) = %d, want 2", len(history))
+	}
+	if history[0].Port != 0x60 || history[0].Data[0] != 2 {
+		t.Errorf("History()[0] = %+v, want the {0x60, [2]} access", history[0])
+	}
+	if history[1].Port != 0x61 || history[1].Write {
+		t.Errorf("History()[1] = %+v, want the unhandled read on 0x61", history[1])
+	}
+
+	// Appending to the returned slice mustn't reach back into the bus.
+	history = append(history, IOAccessRecord{Port: 0x99})
+	if len(bus.History()) != 2 {
+		t.Error("History() returned a slice that aliased the bus's backing array")
+	}
+}
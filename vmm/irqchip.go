@@ -0,0 +1,45 @@
+package vmm
+
+// IrqRaiser is implemented by anything a device can assert or deassert a
+// level-triggered interrupt line on: the userspace PICDevice, or (when a
+// VM uses an in-kernel irqchip) InKernelIrqChip, which routes the same
+// calls to KVM_IRQ_LINE instead. Devices that raise interrupts should be
+// constructed with this interface (see VirtualMachine.IrqRaiser) rather
+// than a concrete *PICDevice, so they work unmodified in either mode.
+type IrqRaiser interface {
+	RaiseIRQ(irq int)
+	LowerIRQ(irq int)
+}
+
+// InKernelIrqChip implements IrqRaiser by routing RaiseIRQ/LowerIRQ to
+// KVM_IRQ_LINE instead of emulating an 8259A pair in userspace.
+// setIrqLine is supplied by the caller, the same as VirtualMachine's
+// reload and injectInterrupt, because the ioctl plumbing (and the VM file
+// descriptor it needs) lives in the hypervisor package, outside vmm.
+type InKernelIrqChip struct {
+	setIrqLine func(irq int, level bool) error
+}
+
+// NewInKernelIrqChip returns an InKernelIrqChip that calls setIrqLine for
+// every RaiseIRQ/LowerIRQ, e.g. wrapping hypervisor.SetIrqLine against the
+// VM file descriptor.
+func NewInKernelIrqChip(setIrqLine func(irq int, level bool) error) *InKernelIrqChip {
+	return &InKernelIrqChip{setIrqLine: setIrqLine}
+}
+
+// RaiseIRQ implements IrqRaiser. A failing ioctl is dropped rather than
+// returned, matching PICDevice.RaiseIRQ's fire-and-forget signature.
+func (c *InKernelIrqChip) RaiseIRQ(irq int) {
+	if c.setIrqLine == nil {
+		return
+	}
+	_ = c.setIrqLine(irq, true)
+}
+
+// LowerIRQ implements IrqRaiser.
+func (c *InKernelIrqChip) LowerIRQ(irq int) {
+	if c.setIrqLine == nil {
+		return
+	}
+	_ = c.setIrqLine(irq, false)
+}
@@ -0,0 +1,101 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// TestInKernelIrqChipRaiseAndLowerTranslateToSetIrqLine checks that
+// RaiseIRQ/LowerIRQ on an InKernelIrqChip reach the underlying
+// KVM_IRQ_LINE ioctl with the right IRQ/Level arguments, using
+// hypervisor.FakeIrqChip as the recording fake in place of a real VM file
+// descriptor.
+func TestInKernelIrqChipRaiseAndLowerTranslateToSetIrqLine(t *testing.T) {
+	fake := hypervisor.NewFakeIrqChip()
+	chip := NewInKernelIrqChip(func(irq int, level bool) error {
+		return fake.SetIrqLine(hypervisor.KvmIrqLevel{IRQ: uint32(irq), Level: b2u32(level)})
+	})
+
+	chip.RaiseIRQ(4)
+	chip.LowerIRQ(4)
+
+	want := []hypervisor.KvmIrqLevel{
+		{IRQ: 4, Level: 1},
+		{IRQ: 4, Level: 0},
+	}
+	if len(fake.IrqLineCalls) != len(want) {
+		t.Fatalf("IrqLineCalls = %+v, want %+v", fake.IrqLineCalls, want)
+	}
+	for i, w := range want {
+		if fake.IrqLineCalls[i] != w {
+			t.Errorf("IrqLineCalls[%d] = %+v, want %+v", i, fake.IrqLineCalls[i], w)
+		}
+	}
+}
+
+func b2u32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// TestVirtualMachineIrqRaiserPicksModeByInKernelIrqchip checks that
+// IrqRaiser returns the userspace PIC by default and the in-kernel chip
+// once SetInKernelIrqchip has been called.
+func TestVirtualMachineIrqRaiserPicksModeByInKernelIrqchip(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+	vm := NewVirtualMachine([]hypervisor.VCPU{vcpu}, nil)
+	pic := NewMasterPIC()
+
+	if got := vm.IrqRaiser(pic); got != IrqRaiser(pic) {
+		t.Errorf("IrqRaiser before SetInKernelIrqchip = %v, want the userspace pic", got)
+	}
+	if vm.UseInKernelIrqchip() {
+		t.Error("UseInKernelIrqchip() = true before SetInKernelIrqchip was called")
+	}
+
+	chip := NewInKernelIrqChip(nil)
+	vm.SetInKernelIrqchip(chip)
+
+	if !vm.UseInKernelIrqchip() {
+		t.Error("UseInKernelIrqchip() = false after SetInKernelIrqchip")
+	}
+	if got := vm.IrqRaiser(pic); got != IrqRaiser(chip) {
+		t.Errorf("IrqRaiser after SetInKernelIrqchip = %v, want the in-kernel chip", got)
+	}
+}
+
+// TestRegisterUserspacePICAndPITSkipIOBusInKernelMode checks that
+// RegisterUserspacePIC/RegisterUserspacePIT register on the IOBus in the
+// default userspace mode, but are no-ops once SetInKernelIrqchip has been
+// called, since KVM owns interrupt delivery in that mode.
+func TestRegisterUserspacePICAndPITSkipIOBusInKernelMode(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+	clock := NewManualClock(time.Unix(0, 0))
+
+	userspaceVM := NewVirtualMachine([]hypervisor.VCPU{vcpu}, nil)
+	if err := userspaceVM.RegisterUserspacePIC(NewMasterPIC()); err != nil {
+		t.Fatalf("RegisterUserspacePIC: %v", err)
+	}
+	if err := userspaceVM.RegisterUserspacePIT(NewPITDevice(clock)); err != nil {
+		t.Fatalf("RegisterUserspacePIT: %v", err)
+	}
+	if err := userspaceVM.IOBus.Dispatch(picMasterCommandPort, []byte{0}, true); err != nil {
+		t.Errorf("userspace mode: PIC not reachable on the IOBus: %v", err)
+	}
+
+	inKernelVM := NewVirtualMachine([]hypervisor.VCPU{vcpu}, nil)
+	inKernelVM.SetInKernelIrqchip(NewInKernelIrqChip(nil))
+	if err := inKernelVM.RegisterUserspacePIC(NewMasterPIC()); err != nil {
+		t.Fatalf("RegisterUserspacePIC: %v", err)
+	}
+	if err := inKernelVM.RegisterUserspacePIT(NewPITDevice(clock)); err != nil {
+		t.Fatalf("RegisterUserspacePIT: %v", err)
+	}
+	if err := inKernelVM.IOBus.Dispatch(picMasterCommandPort, []byte{0}, true); err == nil {
+		t.Error("in-kernel mode: PIC was registered on the IOBus, want it skipped")
+	}
+}
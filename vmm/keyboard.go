@@ -0,0 +1,759 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 8042 keyboard controller ports.
+const (
+	kbcPortData   = 0x60
+	kbcPortStatus = 0x64 // read: status register, write: command register
+)
+
+// Status register bits.
+const (
+	kbcStatusOutputFull    = 1 << 0
+	kbcStatusAuxOutputFull = 1 << 5 // set when the byte at the head of the output buffer came from the mouse, not the keyboard
+)
+
+// Controller commands relevant to system control (as opposed to keyboard
+// scancode I/O, which is handled elsewhere).
+const (
+	kbcCmdReadCommandByte  = 0x20
+	kbcCmdWriteCommandByte = 0x60
+	kbcCmdWriteToAux       = 0xd4 // route the next data-port write to the mouse instead of the keyboard
+	kbcCmdWriteOutputPort  = 0xd1
+	kbcCmdPulseOutputBase  = 0xf0 // 0xf0-0xff: pulse output port bits ^cmd[3:0]
+)
+
+// Command byte bits, read and written via kbcCmdReadCommandByte and
+// kbcCmdWriteCommandByte. Only the bits this package models are named;
+// the rest (keyboard/mouse clock disable, system flag, ...) are accepted
+// and stored but have no effect.
+const (
+	kbcCmdByteIRQ1Enable  = 1 << 0 // deliver IRQ1 when the output buffer holds keyboard data
+	kbcCmdByteIRQ12Enable = 1 << 1 // deliver IRQ12 when the output buffer holds mouse data
+	kbcCmdByteTranslate   = 1 << 6 // translate injected scancode set 2 to set 1
+)
+
+// Output port bits (as written via command 0xd1).
+const (
+	kbcOutputReset = 1 << 0
+	kbcOutputA20   = 1 << 1
+)
+
+// Keyboard device commands, sent to the data port once no controller
+// command (kbcCmdWriteOutputPort, kbcCmdWriteCommandByte, ...) is
+// pending — the real 8042 forwards these straight through to the
+// keyboard rather than acting on them itself.
+const (
+	kbdCmdSetLEDs      = 0xed
+	kbdCmdSetTypematic = 0xf3
+	kbdAck             = 0xfa
+)
+
+// Auxiliary (mouse) device commands, sent to the data port after command
+// 0xd4 routes the next write there instead of to the keyboard.
+const (
+	mouseCmdSetResolution    = 0xe8
+	mouseCmdGetDeviceID      = 0xf2
+	mouseCmdSetSampleRate    = 0xf3
+	mouseCmdDisableReporting = 0xf5
+	mouseCmdEnableReporting  = 0xf4
+	mouseCmdSetDefaults      = 0xf6
+	mouseCmdReset            = 0xff
+)
+
+// kbcMaxQueuedMousePackets caps how many not-yet-delivered mouse packets
+// InjectMouseEvent will queue. Beyond this, the two oldest queued
+// packets are coalesced into one (their motion summed, not simply
+// dropped) so a guest that reads slowly degrades to coarser motion
+// rather than unbounded queue growth.
+const kbcMaxQueuedMousePackets = 8
+
+// scancodeSet2ToSet1 maps a handful of common PS/2 scancode set 2 make
+// codes to their set 1 equivalents — enough to demonstrate translation
+// without embedding the full multi-hundred-entry 8042 translation table
+// this package has no other use for yet. A code with no entry passes
+// through unchanged.
+var scancodeSet2ToSet1 = map[uint8]uint8{
+	0x1c: 0x1e, // 'A'
+	0x32: 0x30, // 'B'
+	0x21: 0x2e, // 'C'
+	0x29: 0x39, // space
+}
+
+// kbcOutputByte is one byte sitting in the shared output buffer, tagged
+// with which device it came from — the controller uses this to compute
+// the status register's AUX bit and to route IRQ1 vs IRQ12.
+type kbcOutputByte struct {
+	data uint8
+	aux  bool
+}
+
+// KeyboardControllerDevice emulates the Intel 8042 keyboard controller:
+// system-reset and A20 control through the output port (command 0xd1 and
+// the 0xf0-0xff pulse range), a command byte gating IRQ1/IRQ12 delivery
+// and scancode translation, the attached keyboard device (scancode
+// injection, LED state via command 0xed, typematic rate/delay via
+// command 0xf3), and an auxiliary PS/2 mouse reached through command
+// 0xd4.
+type KeyboardControllerDevice struct {
+	mu sync.Mutex
+
+	onReset func()
+	a20     *A20Gate
+
+	statusReg  uint8
+	pendingCmd uint8
+
+	commandByte uint8
+	outputBuf   []kbcOutputByte
+
+	pendingKeyboardCmd uint8
+
+	ledState    uint8
+	onLEDChange func(uint8)
+
+	typematicByte uint8
+
+	pendingMouseCmd       uint8
+	mouseReportingEnabled bool
+	mouseSampleRate       uint8
+	mouseResolution       uint8
+	mouseWheelMode        bool
+	sampleRateHistory     []uint8
+	mousePacketQueue      [][]uint8
+
+	raiser      IrqRaiser
+	irq         int
+	irqAsserted bool
+
+	auxRaiser      IrqRaiser
+	auxIrq         int
+	auxIrqAsserted bool
+}
+
+// NewKeyboardControllerDevice returns a controller that invokes onReset
+// whenever the guest pulses the output port's reset bit low, and that
+// toggles gate when the guest writes the output port's A20 bit via
+// command 0xd1.
+func NewKeyboardControllerDevice(onReset func(), gate *A20Gate) *KeyboardControllerDevice {
+	return &KeyboardControllerDevice{onReset: onReset, a20: gate}
+}
+
+// SetIrqRaiser wires this controller's keyboard channel to raiser's irq
+// line: whenever the output buffer's head byte came from the keyboard
+// and the command byte's IRQ1-enable bit is set, RaiseIRQ(irq) is called
+// once, followed by a matching LowerIRQ(irq) once that's no longer true.
+// Call it once after construction; leaving it unset means scancodes
+// still enter the output buffer and set the status register's OBF bit,
+// just without ever raising an interrupt for it.
+func (k *KeyboardControllerDevice) SetIrqRaiser(raiser IrqRaiser, irq int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.raiser = raiser
+	k.irq = irq
+	k.updateIrqLocked()
+}
+
+// SetAuxIrqRaiser is SetIrqRaiser for the mouse channel: it fires when
+// the output buffer's head byte came from the mouse and the command
+// byte's IRQ12-enable bit is set — IRQ12 being the slave PIC line the
+// auxiliary PS/2 port is conventionally wired to.
+func (k *KeyboardControllerDevice) SetAuxIrqRaiser(raiser IrqRaiser, irq int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.auxRaiser = raiser
+	k.auxIrq = irq
+	k.updateIrqLocked()
+}
+
+// updateIrqLocked asserts or deasserts each interrupt line to match
+// whichever device's byte currently sits at the head of the output
+// buffer, a no-op for a channel whose SetIrqRaiser/SetAuxIrqRaiser was
+// never called.
+func (k *KeyboardControllerDevice) updateIrqLocked() {
+	headAux := len(k.outputBuf) > 0 && k.outputBuf[0].aux
+	obf := k.statusReg&kbcStatusOutputFull != 0
+
+	if k.raiser != nil {
+		pending := obf && !headAux && k.commandByte&kbcCmdByteIRQ1Enable != 0
+		if pending && !k.irqAsserted {
+			k.irqAsserted = true
+			k.raiser.RaiseIRQ(k.irq)
+		} else if !pending && k.irqAsserted {
+			k.irqAsserted = false
+			k.raiser.LowerIRQ(k.irq)
+		}
+	}
+
+	if k.auxRaiser != nil {
+		pending := obf && headAux && k.commandByte&kbcCmdByteIRQ12Enable != 0
+		if pending && !k.auxIrqAsserted {
+			k.auxIrqAsserted = true
+			k.auxRaiser.RaiseIRQ(k.auxIrq)
+		} else if !pending && k.auxIrqAsserted {
+			k.auxIrqAsserted = false
+			k.auxRaiser.LowerIRQ(k.auxIrq)
+		}
+	}
+}
+
+// InjectScancode delivers set-2 scancode bytes — the set every PS/2
+// keyboard actually generates on the wire — into the output buffer,
+// translating them to set 1 first if the command byte's translate bit is
+// set. A real 8042 performs this translation transparently so legacy
+// (set-1-only) software never has to care which set the keyboard speaks.
+func (k *KeyboardControllerDevice) InjectScancode(set2 []uint8) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	translate := k.commandByte&kbcCmdByteTranslate != 0
+	pendingBreak := false
+	for _, b := range set2 {
+		if translate && b == 0xf0 {
+			// Set 1 has no break-code prefix: a break is just the make
+			// code with its high bit set. Fold the prefix into the next
+			// byte instead of emitting it.
+			pendingBreak = true
+			continue
+		}
+		out := b
+		if translate {
+			if s1, ok := scancodeSet2ToSet1[b]; ok {
+				out = s1
+			}
+			if pendingBreak {
+				out |= 0x80
+			}
+		}
+		pendingBreak = false
+		k.pushOutputByteLocked(out, false)
+	}
+}
+
+// GetLEDState returns the LED bitmask last set via keyboard command
+// 0xed: bit 0 scroll lock, bit 1 num lock, bit 2 caps lock.
+func (k *KeyboardControllerDevice) GetLEDState() uint8 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.ledState
+}
+
+// SetLEDChangeCallback installs fn to be called, with the new LED
+// bitmask, whenever the guest sets it via keyboard command 0xed — a host
+// frontend uses this to keep its caps/num/scroll lock indicators in
+// sync. Passing nil disables the callback.
+func (k *KeyboardControllerDevice) SetLEDChangeCallback(fn func(state uint8)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.onLEDChange = fn
+}
+
+// TypematicByte returns the raw rate/delay byte last set via keyboard
+// command 0xf3 (bits [4:0] encode the repeat rate, bits [6:5] the delay
+// before repeat starts), or 0 if the guest hasn't set one yet.
+func (k *KeyboardControllerDevice) TypematicByte() uint8 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.typematicByte
+}
+
+// InjectMouseEvent queues one movement packet for the auxiliary PS/2
+// mouse: dx/dy are relative motion in the PS/2 wire convention (positive
+// dx right, positive dy up), buttons carries left/right/middle in bits
+// 0-2, and wheel is a signed detent count that's only actually included
+// in the packet once the IntelliMouse wheel-detection knock sequence
+// (sample rates set to 200, then 100, then 80 via command 0xf3) has been
+// seen. The event is dropped, not queued, if the guest hasn't enabled
+// reporting with command 0xf4 — matching a real mouse, which stays quiet
+// until told to start streaming. If the guest is reading slower than
+// events arrive, the queue is capped at kbcMaxQueuedMousePackets by
+// coalescing the two oldest queued packets' motion together rather than
+// growing without bound.
+func (k *KeyboardControllerDevice) InjectMouseEvent(dx, dy int, buttons uint8, wheel int8) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.mouseReportingEnabled {
+		return
+	}
+	k.queueMousePacketLocked(buildMousePacket(dx, dy, buttons, wheel, k.mouseWheelMode))
+}
+
+func (k *KeyboardControllerDevice) queueMousePacketLocked(packet []uint8) {
+	k.mousePacketQueue = append(k.mousePacketQueue, packet)
+	for len(k.mousePacketQueue) > kbcMaxQueuedMousePackets {
+		merged := coalesceMousePackets(k.mousePacketQueue[0], k.mousePacketQueue[1])
+		k.mousePacketQueue = append([][]uint8{merged}, k.mousePacketQueue[2:]...)
+	}
+	k.tryFlushMouseQueueLocked()
+}
+
+// tryFlushMouseQueueLocked moves the next queued packet into the output
+// buffer, but only once no byte from a previous packet is still waiting
+// there — a guest must never see a mouse packet's bytes interleaved with
+// another one's.
+func (k *KeyboardControllerDevice) tryFlushMouseQueueLocked() {
+	for _, e := range k.outputBuf {
+		if e.aux {
+			return
+		}
+	}
+	if len(k.mousePacketQueue) == 0 {
+		return
+	}
+	packet := k.mousePacketQueue[0]
+	k.mousePacketQueue = k.mousePacketQueue[1:]
+	for _, b := range packet {
+		k.pushOutputByteLocked(b, true)
+	}
+}
+
+// buildMousePacket encodes a standard 3-byte PS/2 movement packet
+// (4 bytes, with a trailing wheel-delta byte, in wheel mode). dx/dy
+// beyond the wire format's 9-bit signed range are clamped, with the
+// corresponding overflow bit set, rather than wrapping.
+func buildMousePacket(dx, dy int, buttons uint8, wheel int8, wheelMode bool) []uint8 {
+	clamp := func(v int) (b uint8, negative, overflow bool) {
+		if v > 255 {
+			v, overflow = 255, true
+		}
+		if v < -256 {
+			v, overflow = -256, true
+		}
+		return uint8(v), v < 0, overflow
+	}
+
+	xByte, xNeg, xOverflow := clamp(dx)
+	yByte, yNeg, yOverflow := clamp(dy)
+
+	b0 := uint8(0x08) | (buttons & 0x07) // bit 3 is always set, identifying a valid first packet byte
+	if xNeg {
+		b0 |= 1 << 4
+	}
+	if yNeg {
+		b0 |= 1 << 5
+	}
+	if xOverflow {
+		b0 |= 1 << 6
+	}
+	if yOverflow {
+		b0 |= 1 << 7
+	}
+
+	packet := []uint8{b0, xByte, yByte}
+	if wheelMode {
+		packet = append(packet, uint8(wheel))
+	}
+	return packet
+}
+
+// decodeMousePacket is buildMousePacket's inverse, used only to combine
+// two already-encoded queued packets in coalesceMousePackets.
+func decodeMousePacket(packet []uint8) (dx, dy int, buttons uint8, wheel int8) {
+	b0 := packet[0]
+	buttons = b0 & 0x07
+	x, y := int(packet[1]), int(packet[2])
+	if b0&(1<<4) != 0 {
+		x -= 256
+	}
+	if b0&(1<<5) != 0 {
+		y -= 256
+	}
+	dx, dy = x, y
+	if len(packet) > 3 {
+		wheel = int8(packet[3])
+	}
+	return dx, dy, buttons, wheel
+}
+
+// coalesceMousePackets merges two queued packets into one carrying their
+// summed motion and wheel delta, keeping the more recent packet's button
+// state (buttons don't accumulate the way motion does).
+func coalesceMousePackets(older, newer []uint8) []uint8 {
+	odx, ody, _, owheel := decodeMousePacket(older)
+	ndx, ndy, nbuttons, nwheel := decodeMousePacket(newer)
+	wheelMode := len(older) > 3 || len(newer) > 3
+	return buildMousePacket(odx+ndx, ody+ndy, nbuttons, owheel+nwheel, wheelMode)
+}
+
+// Ports implements PioDevice.
+func (k *KeyboardControllerDevice) Ports() []uint16 {
+	return []uint16{kbcPortData, kbcPortStatus}
+}
+
+// HandleIO implements PioDevice.
+func (k *KeyboardControllerDevice) HandleIO(port uint16, data []byte, write bool) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch port {
+	case kbcPortStatus:
+		if write {
+			if len(data) > 0 {
+				k.handleCommand(data[0])
+			}
+			return nil
+		}
+		if len(data) > 0 {
+			status := k.statusReg
+			if len(k.outputBuf) > 0 && k.outputBuf[0].aux {
+				status |= kbcStatusAuxOutputFull
+			}
+			data[0] = status
+		}
+		return nil
+	case kbcPortData:
+		if write {
+			if len(data) > 0 {
+				k.handleDataWriteLocked(data[0])
+			}
+			return nil
+		}
+		if len(data) > 0 {
+			data[0] = k.popOutputByteLocked()
+		}
+		return nil
+	}
+	return nil
+}
+
+func (k *KeyboardControllerDevice) handleDataWriteLocked(b uint8) {
+	switch k.pendingCmd {
+	case kbcCmdWriteOutputPort:
+		k.writeOutputPort(b)
+		k.pendingCmd = 0
+		return
+	case kbcCmdWriteCommandByte:
+		k.commandByte = b
+		k.pendingCmd = 0
+		k.updateIrqLocked()
+		return
+	case kbcCmdWriteToAux:
+		k.pendingCmd = 0
+		k.handleMouseCommandLocked(b)
+		return
+	}
+
+	switch k.pendingKeyboardCmd {
+	case kbdCmdSetLEDs:
+		k.pendingKeyboardCmd = 0
+		k.ledState = b & 0x07
+		if k.onLEDChange != nil {
+			k.onLEDChange(k.ledState)
+		}
+		k.pushOutputByteLocked(kbdAck, false)
+		return
+	case kbdCmdSetTypematic:
+		k.pendingKeyboardCmd = 0
+		k.typematicByte = b
+		k.pushOutputByteLocked(kbdAck, false)
+		return
+	}
+
+	switch b {
+	case kbdCmdSetLEDs, kbdCmdSetTypematic:
+		k.pendingKeyboardCmd = b
+		k.pushOutputByteLocked(kbdAck, false)
+	}
+}
+
+// handleMouseCommandLocked implements the standard PS/2 mouse command
+// set: reset, defaults, reporting on/off, sample rate, resolution, and
+// device ID (which reports as 0x03, not 0x00, once the wheel knock
+// sequence has been seen). Every command is acknowledged with 0xfa, the
+// same as a real mouse; a command with no specific handling here is
+// still acked, matching a real mouse's tolerance for ones it ignores.
+func (k *KeyboardControllerDevice) handleMouseCommandLocked(b uint8) {
+	switch k.pendingMouseCmd {
+	case mouseCmdSetSampleRate:
+		k.pendingMouseCmd = 0
+		k.recordSampleRateLocked(b)
+		k.pushOutputByteLocked(kbdAck, true)
+		return
+	case mouseCmdSetResolution:
+		k.pendingMouseCmd = 0
+		k.mouseResolution = b
+		k.pushOutputByteLocked(kbdAck, true)
+		return
+	}
+
+	switch b {
+	case mouseCmdReset:
+		k.mouseReportingEnabled = false
+		k.mouseWheelMode = false
+		k.sampleRateHistory = nil
+		k.mousePacketQueue = nil
+		k.pushOutputByteLocked(kbdAck, true)
+		k.pushOutputByteLocked(0xaa, true) // self-test passed
+		k.pushOutputByteLocked(0x00, true) // standard mouse device ID
+	case mouseCmdSetDefaults:
+		k.mouseReportingEnabled = false
+		k.mouseSampleRate = 100
+		k.mouseResolution = 2
+		k.pushOutputByteLocked(kbdAck, true)
+	case mouseCmdEnableReporting:
+		k.mouseReportingEnabled = true
+		k.pushOutputByteLocked(kbdAck, true)
+	case mouseCmdDisableReporting:
+		k.mouseReportingEnabled = false
+		k.pushOutputByteLocked(kbdAck, true)
+	case mouseCmdSetSampleRate, mouseCmdSetResolution:
+		k.pendingMouseCmd = b
+		k.pushOutputByteLocked(kbdAck, true)
+	case mouseCmdGetDeviceID:
+		id := uint8(0x00)
+		if k.mouseWheelMode {
+			id = 0x03
+		}
+		k.pushOutputByteLocked(kbdAck, true)
+		k.pushOutputByteLocked(id, true)
+	default:
+		k.pushOutputByteLocked(kbdAck, true)
+	}
+}
+
+// recordSampleRateLocked tracks the last three sample rates set via
+// command 0xf3 and enables wheel mode once they match the IntelliMouse
+// knock sequence: 200, then 100, then 80.
+func (k *KeyboardControllerDevice) recordSampleRateLocked(rate uint8) {
+	k.mouseSampleRate = rate
+	k.sampleRateHistory = append(k.sampleRateHistory, rate)
+	if len(k.sampleRateHistory) > 3 {
+		k.sampleRateHistory = k.sampleRateHistory[len(k.sampleRateHistory)-3:]
+	}
+	if len(k.sampleRateHistory) == 3 &&
+		k.sampleRateHistory[0] == 200 && k.sampleRateHistory[1] == 100 && k.sampleRateHistory[2] == 80 {
+		k.mouseWheelMode = true
+	}
+}
+
+func (k *KeyboardControllerDevice) pushOutputByteLocked(b uint8, aux bool) {
+	k.outputBuf = append(k.outputBuf, kbcOutputByte{data: b, aux: aux})
+	k.statusReg |= kbcStatusOutputFull
+	k.updateIrqLocked()
+}
+
+func (k *KeyboardControllerDevice) popOutputByteLocked() uint8 {
+	if len(k.outputBuf) == 0 {
+		return 0
+	}
+	b := k.outputBuf[0]
+	k.outputBuf = k.outputBuf[1:]
+	if len(k.outputBuf) == 0 {
+		k.statusReg &^= kbcStatusOutputFull
+	}
+	k.updateIrqLocked()
+	k.tryFlushMouseQueueLocked()
+	return b.data
+}
+
+func (k *KeyboardControllerDevice) writeOutputPort(v uint8) {
+	if k.a20 != nil {
+		k.a20.SetEnabled(v&kbcOutputA20 != 0)
+	}
+	if v&kbcOutputReset == 0 && k.onReset != nil {
+		k.onReset()
+	}
+}
+
+func (k *KeyboardControllerDevice) handleCommand(cmd uint8) {
+	switch cmd {
+	case kbcCmdReadCommandByte:
+		k.pushOutputByteLocked(k.commandByte, false)
+		return
+	case kbcCmdWriteCommandByte, kbcCmdWriteToAux:
+		k.pendingCmd = cmd
+		return
+	}
+
+	if cmd < kbcCmdPulseOutputBase {
+		// Command requires a following data-port write; only 0xd1 (write
+		// output port) is modeled beyond bookkeeping the pending byte.
+		k.pendingCmd = cmd
+		return
+	}
+
+	// Pulse output port: bits [3:0] of cmd, inverted, are driven onto the
+	// low 4 output-port lines for one cycle. Bit 0 is system RESET; a low
+	// pulse (bit clear in cmd, since the value is inverted) triggers it.
+	pulseBits := cmd & 0x0f
+	if pulseBits&0x01 == 0 {
+		if k.onReset != nil {
+			k.onReset()
+		}
+	}
+}
+
+// Reset implements PioDevice.
+func (k *KeyboardControllerDevice) Reset() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.statusReg = 0
+	k.pendingCmd = 0
+	k.commandByte = 0
+	k.outputBuf = nil
+	k.pendingKeyboardCmd = 0
+	k.ledState = 0
+	k.typematicByte = 0
+	k.pendingMouseCmd = 0
+	k.mouseReportingEnabled = false
+	k.mouseSampleRate = 0
+	k.mouseResolution = 0
+	k.mouseWheelMode = false
+	k.sampleRateHistory = nil
+	k.mousePacketQueue = nil
+	k.updateIrqLocked()
+}
+
+// Name implements PioDevice.
+func (k *KeyboardControllerDevice) Name() string { return "kbc" }
+
+// kbcFixedState is KeyboardControllerDevice's fixed-size SaveState/
+// LoadState fields; outputBuf and mousePacketQueue are variable-length
+// and are framed separately. onReset, a20, and the IRQ wiring are
+// constructor/SetIrqRaiser/SetAuxIrqRaiser arguments, not state, and
+// aren't serialized.
+type kbcFixedState struct {
+	StatusReg          uint8
+	PendingCmd         uint8
+	CommandByte        uint8
+	PendingKeyboardCmd uint8
+	LEDState           uint8
+	TypematicByte      uint8
+
+	PendingMouseCmd       uint8
+	MouseReportingEnabled bool
+	MouseSampleRate       uint8
+	MouseResolution       uint8
+	MouseWheelMode        bool
+}
+
+// StateName implements StatefulDevice.
+func (k *KeyboardControllerDevice) StateName() string { return "kbc" }
+
+// StateVersion implements StatefulDevice.
+func (k *KeyboardControllerDevice) StateVersion() uint32 { return 3 }
+
+// SaveState implements StatefulDevice.
+func (k *KeyboardControllerDevice) SaveState(w io.Writer) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	st := kbcFixedState{
+		StatusReg:             k.statusReg,
+		PendingCmd:            k.pendingCmd,
+		CommandByte:           k.commandByte,
+		PendingKeyboardCmd:    k.pendingKeyboardCmd,
+		LEDState:              k.ledState,
+		TypematicByte:         k.typematicByte,
+		PendingMouseCmd:       k.pendingMouseCmd,
+		MouseReportingEnabled: k.mouseReportingEnabled,
+		MouseSampleRate:       k.mouseSampleRate,
+		MouseResolution:       k.mouseResolution,
+		MouseWheelMode:        k.mouseWheelMode,
+	}
+	if err := binary.Write(w, binary.LittleEndian, st); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, encodeOutputBuf(k.outputBuf)); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, k.sampleRateHistory); err != nil {
+		return err
+	}
+	return writeSnapshotBytes(w, encodeMousePacketQueue(k.mousePacketQueue))
+}
+
+// LoadState implements StatefulDevice.
+func (k *KeyboardControllerDevice) LoadState(r io.Reader, version uint32) error {
+	if version != k.StateVersion() {
+		return fmt.Errorf("vmm: kbc: unsupported state version %d (want %d)", version, k.StateVersion())
+	}
+	var st kbcFixedState
+	if err := binary.Read(r, binary.LittleEndian, &st); err != nil {
+		return fmt.Errorf("vmm: kbc: %w", err)
+	}
+	rawOutputBuf, err := readSnapshotBytes(r)
+	if err != nil {
+		return fmt.Errorf("vmm: kbc: reading outputBuf: %w", err)
+	}
+	sampleRateHistory, err := readSnapshotBytes(r)
+	if err != nil {
+		return fmt.Errorf("vmm: kbc: reading sampleRateHistory: %w", err)
+	}
+	rawMouseQueue, err := readSnapshotBytes(r)
+	if err != nil {
+		return fmt.Errorf("vmm: kbc: reading mousePacketQueue: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.statusReg = st.StatusReg
+	k.pendingCmd = st.PendingCmd
+	k.commandByte = st.CommandByte
+	k.pendingKeyboardCmd = st.PendingKeyboardCmd
+	k.ledState = st.LEDState
+	k.typematicByte = st.TypematicByte
+	k.pendingMouseCmd = st.PendingMouseCmd
+	k.mouseReportingEnabled = st.MouseReportingEnabled
+	k.mouseSampleRate = st.MouseSampleRate
+	k.mouseResolution = st.MouseResolution
+	k.mouseWheelMode = st.MouseWheelMode
+	k.outputBuf = decodeOutputBuf(rawOutputBuf)
+	k.sampleRateHistory = sampleRateHistory
+	k.mousePacketQueue = decodeMousePacketQueue(rawMouseQueue)
+	k.updateIrqLocked()
+	return nil
+}
+
+// encodeOutputBuf/decodeOutputBuf flatten the output buffer's
+// (data, aux) pairs into a byte stream writeSnapshotBytes can frame.
+func encodeOutputBuf(buf []kbcOutputByte) []byte {
+	raw := make([]byte, len(buf)*2)
+	for i, e := range buf {
+		raw[i*2] = e.data
+		if e.aux {
+			raw[i*2+1] = 1
+		}
+	}
+	return raw
+}
+
+func decodeOutputBuf(raw []byte) []kbcOutputByte {
+	buf := make([]kbcOutputByte, len(raw)/2)
+	for i := range buf {
+		buf[i] = kbcOutputByte{data: raw[i*2], aux: raw[i*2+1] != 0}
+	}
+	return buf
+}
+
+// encodeMousePacketQueue/decodeMousePacketQueue frame a list of
+// variable-length packets as a length byte followed by that many
+// payload bytes, repeated — packets are at most 4 bytes, so a single
+// byte is enough to hold each one's length.
+func encodeMousePacketQueue(queue [][]uint8) []byte {
+	var raw []byte
+	for _, p := range queue {
+		raw = append(raw, uint8(len(p)))
+		raw = append(raw, p...)
+	}
+	return raw
+}
+
+func decodeMousePacketQueue(raw []byte) [][]uint8 {
+	var queue [][]uint8
+	for i := 0; i < len(raw); {
+		n := int(raw[i])
+		i++
+		queue = append(queue, append([]uint8(nil), raw[i:i+n]...))
+		i += n
+	}
+	return queue
+}
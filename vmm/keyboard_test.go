@@ -0,0 +1,186 @@
+package vmm
+
+import "testing"
+
+// readKbcData reads one byte from the data port, the way a guest polling
+// the status register before reading would.
+func readKbcData(t *testing.T, kbc *KeyboardControllerDevice) uint8 {
+	t.Helper()
+	buf := make([]byte, 1)
+	if err := kbc.HandleIO(kbcPortData, buf, false); err != nil {
+		t.Fatalf("HandleIO(read data): %v", err)
+	}
+	return buf[0]
+}
+
+// writeKbcCommandByte drives the two-step 0x60/0x60 sequence a guest
+// uses to program the controller's command byte.
+func writeKbcCommandByte(t *testing.T, kbc *KeyboardControllerDevice, cmdByte uint8) {
+	t.Helper()
+	if err := kbc.HandleIO(kbcPortStatus, []byte{kbcCmdWriteCommandByte}, true); err != nil {
+		t.Fatalf("HandleIO(write command byte cmd): %v", err)
+	}
+	if err := kbc.HandleIO(kbcPortData, []byte{cmdByte}, true); err != nil {
+		t.Fatalf("HandleIO(write command byte data): %v", err)
+	}
+}
+
+// TestKeyboardTranslationProducesDifferentByteSequenceForSameKey injects
+// the same logical key press-and-release (set 2's make code followed by
+// its 0xf0-prefixed break code) with translation off and then on, and
+// checks the controller emits a different byte sequence each time — set
+// 2 unmodified without translation, set 1's prefix-free break encoding
+// with it.
+func TestKeyboardTranslationProducesDifferentByteSequenceForSameKey(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+
+	// Translation off (the command byte's power-on-equivalent zero
+	// value): set 2 passes straight through.
+	kbc.InjectScancode([]uint8{0x1c, 0xf0, 0x1c})
+	var withoutTranslation []uint8
+	for i := 0; i < 3; i++ {
+		withoutTranslation = append(withoutTranslation, readKbcData(t, kbc))
+	}
+	want := []uint8{0x1c, 0xf0, 0x1c}
+	if !equalBytes(withoutTranslation, want) {
+		t.Errorf("without translation = %#v, want %#v", withoutTranslation, want)
+	}
+
+	writeKbcCommandByte(t, kbc, kbcCmdByteTranslate)
+	kbc.InjectScancode([]uint8{0x1c, 0xf0, 0x1c})
+	var withTranslation []uint8
+	for i := 0; i < 2; i++ {
+		withTranslation = append(withTranslation, readKbcData(t, kbc))
+	}
+	if want := (uint8(0x1e)); withTranslation[0] != want {
+		t.Errorf("translated make code = %#x, want %#x", withTranslation[0], want)
+	}
+	if want := uint8(0x1e | 0x80); withTranslation[1] != want {
+		t.Errorf("translated break code = %#x, want %#x", withTranslation[1], want)
+	}
+	if len(withTranslation) == len(withoutTranslation) {
+		t.Errorf("translated sequence is the same length as the untranslated one (%d bytes); the 0xf0 prefix should have folded away", len(withTranslation))
+	}
+}
+
+func equalBytes(a, b []uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestKeyboardIRQ1EnableGatesRaiseIRQButNotOBF checks that with the
+// command byte's IRQ1-enable bit clear, injecting a scancode still sets
+// the status register's output-buffer-full bit but never calls
+// RaiseIRQ; setting the bit and injecting again then does.
+func TestKeyboardIRQ1EnableGatesRaiseIRQButNotOBF(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	raiser := &fakeIrqRaiser{}
+	kbc.SetIrqRaiser(raiser, 1)
+
+	kbc.InjectScancode([]uint8{0x1c})
+	buf := make([]byte, 1)
+	if err := kbc.HandleIO(kbcPortStatus, buf, false); err != nil {
+		t.Fatalf("HandleIO(status): %v", err)
+	}
+	if buf[0]&kbcStatusOutputFull == 0 {
+		t.Error("OBF not set after InjectScancode with IRQ1 disabled")
+	}
+	if len(raiser.raised) != 0 {
+		t.Errorf("RaiseIRQ called %d times with IRQ1 disabled, want 0", len(raiser.raised))
+	}
+	readKbcData(t, kbc) // drain, so the next assertion starts from empty
+
+	writeKbcCommandByte(t, kbc, kbcCmdByteIRQ1Enable)
+	kbc.InjectScancode([]uint8{0x1c})
+	if len(raiser.raised) != 1 || raiser.raised[0] != 1 {
+		t.Errorf("raised = %v, want a single RaiseIRQ(1) once IRQ1 is enabled", raiser.raised)
+	}
+
+	readKbcData(t, kbc)
+	if len(raiser.lowered) != 1 || raiser.lowered[0] != 1 {
+		t.Errorf("lowered = %v, want a single LowerIRQ(1) once the output buffer is drained", raiser.lowered)
+	}
+}
+
+// TestKeyboardCommandByteRoundTrips checks that command 0x60 (write
+// command byte) followed by a data write is readable back via command
+// 0x20 (read command byte).
+func TestKeyboardCommandByteRoundTrips(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	writeKbcCommandByte(t, kbc, kbcCmdByteIRQ1Enable|kbcCmdByteTranslate)
+
+	if err := kbc.HandleIO(kbcPortStatus, []byte{kbcCmdReadCommandByte}, true); err != nil {
+		t.Fatalf("HandleIO(read command byte cmd): %v", err)
+	}
+	if got, want := readKbcData(t, kbc), uint8(kbcCmdByteIRQ1Enable|kbcCmdByteTranslate); got != want {
+		t.Errorf("read-back command byte = %#x, want %#x", got, want)
+	}
+}
+
+// TestKeyboardSetLEDsUpdatesStateAndFiresCallback drives the guest side
+// of keyboard command 0xed (set LEDs): a data-port write of 0xed, acked
+// with 0xfa, followed by the LED bitmask, also acked, ending with
+// GetLEDState reporting the new mask and the change callback having
+// fired with it.
+func TestKeyboardSetLEDsUpdatesStateAndFiresCallback(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	var callbackState uint8
+	callbackCalls := 0
+	kbc.SetLEDChangeCallback(func(state uint8) {
+		callbackState = state
+		callbackCalls++
+	})
+
+	if err := kbc.HandleIO(kbcPortData, []byte{kbdCmdSetLEDs}, true); err != nil {
+		t.Fatalf("HandleIO(0xed): %v", err)
+	}
+	if got := readKbcData(t, kbc); got != kbdAck {
+		t.Fatalf("ack after 0xed = %#x, want %#x", got, kbdAck)
+	}
+
+	const leds = 0x05 // scroll lock + caps lock
+	if err := kbc.HandleIO(kbcPortData, []byte{leds}, true); err != nil {
+		t.Fatalf("HandleIO(led mask): %v", err)
+	}
+	if got := readKbcData(t, kbc); got != kbdAck {
+		t.Fatalf("ack after led mask = %#x, want %#x", got, kbdAck)
+	}
+
+	if got := kbc.GetLEDState(); got != leds {
+		t.Errorf("GetLEDState = %#x, want %#x", got, leds)
+	}
+	if callbackCalls != 1 || callbackState != leds {
+		t.Errorf("callback called %d times with state %#x, want 1 call with %#x", callbackCalls, callbackState, leds)
+	}
+}
+
+// TestKeyboardSetTypematicStoresRateAndDelayByte drives command 0xf3
+// (set typematic rate/delay) and checks TypematicByte reports the raw
+// byte the guest sent, unmodified.
+func TestKeyboardSetTypematicStoresRateAndDelayByte(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+
+	if err := kbc.HandleIO(kbcPortData, []byte{kbdCmdSetTypematic}, true); err != nil {
+		t.Fatalf("HandleIO(0xf3): %v", err)
+	}
+	readKbcData(t, kbc) // ack
+
+	const rateDelay = 0x2b
+	if err := kbc.HandleIO(kbcPortData, []byte{rateDelay}, true); err != nil {
+		t.Fatalf("HandleIO(rate/delay byte): %v", err)
+	}
+	if got := readKbcData(t, kbc); got != kbdAck {
+		t.Fatalf("ack after rate/delay byte = %#x, want %#x", got, kbdAck)
+	}
+
+	if got := kbc.TypematicByte(); got != rateDelay {
+		t.Errorf("TypematicByte = %#x, want %#x", got, rateDelay)
+	}
+}
@@ -0,0 +1,281 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Local APIC MMIO window: a single 4KB page at the well-known guest
+// physical address every xAPIC-mode guest expects, holding one register
+// per 16-byte-aligned slot (only the low 4 bytes of each slot are
+// architecturally defined; this model doesn't back the rest).
+const (
+	lapicBase = 0xfee00000
+	lapicSize = 0x1000
+)
+
+// Register offsets within the local APIC's MMIO window. Only the subset
+// needed for ID/version reporting and the LVT timer is modeled; an
+// access to any other offset reads as zero and ignores writes, the same
+// as an unimplemented register reading reserved on real hardware.
+const (
+	lapicRegID        = 0x020
+	lapicRegVersion   = 0x030
+	lapicRegEOI       = 0x0b0
+	lapicRegSVR       = 0x0f0
+	lapicRegLVTTimer  = 0x320
+	lapicRegInitCount = 0x380
+	lapicRegCurCount  = 0x390
+	lapicRegDivide    = 0x3e0
+)
+
+// Spurious Interrupt Vector Register bits.
+const (
+	lapicSVRVectorMask = 0xff
+	lapicSVRAPICEnable = 1 << 8
+)
+
+// LVT Timer register bits.
+const (
+	lapicLVTVectorMask   = 0xff
+	lapicLVTMasked       = 1 << 16
+	lapicLVTTimerModeBit = 1 << 17 // 0 = one-shot, 1 = periodic
+)
+
+// lapicVersion reports version 0x14, the value a real Pentium 4/Xeon-era
+// xAPIC reports and guests sniff for.
+const lapicVersion = 0x00000014
+
+// lapicDefaultBusClockHz is used when NewLocalAPIC is given busClockHz
+// 0, matching a real Pentium 4-class chipset's ~100MHz front-side bus.
+const lapicDefaultBusClockHz = 100_000_000
+
+// LocalAPIC models the subset of a guest CPU's local APIC a 32-bit
+// kernel built without legacy PIC support (CONFIG_X86_UP_APIC) needs:
+// ID/version reporting, the spurious vector register, and an LVT timer
+// in one-shot or periodic mode. It has no goroutine or OS timer of its
+// own; PollTimer, called from the VCPU exit loop, computes elapsed time
+// against Clock the same lazy way PITDevice.currentCount does.
+//
+// This is deliberately independent of PICDevice: when the APIC is left
+// software-disabled (SVR bit 8 clear, the power-on default) or its LVT
+// timer entry is masked, PollTimer never calls injectInterrupt, so a
+// PIC-only guest that never programs its local APIC behaves exactly as
+// if this device weren't installed at all (the real chipset's ExtINT
+// passthrough).
+type LocalAPIC struct {
+	mu sync.Mutex
+
+	id              uint32
+	clock           Clock
+	busClockHz      uint64
+	injectInterrupt func(vector uint8) error
+
+	svr          uint32
+	lvtTimer     uint32
+	divideConfig uint32
+	initialCount uint32
+
+	// armedAt and lastFire anchor the lazily-computed current count and
+	// next-firing check, the same way pitChannel.programmed anchors
+	// PITDevice.currentCount.
+	armedAt  time.Time
+	lastFire time.Time
+}
+
+// NewLocalAPIC returns a local APIC with its timer disarmed and its ID
+// register set to id (the owning VCPU's local APIC ID), reading elapsed
+// time from clock and running at busClockHz (a real chipset's bus clock
+// the divide register scales down from, typically in the 100-133MHz
+// range; busClockHz 0 uses lapicDefaultBusClockHz). injectInterrupt
+// delivers a fired timer's vector; pass VirtualMachine.InjectInterrupt
+// in production, or a func that just records the vector in a test.
+func NewLocalAPIC(id uint32, clock Clock, busClockHz uint64, injectInterrupt func(vector uint8) error) *LocalAPIC {
+	if busClockHz == 0 {
+		busClockHz = lapicDefaultBusClockHz
+	}
+	a := &LocalAPIC{id: id, clock: clock, busClockHz: busClockHz, injectInterrupt: injectInterrupt}
+	a.Reset()
+	return a
+}
+
+// Base implements MmioDevice.
+func (a *LocalAPIC) Base() uint64 { return lapicBase }
+
+// Size implements MmioDevice.
+func (a *LocalAPIC) Size() uint64 { return lapicSize }
+
+// Name implements MmioDevice.
+func (a *LocalAPIC) Name() string { return "lapic" }
+
+// Reset implements MmioDevice, returning every register to its power-on
+// state: SVR software-disabled with the default spurious vector 0xff,
+// LVT timer masked, divide-by-2, and no count programmed.
+func (a *LocalAPIC) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.svr = 0x000000ff
+	a.lvtTimer = lapicLVTMasked
+	a.divideConfig = 0
+	a.initialCount = 0
+	now := a.clock.Now()
+	a.armedAt, a.lastFire = now, now
+}
+
+// HandleMMIO implements MmioDevice. Only 4-byte-aligned, 4-byte accesses
+// are modeled, the same restriction real xAPIC hardware imposes; any
+// other width is ignored on a write and reads back as zero.
+func (a *LocalAPIC) HandleMMIO(addr uint64, data []byte, write bool) error {
+	off := addr - lapicBase
+	if len(data) != 4 || off%4 != 0 {
+		if !write {
+			for i := range data {
+				data[i] = 0
+			}
+		}
+		return nil
+	}
+	reg := uint32(off &^ 0xf) // registers occupy 16-byte-aligned slots
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if write {
+		a.writeRegisterLocked(reg, binary.LittleEndian.Uint32(data))
+		return nil
+	}
+	binary.LittleEndian.PutUint32(data, a.readRegisterLocked(reg))
+	return nil
+}
+
+func (a *LocalAPIC) readRegisterLocked(reg uint32) uint32 {
+	switch reg {
+	case lapicRegID:
+		return a.id
+	case lapicRegVersion:
+		return lapicVersion
+	case lapicRegSVR:
+		return a.svr
+	case lapicRegLVTTimer:
+		return a.lvtTimer
+	case lapicRegInitCount:
+		return a.initialCount
+	case lapicRegCurCount:
+		return a.currentCountLocked()
+	case lapicRegDivide:
+		return a.divideConfig
+	default:
+		return 0
+	}
+}
+
+func (a *LocalAPIC) writeRegisterLocked(reg, val uint32) {
+	switch reg {
+	case lapicRegID:
+		a.id = val
+	case lapicRegSVR:
+		a.svr = val
+	case lapicRegLVTTimer:
+		a.lvtTimer = val
+	case lapicRegDivide:
+		a.divideConfig = val
+	case lapicRegInitCount:
+		a.initialCount = val
+		a.armedAt = a.clock.Now()
+		a.lastFire = a.armedAt
+	case lapicRegEOI:
+		// Writing any value ends the in-service interrupt. This model
+		// tracks no ISR bit of its own (PollTimer's period-catch-up
+		// already prevents a stalled EOI from queuing a firing burst),
+		// so EOI is accepted and otherwise ignored.
+	}
+}
+
+// divideValueLocked decodes the Divide Configuration Register's bits 0,
+// 1 and 3 (bit 2 is reserved) into the actual bus-clock divisor, per the
+// architectural encoding: those three bits, read as (bit3 bit1 bit0),
+// form a value from 0-7 indexing divisors 2,4,8,16,32,64,128,1 in order.
+func (a *LocalAPIC) divideValueLocked() uint64 {
+	idx := (a.divideConfig & 0x1) | (a.divideConfig & 0x2) | ((a.divideConfig & 0x8) >> 1)
+	divisors := [8]uint64{2, 4, 8, 16, 32, 64, 128, 1}
+	return divisors[idx]
+}
+
+// periodLocked returns the wall-clock duration of one full count-down
+// from initialCount to zero at the current divide setting, or 0 if the
+// timer isn't armed.
+func (a *LocalAPIC) periodLocked() time.Duration {
+	if a.initialCount == 0 {
+		return 0
+	}
+	ticks := uint64(a.initialCount) * a.divideValueLocked()
+	return time.Duration(ticks * uint64(time.Second) / a.busClockHz)
+}
+
+// currentCountLocked computes the live Current Count Register value by
+// counting down from initialCount over periodLocked's duration, the same
+// lazy, goroutine-free approach PITDevice.currentCount uses.
+func (a *LocalAPIC) currentCountLocked() uint32 {
+	period := a.periodLocked()
+	if period <= 0 {
+		return 0
+	}
+	elapsed := a.clock.Now().Sub(a.armedAt)
+	if elapsed >= period {
+		if a.lvtTimer&lapicLVTTimerModeBit == 0 {
+			return 0 // one-shot: holds at 0 once expired
+		}
+		elapsed %= period
+	}
+	frac := uint64(elapsed) * uint64(a.initialCount) / uint64(period)
+	return a.initialCount - uint32(frac)
+}
+
+// PollTimer checks whether the LVT timer has completed one or more full
+// periods since it last fired and, if so, delivers its programmed
+// vector through injectInterrupt once per call, catching up its internal
+// bookkeeping for however many periods actually elapsed so a caller that
+// polls infrequently doesn't queue up a burst of deliveries. Callers
+// drive this once per VCPU exit loop iteration, the same way
+// VirtualMachine.Run drives its own reset/poweroff requests between
+// steps.
+//
+// One-shot mode fires at most once per arm (a write to the Initial Count
+// Register); periodic mode re-arms itself and keeps firing every period.
+// A masked LVT entry, or an APIC left software-disabled via the SVR
+// (this device's and a real chip's power-on default), never fires.
+func (a *LocalAPIC) PollTimer() error {
+	a.mu.Lock()
+
+	if a.lvtTimer&lapicLVTMasked != 0 || a.svr&lapicSVRAPICEnable == 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	period := a.periodLocked()
+	if period <= 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	elapsed := a.clock.Now().Sub(a.lastFire)
+	if elapsed < period {
+		a.mu.Unlock()
+		return nil
+	}
+
+	periodic := a.lvtTimer&lapicLVTTimerModeBit != 0
+	vector := uint8(a.lvtTimer & lapicLVTVectorMask)
+	if periodic {
+		n := elapsed / period
+		a.lastFire = a.lastFire.Add(n * period)
+	} else {
+		a.initialCount = 0 // one-shot: don't fire again until re-armed
+	}
+	inject := a.injectInterrupt
+	a.mu.Unlock()
+
+	if inject == nil {
+		return nil
+	}
+	return inject(vector)
+}
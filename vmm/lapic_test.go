@@ -0,0 +1,202 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func writeLapicReg(t *testing.T, a *LocalAPIC, reg uint32, val uint32) {
+	t.Helper()
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, val)
+	if err := a.HandleMMIO(lapicBase+uint64(reg), data, true); err != nil {
+		t.Fatalf("write reg 0x%x: %v", reg, err)
+	}
+}
+
+func readLapicReg(t *testing.T, a *LocalAPIC, reg uint32) uint32 {
+	t.Helper()
+	data := make([]byte, 4)
+	if err := a.HandleMMIO(lapicBase+uint64(reg), data, false); err != nil {
+		t.Fatalf("read reg 0x%x: %v", reg, err)
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+// armLapicTimer enables the APIC, programs the LVT timer with vector and
+// mode, sets divide-by-1, and arms the initial count.
+func armLapicTimer(t *testing.T, a *LocalAPIC, vector uint8, periodic bool, initialCount uint32) {
+	t.Helper()
+	writeLapicReg(t, a, lapicRegSVR, lapicSVRAPICEnable|0xff)
+	lvt := uint32(vector)
+	if periodic {
+		lvt |= lapicLVTTimerModeBit
+	}
+	writeLapicReg(t, a, lapicRegLVTTimer, lvt)
+	writeLapicReg(t, a, lapicRegDivide, 0x0b) // divide-by-1
+	writeLapicReg(t, a, lapicRegInitCount, initialCount)
+}
+
+func TestLocalAPICOneShotTimerFiresOnceAtDeadline(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var delivered []uint8
+	a := NewLocalAPIC(0, clock, 1_000_000, func(vector uint8) error {
+		delivered = append(delivered, vector)
+		return nil
+	})
+
+	armLapicTimer(t, a, 0x30, false, 1000) // 1000 ticks at 1MHz/1 = 1ms
+
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer before deadline: %v", err)
+	}
+	if len(delivered) != 0 {
+		t.Fatalf("delivered = %v before the deadline, want none", delivered)
+	}
+
+	clock.Advance(999 * time.Microsecond)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer just before deadline: %v", err)
+	}
+	if len(delivered) != 0 {
+		t.Fatalf("delivered = %v just before the deadline, want none", delivered)
+	}
+
+	clock.Advance(2 * time.Microsecond)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer at deadline: %v", err)
+	}
+	if len(delivered) != 1 || delivered[0] != 0x30 {
+		t.Fatalf("delivered = %v, want [0x30]", delivered)
+	}
+
+	// A one-shot timer doesn't re-fire just because more time passes.
+	clock.Advance(10 * time.Millisecond)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer long after the one-shot fired: %v", err)
+	}
+	if len(delivered) != 1 {
+		t.Fatalf("delivered = %v after extra elapsed time, want still just one", delivered)
+	}
+}
+
+func TestLocalAPICPeriodicTimerFiresEveryPeriod(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var delivered []uint8
+	a := NewLocalAPIC(0, clock, 1_000_000, func(vector uint8) error {
+		delivered = append(delivered, vector)
+		return nil
+	})
+
+	armLapicTimer(t, a, 0x40, true, 1000) // 1ms period
+
+	// Even when multiple periods elapse between polls, one PollTimer
+	// call delivers at most one interrupt: this coalescing is what
+	// avoids an interrupt storm after e.g. a long VM-exit stalls
+	// polling.
+	clock.Advance(3500 * time.Microsecond)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer: %v", err)
+	}
+	if len(delivered) != 1 {
+		t.Fatalf("delivered = %v after 3.5 elapsed periods in one call, want exactly 1 (coalesced)", delivered)
+	}
+
+	// Its internal bookkeeping still caught up by whole periods, so the
+	// next firing is due after only the remaining half period, not a
+	// full period from now.
+	clock.Advance(600 * time.Microsecond)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer: %v", err)
+	}
+	if len(delivered) != 2 {
+		t.Fatalf("delivered = %v after the remaining half period elapsed, want 2", delivered)
+	}
+	for _, v := range delivered {
+		if v != 0x40 {
+			t.Errorf("delivered vector = %#x, want 0x40", v)
+		}
+	}
+}
+
+func TestLocalAPICMaskedOrDisabledTimerNeverFires(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	fired := false
+	a := NewLocalAPIC(0, clock, 1_000_000, func(uint8) error {
+		fired = true
+		return nil
+	})
+
+	// APIC left software-disabled (the power-on default): programming
+	// the timer without setting SVR's enable bit must not fire it, so a
+	// PIC-only guest that never touches the local APIC sees no effect.
+	lvt := uint32(0x30)
+	writeLapicReg(t, a, lapicRegLVTTimer, lvt)
+	writeLapicReg(t, a, lapicRegDivide, 0x0b)
+	writeLapicReg(t, a, lapicRegInitCount, 10)
+	clock.Advance(time.Second)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer: %v", err)
+	}
+	if fired {
+		t.Fatal("timer fired while the APIC was software-disabled")
+	}
+
+	// Enabling the APIC but masking the LVT entry must also not fire it.
+	writeLapicReg(t, a, lapicRegSVR, lapicSVRAPICEnable|0xff)
+	writeLapicReg(t, a, lapicRegLVTTimer, lvt|lapicLVTMasked)
+	writeLapicReg(t, a, lapicRegInitCount, 10)
+	clock.Advance(time.Second)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer: %v", err)
+	}
+	if fired {
+		t.Fatal("timer fired while its LVT entry was masked")
+	}
+}
+
+func TestLocalAPICCurrentCountCountsDownAndIDVersionReadSaneValues(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	a := NewLocalAPIC(7, clock, 1_000_000, nil)
+
+	if got := readLapicReg(t, a, lapicRegID); got != 7 {
+		t.Errorf("ID register = %d, want 7", got)
+	}
+	if got := readLapicReg(t, a, lapicRegVersion); got != lapicVersion {
+		t.Errorf("version register = %#x, want %#x", got, lapicVersion)
+	}
+
+	armLapicTimer(t, a, 0x30, true, 1000)
+	if got := readLapicReg(t, a, lapicRegCurCount); got != 1000 {
+		t.Fatalf("current count immediately after arming = %d, want 1000", got)
+	}
+
+	clock.Advance(250 * time.Microsecond) // a quarter of the 1ms period
+	if got, want := readLapicReg(t, a, lapicRegCurCount), uint32(750); got != want {
+		t.Errorf("current count after a quarter period = %d, want %d", got, want)
+	}
+}
+
+func TestLocalAPICResetDisarmsTimer(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	fired := false
+	a := NewLocalAPIC(0, clock, 1_000_000, func(uint8) error {
+		fired = true
+		return nil
+	})
+
+	armLapicTimer(t, a, 0x30, false, 10)
+	a.Reset()
+
+	clock.Advance(time.Second)
+	if err := a.PollTimer(); err != nil {
+		t.Fatalf("PollTimer: %v", err)
+	}
+	if fired {
+		t.Fatal("timer fired after Reset disarmed it")
+	}
+	if got := readLapicReg(t, a, lapicRegSVR); got&lapicSVRAPICEnable != 0 {
+		t.Errorf("SVR = %#x after Reset, want APIC software-disabled", got)
+	}
+}
@@ -0,0 +1,21 @@
+package vmm
+
+// Logger is the leveled diagnostic sink devices report through, kept
+// deliberately separate from a device's own data path — e.g.
+// SerialPortDevice.out only ever receives guest-transmitted bytes, never
+// a diagnostic line, no matter what's installed here.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// noopLogger discards everything; it's every device's Logger until
+// SetLogger installs a real one.
+type noopLogger struct{}
+
+func (noopLogger) Debugf(string, ...interface{}) {}
+func (noopLogger) Infof(string, ...interface{})  {}
+func (noopLogger) Warnf(string, ...interface{})  {}
+func (noopLogger) Errorf(string, ...interface{}) {}
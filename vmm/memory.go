@@ -0,0 +1,55 @@
+package vmm
+
+import "fmt"
+
+// GuestMemory is the VMM-side accessor for guest physical memory. All
+// reads and writes go through it (rather than touching the mmap'd region
+// directly) so that address-space quirks like A20 wrapping are applied
+// consistently, including from MMIO/DMA-issuing devices.
+type GuestMemory struct {
+	bytes []byte
+	a20   *A20Gate
+}
+
+// NewGuestMemory wraps backing as guest physical memory starting at
+// address 0. gate may be nil, in which case A20 is always treated as
+// enabled (no wrapping).
+func NewGuestMemory(backing []byte, gate *A20Gate) *GuestMemory {
+	return &GuestMemory{bytes: backing, a20: gate}
+}
+
+func (m *GuestMemory) resolve(addr uint64) uint64 {
+	if m.a20 == nil {
+		return addr
+	}
+	return m.a20.Mask(addr)
+}
+
+// ReadAt copies len(dst) bytes starting at guest physical address addr
+// into dst, after applying A20 masking.
+func (m *GuestMemory) ReadAt(dst []byte, addr uint64) error {
+	addr = m.resolve(addr)
+	end := addr + uint64(len(dst))
+	if end > uint64(len(m.bytes)) {
+		return fmt.Errorf("vmm: guest memory read [%#x,%#x) out of range (size %#x)", addr, end, len(m.bytes))
+	}
+	copy(dst, m.bytes[addr:end])
+	return nil
+}
+
+// WriteAt copies src into guest physical memory starting at addr, after
+// applying A20 masking.
+func (m *GuestMemory) WriteAt(src []byte, addr uint64) error {
+	addr = m.resolve(addr)
+	end := addr + uint64(len(src))
+	if end > uint64(len(m.bytes)) {
+		return fmt.Errorf("vmm: guest memory write [%#x,%#x) out of range (size %#x)", addr, end, len(m.bytes))
+	}
+	copy(m.bytes[addr:end], src)
+	return nil
+}
+
+// Len returns the size of the backing memory in bytes.
+func (m *GuestMemory) Len() int {
+	return len(m.bytes)
+}
@@ -0,0 +1,382 @@
+package vmm
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/BigBossBoolingB/VDATABPro/hostmem"
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// MemorySlot is one contiguous span of guest physical memory backed by a
+// host byte slice, e.g. low memory below the legacy sub-1MB hole or high
+// memory starting at 1MB.
+type MemorySlot struct {
+	GuestPhysAddr uint64
+	Backing       []byte
+
+	// TrackDirty asks Install to set KvmMemLogDirtyPages on this slot, so
+	// VirtualMachine.GetDirtyPages can report which of its pages a guest
+	// has written since the last call.
+	TrackDirty bool
+}
+
+// MemoryLayout aggregates the, possibly non-contiguous, memory slots that
+// make up a guest's address space. Gaps between slots (such as the video
+// BIOS hole below 1MB, or an MMIO hole below 4GB) are simply left
+// unregistered so KVM reports them as unmapped and our own devices can
+// claim the same addresses over MMIO.
+type MemoryLayout struct {
+	slots []MemorySlot
+
+	// regions holds the hostmem.Region backing every slot added through
+	// AddHostMemSlot, so Close can munmap them. Slots added through
+	// AddSlot/AddSlotWithFlags aren't tracked here: their Backing wasn't
+	// mmap'd by this package, so it isn't this package's to munmap.
+	regions []*hostmem.Region
+}
+
+// NewMemoryLayout returns a layout with no slots registered.
+func NewMemoryLayout() *MemoryLayout {
+	return &MemoryLayout{}
+}
+
+const (
+	// DefaultPCIHoleBase is the guest physical address below 4GB where
+	// the PCI hole conventionally begins. RAM would otherwise overlap
+	// the 0xC0000000-0xFFFFFFFF window devices get memory-mapped into,
+	// so NewStandardMemoryLayout relocates anything that doesn't fit
+	// below it to HighMemoryBase instead of mapping over the hole.
+	DefaultPCIHoleBase uint64 = 0xC0000000
+
+	// HighMemoryBase is the guest physical address at which RAM beyond
+	// DefaultPCIHoleBase is remapped, mirroring how real PC firmware
+	// exposes memory a PCI hole would otherwise waste.
+	HighMemoryBase uint64 = 0x100000000
+)
+
+// NewStandardMemoryLayout builds the two-slot layout real PC guests use
+// once they have enough RAM to reach the PCI hole: low bytes below
+// pciHoleBase in slot 0, and, if totalSize exceeds pciHoleBase, the
+// remainder in a second slot starting at HighMemoryBase rather than
+// inside the hole. For guests that fit entirely below pciHoleBase, the
+// second slot is simply omitted.
+//
+// Both slots are allocated (via make) and registered by this call; there
+// is deliberately no way to hand in existing backing, since unlike
+// AddSlot's single-slot API this is meant as the one-line entry point
+// for "give me totalSize bytes of standard PC guest RAM".
+func NewStandardMemoryLayout(totalSize, pciHoleBase uint64) (*MemoryLayout, error) {
+	if pciHoleBase == 0 {
+		return nil, fmt.Errorf("vmm: pciHoleBase must be non-zero")
+	}
+
+	l := NewMemoryLayout()
+
+	lowSize := totalSize
+	if lowSize > pciHoleBase {
+		lowSize = pciHoleBase
+	}
+	if err := l.AddSlot(0, make([]byte, lowSize)); err != nil {
+		return nil, err
+	}
+
+	if totalSize > pciHoleBase {
+		highSize := totalSize - pciHoleBase
+		if err := l.AddSlot(HighMemoryBase, make([]byte, highSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// AddSlot registers a memory region starting at guestPhysAddr backed by
+// backing. Slots must not overlap each other.
+func (l *MemoryLayout) AddSlot(guestPhysAddr uint64, backing []byte) error {
+	return l.AddSlotWithFlags(guestPhysAddr, backing, false)
+}
+
+// AddSlotWithFlags is AddSlot, additionally letting the caller opt this
+// slot into dirty-page tracking (see MemorySlot.TrackDirty).
+func (l *MemoryLayout) AddSlotWithFlags(guestPhysAddr uint64, backing []byte, trackDirty bool) error {
+	end := guestPhysAddr + uint64(len(backing))
+	for _, s := range l.slots {
+		sEnd := s.GuestPhysAddr + uint64(len(s.Backing))
+		if guestPhysAddr < sEnd && s.GuestPhysAddr < end {
+			return fmt.Errorf("vmm: memory slot [%#x,%#x) overlaps existing slot [%#x,%#x)", guestPhysAddr, end, s.GuestPhysAddr, sEnd)
+		}
+	}
+	l.slots = append(l.slots, MemorySlot{GuestPhysAddr: guestPhysAddr, Backing: backing, TrackDirty: trackDirty})
+	return nil
+}
+
+// AddHostMemSlot allocates size bytes through hostmem.Allocate with opts
+// and registers the result as a slot at guestPhysAddr, the same as
+// AddSlot. Unlike a slot added directly with AddSlot, this one is mmap'd
+// by this call and is munmap'd by Close, so a caller doesn't need to
+// track the backing separately.
+//
+// Any non-fatal problem hostmem.Allocate hits (e.g. huge pages
+// unavailable) is returned as warnings rather than failing the call.
+func (l *MemoryLayout) AddHostMemSlot(guestPhysAddr uint64, size int, opts hostmem.Options) (warnings []string, err error) {
+	region, warnings, err := hostmem.Allocate(size, opts)
+	if err != nil {
+		return warnings, fmt.Errorf("vmm: allocating host memory for slot at %#x: %w", guestPhysAddr, err)
+	}
+	if err := l.AddSlot(guestPhysAddr, region.Bytes()); err != nil {
+		region.Close()
+		return warnings, err
+	}
+	l.regions = append(l.regions, region)
+	return warnings, nil
+}
+
+// Close munmaps every Region this layout allocated through
+// AddHostMemSlot. Slots added directly with AddSlot/AddSlotWithFlags are
+// left untouched, since this layout never owned that memory. It returns
+// the first error encountered, after attempting to close every region.
+func (l *MemoryLayout) Close() error {
+	var firstErr error
+	for _, region := range l.regions {
+		if err := region.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.regions = nil
+	return firstErr
+}
+
+// Install issues KVM_SET_USER_MEMORY_REGION for every registered slot on
+// vmFile, numbering them in registration order.
+func (l *MemoryLayout) Install(vmFile *os.File) error {
+	for i, s := range l.slots {
+		if len(s.Backing) == 0 {
+			continue
+		}
+		region := hypervisor.KvmUserspaceMemoryRegion{
+			Slot:          uint32(i),
+			GuestPhysAddr: s.GuestPhysAddr,
+			MemorySize:    uint64(len(s.Backing)),
+			UserspaceAddr: uint64(uintptr(unsafe.Pointer(&s.Backing[0]))),
+		}
+		if s.TrackDirty {
+			region.Flags |= hypervisor.KvmMemLogDirtyPages
+		}
+		if err := hypervisor.SetUserMemoryRegion(vmFile, region); err != nil {
+			return fmt.Errorf("vmm: installing memory slot %d at %#x: %w", i, s.GuestPhysAddr, err)
+		}
+	}
+	return nil
+}
+
+// Slots returns the registered memory slots in registration order.
+func (l *MemoryLayout) Slots() []MemorySlot {
+	return append([]MemorySlot(nil), l.slots...)
+}
+
+// Size returns the guest physical address just past the end of the
+// highest-addressed slot, i.e. the length of [0, Size()) that
+// DumpMemory/LoadMemory would need to cover every registered slot
+// (including any zero-filled gaps below it). It is 0 for an empty layout.
+func (l *MemoryLayout) Size() uint64 {
+	var size uint64
+	for _, s := range l.slots {
+		if end := s.GuestPhysAddr + uint64(len(s.Backing)); end > size {
+			size = end
+		}
+	}
+	return size
+}
+
+// dirtyPageSize is the page granularity KVM's dirty-page bitmap uses,
+// and also the alignment ReclaimRange/PopulateRange require: madvise
+// operates on whole pages, so a misaligned request would silently affect
+// neighboring bytes the caller never asked about.
+const dirtyPageSize = 4096
+
+// reservedBootRange is one guest physical range ReclaimRange refuses to
+// touch because a boot-time structure lives there.
+type reservedBootRange struct {
+	start, end uint64
+	what       string
+}
+
+// reservedBootRanges lists the guest physical ranges ReclaimRange must
+// never punch a hole in: the identity-mapped page tables initRegisters
+// points CR3 at, the guest parameters blob InstallGuestParams writes for
+// the guest to read directly out of memory, and the MP floating
+// pointer/config table InstallMPTable writes into the conventional BIOS
+// ROM window. Reclaiming any of these would leave a running guest
+// referencing a structure that reads back as zero.
+func reservedBootRanges() []reservedBootRange {
+	return []reservedBootRange{
+		{pml4Base, pdBase + dirtyPageSize, "the identity-mapped page tables"},
+		{GuestParamsAddr, MPFloatingPointerAddr, "the guest parameters region"},
+		{MPFloatingPointerAddr, 0x100000, "the MP floating pointer/config table"},
+	}
+}
+
+// checkNotReserved returns an error if [addr, addr+length) overlaps any
+// reservedBootRange.
+func checkNotReserved(addr, length uint64) error {
+	end := addr + length
+	for _, r := range reservedBootRanges() {
+		if addr < r.end && r.start < end {
+			return fmt.Errorf("vmm: range [%#x,%#x) overlaps %s at [%#x,%#x)", addr, end, r.what, r.start, r.end)
+		}
+	}
+	return nil
+}
+
+// ReclaimRange releases the host pages backing [gpa, gpa+length) back to
+// the kernel via hostmem.DontNeed, without unmapping them: the slot's
+// mapping stays valid, so a later guest access (or PopulateRange) simply
+// faults a fresh zero page back in. gpa and length must both be
+// dirtyPageSize-aligned, and the range must not overlap a boot-time
+// structure (see reservedBootRanges) — this is meant for reclaiming a
+// guest's own idle memory, not memory the boot loader or firmware still
+// depends on.
+func (l *MemoryLayout) ReclaimRange(gpa, length uint64) error {
+	if gpa%dirtyPageSize != 0 || length%dirtyPageSize != 0 {
+		return fmt.Errorf("vmm: ReclaimRange: [%#x,+%#x) is not %d-byte aligned", gpa, length, dirtyPageSize)
+	}
+	if err := checkNotReserved(gpa, length); err != nil {
+		return fmt.Errorf("vmm: ReclaimRange: %w", err)
+	}
+	s, off, err := l.resolveSlot(gpa, int(length))
+	if err != nil {
+		return fmt.Errorf("vmm: ReclaimRange: %w", err)
+	}
+	return hostmem.DontNeed(s.Backing[off : off+length])
+}
+
+// PopulateRange prefaults the host pages backing [gpa, gpa+length) via
+// hostmem.WillNeed, so latency-sensitive guest work resuming after a
+// ReclaimRange doesn't take first-touch page faults on its way back in.
+// gpa and length must both be dirtyPageSize-aligned. Unlike ReclaimRange,
+// it has no reason to refuse boot-time structures: prefaulting them is
+// harmless.
+func (l *MemoryLayout) PopulateRange(gpa, length uint64) error {
+	if gpa%dirtyPageSize != 0 || length%dirtyPageSize != 0 {
+		return fmt.Errorf("vmm: PopulateRange: [%#x,+%#x) is not %d-byte aligned", gpa, length, dirtyPageSize)
+	}
+	s, off, err := l.resolveSlot(gpa, int(length))
+	if err != nil {
+		return fmt.Errorf("vmm: PopulateRange: %w", err)
+	}
+	return hostmem.WillNeed(s.Backing[off : off+length])
+}
+
+// dirtyBitmapWords returns how many uint64 words a dirty-page bitmap
+// needs to cover a slot's Backing, i.e. one bit per dirtyPageSize-byte
+// page, packed 64 to a word.
+func dirtyBitmapWords(backingLen int) int {
+	pages := (backingLen + dirtyPageSize - 1) / dirtyPageSize
+	return (pages + 63) / 64
+}
+
+// resolveSlot finds the slot fully covering [addr, addr+length).
+func (l *MemoryLayout) resolveSlot(addr uint64, length int) (*MemorySlot, uint64, error) {
+	end := addr + uint64(length)
+	for i := range l.slots {
+		s := &l.slots[i]
+		sEnd := s.GuestPhysAddr + uint64(len(s.Backing))
+		if addr >= s.GuestPhysAddr && end <= sEnd {
+			return s, addr - s.GuestPhysAddr, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("vmm: guest physical range [%#x,%#x) not backed by any memory slot", addr, end)
+}
+
+// ReadAt copies len(dst) bytes starting at guest physical address addr
+// from whichever slot contains the whole range.
+func (l *MemoryLayout) ReadAt(dst []byte, addr uint64) error {
+	s, off, err := l.resolveSlot(addr, len(dst))
+	if err != nil {
+		return err
+	}
+	copy(dst, s.Backing[off:off+uint64(len(dst))])
+	return nil
+}
+
+// WriteAt copies src into guest physical memory starting at addr, into
+// whichever slot contains the whole range.
+func (l *MemoryLayout) WriteAt(src []byte, addr uint64) error {
+	s, off, err := l.resolveSlot(addr, len(src))
+	if err != nil {
+		return err
+	}
+	copy(s.Backing[off:off+uint64(len(src))], src)
+	return nil
+}
+
+// Slice returns the length-byte sub-slice of whichever slot backs
+// [addr, addr+length), aliasing that slot's Backing array directly
+// rather than copying it. It's meant for DMA-capable devices (a busmaster
+// IDE controller, an 8237, ...) that need to read or write guest memory
+// in place instead of going through ReadAt/WriteAt one buffer at a time;
+// a write through the returned slice is visible to a later ReadAt (and
+// vice versa) exactly as if the guest itself had made it. As with
+// ReadAt/WriteAt, the whole range must be covered by a single slot — a
+// caller can't request a slice spanning a memory hole or two slots at
+// once.
+func (l *MemoryLayout) Slice(addr uint64, length int) ([]byte, error) {
+	s, off, err := l.resolveSlot(addr, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.Backing[off : off+uint64(length)], nil
+}
+
+// ReadAtLossy fills dst with guest memory starting at addr, the same as
+// ReadAt, except a range that isn't fully covered by one slot is not an
+// error: bytes in a gap between slots (such as the sub-1MB video BIOS
+// hole) are left zeroed instead. DumpMemory uses this so a dump's byte
+// offsets stay aligned with guest physical addresses across gaps.
+func (l *MemoryLayout) ReadAtLossy(dst []byte, addr uint64) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	end := addr + uint64(len(dst))
+	for i := range l.slots {
+		s := &l.slots[i]
+		sEnd := s.GuestPhysAddr + uint64(len(s.Backing))
+		lo, hi := addr, end
+		if s.GuestPhysAddr > lo {
+			lo = s.GuestPhysAddr
+		}
+		if sEnd < hi {
+			hi = sEnd
+		}
+		if lo >= hi {
+			continue
+		}
+		copy(dst[lo-addr:hi-addr], s.Backing[lo-s.GuestPhysAddr:hi-s.GuestPhysAddr])
+	}
+}
+
+// WriteAtLossy writes src into guest memory starting at addr, the same as
+// WriteAt, except bytes that land in a gap between slots are silently
+// discarded instead of returning an error. LoadMemory uses this so
+// reloading a dump produced by ReadAtLossy doesn't fail on the same gaps
+// that dump zero-filled.
+func (l *MemoryLayout) WriteAtLossy(src []byte, addr uint64) {
+	end := addr + uint64(len(src))
+	for i := range l.slots {
+		s := &l.slots[i]
+		sEnd := s.GuestPhysAddr + uint64(len(s.Backing))
+		lo, hi := addr, end
+		if s.GuestPhysAddr > lo {
+			lo = s.GuestPhysAddr
+		}
+		if sEnd < hi {
+			hi = sEnd
+		}
+		if lo >= hi {
+			continue
+		}
+		copy(s.Backing[lo-s.GuestPhysAddr:hi-s.GuestPhysAddr], src[lo-addr:hi-addr])
+	}
+}
@@ -0,0 +1,283 @@
+package vmm
+
+import (
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hostmem"
+)
+
+func TestMemoryLayoutReadsAndWritesLandInCorrectSlot(t *testing.T) {
+	layout := NewMemoryLayout()
+	low := make([]byte, 0x1000)
+	high := make([]byte, 0x1000)
+
+	if err := layout.AddSlot(0, low); err != nil {
+		t.Fatalf("AddSlot(low): %v", err)
+	}
+	if err := layout.AddSlot(0x100000, high); err != nil {
+		t.Fatalf("AddSlot(high): %v", err)
+	}
+
+	if err := layout.WriteAt([]byte{0xaa}, 0x10); err != nil {
+		t.Fatalf("WriteAt(low): %v", err)
+	}
+	if err := layout.WriteAt([]byte{0xbb}, 0x100010); err != nil {
+		t.Fatalf("WriteAt(high): %v", err)
+	}
+
+	if low[0x10] != 0xaa {
+		t.Errorf("low[0x10] = %#x, want 0xaa", low[0x10])
+	}
+	if high[0x10] != 0xbb {
+		t.Errorf("high[0x10] = %#x, want 0xbb", high[0x10])
+	}
+
+	buf := make([]byte, 1)
+	if err := layout.ReadAt(buf, 0x10); err != nil {
+		t.Fatalf("ReadAt(low): %v", err)
+	}
+	if buf[0] != 0xaa {
+		t.Errorf("ReadAt(low) = %#x, want 0xaa", buf[0])
+	}
+	if err := layout.ReadAt(buf, 0x100010); err != nil {
+		t.Fatalf("ReadAt(high): %v", err)
+	}
+	if buf[0] != 0xbb {
+		t.Errorf("ReadAt(high) = %#x, want 0xbb", buf[0])
+	}
+}
+
+func TestMemoryLayoutRejectsOverlappingSlots(t *testing.T) {
+	layout := NewMemoryLayout()
+	if err := layout.AddSlot(0, make([]byte, 0x2000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	if err := layout.AddSlot(0x1000, make([]byte, 0x1000)); err == nil {
+		t.Errorf("AddSlot overlapping existing slot: got nil error, want overlap error")
+	}
+}
+
+func TestMemoryLayoutRejectsAccessSpanningTheHole(t *testing.T) {
+	layout := NewMemoryLayout()
+	if err := layout.AddSlot(0, make([]byte, 0x1000)); err != nil {
+		t.Fatalf("AddSlot(low): %v", err)
+	}
+	if err := layout.AddSlot(0x100000, make([]byte, 0x1000)); err != nil {
+		t.Fatalf("AddSlot(high): %v", err)
+	}
+	if err := layout.ReadAt(make([]byte, 1), 0x50000); err == nil {
+		t.Errorf("ReadAt in memory hole: got nil error, want out-of-range error")
+	}
+}
+
+func TestNewStandardMemoryLayoutSplitsAtPCIHole(t *testing.T) {
+	const fiveGB = 5 << 30
+	layout, err := NewStandardMemoryLayout(fiveGB, DefaultPCIHoleBase)
+	if err != nil {
+		t.Fatalf("NewStandardMemoryLayout: %v", err)
+	}
+
+	slots := layout.Slots()
+	if len(slots) != 2 {
+		t.Fatalf("got %d slots, want 2", len(slots))
+	}
+	if slots[0].GuestPhysAddr != 0 || uint64(len(slots[0].Backing)) != DefaultPCIHoleBase {
+		t.Errorf("low slot = [%#x,+%#x), want [0,+%#x)", slots[0].GuestPhysAddr, len(slots[0].Backing), DefaultPCIHoleBase)
+	}
+	wantHighSize := uint64(fiveGB) - DefaultPCIHoleBase
+	if slots[1].GuestPhysAddr != HighMemoryBase || uint64(len(slots[1].Backing)) != wantHighSize {
+		t.Errorf("high slot = [%#x,+%#x), want [%#x,+%#x)", slots[1].GuestPhysAddr, len(slots[1].Backing), HighMemoryBase, wantHighSize)
+	}
+
+	if err := layout.WriteAt([]byte{0xaa}, 0x1000); err != nil {
+		t.Errorf("WriteAt(low): %v", err)
+	}
+	if err := layout.WriteAt([]byte{0xbb}, HighMemoryBase+0x1000); err != nil {
+		t.Errorf("WriteAt(high): %v", err)
+	}
+	if err := layout.ReadAt(make([]byte, 1), DefaultPCIHoleBase+0x1000); err == nil {
+		t.Errorf("ReadAt in the PCI hole: got nil error, want out-of-range error")
+	}
+}
+
+func TestNewStandardMemoryLayoutOmitsHighSlotWhenRAMFitsBelowHole(t *testing.T) {
+	layout, err := NewStandardMemoryLayout(0x1000, DefaultPCIHoleBase)
+	if err != nil {
+		t.Fatalf("NewStandardMemoryLayout: %v", err)
+	}
+	if got := layout.Slots(); len(got) != 1 {
+		t.Fatalf("got %d slots, want 1", len(got))
+	}
+}
+
+// TestMemoryLayoutAcrossPCIHoleBoundaryWithFakeSlots exercises the same
+// address translation and hole rejection NewStandardMemoryLayout relies
+// on, but against small hand-built slots (rather than a real 5GB
+// allocation) placed at the real guest physical addresses a 5GB guest
+// would use.
+func TestMemoryLayoutAcrossPCIHoleBoundaryWithFakeSlots(t *testing.T) {
+	layout := NewMemoryLayout()
+	low := make([]byte, 0x10)
+	high := make([]byte, 0x10)
+	if err := layout.AddSlot(DefaultPCIHoleBase-0x10, low); err != nil {
+		t.Fatalf("AddSlot(low): %v", err)
+	}
+	if err := layout.AddSlot(HighMemoryBase, high); err != nil {
+		t.Fatalf("AddSlot(high): %v", err)
+	}
+
+	if err := layout.WriteAt([]byte{0xaa}, DefaultPCIHoleBase-0x8); err != nil {
+		t.Fatalf("WriteAt(low): %v", err)
+	}
+	if low[0x8] != 0xaa {
+		t.Errorf("low[0x8] = %#x, want 0xaa", low[0x8])
+	}
+	if err := layout.WriteAt([]byte{0xbb}, HighMemoryBase+0x8); err != nil {
+		t.Fatalf("WriteAt(high): %v", err)
+	}
+	if high[0x8] != 0xbb {
+		t.Errorf("high[0x8] = %#x, want 0xbb", high[0x8])
+	}
+
+	if err := layout.ReadAt(make([]byte, 1), DefaultPCIHoleBase+0x1000); err == nil {
+		t.Errorf("ReadAt in the PCI hole: got nil error, want out-of-range error")
+	}
+}
+
+func TestAddHostMemSlotReadWriteAndClose(t *testing.T) {
+	layout := NewMemoryLayout()
+	if _, err := layout.AddHostMemSlot(0, 0x1000, hostmem.Options{}); err != nil {
+		t.Fatalf("AddHostMemSlot: %v", err)
+	}
+
+	if err := layout.WriteAt([]byte{0xaa}, 0x10); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	buf := make([]byte, 1)
+	if err := layout.ReadAt(buf, 0x10); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if buf[0] != 0xaa {
+		t.Errorf("ReadAt = %#x, want 0xaa", buf[0])
+	}
+
+	if err := layout.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// TestMemoryLayoutCloseLeavesCallerOwnedSlotsAlone confirms Close only
+// munmaps memory this layout allocated itself, not backing a caller
+// supplied directly through AddSlot.
+func TestMemoryLayoutCloseLeavesCallerOwnedSlotsAlone(t *testing.T) {
+	layout := NewMemoryLayout()
+	backing := make([]byte, 0x1000)
+	if err := layout.AddSlot(0, backing); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+	if err := layout.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// backing is still a normal, usable Go slice: Close must not have
+	// tried to munmap it.
+	backing[0] = 0x42
+	if backing[0] != 0x42 {
+		t.Error("backing slice unusable after Close")
+	}
+}
+
+func TestSliceAliasesBackingStoreAndRejectsCrossingSlotBoundary(t *testing.T) {
+	layout := NewMemoryLayout()
+	backing := make([]byte, 0x1000)
+	if err := layout.AddSlot(0, backing); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	s, err := layout.Slice(0x10, 4)
+	if err != nil {
+		t.Fatalf("Slice: %v", err)
+	}
+	s[0] = 0xaa
+	if backing[0x10] != 0xaa {
+		t.Errorf("backing[0x10] = %#x, want 0xaa (Slice should alias, not copy)", backing[0x10])
+	}
+
+	got := make([]byte, 4)
+	if err := layout.ReadAt(got, 0x10); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if got[0] != 0xaa {
+		t.Errorf("ReadAt after writing through Slice = %#x, want 0xaa", got[0])
+	}
+
+	if _, err := layout.Slice(0xffe, 4); err == nil {
+		t.Error("Slice spanning past the end of the slot: got nil error, want one")
+	}
+}
+
+func TestReclaimRangeZeroesAndPopulateRangeRefills(t *testing.T) {
+	layout := NewMemoryLayout()
+	if _, err := layout.AddHostMemSlot(0, 2*dirtyPageSize, hostmem.Options{}); err != nil {
+		t.Fatalf("AddHostMemSlot: %v", err)
+	}
+	defer layout.Close()
+
+	pattern := make([]byte, dirtyPageSize)
+	for i := range pattern {
+		pattern[i] = 0xaa
+	}
+	if err := layout.WriteAt(pattern, dirtyPageSize); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	if err := layout.ReclaimRange(dirtyPageSize, dirtyPageSize); err != nil {
+		t.Fatalf("ReclaimRange: %v", err)
+	}
+
+	got := make([]byte, dirtyPageSize)
+	if err := layout.ReadAt(got, dirtyPageSize); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	for i, b := range got {
+		if b != 0 {
+			t.Fatalf("byte %d = %#x after ReclaimRange, want 0", i, b)
+		}
+	}
+
+	if err := layout.PopulateRange(dirtyPageSize, dirtyPageSize); err != nil {
+		t.Fatalf("PopulateRange: %v", err)
+	}
+}
+
+func TestReclaimRangeRejectsMisalignedRequest(t *testing.T) {
+	layout := NewMemoryLayout()
+	if _, err := layout.AddHostMemSlot(0, dirtyPageSize, hostmem.Options{}); err != nil {
+		t.Fatalf("AddHostMemSlot: %v", err)
+	}
+	defer layout.Close()
+
+	if err := layout.ReclaimRange(0x10, dirtyPageSize); err == nil {
+		t.Error("ReclaimRange at a misaligned address: got nil error, want one")
+	}
+	if err := layout.ReclaimRange(0, dirtyPageSize/2); err == nil {
+		t.Error("ReclaimRange with a misaligned length: got nil error, want one")
+	}
+}
+
+// TestReclaimRangeRejectsBootStructureOverlap confirms ReclaimRange
+// refuses to punch a hole in the identity-mapped page tables or the MP
+// table region, both of which a running guest may still depend on.
+func TestReclaimRangeRejectsBootStructureOverlap(t *testing.T) {
+	layout := NewMemoryLayout()
+	if _, err := layout.AddHostMemSlot(0, 0x100000, hostmem.Options{}); err != nil {
+		t.Fatalf("AddHostMemSlot: %v", err)
+	}
+	defer layout.Close()
+
+	if err := layout.ReclaimRange(pml4Base, dirtyPageSize); err == nil {
+		t.Error("ReclaimRange over the identity page tables: got nil error, want one")
+	}
+	if err := layout.ReclaimRange(MPFloatingPointerAddr, dirtyPageSize); err == nil {
+		t.Error("ReclaimRange over the MP table region: got nil error, want one")
+	}
+}
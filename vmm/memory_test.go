@@ -0,0 +1,22 @@
+package vmm
+
+import "testing"
+
+func TestGuestMemoryWrapsAboveOneMBWhenA20Disabled(t *testing.T) {
+	backing := make([]byte, 2<<20) // 2MB
+	gate := NewA20Gate()
+	gate.SetEnabled(false)
+	mem := NewGuestMemory(backing, gate)
+
+	if err := mem.WriteAt([]byte{0x42}, 0x100010); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	buf := make([]byte, 1)
+	if err := mem.ReadAt(buf, 0x10); err != nil { // wrapped address
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if buf[0] != 0x42 {
+		t.Errorf("wrapped read = %#x, want 0x42", buf[0])
+	}
+}
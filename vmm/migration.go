@@ -0,0 +1,383 @@
+package vmm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+// migrationMagic identifies a Migrate/ReceiveMigration stream.
+const migrationMagic = "VDBMIGR1"
+
+// migrationFormatVersion versions the framing Migrate/ReceiveMigration
+// itself uses.
+const migrationFormatVersion = 1
+
+// Migration message types, one per frame written by writeMigrationFrame.
+const (
+	// migMsgFullRAM carries the memory slot's complete contents, sent
+	// once at the start of a migration while the guest keeps running.
+	migMsgFullRAM byte = iota + 1
+	// migMsgDeltaRAM carries a SnapshotIncremental payload: the pages
+	// dirtied since the previous full or delta pass.
+	migMsgDeltaRAM
+	// migMsgFinal carries VCPU registers and device state, captured
+	// after the guest is paused; it's always the last frame.
+	migMsgFinal
+)
+
+// writeMigrationFrame writes one framed message: a 1-byte type, a
+// 4-byte length, a CRC32 of payload, and payload itself, so a corrupted
+// or truncated chunk is caught immediately instead of silently
+// desyncing the stream.
+func writeMigrationFrame(w io.Writer, msgType byte, payload []byte) error {
+	if _, err := w.Write([]byte{msgType}); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(payload))); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, crc32.ChecksumIEEE(payload)); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readMigrationFrame reads back what writeMigrationFrame wrote, refusing
+// a payload whose CRC32 doesn't match.
+func readMigrationFrame(r io.Reader) (msgType byte, payload []byte, err error) {
+	var typeByte [1]byte
+	if _, err := io.ReadFull(r, typeByte[:]); err != nil {
+		return 0, nil, err
+	}
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	var wantSum uint32
+	if err := binary.Read(r, binary.LittleEndian, &wantSum); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if gotSum := crc32.ChecksumIEEE(payload); gotSum != wantSum {
+		return 0, nil, fmt.Errorf("checksum mismatch in message type %d (got %#x, want %#x)", typeByte[0], gotSum, wantSum)
+	}
+	return typeByte[0], payload, nil
+}
+
+// MigrationConfig tunes Migrate's iterative pre-copy phase. The zero
+// value is replaced field-by-field with DefaultMigrationConfig's values.
+type MigrationConfig struct {
+	// Slot is the memory slot index (in MemoryLayout registration order)
+	// to migrate.
+	Slot uint32
+
+	// MaxIterations bounds how many dirty-page delta passes Migrate
+	// makes before forcing the final pause, in case the guest dirties
+	// pages faster than they can be sent.
+	MaxIterations int
+
+	// BytesPerSecond estimates this migration's transfer rate, used
+	// with DowntimeThreshold to judge whether a delta pass's dirty set
+	// is small enough to send within the downtime budget.
+	BytesPerSecond uint64
+
+	// DowntimeThreshold is the longest guest-visible pause Migrate
+	// should aim for: once a delta pass's dirty set is estimated (at
+	// BytesPerSecond) to transfer within this long, Migrate stops
+	// iterating and moves to the final pause instead of running
+	// another round.
+	DowntimeThreshold time.Duration
+}
+
+// DefaultMigrationConfig returns a MigrationConfig migrating slot 0 with
+// reasonable defaults for a modest local-network link.
+func DefaultMigrationConfig() MigrationConfig {
+	return MigrationConfig{
+		Slot:              0,
+		MaxIterations:     30,
+		BytesPerSecond:    1 << 30, // 1 GiB/s
+		DowntimeThreshold: 300 * time.Millisecond,
+	}
+}
+
+// normalized fills any zero-valued field with DefaultMigrationConfig's
+// value for it, so callers can supply a partial MigrationConfig (e.g.
+// just Slot) the same way MemoryLayout callers rely on AddSlot's zero
+// values.
+func (cfg MigrationConfig) normalized() MigrationConfig {
+	def := DefaultMigrationConfig()
+	if cfg.MaxIterations <= 0 {
+		cfg.MaxIterations = def.MaxIterations
+	}
+	if cfg.BytesPerSecond == 0 {
+		cfg.BytesPerSecond = def.BytesPerSecond
+	}
+	if cfg.DowntimeThreshold <= 0 {
+		cfg.DowntimeThreshold = def.DowntimeThreshold
+	}
+	return cfg
+}
+
+// estimatedTransferTime estimates how long sending pageCount dirty pages
+// would take at bytesPerSecond.
+func estimatedTransferTime(pageCount uint32, bytesPerSecond uint64) time.Duration {
+	seconds := float64(uint64(pageCount)*dirtyPageSize) / float64(bytesPerSecond)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Migrate sends this VM's live state to conn: a full pass over the
+// configured memory slot, then dirty-page delta passes while the guest
+// keeps running (each pass only holds pauseMu long enough to copy dirty
+// pages into a buffer, not for the network write), stopping once a
+// delta's estimated transfer time drops within cfg.DowntimeThreshold or
+// cfg.MaxIterations is reached. It then pauses the VM once more (holding
+// pauseMu) to capture any pages dirtied since the last delta together
+// with every VCPU's registers and every registered StatefulDevice's
+// state, releases pauseMu the same way Snapshot does, and sends them as
+// the final frames. The VM is not left paused when Migrate returns.
+//
+// Migrate requires a DirtyLogReader (see SetDirtyLogReader); without
+// dirty-page tracking there is nothing to iterate on, and it degrades to
+// one full pass followed immediately by the final frame.
+func (vm *VirtualMachine) Migrate(ctx context.Context, conn net.Conn, cfg MigrationConfig) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: Migrate: no memory layout installed (call SetMemoryLayout first)")
+	}
+	cfg = cfg.normalized()
+
+	slots := vm.memory.Slots()
+	if int(cfg.Slot) >= len(slots) {
+		return fmt.Errorf("vmm: Migrate: slot %d out of range (%d slots registered)", cfg.Slot, len(slots))
+	}
+	memSize := uint64(len(slots[cfg.Slot].Backing))
+
+	if _, err := io.WriteString(conn, migrationMagic); err != nil {
+		return fmt.Errorf("vmm: Migrate: %w", err)
+	}
+	if err := binary.Write(conn, binary.LittleEndian, uint32(migrationFormatVersion)); err != nil {
+		return fmt.Errorf("vmm: Migrate: %w", err)
+	}
+	if err := binary.Write(conn, binary.LittleEndian, cfg.Slot); err != nil {
+		return fmt.Errorf("vmm: Migrate: %w", err)
+	}
+	if err := binary.Write(conn, binary.LittleEndian, memSize); err != nil {
+		return fmt.Errorf("vmm: Migrate: %w", err)
+	}
+
+	var full bytes.Buffer
+	if err := vm.DumpMemory(&full, slots[cfg.Slot].GuestPhysAddr, memSize); err != nil {
+		return fmt.Errorf("vmm: Migrate: full pass: %w", err)
+	}
+	if err := writeMigrationFrame(conn, migMsgFullRAM, full.Bytes()); err != nil {
+		return fmt.Errorf("vmm: Migrate: full pass: %w", err)
+	}
+
+	if vm.dirtyLog != nil {
+		// Drain whatever the full pass itself raced with, so the first
+		// delta only reports pages dirtied after the full pass read them.
+		if _, err := vm.GetDirtyPages(cfg.Slot); err != nil {
+			return fmt.Errorf("vmm: Migrate: draining dirty log: %w", err)
+		}
+
+		for i := 0; i < cfg.MaxIterations; i++ {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("vmm: Migrate: %w", err)
+			}
+			var delta bytes.Buffer
+			if err := vm.SnapshotIncremental(&delta, cfg.Slot); err != nil {
+				return fmt.Errorf("vmm: Migrate: delta pass %d: %w", i, err)
+			}
+			pageCount, err := incrementalPageCount(delta.Bytes())
+			if err != nil {
+				return fmt.Errorf("vmm: Migrate: delta pass %d: %w", i, err)
+			}
+			if err := writeMigrationFrame(conn, migMsgDeltaRAM, delta.Bytes()); err != nil {
+				return fmt.Errorf("vmm: Migrate: delta pass %d: %w", i, err)
+			}
+			if estimatedTransferTime(pageCount, cfg.BytesPerSecond) <= cfg.DowntimeThreshold {
+				break
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("vmm: Migrate: %w", err)
+	}
+
+	vm.pauseMu.Lock()
+	var lastDelta bytes.Buffer
+	if vm.dirtyLog != nil {
+		if err := vm.snapshotIncrementalLocked(&lastDelta, cfg.Slot); err != nil {
+			vm.pauseMu.Unlock()
+			return fmt.Errorf("vmm: Migrate: final delta: %w", err)
+		}
+	}
+	statefulDevices := vm.statefulDevicesLocked()
+	var final bytes.Buffer
+	if err := binary.Write(&final, binary.LittleEndian, uint32(len(vm.vcpus))); err != nil {
+		vm.pauseMu.Unlock()
+		return fmt.Errorf("vmm: Migrate: final frame: %w", err)
+	}
+	if err := binary.Write(&final, binary.LittleEndian, uint32(len(statefulDevices))); err != nil {
+		vm.pauseMu.Unlock()
+		return fmt.Errorf("vmm: Migrate: final frame: %w", err)
+	}
+	if err := vm.writeVCPURegsLocked(&final); err != nil {
+		vm.pauseMu.Unlock()
+		return fmt.Errorf("vmm: Migrate: final frame: %w", err)
+	}
+	if err := vm.writeDeviceStateLocked(&final, statefulDevices); err != nil {
+		vm.pauseMu.Unlock()
+		return fmt.Errorf("vmm: Migrate: final frame: %w", err)
+	}
+	vm.pauseMu.Unlock()
+
+	if lastDelta.Len() > 0 {
+		if err := writeMigrationFrame(conn, migMsgDeltaRAM, lastDelta.Bytes()); err != nil {
+			return fmt.Errorf("vmm: Migrate: final delta: %w", err)
+		}
+	}
+	if err := writeMigrationFrame(conn, migMsgFinal, final.Bytes()); err != nil {
+		return fmt.Errorf("vmm: Migrate: final frame: %w", err)
+	}
+	return nil
+}
+
+// ReceiveMigration applies an incoming migration stream from conn (see
+// Migrate) to vm, which must already have its memory layout, devices,
+// and VCPUs configured the same as RestoreSnapshot expects. It returns
+// once the final frame has been applied, leaving the guest ready to run.
+func (vm *VirtualMachine) ReceiveMigration(ctx context.Context, conn net.Conn) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: ReceiveMigration: no memory layout installed (call SetMemoryLayout first)")
+	}
+
+	magic := make([]byte, len(migrationMagic))
+	if _, err := io.ReadFull(conn, magic); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: reading magic: %w", err)
+	}
+	if string(magic) != migrationMagic {
+		return fmt.Errorf("vmm: ReceiveMigration: not a migration stream (bad magic %q)", magic)
+	}
+	var formatVersion uint32
+	if err := binary.Read(conn, binary.LittleEndian, &formatVersion); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: reading format version: %w", err)
+	}
+	if formatVersion != migrationFormatVersion {
+		return fmt.Errorf("vmm: ReceiveMigration: unsupported migration format version %d (want %d)", formatVersion, migrationFormatVersion)
+	}
+	var slot uint32
+	if err := binary.Read(conn, binary.LittleEndian, &slot); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: reading slot: %w", err)
+	}
+	var memSize uint64
+	if err := binary.Read(conn, binary.LittleEndian, &memSize); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: reading memory size: %w", err)
+	}
+
+	slots := vm.memory.Slots()
+	if int(slot) >= len(slots) {
+		return fmt.Errorf("vmm: ReceiveMigration: slot %d out of range (%d slots registered)", slot, len(slots))
+	}
+	if memSize != uint64(len(slots[slot].Backing)) {
+		return fmt.Errorf("vmm: ReceiveMigration: incoming slot %d is %d bytes, this VM's is %d", slot, memSize, len(slots[slot].Backing))
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("vmm: ReceiveMigration: %w", err)
+		}
+
+		msgType, payload, err := readMigrationFrame(conn)
+		if err != nil {
+			return fmt.Errorf("vmm: ReceiveMigration: %w", err)
+		}
+
+		switch msgType {
+		case migMsgFullRAM:
+			if err := vm.LoadMemory(bytes.NewReader(payload), slots[slot].GuestPhysAddr); err != nil {
+				return fmt.Errorf("vmm: ReceiveMigration: full pass: %w", err)
+			}
+		case migMsgDeltaRAM:
+			if err := vm.RestoreIncremental(bytes.NewReader(payload)); err != nil {
+				return fmt.Errorf("vmm: ReceiveMigration: delta pass: %w", err)
+			}
+		case migMsgFinal:
+			return vm.applyMigrationFinal(payload)
+		default:
+			return fmt.Errorf("vmm: ReceiveMigration: unrecognized message type %d", msgType)
+		}
+	}
+}
+
+// applyMigrationFinal applies the VCPU registers and device state
+// Migrate's final frame carries.
+func (vm *VirtualMachine) applyMigrationFinal(payload []byte) error {
+	r := bytes.NewReader(payload)
+	var vcpuCount, deviceCount uint32
+	if err := binary.Read(r, binary.LittleEndian, &vcpuCount); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: final frame: reading vcpu count: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &deviceCount); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: final frame: reading device count: %w", err)
+	}
+
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+
+	if int(vcpuCount) != len(vm.vcpus) {
+		return fmt.Errorf("vmm: ReceiveMigration: final frame has %d vCPUs, this VM has %d", vcpuCount, len(vm.vcpus))
+	}
+	if err := vm.readVCPURegsLocked(r); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: final frame: %w", err)
+	}
+	if err := vm.readDeviceStateLocked(r, deviceCount); err != nil {
+		return fmt.Errorf("vmm: ReceiveMigration: final frame: %w", err)
+	}
+	return nil
+}
+
+// AcceptMigration blocks until l accepts one connection or ctx is done,
+// then applies the incoming migration stream via ReceiveMigration.
+func (vm *VirtualMachine) AcceptMigration(ctx context.Context, l net.Listener) error {
+	conn, err := acceptWithContext(ctx, l)
+	if err != nil {
+		return fmt.Errorf("vmm: AcceptMigration: %w", err)
+	}
+	defer conn.Close()
+	return vm.ReceiveMigration(ctx, conn)
+}
+
+// acceptWithContext calls l.Accept, returning early with ctx.Err if ctx
+// is done first. net.Listener has no native context support, so this
+// races Accept in a goroutine against ctx.Done; if ctx wins, l is closed
+// to unblock the still-pending Accept rather than leaking the goroutine.
+func acceptWithContext(ctx context.Context, l net.Listener) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		l.Close()
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.conn, r.err
+	}
+}
@@ -0,0 +1,132 @@
+package vmm
+
+import (
+	"fmt"
+	"time"
+)
+
+// MmioDevice is implemented by any device that answers guest
+// memory-mapped I/O accesses, the MMIO counterpart of PioDevice.
+type MmioDevice interface {
+	// Base returns the guest physical address this device's region
+	// starts at.
+	Base() uint64
+	// Size returns the length in bytes of this device's region.
+	Size() uint64
+	// HandleMMIO services a single access at addr, a guest physical
+	// address within [Base, Base+Size). write is true for a store, false
+	// for a load. data is the guest-supplied bytes for a store, or the
+	// buffer to fill for a load; its length is the access width.
+	HandleMMIO(addr uint64, data []byte, write bool) error
+	// Reset restores the device to its power-on state, as if the guest
+	// had just been (re)booted.
+	Reset()
+	// Name identifies the device in error messages, e.g. "lapic".
+	Name() string
+}
+
+// MmioBus routes guest memory-mapped accesses that fall within a
+// registered device's region, the MMIO counterpart of IOBus.
+type MmioBus struct {
+	devices []MmioDevice
+	stats   *Stats
+	clock   Clock
+
+	unhandled   *unhandledAccessTracker
+	unhandledFn func(addr uint64, write bool, count int64)
+}
+
+// NewMmioBus returns an empty MmioBus with no devices registered.
+func NewMmioBus() *MmioBus {
+	return &MmioBus{clock: RealClock{}}
+}
+
+// SetStats wires stats to be updated on every Dispatch that no device
+// claims; accesses a device handles are not recorded. Passing nil (the
+// default) disables recording.
+func (b *MmioBus) SetStats(stats *Stats) {
+	b.stats = stats
+}
+
+// SetUnhandledLogPolicy enables rate-limited logging of accesses to
+// addresses no device claims, the MMIO counterpart of
+// IOBus.SetUnhandledLogPolicy: fn is called the first time a given
+// (addr, direction) is seen, and then again every logEvery further
+// occurrences of it (0 disables repeats) or once per minInterval (0
+// disables), whichever permits it sooner. Passing fn == nil disables
+// tracking again.
+func (b *MmioBus) SetUnhandledLogPolicy(logEvery int64, minInterval time.Duration, fn func(addr uint64, write bool, count int64)) {
+	if fn == nil {
+		b.unhandled = nil
+		b.unhandledFn = nil
+		return
+	}
+	b.unhandled = &unhandledAccessTracker{LogEvery: logEvery, MinInterval: minInterval, Clock: b.clock}
+	b.unhandledFn = fn
+}
+
+// DumpUnhandled returns a snapshot of every unhandled address this bus
+// has seen and how many times it's been accessed, in no particular
+// order. It's empty unless SetUnhandledLogPolicy has been called.
+func (b *MmioBus) DumpUnhandled() []UnhandledAccessStats {
+	if b.unhandled == nil {
+		return nil
+	}
+	return b.unhandled.Dump()
+}
+
+// noteUnhandled records one unhandled access with Stats and, if
+// SetUnhandledLogPolicy is active, the rate-limited tracker, calling
+// unhandledFn when the tracker says this one should be logged.
+func (b *MmioBus) noteUnhandled(addr uint64, write bool) {
+	b.stats.RecordUnhandledMMIOAccess(addr, write)
+	if b.unhandled == nil {
+		return
+	}
+	if count, shouldLog := b.unhandled.Note(UnhandledAccessKey{Addr: addr, Write: write}); shouldLog {
+		b.unhandledFn(addr, write, count)
+	}
+}
+
+// Register adds dev's region to the bus. It returns an error if the
+// region overlaps one already registered.
+func (b *MmioBus) Register(dev MmioDevice) error {
+	start, end := dev.Base(), dev.Base()+dev.Size()
+	for _, existing := range b.devices {
+		existingStart, existingEnd := existing.Base(), existing.Base()+existing.Size()
+		if start < existingEnd && existingStart < end {
+			return fmt.Errorf("vmm: mmio region [0x%x, 0x%x) overlaps %s's [0x%x, 0x%x)",
+				start, end, existing.Name(), existingStart, existingEnd)
+		}
+	}
+	b.devices = append(b.devices, dev)
+	return nil
+}
+
+// Dispatch routes a single access at addr to the device claiming it. It
+// returns ErrUnhandledMmio if no device's region contains addr.
+func (b *MmioBus) Dispatch(addr uint64, data []byte, write bool) error {
+	for _, dev := range b.devices {
+		start, end := dev.Base(), dev.Base()+dev.Size()
+		if addr >= start && addr < end {
+			return dev.HandleMMIO(addr, data, write)
+		}
+	}
+	b.noteUnhandled(addr, write)
+	return &ErrUnhandledMmio{Addr: addr, Write: write}
+}
+
+// ErrUnhandledMmio is returned by MmioBus.Dispatch when no device's
+// region contains the accessed address.
+type ErrUnhandledMmio struct {
+	Addr  uint64
+	Write bool
+}
+
+func (e *ErrUnhandledMmio) Error() string {
+	dir := "load from"
+	if e.Write {
+		dir = "store to"
+	}
+	return fmt.Sprintf("vmm: unhandled MMIO %s 0x%x", dir, e.Addr)
+}
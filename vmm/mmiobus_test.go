@@ -0,0 +1,46 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMmioBusDispatchRoutesToRegisteredDevice(t *testing.T) {
+	bus := NewMmioBus()
+	a := NewLocalAPIC(1, NewManualClock(time.Unix(0, 0)), 0, nil)
+	if err := bus.Register(a); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	data := make([]byte, 4)
+	if err := bus.Dispatch(lapicBase+lapicRegID, data, false); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if got := binary.LittleEndian.Uint32(data); got != 1 {
+		t.Errorf("ID register via bus = %d, want 1", got)
+	}
+}
+
+func TestMmioBusDispatchReturnsErrUnhandledMmioForUnclaimedAddress(t *testing.T) {
+	bus := NewMmioBus()
+	if err := bus.Register(NewLocalAPIC(0, NewManualClock(time.Unix(0, 0)), 0, nil)); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	err := bus.Dispatch(0xdeadbeef, make([]byte, 4), false)
+	if _, ok := err.(*ErrUnhandledMmio); !ok {
+		t.Fatalf("Dispatch to an unclaimed address = %v (%T), want *ErrUnhandledMmio", err, err)
+	}
+}
+
+func TestMmioBusRegisterRejectsOverlap(t *testing.T) {
+	bus := NewMmioBus()
+	clock := NewManualClock(time.Unix(0, 0))
+	if err := bus.Register(NewLocalAPIC(0, clock, 0, nil)); err != nil {
+		t.Fatalf("Register(first): %v", err)
+	}
+	if err := bus.Register(NewLocalAPIC(1, clock, 0, nil)); err == nil {
+		t.Fatal("Register(second) at the same MMIO window = nil error, want one")
+	}
+}
@@ -0,0 +1,206 @@
+package vmm
+
+import "testing"
+
+// writeKbcAux writes b to the data port after first issuing command 0xd4
+// at the status port, the way a guest driver routes a byte to the mouse
+// instead of the keyboard.
+func writeKbcAux(t *testing.T, kbc *KeyboardControllerDevice, b uint8) {
+	t.Helper()
+	if err := kbc.HandleIO(kbcPortStatus, []byte{kbcCmdWriteToAux}, true); err != nil {
+		t.Fatalf("HandleIO(0xd4): %v", err)
+	}
+	if err := kbc.HandleIO(kbcPortData, []byte{b}, true); err != nil {
+		t.Fatalf("HandleIO(aux data %#x): %v", b, err)
+	}
+}
+
+// initMouse runs the handshake a real PS/2 mouse driver performs: reset
+// (ack + self-test result + device ID), set defaults, and enable
+// reporting, checking each ack along the way.
+func initMouse(t *testing.T, kbc *KeyboardControllerDevice) {
+	t.Helper()
+	writeKbcAux(t, kbc, mouseCmdReset)
+	if got := readKbcData(t, kbc); got != kbdAck {
+		t.Fatalf("reset ack = %#x, want %#x", got, kbdAck)
+	}
+	if got := readKbcData(t, kbc); got != 0xaa {
+		t.Fatalf("self-test result = %#x, want 0xaa", got)
+	}
+	if got := readKbcData(t, kbc); got != 0x00 {
+		t.Fatalf("device ID = %#x, want 0x00", got)
+	}
+
+	writeKbcAux(t, kbc, mouseCmdSetDefaults)
+	if got := readKbcData(t, kbc); got != kbdAck {
+		t.Fatalf("set defaults ack = %#x, want %#x", got, kbdAck)
+	}
+
+	writeKbcAux(t, kbc, mouseCmdEnableReporting)
+	if got := readKbcData(t, kbc); got != kbdAck {
+		t.Fatalf("enable reporting ack = %#x, want %#x", got, kbdAck)
+	}
+}
+
+// TestMouseInitHandshakeAndMovementPacket runs the standard PS/2 mouse
+// driver init sequence, then injects a known movement and checks the
+// resulting 3-byte packet matches the wire format: sign/overflow bits in
+// byte 0, X delta in byte 1, Y delta in byte 2.
+func TestMouseInitHandshakeAndMovementPacket(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	initMouse(t, kbc)
+
+	kbc.InjectMouseEvent(5, -3, 0x01, 0)
+
+	b0 := readKbcData(t, kbc)
+	b1 := int8(readKbcData(t, kbc))
+	b2 := int8(readKbcData(t, kbc))
+
+	if b0&0x08 == 0 {
+		t.Errorf("byte0 = %#x, always-1 bit 3 not set", b0)
+	}
+	if b0&0x01 == 0 {
+		t.Errorf("byte0 = %#x, left button bit not set", b0)
+	}
+	if b0&(1<<4) != 0 {
+		t.Errorf("byte0 = %#x, X sign bit set for a positive dx", b0)
+	}
+	if b0&(1<<5) == 0 {
+		t.Errorf("byte0 = %#x, Y sign bit not set for a negative dy", b0)
+	}
+	if b1 != 5 {
+		t.Errorf("byte1 (dx) = %d, want 5", b1)
+	}
+	if b2 != -3 {
+		t.Errorf("byte2 (dy) = %d, want -3", b2)
+	}
+}
+
+// TestMouseStatusRegisterAUXBitDistinguishesMouseBytesFromKeyboardBytes
+// checks that the status register's AUX bit tracks whichever device's
+// byte currently sits at the head of the shared output buffer.
+func TestMouseStatusRegisterAUXBitDistinguishesMouseBytesFromKeyboardBytes(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	initMouse(t, kbc)
+	kbc.InjectMouseEvent(1, 1, 0, 0)
+
+	status := make([]byte, 1)
+	if err := kbc.HandleIO(kbcPortStatus, status, false); err != nil {
+		t.Fatalf("HandleIO(status): %v", err)
+	}
+	if status[0]&kbcStatusAuxOutputFull == 0 {
+		t.Error("AUX bit not set with a mouse packet at the head of the output buffer")
+	}
+	for i := 0; i < 3; i++ {
+		readKbcData(t, kbc)
+	}
+
+	kbc.InjectScancode([]uint8{0x1c})
+	if err := kbc.HandleIO(kbcPortStatus, status, false); err != nil {
+		t.Fatalf("HandleIO(status): %v", err)
+	}
+	if status[0]&kbcStatusAuxOutputFull != 0 {
+		t.Error("AUX bit set with a keyboard byte at the head of the output buffer")
+	}
+}
+
+// TestMouseIRQ12FiresOnlyWhenEnabledAndPacketIsAtHead checks IRQ12 is
+// raised for a queued mouse packet only once the command byte's IRQ12
+// bit is set, and that it's never raised for keyboard bytes.
+func TestMouseIRQ12FiresOnlyWhenEnabledAndPacketIsAtHead(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	auxRaiser := &fakeIrqRaiser{}
+	kbc.SetAuxIrqRaiser(auxRaiser, 12)
+	initMouse(t, kbc)
+
+	kbc.InjectMouseEvent(1, 0, 0, 0)
+	if len(auxRaiser.raised) != 0 {
+		t.Errorf("RaiseIRQ(12) called with IRQ12 disabled, want none")
+	}
+	for i := 0; i < 3; i++ {
+		readKbcData(t, kbc)
+	}
+
+	writeKbcCommandByte(t, kbc, kbcCmdByteIRQ12Enable)
+	kbc.InjectMouseEvent(1, 0, 0, 0)
+	if len(auxRaiser.raised) != 1 || auxRaiser.raised[0] != 12 {
+		t.Errorf("raised = %v, want a single RaiseIRQ(12)", auxRaiser.raised)
+	}
+}
+
+// TestMouseWheelKnockSequenceEnablesFourthPacketByte drives the
+// IntelliMouse wheel-detection knock (sample rates 200, 100, 80) and
+// checks that a subsequent Get Device ID reports 0x03, and that
+// movement packets grow a fourth wheel byte.
+func TestMouseWheelKnockSequenceEnablesFourthPacketByte(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	initMouse(t, kbc)
+
+	for _, rate := range []uint8{200, 100, 80} {
+		writeKbcAux(t, kbc, mouseCmdSetSampleRate)
+		readKbcData(t, kbc) // ack
+		writeKbcAux(t, kbc, rate)
+		readKbcData(t, kbc) // ack
+	}
+
+	writeKbcAux(t, kbc, mouseCmdGetDeviceID)
+	readKbcData(t, kbc) // ack
+	if got := readKbcData(t, kbc); got != 0x03 {
+		t.Fatalf("device ID after wheel knock = %#x, want 0x03", got)
+	}
+
+	kbc.InjectMouseEvent(0, 0, 0, -1)
+	readKbcData(t, kbc) // byte0
+	readKbcData(t, kbc) // byte1
+	readKbcData(t, kbc) // byte2
+	buf := make([]byte, 1)
+	if err := kbc.HandleIO(kbcPortStatus, buf, false); err != nil {
+		t.Fatalf("HandleIO(status): %v", err)
+	}
+	if buf[0]&kbcStatusOutputFull == 0 {
+		t.Fatal("OBF not set for the wheel packet's 4th byte")
+	}
+	if got := int8(readKbcData(t, kbc)); got != -1 {
+		t.Errorf("wheel byte = %d, want -1", got)
+	}
+}
+
+// TestMouseQueueCoalescesWhenGuestFallsBehind injects more packets than
+// kbcMaxQueuedMousePackets without draining the queue, and checks the
+// queued packet count is capped while the net motion is preserved
+// (coalescing sums motion rather than dropping it outright).
+func TestMouseQueueCoalescesWhenGuestFallsBehind(t *testing.T) {
+	kbc := NewKeyboardControllerDevice(nil, NewA20Gate())
+	initMouse(t, kbc)
+
+	for i := 0; i < kbcMaxQueuedMousePackets+3; i++ {
+		kbc.InjectMouseEvent(1, 0, 0, 0)
+	}
+
+	if got := len(kbc.mousePacketQueue); got > kbcMaxQueuedMousePackets {
+		t.Errorf("queued (not yet flushed) packet count = %d, want <= %d", got, kbcMaxQueuedMousePackets)
+	}
+
+	// Draining the output buffer automatically flushes each subsequent
+	// queued packet in turn (see tryFlushMouseQueueLocked), so read
+	// every packet all the way through the port interface and sum the
+	// dx each one reports, rather than inspecting the queue mid-drain.
+	var totalDX, packets int
+	for len(kbc.outputBuf) > 0 || len(kbc.mousePacketQueue) > 0 {
+		b0 := readKbcData(t, kbc)
+		dx := int(readKbcData(t, kbc))
+		if b0&(1<<4) != 0 {
+			dx -= 256
+		}
+		readKbcData(t, kbc) // byte2
+		totalDX += dx
+		packets++
+	}
+
+	if totalDX != kbcMaxQueuedMousePackets+3 {
+		t.Errorf("total dx across all drained packets = %d, want %d (one unit per injected event, none lost)", totalDX, kbcMaxQueuedMousePackets+3)
+	}
+	if packets != kbcMaxQueuedMousePackets+1 {
+		t.Errorf("drained %d packets, want %d (queue capped at %d, plus the one already in flight)", packets, kbcMaxQueuedMousePackets+1, kbcMaxQueuedMousePackets)
+	}
+}
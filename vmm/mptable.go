@@ -0,0 +1,160 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MPFloatingPointerAddr and MPConfigTableAddr are the fixed guest
+// physical addresses InstallMPTable places the MP floating pointer
+// structure and configuration table at, within the sub-1MB BIOS region
+// real hardware reserves for it — a guest OS's MP table scan looks there
+// first, so no pointer to either has to be communicated any other way.
+const (
+	MPFloatingPointerAddr = 0xf0000
+	MPConfigTableAddr     = MPFloatingPointerAddr + 16
+)
+
+// Local APIC and I/O APIC MMIO base addresses, as recorded in the MP
+// config table header and the stub I/O APIC entry respectively. The
+// local APIC at this address is LocalAPIC (lapicBase); the I/O APIC is
+// not modeled by this package, and its address is here only so the MP
+// table's own layout is self-consistent.
+const (
+	mpLocalAPICAddr = 0xfee00000
+	mpIOAPICAddr    = 0xfec00000
+)
+
+// MP config table entry types (Intel MP Specification 1.4, table 4-1).
+const (
+	mpEntryProcessor = 0
+	mpEntryBus       = 1
+	mpEntryIOAPIC    = 2
+)
+
+// Processor entry CPU flags.
+const (
+	mpCPUFlagEnabled = 1 << 0
+	mpCPUFlagBSP     = 1 << 1
+)
+
+// buildMPFloatingPointer returns a 16-byte MP Floating Pointer Structure
+// pointing at a configuration table located at configTableAddr, with its
+// checksum filled in. Feature byte 1 is left 0, meaning "no default
+// configuration; the configuration table is present and valid."
+func buildMPFloatingPointer(configTableAddr uint32) []byte {
+	b := make([]byte, 16)
+	copy(b[0:4], "_MP_")
+	binary.LittleEndian.PutUint32(b[4:8], configTableAddr)
+	b[8] = 1 // length in 16-byte paragraphs
+	b[9] = 4 // spec revision 1.4
+	// b[10] is the checksum, filled in below.
+	// b[11:16] (feature bytes) are left zero.
+	setMPChecksum(b, 10)
+	return b
+}
+
+// buildMPConfigTable returns an MP Configuration Table Header followed by
+// one processor entry per vCPU (index 0 is the BSP), one ISA bus entry,
+// and one I/O APIC entry — enough for a guest OS's MP table scan to
+// enumerate its CPUs and start its APs, without modeling the I/O APIC's
+// actual redirection table.
+func buildMPConfigTable(numVCPUs int) []byte {
+	const headerLen = 44
+
+	var entries []byte
+	for i := 0; i < numVCPUs; i++ {
+		e := make([]byte, 20)
+		e[0] = mpEntryProcessor
+		e[1] = byte(i) // local APIC ID == vCPU index
+		e[2] = 0x14    // local APIC version (stub)
+		flags := byte(mpCPUFlagEnabled)
+		if i == 0 {
+			flags |= mpCPUFlagBSP
+		}
+		e[3] = flags
+		// e[4:8] (CPU signature) and e[8:12] (feature flags) are left
+		// zero: nothing in this package reads them back.
+		entries = append(entries, e...)
+	}
+
+	bus := make([]byte, 8)
+	bus[0] = mpEntryBus
+	bus[1] = 0 // bus ID 0
+	copy(bus[2:8], padASCII("ISA", 6))
+	entries = append(entries, bus...)
+
+	ioapic := make([]byte, 8)
+	ioapic[0] = mpEntryIOAPIC
+	ioapic[1] = 0    // I/O APIC ID 0
+	ioapic[2] = 0x11 // I/O APIC version (stub)
+	ioapic[3] = 1    // enabled
+	binary.LittleEndian.PutUint32(ioapic[4:8], mpIOAPICAddr)
+	entries = append(entries, ioapic...)
+
+	total := headerLen + len(entries)
+	b := make([]byte, total)
+	copy(b[0:4], "PCMP")
+	binary.LittleEndian.PutUint16(b[4:6], uint16(total))
+	b[6] = 4 // spec revision 1.4
+	// b[7] is the checksum, filled in below.
+	copy(b[8:16], padASCII("VDBPRO", 8))
+	copy(b[16:28], padASCII("VDATABPro", 12))
+	binary.LittleEndian.PutUint32(b[28:32], 0) // OEM table pointer: none
+	binary.LittleEndian.PutUint16(b[32:34], 0) // OEM table size: none
+	binary.LittleEndian.PutUint16(b[34:36], uint16(numVCPUs+2))
+	binary.LittleEndian.PutUint32(b[36:40], mpLocalAPICAddr)
+	binary.LittleEndian.PutUint16(b[40:42], 0) // extended table length: none
+	b[42] = 0                                  // extended table checksum
+	b[43] = 0                                  // reserved
+	copy(b[44:], entries)
+	setMPChecksum(b, 7)
+	return b
+}
+
+// padASCII returns an n-byte, space-padded copy of s, the fixed-width
+// string encoding the MP table's OEM ID/product ID/bus type fields use. s
+// must not be longer than n.
+func padASCII(s string, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = ' '
+	}
+	copy(b, s)
+	return b
+}
+
+// setMPChecksum sets b[offset] so the bytes of b sum to 0 mod 256, the
+// checksum convention both the MP floating pointer structure and the MP
+// configuration table header use.
+func setMPChecksum(b []byte, offset int) {
+	b[offset] = 0
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	b[offset] = -sum
+}
+
+// InstallMPTable writes an Intel MP Specification 1.4 floating pointer
+// structure and configuration table describing numVCPUs processors into
+// mem, at MPFloatingPointerAddr/MPConfigTableAddr, so a multiprocessor-
+// aware guest OS can discover its APs and start them via INIT/SIPI
+// instead of only ever finding one CPU. numVCPUs must match the vCPU
+// count NewVirtualMachine was given; the first is always the BSP. Call it
+// once during VM setup, after the memory region covering
+// MPFloatingPointerAddr has been added to mem but before Install.
+func InstallMPTable(mem *MemoryLayout, numVCPUs int) error {
+	if numVCPUs <= 0 {
+		return fmt.Errorf("vmm: InstallMPTable: numVCPUs must be positive, got %d", numVCPUs)
+	}
+	fp := buildMPFloatingPointer(MPConfigTableAddr)
+	cfg := buildMPConfigTable(numVCPUs)
+	if err := mem.WriteAt(fp, MPFloatingPointerAddr); err != nil {
+		return fmt.Errorf("vmm: InstallMPTable: writing floating pointer: %w", err)
+	}
+	if err := mem.WriteAt(cfg, MPConfigTableAddr); err != nil {
+		return fmt.Errorf("vmm: InstallMPTable: writing config table: %w", err)
+	}
+	return nil
+}
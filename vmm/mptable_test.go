@@ -0,0 +1,137 @@
+package vmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// sumBytes returns the sum of b's bytes mod 256, the quantity the MP
+// table checksum convention requires to be 0.
+func sumBytes(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
+
+// TestBuildMPFloatingPointerChecksumsAndPointsAtConfigTable checks the MP
+// floating pointer structure's fixed fields and that its checksum makes
+// the whole 16 bytes sum to 0.
+func TestBuildMPFloatingPointerChecksumsAndPointsAtConfigTable(t *testing.T) {
+	fp := buildMPFloatingPointer(MPConfigTableAddr)
+
+	if len(fp) != 16 {
+		t.Fatalf("len(fp) = %d, want 16", len(fp))
+	}
+	if string(fp[0:4]) != "_MP_" {
+		t.Errorf("signature = %q, want \"_MP_\"", fp[0:4])
+	}
+	if got := binary.LittleEndian.Uint32(fp[4:8]); got != uint32(MPConfigTableAddr) {
+		t.Errorf("config table pointer = %#x, want %#x", got, MPConfigTableAddr)
+	}
+	if fp[8] != 1 {
+		t.Errorf("length in paragraphs = %d, want 1", fp[8])
+	}
+	if fp[9] != 4 {
+		t.Errorf("spec revision = %d, want 4", fp[9])
+	}
+	if sumBytes(fp) != 0 {
+		t.Errorf("checksum invalid: bytes sum to %d mod 256, want 0", sumBytes(fp))
+	}
+}
+
+// TestBuildMPConfigTableLayoutAndChecksum checks the configuration table
+// header's entry count and length against a 4-vCPU table's actual
+// entries, and that its checksum makes the whole table sum to 0.
+func TestBuildMPConfigTableLayoutAndChecksum(t *testing.T) {
+	const numVCPUs = 4
+	cfg := buildMPConfigTable(numVCPUs)
+
+	if string(cfg[0:4]) != "PCMP" {
+		t.Errorf("signature = %q, want \"PCMP\"", cfg[0:4])
+	}
+	wantLen := 44 + numVCPUs*20 + 8 + 8 // header + processors + bus + ioapic
+	if got := binary.LittleEndian.Uint16(cfg[4:6]); int(got) != wantLen {
+		t.Errorf("base table length = %d, want %d", got, wantLen)
+	}
+	if len(cfg) != wantLen {
+		t.Errorf("len(cfg) = %d, want %d", len(cfg), wantLen)
+	}
+	if got := binary.LittleEndian.Uint16(cfg[34:36]); int(got) != numVCPUs+2 {
+		t.Errorf("entry count = %d, want %d", got, numVCPUs+2)
+	}
+	if sumBytes(cfg) != 0 {
+		t.Errorf("checksum invalid: bytes sum to %d mod 256, want 0", sumBytes(cfg))
+	}
+
+	// The first numVCPUs entries must be processor entries, index 0
+	// flagged as the BSP and the rest not, all enabled.
+	for i := 0; i < numVCPUs; i++ {
+		e := cfg[44+i*20 : 44+i*20+20]
+		if e[0] != mpEntryProcessor {
+			t.Fatalf("entry %d type = %d, want processor (%d)", i, e[0], mpEntryProcessor)
+		}
+		if e[1] != byte(i) {
+			t.Errorf("entry %d local APIC ID = %d, want %d", i, e[1], i)
+		}
+		wantBSP := i == 0
+		if gotBSP := e[3]&mpCPUFlagBSP != 0; gotBSP != wantBSP {
+			t.Errorf("entry %d BSP flag = %v, want %v", i, gotBSP, wantBSP)
+		}
+		if e[3]&mpCPUFlagEnabled == 0 {
+			t.Errorf("entry %d not flagged enabled", i)
+		}
+	}
+
+	busOff := 44 + numVCPUs*20
+	if cfg[busOff] != mpEntryBus {
+		t.Errorf("entry after processors type = %d, want bus (%d)", cfg[busOff], mpEntryBus)
+	}
+	ioapicOff := busOff + 8
+	if cfg[ioapicOff] != mpEntryIOAPIC {
+		t.Errorf("last entry type = %d, want I/O APIC (%d)", cfg[ioapicOff], mpEntryIOAPIC)
+	}
+}
+
+// TestInstallMPTableWritesBothStructuresToMemory checks that
+// InstallMPTable places a valid floating pointer at MPFloatingPointerAddr
+// pointing at a valid configuration table at MPConfigTableAddr.
+func TestInstallMPTableWritesBothStructuresToMemory(t *testing.T) {
+	mem := NewMemoryLayout()
+	if err := mem.AddSlot(0, make([]byte, 0x100000)); err != nil {
+		t.Fatalf("AddSlot: %v", err)
+	}
+
+	if err := InstallMPTable(mem, 2); err != nil {
+		t.Fatalf("InstallMPTable: %v", err)
+	}
+
+	fp := make([]byte, 16)
+	if err := mem.ReadAt(fp, MPFloatingPointerAddr); err != nil {
+		t.Fatalf("ReadAt floating pointer: %v", err)
+	}
+	if !bytes.Equal(fp[0:4], []byte("_MP_")) {
+		t.Fatalf("floating pointer signature = %q, want \"_MP_\"", fp[0:4])
+	}
+	if sumBytes(fp) != 0 {
+		t.Errorf("floating pointer checksum invalid")
+	}
+
+	hdr := make([]byte, 6)
+	if err := mem.ReadAt(hdr, MPConfigTableAddr); err != nil {
+		t.Fatalf("ReadAt config table header: %v", err)
+	}
+	cfgLen := binary.LittleEndian.Uint16(hdr[4:6])
+	cfg := make([]byte, cfgLen)
+	if err := mem.ReadAt(cfg, MPConfigTableAddr); err != nil {
+		t.Fatalf("ReadAt config table: %v", err)
+	}
+	if !bytes.Equal(cfg[0:4], []byte("PCMP")) {
+		t.Fatalf("config table signature = %q, want \"PCMP\"", cfg[0:4])
+	}
+	if sumBytes(cfg) != 0 {
+		t.Errorf("config table checksum invalid")
+	}
+}
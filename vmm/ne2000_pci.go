@@ -0,0 +1,76 @@
+package vmm
+
+// NE2000PCIFunction exposes an NE2000Device as an RTL8029-compatible PCI
+// function: vendor/device IDs Realtek's real hardware uses, an I/O BAR0
+// programmable by guest firmware, and class code 0x020000 (Ethernet
+// controller). bus is used to move dev's port claim whenever BAR0 is
+// reprogrammed; irqRaiser (may be nil) is where dev's interrupt line goes
+// once firmware routes it via the Interrupt Line register.
+type NE2000PCIFunction struct {
+	dev       *NE2000Device
+	bus       *IOBus
+	irqRaiser IrqRaiser
+
+	command       uint16
+	bar0          uint32
+	interruptLine uint8
+}
+
+const (
+	rtl8029VendorID = 0x10ec
+	rtl8029DeviceID = 0x8029
+)
+
+// NewNE2000PCIFunction wraps dev for attachment to a PCIBus via
+// PCIBus.RegisterFunction. dev must already be registered on bus at its
+// current Ports() — the Command register's I/O Space Enable bit powers
+// on clear, matching real hardware, so dev's ports won't actually answer
+// until ConfigWrite enables them.
+func NewNE2000PCIFunction(dev *NE2000Device, bus *IOBus, irqRaiser IrqRaiser) *NE2000PCIFunction {
+	dev.SetIOEnabled(false)
+	return &NE2000PCIFunction{dev: dev, bus: bus, irqRaiser: irqRaiser, bar0: uint32(dev.base) | 0x1} // bit 0 set: I/O space BAR
+}
+
+// ConfigRead implements PCIFunction.
+func (f *NE2000PCIFunction) ConfigRead(off uint8, size int) uint32 {
+	switch off {
+	case 0x00:
+		return uint32(rtl8029DeviceID)<<16 | rtl8029VendorID
+	case 0x04:
+		return uint32(f.command)
+	case 0x08:
+		return 0x02000000 // class=network, subclass=ethernet
+	case 0x10:
+		return f.bar0
+	case pciRegInterruptLine:
+		return uint32(f.interruptLine)
+	default:
+		return 0
+	}
+}
+
+// ConfigWrite implements PCIFunction. BAR0, the command register, and the
+// interrupt line register are writable; guests use BAR0 to relocate the
+// device's I/O ports, the command register to enable I/O space, and the
+// interrupt line register to tell the device which IRQ it's been routed
+// to.
+func (f *NE2000PCIFunction) ConfigWrite(off uint8, size int, val uint32) {
+	switch off {
+	case 0x04:
+		f.command = uint16(val)
+		f.dev.SetIOEnabled(f.command&pciCommandIOSpace != 0)
+	case 0x10:
+		oldPorts := f.dev.Ports()
+		f.bar0 = (val &^ 0x3) | 0x1
+		f.dev.setBase(uint16(f.bar0 &^ 0x3))
+		// A guest that relocates BAR0 onto a range another device
+		// already owns gets what real hardware would too: an
+		// unresolvable resource conflict. There's nowhere in this
+		// interface to report that, so it's left for the guest (or the
+		// firmware that assigned the overlapping ranges) to sort out.
+		_ = f.bus.Reregister(f.dev, oldPorts)
+	case pciRegInterruptLine:
+		f.interruptLine = uint8(val)
+		f.dev.SetIrqRaiser(f.irqRaiser, int(f.interruptLine))
+	}
+}
@@ -0,0 +1,125 @@
+package vmm
+
+import "testing"
+
+func TestNE2000PCIFunctionReportsRTL8029Identity(t *testing.T) {
+	dev := newTestNE2000(nil)
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	fn := NewNE2000PCIFunction(dev, bus, nil)
+
+	pciBus := NewPCIBus()
+	pciBus.RegisterFunction(0, 4, 0, fn)
+
+	addrBuf := make([]byte, 4)
+	encodeLE(addrBuf, pciConfigAddr(0, 4, 0, 0x00))
+	if err := pciBus.HandleIO(pciPortConfigAddress, addrBuf, true); err != nil {
+		t.Fatalf("write address: %v", err)
+	}
+	data := make([]byte, 4)
+	if err := pciBus.HandleIO(pciPortConfigData, data, false); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	want := uint32(rtl8029DeviceID)<<16 | rtl8029VendorID
+	if got := decodeLE(data); got != want {
+		t.Errorf("vendor/device = %#x, want %#x", got, want)
+	}
+}
+
+// TestNE2000PCIFunctionBAR0RelocatesDeviceOnIOBus checks that writing
+// BAR0 doesn't just update dev.base: the old ports actually stop
+// answering on the IOBus, and the new ports actually take over, once
+// I/O space is enabled via the command register.
+func TestNE2000PCIFunctionBAR0RelocatesDeviceOnIOBus(t *testing.T) {
+	dev := newTestNE2000(nil)
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	fn := NewNE2000PCIFunction(dev, bus, nil)
+	fn.ConfigWrite(0x04, 2, pciCommandIOSpace)
+
+	oldBase := dev.base
+	if err := bus.Dispatch(oldBase+ne2000RegCR, []byte{0}, false); err != nil {
+		t.Fatalf("Dispatch at old base before relocation: %v", err)
+	}
+
+	fn.ConfigWrite(0x10, 4, 0x0340)
+	if dev.base != 0x340 {
+		t.Fatalf("dev.base = %#x, want 0x340", dev.base)
+	}
+
+	if err := bus.Dispatch(oldBase+ne2000RegCR, []byte{0}, false); err == nil {
+		t.Errorf("Dispatch at old base %#x after relocation: got nil error, want ErrUnhandledPort", oldBase)
+	}
+	if err := bus.Dispatch(dev.base+ne2000RegCR, []byte{0}, false); err != nil {
+		t.Errorf("Dispatch at new base %#x after relocation: %v", dev.base, err)
+	}
+}
+
+// TestNE2000PCIFunctionCommandRegisterGatesIOSpace checks that clearing
+// the command register's I/O Space Enable bit makes the device's ports
+// stop responding without unregistering them, and that a read yields the
+// floating-bus value (0xff).
+func TestNE2000PCIFunctionCommandRegisterGatesIOSpace(t *testing.T) {
+	dev := newTestNE2000(nil)
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	fn := NewNE2000PCIFunction(dev, bus, nil)
+
+	data := []byte{0xaa}
+	if err := bus.Dispatch(dev.base+ne2000RegCR, data, false); err != nil {
+		t.Fatalf("Dispatch with I/O space disabled: %v", err)
+	}
+	if data[0] != 0xff {
+		t.Errorf("read with I/O space disabled = %#x, want 0xff (floating bus)", data[0])
+	}
+
+	fn.ConfigWrite(0x04, 2, pciCommandIOSpace)
+	data[0] = 0xaa
+	if err := bus.Dispatch(dev.base+ne2000RegCR, data, false); err != nil {
+		t.Fatalf("Dispatch with I/O space enabled: %v", err)
+	}
+	if data[0] == 0xff {
+		t.Errorf("read with I/O space enabled still returned the floating-bus value")
+	}
+}
+
+// TestNE2000PCIFunctionInterruptLineRoutesToPIC checks that writing the
+// Interrupt Line register wires the device to raise that IRQ on the
+// configured PIC.
+func TestNE2000PCIFunctionInterruptLineRoutesToPIC(t *testing.T) {
+	dev := newTestNE2000(nil)
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	pic := NewMasterPIC()
+	fn := NewNE2000PCIFunction(dev, bus, pic)
+	fn.ConfigWrite(0x04, 2, pciCommandIOSpace)
+	fn.ConfigWrite(pciRegInterruptLine, 1, 5)
+
+	if got := fn.ConfigRead(pciRegInterruptLine, 1); got != 5 {
+		t.Errorf("Interrupt Line readback = %d, want 5", got)
+	}
+
+	// Unmask IRQ5 so the PIC actually latches it (see TestPICStateRoundTrips).
+	if err := pic.HandleIO(picMasterDataPort, []byte{^uint8(1 << 5)}, true); err != nil {
+		t.Fatalf("unmask IRQ5: %v", err)
+	}
+	// Enable ISR_PTX (unmoderated, unlike ISR_PRX) then complete a
+	// transmit to set it, raising the device's now-routed IRQ line.
+	if err := bus.Dispatch(dev.base+ne2000RegIMR, []byte{isrPTX}, true); err != nil {
+		t.Fatalf("Dispatch IMR write: %v", err)
+	}
+	if err := bus.Dispatch(dev.base+ne2000RegCR, []byte{crTXP}, true); err != nil {
+		t.Fatalf("Dispatch CR write to complete a transmit: %v", err)
+	}
+	if _, ok := pic.GetInterruptVector(); !ok {
+		t.Error("PIC did not latch a vector after the device raised its routed IRQ")
+	}
+}
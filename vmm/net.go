@@ -0,0 +1,8 @@
+package vmm
+
+// HostNetInterface is the host-side transport an emulated NIC is bridged
+// to: a TAP device, a pcap writer, a loopback stub for tests, etc.
+type HostNetInterface interface {
+	// WritePacket sends one Ethernet frame to the host side.
+	WritePacket(pkt []byte) error
+}
@@ -0,0 +1,220 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// RTNETLINK message types, flags and attribute numbers used to
+// configure a link, taken from linux/rtnetlink.h and linux/if_link.h.
+// As with tap.go's TUNSETIFF, this package encodes these by hand rather
+// than pulling in golang.org/x/sys/unix.
+const (
+	rtmNewlink = 16
+	rtmNewaddr = 20
+
+	nlmFRequest = 0x01
+	nlmFCreate  = 0x400
+	nlmFReplace = 0x100
+	nlmFAck     = 0x04
+
+	nlmsgError = 0x02
+
+	iflaAddress = 1
+	iflaMTU     = 4
+
+	ifaLocal   = 2
+	ifaAddress = 1
+
+	rtScopeUniverse = 0
+
+	ifFlagUp = 0x1
+)
+
+// TapInterfaceConfig describes how ConfigureTapInterface should bring up
+// a TAP interface. Address and PrefixLen are required; MTU and
+// HardwareAddr are optional and left at their kernel defaults when zero
+// / nil.
+type TapInterfaceConfig struct {
+	Address      net.IP
+	PrefixLen    int
+	MTU          int
+	HardwareAddr net.HardwareAddr
+}
+
+// ConfigureTapInterface assigns cfg.Address/PrefixLen to the named
+// interface, applies cfg.MTU and cfg.HardwareAddr if set, and brings the
+// link up — entirely over an RTNETLINK socket, without spawning ip(8)
+// or any other subprocess.
+func ConfigureTapInterface(name string, cfg TapInterfaceConfig) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("vmm: look up interface %s: %w", name, err)
+	}
+
+	sock, err := newNetlinkRouteSocket()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(sock)
+
+	seq := uint32(1)
+
+	if cfg.MTU > 0 || len(cfg.HardwareAddr) > 0 {
+		if err := sendNetlinkRequest(sock, buildSetLinkAttrsMessage(seq, iface.Index, cfg.MTU, cfg.HardwareAddr)); err != nil {
+			return fmt.Errorf("vmm: set MTU/MAC on %s: %w", name, err)
+		}
+		seq++
+	}
+
+	if cfg.Address != nil {
+		msg, err := buildAddAddressMessage(seq, iface.Index, cfg.Address, cfg.PrefixLen)
+		if err != nil {
+			return err
+		}
+		if err := sendNetlinkRequest(sock, msg); err != nil {
+			return fmt.Errorf("vmm: assign address to %s: %w", name, err)
+		}
+		seq++
+	}
+
+	if err := sendNetlinkRequest(sock, buildSetLinkUpMessage(seq, iface.Index)); err != nil {
+		return fmt.Errorf("vmm: bring up %s: %w", name, err)
+	}
+	return nil
+}
+
+// newNetlinkRouteSocket opens and binds an AF_NETLINK/NETLINK_ROUTE
+// socket for issuing RTM_* requests.
+func newNetlinkRouteSocket() (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return -1, fmt.Errorf("vmm: open netlink socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("vmm: bind netlink socket: %w", err)
+	}
+	return fd, nil
+}
+
+// sendNetlinkRequest sends msg (built by one of the buildXxxMessage
+// helpers, which all set NLM_F_ACK) and waits for the kernel's ack,
+// returning a non-nil error if the request was rejected.
+func sendNetlinkRequest(fd int, msg []byte) error {
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("vmm: send netlink request: %w", err)
+	}
+
+	buf := make([]byte, 8192)
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return fmt.Errorf("vmm: receive netlink reply: %w", err)
+	}
+	return parseNetlinkAck(buf[:n])
+}
+
+// parseNetlinkAck reads the nlmsgerr the kernel sends back for a request
+// made with NLM_F_ACK: a zero error code means success, anything else is
+// the (negated) errno the kernel rejected the request with.
+func parseNetlinkAck(b []byte) error {
+	const nlMsgHdrLen = 16
+	if len(b) < nlMsgHdrLen {
+		return fmt.Errorf("vmm: netlink reply too short: %d bytes", len(b))
+	}
+	if msgType := binary.LittleEndian.Uint16(b[4:6]); msgType != nlmsgError {
+		return fmt.Errorf("vmm: unexpected netlink reply type %d", msgType)
+	}
+	if len(b) < nlMsgHdrLen+4 {
+		return fmt.Errorf("vmm: netlink error reply too short: %d bytes", len(b))
+	}
+	if errCode := int32(binary.LittleEndian.Uint32(b[nlMsgHdrLen : nlMsgHdrLen+4])); errCode != 0 {
+		return syscall.Errno(-errCode)
+	}
+	return nil
+}
+
+// wrapNlMsg prepends a struct nlmsghdr to payload.
+func wrapNlMsg(msgType uint16, flags uint16, seq uint32, payload []byte) []byte {
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(16+len(payload)))
+	binary.LittleEndian.PutUint16(hdr[4:6], msgType)
+	binary.LittleEndian.PutUint16(hdr[6:8], flags)
+	binary.LittleEndian.PutUint32(hdr[8:12], seq)
+	// Bytes 12:16 (pid) are left zero; the kernel doesn't require the
+	// sender to identify itself by anything other than the socket.
+	return append(hdr, payload...)
+}
+
+// nlmAlign rounds n up to NLMSG_ALIGNTO (4), the padding every rtattr
+// and nlmsghdr in a netlink message is aligned to.
+func nlmAlign(n int) int { return (n + 3) &^ 3 }
+
+// appendRtAttr appends one struct rtattr (type + length-prefixed value,
+// padded to a 4-byte boundary) to buf.
+func appendRtAttr(buf []byte, attrType uint16, value []byte) []byte {
+	attrLen := 4 + len(value)
+	hdr := make([]byte, 4)
+	binary.LittleEndian.PutUint16(hdr[0:2], uint16(attrLen))
+	binary.LittleEndian.PutUint16(hdr[2:4], attrType)
+	buf = append(buf, hdr...)
+	buf = append(buf, value...)
+	return append(buf, make([]byte, nlmAlign(attrLen)-attrLen)...)
+}
+
+// buildSetLinkAttrsMessage builds an RTM_NEWLINK request that sets MTU
+// and/or the hardware address on index, without touching its flags.
+// Changing the MAC of a link that's already up is typically rejected by
+// the kernel, so callers apply this before bringing the link up.
+func buildSetLinkAttrsMessage(seq uint32, index int, mtu int, mac net.HardwareAddr) []byte {
+	ifi := make([]byte, 16) // struct ifinfomsg: family, pad, type, index, flags, change all zero here
+	binary.LittleEndian.PutUint32(ifi[4:8], uint32(index))
+
+	var attrs []byte
+	if mtu > 0 {
+		val := make([]byte, 4)
+		binary.LittleEndian.PutUint32(val, uint32(mtu))
+		attrs = appendRtAttr(attrs, iflaMTU, val)
+	}
+	if len(mac) > 0 {
+		attrs = appendRtAttr(attrs, iflaAddress, mac)
+	}
+
+	return wrapNlMsg(rtmNewlink, nlmFRequest|nlmFAck, seq, append(ifi, attrs...))
+}
+
+// buildSetLinkUpMessage builds an RTM_NEWLINK request that sets IFF_UP
+// on index, leaving every other flag untouched.
+func buildSetLinkUpMessage(seq uint32, index int) []byte {
+	ifi := make([]byte, 16)
+	binary.LittleEndian.PutUint32(ifi[4:8], uint32(index))
+	binary.LittleEndian.PutUint32(ifi[8:12], ifFlagUp)  // flags
+	binary.LittleEndian.PutUint32(ifi[12:16], ifFlagUp) // change mask
+
+	return wrapNlMsg(rtmNewlink, nlmFRequest|nlmFAck, seq, ifi)
+}
+
+// buildAddAddressMessage builds an RTM_NEWADDR request assigning addr/
+// prefixLen to index. Only IPv4 is supported; IPv6 configuration would
+// need a distinct ifa_family and attribute set.
+func buildAddAddressMessage(seq uint32, index int, addr net.IP, prefixLen int) ([]byte, error) {
+	ip4 := addr.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("vmm: ConfigureTapInterface only supports IPv4 addresses, got %v", addr)
+	}
+
+	ifa := make([]byte, 8) // struct ifaddrmsg
+	ifa[0] = syscall.AF_INET
+	ifa[1] = uint8(prefixLen)
+	ifa[2] = 0 // flags
+	ifa[3] = rtScopeUniverse
+	binary.LittleEndian.PutUint32(ifa[4:8], uint32(index))
+
+	var attrs []byte
+	attrs = appendRtAttr(attrs, ifaLocal, ip4)
+	attrs = appendRtAttr(attrs, ifaAddress, ip4)
+
+	return wrapNlMsg(rtmNewaddr, nlmFRequest|nlmFAck|nlmFCreate|nlmFReplace, seq, append(ifa, attrs...)), nil
+}
@@ -0,0 +1,66 @@
+package vmm
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+// TestConfigureTapInterfaceSetsFlagsAndMTU exercises the real RTNETLINK
+// path end to end: it creates an actual TAP interface and configures it,
+// then reads its state back through the standard "net" package (which,
+// on Linux, is itself backed by netlink). It needs CAP_NET_ADMIN, so it
+// skips instead of failing when not run as root.
+func TestConfigureTapInterfaceSetsFlagsAndMTU(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to create and configure a TAP interface")
+	}
+
+	const ifaceName = "vdbtap-test0"
+	tap, err := NewTapDevice(ifaceName)
+	if err != nil {
+		t.Skipf("creating a TAP interface: %v", err)
+	}
+	defer tap.Close()
+
+	cfg := TapInterfaceConfig{
+		Address:   net.IPv4(192, 168, 200, 1),
+		PrefixLen: 24,
+		MTU:       1400,
+	}
+	if err := ConfigureTapInterface(ifaceName, cfg); err != nil {
+		t.Fatalf("ConfigureTapInterface: %v", err)
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		t.Fatalf("InterfaceByName: %v", err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		t.Errorf("interface flags = %v, want FlagUp set", iface.Flags)
+	}
+	if iface.MTU != cfg.MTU {
+		t.Errorf("MTU = %d, want %d", iface.MTU, cfg.MTU)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		t.Fatalf("Addrs: %v", err)
+	}
+	var found bool
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(cfg.Address) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("addresses = %v, want to include %v", addrs, cfg.Address)
+	}
+}
+
+func TestBuildAddAddressMessageRejectsIPv6(t *testing.T) {
+	_, err := buildAddAddressMessage(1, 1, net.ParseIP("::1"), 128)
+	if err == nil {
+		t.Fatal("buildAddAddressMessage: expected an error for an IPv6 address, got nil")
+	}
+}
@@ -0,0 +1,105 @@
+package vmm
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestNewNetworkInterfaceNoneReturnsNilWithoutError checks that the
+// default, zero-value NetworkConfig disables networking entirely rather
+// than requiring a TAP device.
+func TestNewNetworkInterfaceNoneReturnsNilWithoutError(t *testing.T) {
+	iface, err := NewNetworkInterface(NetworkConfig{})
+	if err != nil {
+		t.Fatalf("NewNetworkInterface: %v", err)
+	}
+	if iface != nil {
+		t.Errorf("iface = %v, want nil for NetworkBackendNone", iface)
+	}
+}
+
+// TestNewNetworkInterfaceCustomReturnsSuppliedInterface checks that
+// NetworkBackendCustom is a pure pass-through, for embedding a
+// network.LoopbackNet or similar test double.
+func TestNewNetworkInterfaceCustomReturnsSuppliedInterface(t *testing.T) {
+	stub := &stubHostNetInterface{}
+	iface, err := NewNetworkInterface(NetworkConfig{Backend: NetworkBackendCustom, Interface: stub})
+	if err != nil {
+		t.Fatalf("NewNetworkInterface: %v", err)
+	}
+	if iface != stub {
+		t.Errorf("iface = %v, want the supplied stub", iface)
+	}
+}
+
+// TestNewNetworkInterfaceTapPropagatesOpenFailure checks that a TAP that
+// fails to open (e.g. no /dev/net/tun, no CAP_NET_ADMIN) is reported as
+// an error rather than silently degrading, since the caller explicitly
+// asked for NetworkBackendTap.
+func TestNewNetworkInterfaceTapPropagatesOpenFailure(t *testing.T) {
+	orig := newTapDeviceFn
+	wantErr := errors.New("open /dev/net/tun: permission denied")
+	newTapDeviceFn = func(string) (*TapDevice, error) { return nil, wantErr }
+	defer func() { newTapDeviceFn = orig }()
+
+	iface, err := NewNetworkInterface(NetworkConfig{Backend: NetworkBackendTap, TapName: "tap0"})
+	if iface != nil {
+		t.Errorf("iface = %v, want nil on TAP open failure", iface)
+	}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("err = %v, want it to wrap %v", err, wantErr)
+	}
+}
+
+// TestVirtualMachineCloseDegradesToNoNetworkingWithoutFailing checks that
+// a VM built with NetworkBackendNone (no SetNetworkInterface call at
+// all) closes cleanly, and that one built by explicitly wiring a nil
+// interface behaves the same way.
+func TestVirtualMachineCloseDegradesToNoNetworkingWithoutFailing(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	if err := vm.Close(); err != nil {
+		t.Errorf("Close() with no networking configured = %v, want nil", err)
+	}
+
+	vm2 := NewVirtualMachine(nil, nil)
+	vm2.SetNetworkInterface(nil)
+	if err := vm2.Close(); err != nil {
+		t.Errorf("Close() after SetNetworkInterface(nil) = %v, want nil", err)
+	}
+}
+
+// TestVirtualMachineCloseClosesTapNetworkInterface checks that Close
+// closes a networking transport that implements io.Closer, e.g. a
+// TapDevice, once it's been wired in with SetNetworkInterface.
+func TestVirtualMachineCloseClosesTapNetworkInterface(t *testing.T) {
+	tap, _ := newSocketpairTap(t)
+
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetNetworkInterface(tap)
+
+	if err := vm.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	if err := tap.WritePacket([]byte{0}); err == nil {
+		t.Error("WritePacket after Close() succeeded, want the tap's fd to already be closed")
+	}
+}
+
+// TestVirtualMachineCloseHandlesCustomInterfaceWithoutCloser checks that
+// a HostNetInterface not implementing io.Closer (e.g. a
+// network.LoopbackNet used as NetworkBackendCustom) is simply left
+// alone, rather than Close failing or panicking on the type assertion.
+func TestVirtualMachineCloseHandlesCustomInterfaceWithoutCloser(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	vm.SetNetworkInterface(&stubHostNetInterface{})
+
+	if err := vm.Close(); err != nil {
+		t.Errorf("Close() with a non-Closer interface = %v, want nil", err)
+	}
+}
+
+// stubHostNetInterface is a minimal HostNetInterface with no Close
+// method, used to exercise NetworkBackendCustom.
+type stubHostNetInterface struct{}
+
+func (*stubHostNetInterface) WritePacket([]byte) error { return nil }
@@ -0,0 +1,152 @@
+package vmm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Parallel port register offsets relative to the port's base address
+// (LPT1 conventionally 0x378).
+const (
+	lptRegData    = 0
+	lptRegStatus  = 1
+	lptRegControl = 2
+)
+
+// Status register bits (base+1), read-only from the guest's perspective.
+const (
+	lptStatusAck     = 1 << 6 // set once a strobed byte has been delivered, until the next strobe
+	lptStatusNotBusy = 1 << 7 // this model never stays busy across HandleIO calls, so it's always set
+)
+
+// Control register bits (base+2).
+const (
+	lptControlStrobe    = 1 << 0 // a 0->1 transition latches Data and delivers it to out
+	lptControlIRQEnable = 1 << 4
+)
+
+const (
+	lpt1Base uint16 = 0x378
+	lpt1Irq  int    = 7
+)
+
+// ParallelPortDevice emulates a standard (SPP-mode) parallel port: a
+// guest writes a byte to the data register, then pulses the control
+// register's strobe bit to deliver it, the way a real Centronics printer
+// interface expects a byte presented on the data lines followed by a
+// strobe pulse. There's no electrical latency to model, so a strobe is
+// delivered to out synchronously and its acknowledge status bit is
+// visible on the very next status read.
+type ParallelPortDevice struct {
+	mu sync.Mutex
+
+	base uint16
+	out  io.Writer
+
+	data    uint8
+	control uint8
+	acked   bool
+
+	raiser IrqRaiser
+	irq    int
+}
+
+// NewParallelPortDevice returns an LPT1 (0x378) parallel port. Bytes
+// strobed through are written to out; out may be nil to discard them.
+func NewParallelPortDevice(out io.Writer) *ParallelPortDevice {
+	return NewParallelPortDeviceAt(lpt1Base, out)
+}
+
+// NewParallelPortDeviceAt is NewParallelPortDevice for a port at a base
+// other than LPT1's 0x378.
+func NewParallelPortDeviceAt(base uint16, out io.Writer) *ParallelPortDevice {
+	return &ParallelPortDevice{base: base, out: out}
+}
+
+// SetIrqRaiser wires this port to raiser's irq line: completing a strobed
+// byte while the control register's IRQ-enable bit is set calls
+// RaiseIRQ(irq) once. This model treats the interrupt as edge-triggered
+// and self-acknowledging (matching a printer's brief ACK pulse), so it
+// never calls LowerIRQ.
+func (p *ParallelPortDevice) SetIrqRaiser(raiser IrqRaiser, irq int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.raiser = raiser
+	p.irq = irq
+}
+
+// Ports implements PioDevice.
+func (p *ParallelPortDevice) Ports() []uint16 {
+	return []uint16{p.base + lptRegData, p.base + lptRegStatus, p.base + lptRegControl}
+}
+
+// Reset implements PioDevice, returning the port to its power-on state.
+func (p *ParallelPortDevice) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.data, p.control, p.acked = 0, 0, false
+}
+
+// Name implements PioDevice.
+func (p *ParallelPortDevice) Name() string { return "parallel" }
+
+// HandleIO implements PioDevice.
+func (p *ParallelPortDevice) HandleIO(port uint16, data []byte, write bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	switch port - p.base {
+	case lptRegData:
+		if write {
+			p.data = data[0]
+		} else {
+			data[0] = p.data
+		}
+	case lptRegStatus:
+		if !write {
+			data[0] = p.statusValueLocked()
+		}
+	case lptRegControl:
+		if write {
+			strobing := data[0]&lptControlStrobe != 0 && p.control&lptControlStrobe == 0
+			p.control = data[0]
+			if strobing {
+				if err := p.strobeLocked(); err != nil {
+					return err
+				}
+			}
+		} else {
+			data[0] = p.control
+		}
+	}
+	return nil
+}
+
+// statusValueLocked computes the current status register value.
+func (p *ParallelPortDevice) statusValueLocked() uint8 {
+	v := uint8(lptStatusNotBusy)
+	if p.acked {
+		v |= lptStatusAck
+	}
+	return v
+}
+
+// strobeLocked delivers p.data to out, sets the ack status bit, and
+// raises this port's IRQ if enabled.
+func (p *ParallelPortDevice) strobeLocked() error {
+	if p.out != nil {
+		if _, err := p.out.Write([]byte{p.data}); err != nil {
+			return fmt.Errorf("vmm: parallel: writing strobed byte: %w", err)
+		}
+	}
+	p.acked = true
+	if p.raiser != nil && p.control&lptControlIRQEnable != 0 {
+		p.raiser.RaiseIRQ(p.irq)
+	}
+	return nil
+}
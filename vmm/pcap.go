@@ -0,0 +1,77 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+// pcap file format constants (see the classic libpcap file format: a
+// 24-byte global header followed by a 16-byte record header per packet).
+const (
+	pcapMagicNumber      = 0xa1b2c3d4
+	pcapVersionMajor     = 2
+	pcapVersionMinor     = 4
+	pcapSnapLen          = 65535
+	pcapLinkTypeEthernet = 1
+)
+
+// PcapWriter writes captured Ethernet frames to a libpcap-format file,
+// readable by Wireshark and any other pcap-compatible tool.
+type PcapWriter struct {
+	f *os.File
+}
+
+// NewPcapWriter creates (truncating if it already exists) the file at
+// path and writes the pcap global header for an Ethernet capture.
+func NewPcapWriter(path string) (*PcapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("vmm: creating pcap file: %w", err)
+	}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicNumber)
+	binary.LittleEndian.PutUint16(header[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(header[6:8], pcapVersionMinor)
+	// Bytes 8:16 (thiszone, sigfigs) are conventionally left zero.
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeEthernet)
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("vmm: writing pcap header: %w", err)
+	}
+
+	return &PcapWriter{f: f}, nil
+}
+
+// WriteFrame appends one packet record captured at ts.
+func (p *PcapWriter) WriteFrame(ts time.Time, frame []byte) error {
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(len(frame)))
+	if _, err := p.f.Write(rec); err != nil {
+		return fmt.Errorf("vmm: writing pcap record header: %w", err)
+	}
+	if _, err := p.f.Write(frame); err != nil {
+		return fmt.Errorf("vmm: writing pcap frame: %w", err)
+	}
+	return nil
+}
+
+// FrameMonitor adapts p to the func(dir string, frame []byte) signature
+// expected by NE2000Device.SetFrameMonitor, capturing every transmitted
+// and received frame with timestamps from clock.
+func (p *PcapWriter) FrameMonitor(clock Clock) func(dir string, frame []byte) {
+	return func(dir string, frame []byte) {
+		_ = p.WriteFrame(clock.Now(), frame)
+	}
+}
+
+// Close closes the underlying file.
+func (p *PcapWriter) Close() error {
+	return p.f.Close()
+}
@@ -0,0 +1,175 @@
+package vmm
+
+import "sync"
+
+// PCI configuration mechanism #1 ports.
+const (
+	pciPortConfigAddress = 0xcf8
+	pciPortConfigData    = 0xcfc
+)
+
+// configAddressEnable is bit 31 of CONFIG_ADDRESS, required to be set for
+// an access to be routed to PCI config space at all.
+const configAddressEnable = 1 << 31
+
+// pciCommandIOSpace is bit 0 of the standard PCI Command register
+// (config-space offset 0x04): a function's I/O BARs only respond to
+// accesses while it's set. It powers on clear, so a function's ports
+// must not answer until firmware has assigned them a BAR and explicitly
+// enabled I/O space.
+const pciCommandIOSpace = 1 << 0
+
+// pciRegInterruptLine is the standard PCI Interrupt Line register
+// (config-space offset 0x3c): firmware writes the IRQ it has routed this
+// function's interrupt pin to, so the device knows which line to raise.
+const pciRegInterruptLine = 0x3c
+
+// PCIFunction is a single PCI function that answers configuration-space
+// reads/writes for one bus/device/function address.
+type PCIFunction interface {
+	// ConfigRead reads size bytes (1, 2 or 4) at config-space offset off.
+	ConfigRead(off uint8, size int) uint32
+	// ConfigWrite writes size bytes of val at config-space offset off.
+	ConfigWrite(off uint8, size int, val uint32)
+}
+
+// pciAddress identifies one function's location on the bus, matching the
+// bus/device/function fields of CONFIG_ADDRESS.
+type pciAddress struct {
+	bus, device, function uint8
+}
+
+// PCIBus emulates configuration mechanism #1: the guest writes a
+// bus/device/function/offset to CONFIG_ADDRESS (0xcf8), then reads or
+// writes CONFIG_DATA (0xcfc) to access that function's configuration
+// space. It also acts as its own host bridge, answering as device 0
+// function 0 when nothing else is registered there.
+type PCIBus struct {
+	mu sync.Mutex
+
+	configAddress uint32
+	functions     map[pciAddress]PCIFunction
+	hostBridge    PCIFunction
+}
+
+// NewPCIBus returns an empty bus with a minimal host bridge already
+// occupying bus 0, device 0, function 0.
+func NewPCIBus() *PCIBus {
+	b := &PCIBus{
+		functions:  make(map[pciAddress]PCIFunction),
+		hostBridge: NewHostBridge(),
+	}
+	b.functions[pciAddress{}] = b.hostBridge
+	return b
+}
+
+// RegisterFunction attaches fn at the given bus/device/function address.
+func (b *PCIBus) RegisterFunction(bus, device, function uint8, fn PCIFunction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.functions[pciAddress{bus, device, function}] = fn
+}
+
+// Ports implements PioDevice.
+func (b *PCIBus) Ports() []uint16 {
+	return []uint16{pciPortConfigAddress, pciPortConfigData}
+}
+
+// HandleIO implements PioDevice.
+func (b *PCIBus) HandleIO(port uint16, data []byte, write bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch port {
+	case pciPortConfigAddress:
+		if write {
+			b.configAddress = decodeLE(data)
+		} else {
+			encodeLE(data, b.configAddress)
+		}
+	case pciPortConfigData:
+		b.handleConfigData(data, write)
+	}
+	return nil
+}
+
+func (b *PCIBus) handleConfigData(data []byte, write bool) {
+	if b.configAddress&configAddressEnable == 0 {
+		if !write {
+			encodeLE(data, 0xffffffff)
+		}
+		return
+	}
+
+	addr := pciAddress{
+		bus:      uint8(b.configAddress >> 16),
+		device:   uint8(b.configAddress>>11) & 0x1f,
+		function: uint8(b.configAddress>>8) & 0x07,
+	}
+	off := uint8(b.configAddress) &^ 0x03 // low 2 bits select the byte within CONFIG_DATA, not the config offset
+
+	fn, ok := b.functions[addr]
+	if !ok {
+		if !write {
+			encodeLE(data, 0xffffffff)
+		}
+		return
+	}
+
+	if write {
+		fn.ConfigWrite(off, len(data), decodeLE(data))
+	} else {
+		encodeLE(data, fn.ConfigRead(off, len(data)))
+	}
+}
+
+func decodeLE(data []byte) uint32 {
+	var v uint32
+	for i, b := range data {
+		v |= uint32(b) << (8 * i)
+	}
+	return v
+}
+
+func encodeLE(data []byte, v uint32) {
+	for i := range data {
+		data[i] = uint8(v >> (8 * i))
+	}
+}
+
+// Reset implements PioDevice.
+func (b *PCIBus) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.configAddress = 0
+}
+
+// Name implements PioDevice.
+func (b *PCIBus) Name() string { return "pci" }
+
+// HostBridge is a minimal PCI-to-host bridge function (class 0x0600,
+// vendor/device IDs chosen to not collide with real hardware).
+type HostBridge struct {
+	vendorID, deviceID uint16
+}
+
+// NewHostBridge returns a host bridge function for device 0/function 0.
+func NewHostBridge() *HostBridge {
+	return &HostBridge{vendorID: 0xfffe, deviceID: 0x0001}
+}
+
+// ConfigRead implements PCIFunction.
+func (h *HostBridge) ConfigRead(off uint8, size int) uint32 {
+	switch off {
+	case 0x00:
+		return uint32(h.deviceID)<<16 | uint32(h.vendorID)
+	case 0x08:
+		return 0x06000000 // class=bridge, subclass=host bridge
+	default:
+		return 0
+	}
+}
+
+// ConfigWrite implements PCIFunction. The host bridge's identity and
+// class registers are read-only.
+func (h *HostBridge) ConfigWrite(off uint8, size int, val uint32) {}
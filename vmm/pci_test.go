@@ -0,0 +1,74 @@
+package vmm
+
+import "testing"
+
+func pciConfigAddr(bus, device, function, offset uint8) uint32 {
+	return configAddressEnable | uint32(bus)<<16 | uint32(device)<<11 | uint32(function)<<8 | uint32(offset)
+}
+
+func TestPCIBusReadsHostBridgeVendorDevice(t *testing.T) {
+	bus := NewPCIBus()
+
+	addrBuf := make([]byte, 4)
+	encodeLE(addrBuf, pciConfigAddr(0, 0, 0, 0x00))
+	if err := bus.HandleIO(pciPortConfigAddress, addrBuf, true); err != nil {
+		t.Fatalf("write address: %v", err)
+	}
+
+	data := make([]byte, 4)
+	if err := bus.HandleIO(pciPortConfigData, data, false); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	got := decodeLE(data)
+	want := uint32(0x0001)<<16 | 0xfffe
+	if got != want {
+		t.Errorf("vendor/device = %#x, want %#x", got, want)
+	}
+}
+
+type fakePCIFunction struct{ reads, writes int }
+
+func (f *fakePCIFunction) ConfigRead(off uint8, size int) uint32 {
+	f.reads++
+	return 0xdeadbeef
+}
+func (f *fakePCIFunction) ConfigWrite(off uint8, size int, val uint32) { f.writes++ }
+
+func TestPCIBusRoutesToRegisteredFunction(t *testing.T) {
+	bus := NewPCIBus()
+	fn := &fakePCIFunction{}
+	bus.RegisterFunction(0, 3, 0, fn)
+
+	addrBuf := make([]byte, 4)
+	encodeLE(addrBuf, pciConfigAddr(0, 3, 0, 0x00))
+	if err := bus.HandleIO(pciPortConfigAddress, addrBuf, true); err != nil {
+		t.Fatalf("write address: %v", err)
+	}
+
+	data := make([]byte, 4)
+	if err := bus.HandleIO(pciPortConfigData, data, false); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	if decodeLE(data) != 0xdeadbeef {
+		t.Errorf("got %#x, want 0xdeadbeef", decodeLE(data))
+	}
+	if fn.reads != 1 {
+		t.Errorf("reads = %d, want 1", fn.reads)
+	}
+}
+
+func TestPCIBusUnmappedFunctionReturnsAllOnes(t *testing.T) {
+	bus := NewPCIBus()
+	addrBuf := make([]byte, 4)
+	encodeLE(addrBuf, pciConfigAddr(0, 5, 0, 0x00))
+	if err := bus.HandleIO(pciPortConfigAddress, addrBuf, true); err != nil {
+		t.Fatalf("write address: %v", err)
+	}
+	data := make([]byte, 4)
+	if err := bus.HandleIO(pciPortConfigData, data, false); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	if decodeLE(data) != 0xffffffff {
+		t.Errorf("got %#x, want 0xffffffff", decodeLE(data))
+	}
+}
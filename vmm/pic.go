@@ -0,0 +1,419 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Standard PC command/data port pairs for the master and slave 8259A
+// chips, cascaded on the master's IRQ2.
+const (
+	picMasterCommandPort = 0x20
+	picMasterDataPort    = 0x21
+	picSlaveCommandPort  = 0xa0
+	picSlaveDataPort     = 0xa1
+)
+
+// ICW1 bits, written to the command port to start (re-)initialization.
+const (
+	icw1Init   = 1 << 4 // always set; distinguishes ICW1 from an OCW2/OCW3
+	icw1Single = 1 << 1 // no cascaded slave; skip ICW3
+	icw1ICW4   = 1 << 0 // ICW4 will follow ICW3 (or ICW2 if Single)
+)
+
+// OCW2 bits, written to the command port outside initialization.
+const (
+	ocw2EOI = 1 << 5 // non-specific EOI: clear the highest-priority set ISR bit
+)
+
+// OCW3 bits, written to the command port outside initialization,
+// identified by bit 3 set and bit 4 clear (so as not to be mistaken for
+// an OCW2, which has neither, or an ICW1, which has bit 4 set).
+const (
+	ocw3Select  = 1 << 3 // marks this write as an OCW3 rather than OCW2
+	ocw3ReadISR = 1 << 0 // next command-port read returns ISR instead of IRR
+	ocw3ReadReg = 1 << 1 // this OCW3 changes the read-register selection
+)
+
+// picInitStep tracks where a chip is in its ICWx initialization sequence;
+// picInitStepDone means the chip is in normal operation and command/data
+// port writes are OCW2/OCW3 and OCW1 respectively.
+type picInitStep int
+
+const (
+	picInitStepDone picInitStep = iota
+	picInitStepICW2
+	picInitStepICW3
+	picInitStepICW4
+)
+
+// PICDevice emulates one 8259A Programmable Interrupt Controller chip: 8
+// level-triggered IRQ lines (0-7, offset by whatever ICW2 programs as the
+// vector base), priority resolution favoring lower IRQ numbers, and
+// non-specific EOI. A full PC has two of these cascaded together (the
+// slave's INT output wired to the master's IRQ2); this type only models
+// a single chip; NewMasterPIC/NewSlavePIC just preset the conventional
+// port addresses.
+//
+// RaiseIRQ/LowerIRQ are reference-counted per line, so more than one
+// device can share a level-triggered IRQ: the line stays asserted until
+// every raiser has lowered it, matching how a real shared level-triggered
+// line works (each device holds it open with its own driver until it has
+// nothing left to report).
+type PICDevice struct {
+	mu sync.Mutex
+
+	base uint16
+	name string
+
+	irr, isr, imr uint8
+	vectorOffset  uint8
+	refCount      [8]int
+
+	// haveLatch/latchedIRQ record the highest-priority line that was
+	// pending the moment nothing else already was, i.e. the request that
+	// caused this chip's INT output to first go high. GetInterruptVector
+	// acknowledges specifically that line, so it can tell a spurious
+	// acknowledge (the line dropped again before the guest read the
+	// vector) from a real one.
+	haveLatch  bool
+	latchedIRQ uint8
+
+	initStep   picInitStep
+	needICW4   bool
+	singleChip bool
+	autoEOI    bool
+	readISR    bool // OCW3 read-register selection: false = IRR, true = ISR
+}
+
+// NewPICDevice returns an 8259A emulation listening on the command/data
+// port pair at base and base+1 (0x20/0x21 for the master chip, 0xa0/0xa1
+// for the slave), identifying itself as name in logs and error messages.
+func NewPICDevice(base uint16, name string) *PICDevice {
+	p := &PICDevice{base: base, name: name}
+	p.Reset()
+	return p
+}
+
+// NewMasterPIC returns a PICDevice at the PC's conventional master ports.
+func NewMasterPIC() *PICDevice {
+	return NewPICDevice(picMasterCommandPort, "pic-master")
+}
+
+// NewSlavePIC returns a PICDevice at the PC's conventional slave ports.
+// Its IRQ lines are numbered 0-7 within the chip; a caller wiring up the
+// cascade addresses them as IRQ8-15 by adding 8 before calling
+// RaiseIRQ/LowerIRQ on this chip.
+func NewSlavePIC() *PICDevice {
+	return NewPICDevice(picSlaveCommandPort, "pic-slave")
+}
+
+// Ports implements PioDevice.
+func (p *PICDevice) Ports() []uint16 {
+	return []uint16{p.base, p.base + 1}
+}
+
+// Name implements PioDevice.
+func (p *PICDevice) Name() string { return p.name }
+
+// Reset implements PioDevice: it returns the chip to its uninitialized
+// power-on state (all lines masked, no vector offset programmed) exactly
+// as real hardware does, requiring the guest to run the ICW1-ICW4
+// sequence again before the chip does anything useful.
+func (p *PICDevice) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.irr, p.isr = 0, 0
+	p.imr = 0xff
+	p.vectorOffset = 0
+	p.refCount = [8]int{}
+	p.haveLatch = false
+	p.initStep = picInitStepDone
+	p.needICW4 = false
+	p.singleChip = false
+	p.autoEOI = false
+	p.readISR = false
+}
+
+// HandleIO implements PioDevice.
+func (p *PICDevice) HandleIO(port uint16, data []byte, write bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch port {
+	case p.base:
+		if write {
+			p.writeCommandLocked(data)
+		} else if len(data) > 0 {
+			data[0] = p.readCommandLocked()
+		}
+	case p.base + 1:
+		if write {
+			p.writeDataLocked(data)
+		} else if len(data) > 0 {
+			data[0] = p.imr
+		}
+	}
+	return nil
+}
+
+// writeCommandLocked applies a command-port write: ICW1 if bit 4 is set,
+// otherwise OCW3 if bit 3 is set, otherwise OCW2.
+func (p *PICDevice) writeCommandLocked(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	v := data[0]
+
+	switch {
+	case v&icw1Init != 0:
+		p.irr, p.isr = 0, 0
+		p.imr = 0xff
+		p.haveLatch = false
+		p.singleChip = v&icw1Single != 0
+		p.needICW4 = v&icw1ICW4 != 0
+		p.initStep = picInitStepICW2
+	case v&ocw3Select != 0:
+		if v&ocw3ReadReg != 0 {
+			p.readISR = v&ocw3ReadISR != 0
+		}
+	default: // OCW2
+		if v&ocw2EOI != 0 {
+			p.eoiLocked()
+		}
+	}
+}
+
+// writeDataLocked applies a data-port write: the next byte of the ICW
+// sequence if one is in progress, otherwise OCW1 (the mask register).
+func (p *PICDevice) writeDataLocked(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	v := data[0]
+
+	switch p.initStep {
+	case picInitStepICW2:
+		p.vectorOffset = v
+		if !p.singleChip {
+			p.initStep = picInitStepICW3
+		} else if p.needICW4 {
+			p.initStep = picInitStepICW4
+		} else {
+			p.initStep = picInitStepDone
+		}
+	case picInitStepICW3:
+		// Cascade wiring (which slave lines feed which master IRQ); this
+		// emulation doesn't need it to resolve priority or vectors.
+		if p.needICW4 {
+			p.initStep = picInitStepICW4
+		} else {
+			p.initStep = picInitStepDone
+		}
+	case picInitStepICW4:
+		p.autoEOI = v&(1<<1) != 0
+		p.initStep = picInitStepDone
+	default:
+		p.imr = v
+		p.recomputeLatchLocked()
+	}
+}
+
+// readCommandLocked returns IRR or ISR depending on the last OCW3
+// read-register selection.
+func (p *PICDevice) readCommandLocked() uint8 {
+	if p.readISR {
+		return p.isr
+	}
+	return p.irr
+}
+
+// eoiLocked clears the highest-priority (lowest-numbered) set ISR bit, as
+// a non-specific EOI does.
+func (p *PICDevice) eoiLocked() {
+	for irq := 0; irq < 8; irq++ {
+		if p.isr&(1<<uint(irq)) != 0 {
+			p.isr &^= 1 << uint(irq)
+			break
+		}
+	}
+	p.recomputeLatchLocked()
+}
+
+// recomputeLatchLocked latches the highest-priority requested, unmasked,
+// not-already-in-service line, if nothing is currently latched. Once
+// latched, a line stays latched (even if it's lowered before being
+// acknowledged) until GetInterruptVector consumes it, which is exactly
+// what lets a disappearing request be told apart from one that was never
+// there.
+func (p *PICDevice) recomputeLatchLocked() {
+	if p.haveLatch {
+		return
+	}
+	pending := p.irr &^ p.imr &^ p.isr
+	if pending == 0 {
+		return
+	}
+	for irq := 0; irq < 8; irq++ {
+		if pending&(1<<uint(irq)) != 0 {
+			p.latchedIRQ = uint8(irq)
+			p.haveLatch = true
+			return
+		}
+	}
+}
+
+// RaiseIRQ asserts irq (0-7), the way a device wired to this chip signals
+// a level-triggered interrupt request. Reference-counted: if another
+// device already has irq raised, this call just adds to the count, and
+// the line stays asserted until every raiser calls LowerIRQ. Out-of-range
+// irq values are ignored.
+func (p *PICDevice) RaiseIRQ(irq int) {
+	if irq < 0 || irq > 7 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.refCount[irq] == 0 {
+		p.irr |= 1 << uint(irq)
+	}
+	p.refCount[irq]++
+	p.recomputeLatchLocked()
+}
+
+// LowerIRQ deasserts one raiser's hold on irq (0-7); the line itself only
+// drops once every RaiseIRQ call has a matching LowerIRQ. Calling LowerIRQ
+// without a matching outstanding RaiseIRQ, or with an out-of-range irq,
+// is ignored.
+func (p *PICDevice) LowerIRQ(irq int) {
+	if irq < 0 || irq > 7 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.refCount[irq] == 0 {
+		return
+	}
+	p.refCount[irq]--
+	if p.refCount[irq] == 0 {
+		p.irr &^= 1 << uint(irq)
+	}
+}
+
+// HasPendingInterrupt reports whether this chip has a line latched for
+// acknowledgment, i.e. whether its INT output is currently asserted.
+func (p *PICDevice) HasPendingInterrupt() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.haveLatch
+}
+
+// GetInterruptVector performs the acknowledge cycle for the currently
+// latched line (see recomputeLatchLocked) and returns its interrupt
+// vector, marking it in-service in ISR. IRR itself is left alone: for a
+// level-triggered line it simply mirrors whether a raiser still holds it
+// asserted (see RaiseIRQ/LowerIRQ), and ISR is what keeps an in-service
+// line from being latched again until EOI'd. ok is false if nothing is
+// latched.
+//
+// If the latched line was lowered (every raiser called LowerIRQ) between
+// being latched and this call, there is nothing left to service:
+// GetInterruptVector returns this chip's spurious interrupt vector
+// (vector offset + 7, the classic IRQ7/IRQ15 spurious vector) without
+// touching ISR, since a spurious vector must not be EOI'd.
+func (p *PICDevice) GetInterruptVector() (vector uint8, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.haveLatch {
+		return 0, false
+	}
+	irq := p.latchedIRQ
+	p.haveLatch = false
+
+	if p.irr&(1<<irq) == 0 {
+		p.recomputeLatchLocked()
+		return p.vectorOffset + 7, true
+	}
+
+	p.isr |= 1 << irq
+	p.recomputeLatchLocked()
+	return p.vectorOffset + irq, true
+}
+
+// picState is PICDevice's full SaveState/LoadState wire representation.
+type picState struct {
+	IRR, ISR, IMR uint8
+	VectorOffset  uint8
+	RefCount      [8]int32
+	HaveLatch     bool
+	LatchedIRQ    uint8
+	InitStep      int32
+	NeedICW4      bool
+	SingleChip    bool
+	AutoEOI       bool
+	ReadISR       bool
+}
+
+// StateName implements StatefulDevice.
+func (p *PICDevice) StateName() string { return p.name }
+
+// StateVersion implements StatefulDevice.
+func (p *PICDevice) StateVersion() uint32 { return 1 }
+
+// SaveState implements StatefulDevice.
+func (p *PICDevice) SaveState(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := picState{
+		IRR:          p.irr,
+		ISR:          p.isr,
+		IMR:          p.imr,
+		VectorOffset: p.vectorOffset,
+		HaveLatch:    p.haveLatch,
+		LatchedIRQ:   p.latchedIRQ,
+		InitStep:     int32(p.initStep),
+		NeedICW4:     p.needICW4,
+		SingleChip:   p.singleChip,
+		AutoEOI:      p.autoEOI,
+		ReadISR:      p.readISR,
+	}
+	for i, n := range p.refCount {
+		st.RefCount[i] = int32(n)
+	}
+	return binary.Write(w, binary.LittleEndian, st)
+}
+
+// LoadState implements StatefulDevice.
+func (p *PICDevice) LoadState(r io.Reader, version uint32) error {
+	if version != p.StateVersion() {
+		return fmt.Errorf("vmm: %s: unsupported state version %d (want %d)", p.name, version, p.StateVersion())
+	}
+	var st picState
+	if err := binary.Read(r, binary.LittleEndian, &st); err != nil {
+		return fmt.Errorf("vmm: %s: %w", p.name, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.irr, p.isr, p.imr = st.IRR, st.ISR, st.IMR
+	p.vectorOffset = st.VectorOffset
+	p.haveLatch = st.HaveLatch
+	p.latchedIRQ = st.LatchedIRQ
+	p.initStep = picInitStep(st.InitStep)
+	p.needICW4 = st.NeedICW4
+	p.singleChip = st.SingleChip
+	p.autoEOI = st.AutoEOI
+	p.readISR = st.ReadISR
+	for i, n := range st.RefCount {
+		p.refCount[i] = int(n)
+	}
+	return nil
+}
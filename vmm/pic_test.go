@@ -0,0 +1,120 @@
+package vmm
+
+import "testing"
+
+// initPIC runs the minimal ICW1/ICW2/ICW4 sequence a real BIOS uses for a
+// single (non-cascaded) chip, programming vectorOffset and unmasking
+// every line.
+func initPIC(t *testing.T, p *PICDevice, vectorOffset uint8) {
+	t.Helper()
+	if err := p.HandleIO(p.base, []byte{icw1Init | icw1Single | icw1ICW4}, true); err != nil {
+		t.Fatalf("ICW1: %v", err)
+	}
+	if err := p.HandleIO(p.base+1, []byte{vectorOffset}, true); err != nil {
+		t.Fatalf("ICW2: %v", err)
+	}
+	if err := p.HandleIO(p.base+1, []byte{0}, true); err != nil { // ICW4
+		t.Fatalf("ICW4: %v", err)
+	}
+	if err := p.HandleIO(p.base+1, []byte{0x00}, true); err != nil { // OCW1: unmask everything
+		t.Fatalf("OCW1: %v", err)
+	}
+}
+
+// TestPICNormalAcknowledgeSetsISR checks a line raised and still asserted
+// at acknowledge time is delivered as its own vector, with ISR marking it
+// in service until EOI'd.
+func TestPICNormalAcknowledgeSetsISR(t *testing.T) {
+	p := NewMasterPIC()
+	initPIC(t, p, 0x08)
+
+	p.RaiseIRQ(5)
+	if !p.HasPendingInterrupt() {
+		t.Fatal("HasPendingInterrupt = false after RaiseIRQ")
+	}
+
+	vector, ok := p.GetInterruptVector()
+	if !ok {
+		t.Fatal("GetInterruptVector: ok = false, want true")
+	}
+	if want := uint8(0x08 + 5); vector != want {
+		t.Errorf("vector = %#x, want %#x", vector, want)
+	}
+	if p.isr&(1<<5) == 0 {
+		t.Error("ISR bit 5 not set after acknowledging IRQ5")
+	}
+	if p.irr&(1<<5) == 0 {
+		t.Error("IRR bit 5 cleared after acknowledging IRQ5, want it to mirror the still-asserted level-triggered line")
+	}
+}
+
+// TestPICSpuriousInterruptOnDisappearedRequest checks that if a device
+// raises then lowers an IRQ before it's acknowledged, GetInterruptVector
+// reports the chip's spurious vector (offset+7) instead of the vanished
+// IRQ's own vector, and leaves ISR untouched.
+func TestPICSpuriousInterruptOnDisappearedRequest(t *testing.T) {
+	p := NewMasterPIC()
+	initPIC(t, p, 0x08)
+
+	p.RaiseIRQ(5)
+	p.LowerIRQ(5) // deasserted before the guest could acknowledge it
+
+	vector, ok := p.GetInterruptVector()
+	if !ok {
+		t.Fatal("GetInterruptVector: ok = false, want true (spurious)")
+	}
+	if want := uint8(0x08 + 7); vector != want {
+		t.Errorf("vector = %#x, want spurious vector %#x", vector, want)
+	}
+	if p.isr != 0 {
+		t.Errorf("ISR = %#x after a spurious acknowledge, want 0 (no EOI required)", p.isr)
+	}
+}
+
+// TestPICSharedIRQStaysAssertedUntilAllDevicesLower checks that two
+// devices sharing one level-triggered line keep it asserted until both
+// have independently lowered it, and that a real level-triggered chip's
+// quirk this implies — an EOI while the line is still physically held
+// re-requests the same IRQ immediately — is itself handled correctly if
+// the remaining holder lowers before that re-request is acknowledged.
+func TestPICSharedIRQStaysAssertedUntilAllDevicesLower(t *testing.T) {
+	p := NewMasterPIC()
+	initPIC(t, p, 0x08)
+
+	p.RaiseIRQ(5) // device A
+	p.RaiseIRQ(5) // device B, sharing IRQ5
+
+	p.LowerIRQ(5) // device A is done; B still holds the line up
+	if !p.HasPendingInterrupt() {
+		t.Fatal("HasPendingInterrupt = false while device B still holds IRQ5 raised")
+	}
+
+	vector, ok := p.GetInterruptVector()
+	if want := uint8(0x08 + 5); !ok || vector != want {
+		t.Fatalf("GetInterruptVector = (%#x, %v), want (%#x, true)", vector, ok, want)
+	}
+	if p.irr&(1<<5) == 0 {
+		t.Error("IRR bit 5 cleared while device B still holds the level-triggered line up")
+	}
+
+	// EOI while B still holds the line: a real level-triggered chip
+	// re-requests immediately, since the line is still electrically
+	// asserted.
+	if err := p.HandleIO(p.base, []byte{ocw2EOI}, true); err != nil {
+		t.Fatalf("EOI: %v", err)
+	}
+	if !p.HasPendingInterrupt() {
+		t.Fatal("HasPendingInterrupt = false right after EOI while device B still holds IRQ5")
+	}
+
+	// Device B lowers its hold before this re-request is acknowledged:
+	// the chip should report it spurious rather than re-delivering IRQ5.
+	p.LowerIRQ(5)
+	vector, ok = p.GetInterruptVector()
+	if want := uint8(0x08 + 7); !ok || vector != want {
+		t.Fatalf("GetInterruptVector after both raisers lowered = (%#x, %v), want spurious (%#x, true)", vector, ok, want)
+	}
+	if p.HasPendingInterrupt() {
+		t.Error("HasPendingInterrupt = true after the spurious acknowledge drained the latch")
+	}
+}
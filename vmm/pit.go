@@ -0,0 +1,285 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// PIT port assignments (8253/8254-compatible programmable interval
+// timer).
+const (
+	pitPortCounter0 = 0x40
+	pitPortCounter1 = 0x41
+	pitPortCounter2 = 0x42
+	pitPortCommand  = 0x43
+)
+
+// pitBaseFrequencyHz is the PIT's fixed input clock frequency; a
+// channel's output period is pitBaseFrequencyHz / reload.
+const pitBaseFrequencyHz = 1193182
+
+var pitTickDuration = time.Duration(math.Round(float64(time.Second) / pitBaseFrequencyHz))
+
+// Command register bit layout (port 0x43).
+const (
+	pitAccessLatch        = 0
+	pitAccessLoByte       = 1
+	pitAccessHiByte       = 2
+	pitAccessLoByteHiByte = 3
+)
+
+// pitChannel is one of the PIT's three independent 16-bit counters.
+type pitChannel struct {
+	accessMode int
+	mode       int
+	bcd        bool
+
+	reload     uint16
+	programmed time.Time
+	loWritten  bool
+	pendingLo  uint8
+
+	latched      bool
+	latchedValue uint16
+	latchLoRead  bool
+}
+
+// PITDevice emulates the 8253/8254 PIT's three-channel counter/timer at
+// ports 0x40-0x43. Channel 0 is conventionally wired to IRQ0 for the
+// system timer tick, channel 2 to the PC speaker. This model takes no
+// IrqRaiser and raises nothing on terminal count, so it never drives
+// IRQ0 on a PICDevice, just as the floppy controller raises no IRQ6.
+type PITDevice struct {
+	clock    Clock
+	channels [3]pitChannel
+}
+
+// NewPITDevice returns a PIT with all channels unprogrammed (reload 0,
+// mode 0), reading elapsed time from clock.
+func NewPITDevice(clock Clock) *PITDevice {
+	p := &PITDevice{clock: clock}
+	p.Reset()
+	return p
+}
+
+// Ports implements PioDevice.
+func (p *PITDevice) Ports() []uint16 {
+	return []uint16{pitPortCounter0, pitPortCounter1, pitPortCounter2, pitPortCommand}
+}
+
+// Reset implements PioDevice, returning every channel to power-on state.
+func (p *PITDevice) Reset() {
+	now := p.clock.Now()
+	for i := range p.channels {
+		p.channels[i] = pitChannel{accessMode: pitAccessLoByteHiByte, programmed: now}
+	}
+}
+
+// Name implements PioDevice.
+func (p *PITDevice) Name() string { return "pit" }
+
+// HandleIO implements PioDevice.
+func (p *PITDevice) HandleIO(port uint16, data []byte, write bool) error {
+	switch port {
+	case pitPortCommand:
+		if write && len(data) > 0 {
+			p.writeCommand(data[0])
+		}
+		return nil
+	case pitPortCounter0, pitPortCounter1, pitPortCounter2:
+		ch := int(port - pitPortCounter0)
+		if write {
+			if len(data) > 0 {
+				p.writeCounter(ch, data[0])
+			}
+			return nil
+		}
+		if len(data) > 0 {
+			data[0] = p.readCounter(ch)
+		}
+		return nil
+	}
+	return nil
+}
+
+func (p *PITDevice) writeCommand(cmd uint8) {
+	channel := int(cmd >> 6)
+	if channel == 3 {
+		// Read-back command; not modeled, treated as a no-op.
+		return
+	}
+	access := int((cmd >> 4) & 0x3)
+	ch := &p.channels[channel]
+
+	if access == pitAccessLatch {
+		ch.latched = true
+		ch.latchedValue = p.currentCount(channel)
+		ch.latchLoRead = false
+		return
+	}
+
+	ch.accessMode = access
+	ch.mode = int((cmd >> 1) & 0x7)
+	ch.bcd = cmd&0x1 != 0
+	ch.loWritten = false
+	ch.latched = false
+}
+
+func (p *PITDevice) writeCounter(channel int, val uint8) {
+	ch := &p.channels[channel]
+	switch ch.accessMode {
+	case pitAccessLoByte:
+		ch.reload = uint16(val)
+		ch.programmed = p.clock.Now()
+	case pitAccessHiByte:
+		ch.reload = uint16(val) << 8
+		ch.programmed = p.clock.Now()
+	case pitAccessLoByteHiByte:
+		if !ch.loWritten {
+			ch.pendingLo = val
+			ch.loWritten = true
+			return
+		}
+		ch.reload = uint16(ch.pendingLo) | uint16(val)<<8
+		ch.loWritten = false
+		ch.programmed = p.clock.Now()
+	}
+}
+
+func (p *PITDevice) readCounter(channel int) uint8 {
+	ch := &p.channels[channel]
+	if ch.latched {
+		if ch.accessMode == pitAccessHiByte {
+			ch.latched = false
+			return uint8(ch.latchedValue >> 8)
+		}
+		if !ch.latchLoRead && ch.accessMode == pitAccessLoByteHiByte {
+			ch.latchLoRead = true
+			return uint8(ch.latchedValue)
+		}
+		ch.latched = false
+		if ch.accessMode == pitAccessLoByteHiByte {
+			return uint8(ch.latchedValue >> 8)
+		}
+		return uint8(ch.latchedValue)
+	}
+
+	count := p.currentCount(channel)
+	switch ch.accessMode {
+	case pitAccessHiByte:
+		return uint8(count >> 8)
+	case pitAccessLoByteHiByte:
+		if !ch.latchLoRead {
+			ch.latchLoRead = true
+			return uint8(count)
+		}
+		ch.latchLoRead = false
+		return uint8(count >> 8)
+	default: // pitAccessLoByte
+		return uint8(count)
+	}
+}
+
+// pitChannelState is one channel's fixed-size wire representation for
+// SaveState/LoadState. programmed is stored as an offset from clock.Now()
+// at save time, rather than an absolute time, since a restored VM's clock
+// may be a fresh instance with a different epoch.
+type pitChannelState struct {
+	AccessMode            int32
+	Mode                  int32
+	BCD                   bool
+	Reload                uint16
+	ProgrammedOffsetNanos int64
+	LoWritten             bool
+	PendingLo             uint8
+	Latched               bool
+	LatchedValue          uint16
+	LatchLoRead           bool
+}
+
+// pitState is PITDevice's full SaveState/LoadState wire representation.
+type pitState struct {
+	Channels [3]pitChannelState
+}
+
+// StateName implements StatefulDevice.
+func (p *PITDevice) StateName() string { return "pit" }
+
+// StateVersion implements StatefulDevice.
+func (p *PITDevice) StateVersion() uint32 { return 1 }
+
+// SaveState implements StatefulDevice.
+func (p *PITDevice) SaveState(w io.Writer) error {
+	now := p.clock.Now()
+	var st pitState
+	for i, ch := range p.channels {
+		st.Channels[i] = pitChannelState{
+			AccessMode:            int32(ch.accessMode),
+			Mode:                  int32(ch.mode),
+			BCD:                   ch.bcd,
+			Reload:                ch.reload,
+			ProgrammedOffsetNanos: int64(ch.programmed.Sub(now)),
+			LoWritten:             ch.loWritten,
+			PendingLo:             ch.pendingLo,
+			Latched:               ch.latched,
+			LatchedValue:          ch.latchedValue,
+			LatchLoRead:           ch.latchLoRead,
+		}
+	}
+	return binary.Write(w, binary.LittleEndian, st)
+}
+
+// LoadState implements StatefulDevice.
+func (p *PITDevice) LoadState(r io.Reader, version uint32) error {
+	if version != p.StateVersion() {
+		return fmt.Errorf("vmm: pit: unsupported state version %d (want %d)", version, p.StateVersion())
+	}
+	var st pitState
+	if err := binary.Read(r, binary.LittleEndian, &st); err != nil {
+		return fmt.Errorf("vmm: pit: %w", err)
+	}
+
+	now := p.clock.Now()
+	for i, cs := range st.Channels {
+		p.channels[i] = pitChannel{
+			accessMode:   int(cs.AccessMode),
+			mode:         int(cs.Mode),
+			bcd:          cs.BCD,
+			reload:       cs.Reload,
+			programmed:   now.Add(time.Duration(cs.ProgrammedOffsetNanos)),
+			loWritten:    cs.LoWritten,
+			pendingLo:    cs.PendingLo,
+			latched:      cs.Latched,
+			latchedValue: cs.LatchedValue,
+			latchLoRead:  cs.LatchLoRead,
+		}
+	}
+	return nil
+}
+
+// currentCount computes channel's live counter value from elapsed time
+// since it was programmed, without needing a running goroutine or timer.
+func (p *PITDevice) currentCount(channel int) uint16 {
+	ch := &p.channels[channel]
+	if ch.reload == 0 {
+		return 0
+	}
+
+	elapsedTicks := uint64(p.clock.Now().Sub(ch.programmed) / pitTickDuration)
+	switch ch.mode {
+	case 0: // interrupt on terminal count: counts down once, then holds at 0
+		if elapsedTicks >= uint64(ch.reload) {
+			return 0
+		}
+		return uint16(uint64(ch.reload) - elapsedTicks)
+	default: // rate generator / square wave: reloads and repeats
+		rem := elapsedTicks % uint64(ch.reload)
+		if rem == 0 {
+			return ch.reload
+		}
+		return uint16(uint64(ch.reload) - rem)
+	}
+}
@@ -0,0 +1,83 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+)
+
+func programPITChannel0(t *testing.T, p *PITDevice, reload uint16) {
+	t.Helper()
+	// Mode 2 (rate generator), lobyte/hibyte access, binary.
+	cmd := uint8(0<<6) | uint8(pitAccessLoByteHiByte<<4) | uint8(2<<1)
+	if err := p.HandleIO(pitPortCommand, []byte{cmd}, true); err != nil {
+		t.Fatalf("write command: %v", err)
+	}
+	if err := p.HandleIO(pitPortCounter0, []byte{byte(reload)}, true); err != nil {
+		t.Fatalf("write lo byte: %v", err)
+	}
+	if err := p.HandleIO(pitPortCounter0, []byte{byte(reload >> 8)}, true); err != nil {
+		t.Fatalf("write hi byte: %v", err)
+	}
+}
+
+func readPITChannel0(t *testing.T, p *PITDevice) uint16 {
+	t.Helper()
+	lo := make([]byte, 1)
+	if err := p.HandleIO(pitPortCounter0, lo, false); err != nil {
+		t.Fatalf("read lo byte: %v", err)
+	}
+	hi := make([]byte, 1)
+	if err := p.HandleIO(pitPortCounter0, hi, false); err != nil {
+		t.Fatalf("read hi byte: %v", err)
+	}
+	return uint16(lo[0]) | uint16(hi[0])<<8
+}
+
+func TestPITCounterDecrementsWithElapsedClockTime(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	p := NewPITDevice(clock)
+
+	programPITChannel0(t, p, 1000)
+
+	if got := readPITChannel0(t, p); got != 1000 {
+		t.Fatalf("count immediately after programming = %d, want 1000", got)
+	}
+
+	clock.Advance(100 * pitTickDuration)
+
+	if got, want := readPITChannel0(t, p), uint16(900); got != want {
+		t.Errorf("count after 100 ticks = %d, want %d", got, want)
+	}
+}
+
+func TestPITRateGeneratorWrapsAtReload(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	p := NewPITDevice(clock)
+
+	programPITChannel0(t, p, 100)
+
+	clock.Advance(150 * pitTickDuration)
+
+	if got, want := readPITChannel0(t, p), uint16(50); got != want {
+		t.Errorf("count after wraparound = %d, want %d", got, want)
+	}
+}
+
+func TestPITLatchCommandFreezesCountForReadback(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	p := NewPITDevice(clock)
+
+	programPITChannel0(t, p, 1000)
+	clock.Advance(10 * pitTickDuration)
+
+	// Latch command: channel 0, access mode 00 (latch), rest ignored.
+	if err := p.HandleIO(pitPortCommand, []byte{0x00}, true); err != nil {
+		t.Fatalf("latch command: %v", err)
+	}
+
+	clock.Advance(500 * pitTickDuration) // should not affect the latched value
+
+	if got, want := readPITChannel0(t, p), uint16(990); got != want {
+		t.Errorf("latched count = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package vmm
+
+// ACPI-less poweroff ports used by bootloaders/kernels without ACPI
+// support: the legacy Bochs/QEMU debug-exit-style port 0x604 and PIIX4's
+// APM control port mirrored at 0xb004 by some firmware for the same
+// purpose. A write of any value to either port requests poweroff.
+const (
+	poweroffPort604  = 0x604
+	poweroffPortB004 = 0xb004
+)
+
+// PowerManagementDevice answers the classic ACPI-less poweroff ports by
+// invoking onPoweroff.
+type PowerManagementDevice struct {
+	onPoweroff func()
+}
+
+// NewPowerManagementDevice returns a device that calls onPoweroff when
+// the guest writes to either poweroff port.
+func NewPowerManagementDevice(onPoweroff func()) *PowerManagementDevice {
+	return &PowerManagementDevice{onPoweroff: onPoweroff}
+}
+
+// Ports implements PioDevice.
+func (p *PowerManagementDevice) Ports() []uint16 {
+	return []uint16{poweroffPort604, poweroffPortB004}
+}
+
+// HandleIO implements PioDevice.
+func (p *PowerManagementDevice) HandleIO(port uint16, data []byte, write bool) error {
+	if write && p.onPoweroff != nil {
+		p.onPoweroff()
+	}
+	return nil
+}
+
+// Reset implements PioDevice. Poweroff has no persistent state.
+func (p *PowerManagementDevice) Reset() {}
+
+// Name implements PioDevice.
+func (p *PowerManagementDevice) Name() string { return "poweroff" }
@@ -0,0 +1,18 @@
+package vmm
+
+import "testing"
+
+func TestPowerManagementDeviceTriggersPoweroff(t *testing.T) {
+	var called int
+	p := NewPowerManagementDevice(func() { called++ })
+
+	if err := p.HandleIO(poweroffPort604, []byte{0x01}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if err := p.HandleIO(poweroffPortB004, []byte{0x34}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if called != 2 {
+		t.Errorf("called = %d, want 2", called)
+	}
+}
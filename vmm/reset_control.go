@@ -0,0 +1,50 @@
+package vmm
+
+// resetControlPort is the classic (ACPI-less) PIIX/ICH "reset control
+// register", 0xcf9. Guests without ACPI use it directly to request a
+// system reset without going through the keyboard controller.
+const resetControlPort = 0xcf9
+
+// Reset control register bits.
+const (
+	rcrSysReset = 1 << 1 // triggers the reset when set together with rcrRstCPU
+	rcrRstCPU   = 1 << 2
+	rcrFullRst  = 1 << 3 // also power-cycles on some chipsets; treated as reset here
+)
+
+// ResetControlDevice emulates port 0xcf9. Writing 0x06 (RSTCPU|SYSRESET)
+// or 0x0e (also FULLRST) requests a guest reset.
+type ResetControlDevice struct {
+	onReset func()
+}
+
+// NewResetControlDevice returns a device that invokes onReset when the
+// guest writes a reset-triggering value to port 0xcf9.
+func NewResetControlDevice(onReset func()) *ResetControlDevice {
+	return &ResetControlDevice{onReset: onReset}
+}
+
+// Ports implements PioDevice.
+func (r *ResetControlDevice) Ports() []uint16 {
+	return []uint16{resetControlPort}
+}
+
+// HandleIO implements PioDevice.
+func (r *ResetControlDevice) HandleIO(port uint16, data []byte, write bool) error {
+	if !write || len(data) == 0 {
+		return nil
+	}
+	if data[0]&(rcrSysReset|rcrRstCPU) == (rcrSysReset | rcrRstCPU) {
+		if r.onReset != nil {
+			r.onReset()
+		}
+	}
+	return nil
+}
+
+// Reset implements PioDevice. The reset control register has no
+// persistent state to restore.
+func (r *ResetControlDevice) Reset() {}
+
+// Name implements PioDevice.
+func (r *ResetControlDevice) Name() string { return "reset-control" }
@@ -0,0 +1,407 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// RTC port assignments (MC146818-compatible CMOS/RTC).
+const (
+	rtcPortIndex = 0x70
+	rtcPortData  = 0x71
+)
+
+// Register B bits.
+const (
+	regBDST    = 1 << 0
+	regBHour24 = 1 << 1 // 1 = 24-hour mode, 0 = 12-hour mode
+	regBDM     = 1 << 2 // 1 = binary, 0 = BCD
+	regBSet    = 1 << 7 // 1 = updates halted, time/date registers are write targets
+)
+
+// Register A bits.
+const (
+	regAUIP = 1 << 7 // update-in-progress
+)
+
+// RTCDevice emulates the real-time clock/CMOS device at ports 0x70/0x71.
+// While register B's SET bit is clear, reads of the time/date registers
+// reflect clock.Now() shifted by offset. Writing SET, then the time/date
+// registers, then clearing SET recomputes offset so that the next read
+// reflects the guest-supplied time.
+type RTCDevice struct {
+	mu sync.Mutex
+
+	clock Clock
+
+	index uint8
+	regB  uint8
+	regA  uint8
+
+	offset time.Duration
+
+	// pending holds the guest-written time/date fields while SET is
+	// asserted; it is committed to offset when SET is cleared.
+	pending      [7]uint8 // sec, min, hour, wday, mday, month, year
+	pendingValid bool
+
+	// uipUntil marks the deadline of a simulated update cycle, during
+	// which reads report UIP=1 in register A.
+	uipUntil time.Time
+
+	// cmos holds the general-purpose configuration bytes at offsets
+	// 0x0e-0x7f, the same array real BIOSes use to remember boot order,
+	// equipment flags, and similar settings across reboots.
+	cmos [128]byte
+}
+
+// NewRTCDevice returns an RTC device that reads time from clock and starts
+// with no guest offset applied (i.e. reads reflect clock.Now()).
+func NewRTCDevice(clock Clock) *RTCDevice {
+	return &RTCDevice{
+		clock: clock,
+		regB:  regBHour24 | regBDM, // default to 24h binary mode
+	}
+}
+
+// Ports implements PioDevice.
+func (r *RTCDevice) Ports() []uint16 {
+	return []uint16{rtcPortIndex, rtcPortData}
+}
+
+// Reset implements PioDevice. It clears any guest-applied time offset and
+// pending SET-mode writes, returning the RTC to reflecting host time.
+func (r *RTCDevice) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.index = 0
+	r.regA = 0
+	r.regB = regBHour24 | regBDM
+	r.offset = 0
+	r.pending = [7]uint8{}
+	r.pendingValid = false
+	r.uipUntil = time.Time{}
+}
+
+// Name implements PioDevice.
+func (r *RTCDevice) Name() string { return "rtc" }
+
+// HandleIO implements PioDevice.
+func (r *RTCDevice) HandleIO(port uint16, data []byte, write bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch port {
+	case rtcPortIndex:
+		if write && len(data) > 0 {
+			r.index = data[0] & 0x7f // bit 7 is NMI-disable, not part of the index
+		}
+		return nil
+	case rtcPortData:
+		if write {
+			if len(data) > 0 {
+				r.writeRegister(r.index, data[0])
+			}
+			return nil
+		}
+		if len(data) > 0 {
+			data[0] = r.readRegister(r.index)
+		}
+		return nil
+	}
+	return nil
+}
+
+// register offsets.
+const (
+	regSeconds = 0x00
+	regMinutes = 0x02
+	regHours   = 0x04
+	regWeekday = 0x06
+	regDay     = 0x07
+	regMonth   = 0x08
+	regYear    = 0x09
+	regA       = 0x0a
+	regB       = 0x0b
+	regC       = 0x0c
+	regD       = 0x0d
+)
+
+func (r *RTCDevice) writeRegister(reg, val uint8) {
+	switch reg {
+	case regA:
+		// UIP is read-only; the guest may only set the divider/rate bits.
+		r.regA = (r.regA & regAUIP) | (val &^ regAUIP)
+	case regB:
+		wasSet := r.regB&regBSet != 0
+		r.regB = val
+		nowSet := r.regB&regBSet != 0
+		if nowSet && !wasSet {
+			// Entering SET mode: start capturing guest writes.
+			r.pendingValid = false
+		} else if !nowSet && wasSet {
+			// Leaving SET mode: commit the pending guest time as a new
+			// offset relative to the host clock, and simulate a brief
+			// update cycle.
+			r.commitPending()
+			r.uipUntil = r.clock.Now().Add(2 * time.Millisecond)
+		}
+	case regC, regD:
+		// Read-only status registers; ignore writes.
+	case regSeconds, regMinutes, regHours, regWeekday, regDay, regMonth, regYear:
+		if r.regB&regBSet != 0 {
+			r.pending[timeFieldIndex(reg)] = val
+			r.pendingValid = true
+		}
+		// Writes to time/date registers outside of SET mode are ignored,
+		// matching real MC146818 behavior.
+	default:
+		if int(reg) < len(r.cmos) {
+			r.cmos[reg] = val
+		}
+	}
+}
+
+func (r *RTCDevice) readRegister(reg uint8) uint8 {
+	switch reg {
+	case regA:
+		v := r.regA
+		if !r.clock.Now().After(r.uipUntil) {
+			v |= regAUIP
+		}
+		return v
+	case regB:
+		return r.regB
+	case regC, regD:
+		return 0
+	case regSeconds, regMinutes, regHours, regWeekday, regDay, regMonth, regYear:
+		if r.regB&regBSet != 0 {
+			// While frozen for SET, reflect whatever the guest has
+			// written so far (or zero for untouched fields).
+			return r.encodeField(reg, r.pending[timeFieldIndex(reg)])
+		}
+		return r.encodeCurrentField(reg)
+	}
+	if int(reg) < len(r.cmos) {
+		return r.cmos[reg]
+	}
+	return 0
+}
+
+func timeFieldIndex(reg uint8) int {
+	switch reg {
+	case regSeconds:
+		return 0
+	case regMinutes:
+		return 1
+	case regHours:
+		return 2
+	case regWeekday:
+		return 3
+	case regDay:
+		return 4
+	case regMonth:
+		return 5
+	default: // regYear
+		return 6
+	}
+}
+
+// commitPending computes offset such that clock.Now().Add(offset) decodes
+// back to the guest time captured in pending.
+func (r *RTCDevice) commitPending() {
+	if !r.pendingValid {
+		return
+	}
+	now := r.clock.Now()
+	guest := decodeGuestTime(r.pending, now, r.regB)
+	r.offset = guest.Sub(now)
+	r.pendingValid = false
+}
+
+// decodeGuestTime interprets the raw pending register bytes (BCD/binary,
+// 12/24-hour per regB) as a guest wall-clock time, reusing now's date for
+// any field not covered by the always-set second/minute/hour/day fields.
+func decodeGuestTime(pending [7]uint8, now time.Time, regB uint8) time.Time {
+	dec := func(v uint8) int {
+		if regB&regBDM == 0 {
+			return int(bcdToBin(v))
+		}
+		return int(v)
+	}
+
+	sec := dec(pending[0])
+	min := dec(pending[1])
+
+	hourRaw := pending[2]
+	pm := false
+	if regB&regBHour24 == 0 {
+		pm = hourRaw&0x80 != 0
+		hourRaw &^= 0x80
+	}
+	hour := dec(hourRaw)
+	if regB&regBHour24 == 0 {
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+	}
+
+	day := dec(pending[4])
+	month := dec(pending[5])
+	year := dec(pending[6]) + 2000
+
+	if day == 0 {
+		day = now.Day()
+	}
+	if month == 0 {
+		month = int(now.Month())
+	}
+
+	return time.Date(year, time.Month(month), day, hour, min, sec, 0, now.Location())
+}
+
+// encodeCurrentField returns reg's value for the current effective time
+// (clock.Now() shifted by offset), formatted per regB's BCD/12h settings.
+func (r *RTCDevice) encodeCurrentField(reg uint8) uint8 {
+	t := r.clock.Now().Add(r.offset)
+	return encodeField(reg, t, r.regB)
+}
+
+// encodeField returns raw unchanged for register reg: raw is already in
+// the guest's BCD/12h-encoded representation. Used for the SET-mode
+// readback path where pending already holds raw guest-format bytes.
+func (r *RTCDevice) encodeField(reg uint8, raw uint8) uint8 {
+	return raw
+}
+
+func encodeField(reg uint8, t time.Time, regB uint8) uint8 {
+	enc := func(v int) uint8 {
+		if regB&regBDM == 0 {
+			return binToBCD(uint8(v))
+		}
+		return uint8(v)
+	}
+
+	switch reg {
+	case regSeconds:
+		return enc(t.Second())
+	case regMinutes:
+		return enc(t.Minute())
+	case regHours:
+		hour := t.Hour()
+		if regB&regBHour24 != 0 {
+			return enc(hour)
+		}
+		pm := hour >= 12
+		h12 := hour % 12
+		if h12 == 0 {
+			h12 = 12
+		}
+		v := enc(h12)
+		if pm {
+			v |= 0x80
+		}
+		return v
+	case regWeekday:
+		return enc(int(t.Weekday()) + 1)
+	case regDay:
+		return enc(t.Day())
+	case regMonth:
+		return enc(int(t.Month()))
+	case regYear:
+		return enc(t.Year() % 100)
+	}
+	return 0
+}
+
+// rtcState is RTCDevice's full SaveState/LoadState wire representation.
+// offset and uipUntil are stored relative to clock.Now() at save time,
+// rather than as absolute times, since a restored VM's clock may be a
+// fresh instance with a different epoch. uipUntil additionally needs an
+// explicit validity flag because its zero value can't be distinguished
+// from a legitimate offset of zero.
+type rtcState struct {
+	Index uint8
+	RegA  uint8
+	RegB  uint8
+
+	OffsetNanos int64
+
+	Pending      [7]uint8
+	PendingValid bool
+
+	UipUntilValid       bool
+	UipUntilOffsetNanos int64
+
+	Cmos [128]byte
+}
+
+// StateName implements StatefulDevice.
+func (r *RTCDevice) StateName() string { return "rtc" }
+
+// StateVersion implements StatefulDevice.
+func (r *RTCDevice) StateVersion() uint32 { return 1 }
+
+// SaveState implements StatefulDevice.
+func (r *RTCDevice) SaveState(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	st := rtcState{
+		Index:        r.index,
+		RegA:         r.regA,
+		RegB:         r.regB,
+		OffsetNanos:  int64(r.offset),
+		Pending:      r.pending,
+		PendingValid: r.pendingValid,
+		Cmos:         r.cmos,
+	}
+	if !r.uipUntil.IsZero() {
+		st.UipUntilValid = true
+		st.UipUntilOffsetNanos = int64(r.uipUntil.Sub(now))
+	}
+	return binary.Write(w, binary.LittleEndian, st)
+}
+
+// LoadState implements StatefulDevice.
+func (r *RTCDevice) LoadState(rd io.Reader, version uint32) error {
+	if version != r.StateVersion() {
+		return fmt.Errorf("vmm: rtc: unsupported state version %d (want %d)", version, r.StateVersion())
+	}
+	var st rtcState
+	if err := binary.Read(rd, binary.LittleEndian, &st); err != nil {
+		return fmt.Errorf("vmm: rtc: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	r.index = st.Index
+	r.regA = st.RegA
+	r.regB = st.RegB
+	r.offset = time.Duration(st.OffsetNanos)
+	r.pending = st.Pending
+	r.pendingValid = st.PendingValid
+	r.cmos = st.Cmos
+	if st.UipUntilValid {
+		r.uipUntil = now.Add(time.Duration(st.UipUntilOffsetNanos))
+	} else {
+		r.uipUntil = time.Time{}
+	}
+	return nil
+}
+
+func bcdToBin(v uint8) uint8 {
+	return (v>>4)*10 + (v & 0x0f)
+}
+
+func binToBCD(v uint8) uint8 {
+	return ((v / 10) << 4) | (v % 10)
+}
@@ -0,0 +1,125 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually-advanced Clock for deterministic RTC tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) AfterFunc(d time.Duration, fn func()) {}
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func rtcRead(t *testing.T, r *RTCDevice, reg uint8) uint8 {
+	t.Helper()
+	if err := r.HandleIO(rtcPortIndex, []byte{reg}, true); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	buf := make([]byte, 1)
+	if err := r.HandleIO(rtcPortData, buf, false); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	return buf[0]
+}
+
+func rtcWrite(t *testing.T, r *RTCDevice, reg, val uint8) {
+	t.Helper()
+	if err := r.HandleIO(rtcPortIndex, []byte{reg}, true); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	if err := r.HandleIO(rtcPortData, []byte{val}, true); err != nil {
+		t.Fatalf("write data: %v", err)
+	}
+}
+
+func TestRTCDefaultsToHostTime(t *testing.T) {
+	clock := newFakeClock()
+	r := NewRTCDevice(clock)
+
+	if got, want := rtcRead(t, r, regYear), uint8(26); got != want {
+		t.Errorf("year = %d, want %d", got, want)
+	}
+	if got, want := rtcRead(t, r, regDay), uint8(1); got != want {
+		t.Errorf("day = %d, want %d", got, want)
+	}
+}
+
+func TestRTCGuestOffsetSurvivesMonthBoundary(t *testing.T) {
+	clock := newFakeClock() // 2026-01-01 00:00:00 UTC
+	r := NewRTCDevice(clock)
+
+	// Guest sets its clock 3 days ahead: 2026-01-04.
+	rtcWrite(t, r, regB, regBSet|regBHour24|regBDM)
+	rtcWrite(t, r, regSeconds, 0)
+	rtcWrite(t, r, regMinutes, 0)
+	rtcWrite(t, r, regHours, 0)
+	rtcWrite(t, r, regDay, 4)
+	rtcWrite(t, r, regMonth, 1)
+	rtcWrite(t, r, regYear, 26)
+	rtcWrite(t, r, regB, regBHour24|regBDM) // clear SET, commit offset
+
+	// Let the simulated update cycle elapse.
+	clock.Advance(5 * time.Millisecond)
+
+	if got, want := rtcRead(t, r, regDay), uint8(4); got != want {
+		t.Fatalf("day after set = %d, want %d", got, want)
+	}
+
+	// Advance the host clock by 30 days; the guest offset should carry
+	// across the month boundary: 2026-01-04 + 30d = 2026-02-03.
+	clock.Advance(30 * 24 * time.Hour)
+
+	if got, want := rtcRead(t, r, regMonth), uint8(2); got != want {
+		t.Errorf("month after 30d = %d, want %d", got, want)
+	}
+	if got, want := rtcRead(t, r, regDay), uint8(3); got != want {
+		t.Errorf("day after 30d = %d, want %d", got, want)
+	}
+}
+
+func TestRTCGuestYear2000RoundTrips(t *testing.T) {
+	clock := newFakeClock() // host year is 2026
+	r := NewRTCDevice(clock)
+
+	rtcWrite(t, r, regB, regBSet|regBHour24|regBDM)
+	rtcWrite(t, r, regSeconds, 0)
+	rtcWrite(t, r, regMinutes, 0)
+	rtcWrite(t, r, regHours, 0)
+	rtcWrite(t, r, regDay, 1)
+	rtcWrite(t, r, regMonth, 1)
+	rtcWrite(t, r, regYear, 0) // year 2000
+	rtcWrite(t, r, regB, regBHour24|regBDM)
+
+	if got, want := rtcRead(t, r, regYear), uint8(0); got != want {
+		t.Errorf("year after setting 2000 = %d, want %d (host year must not leak in)", got, want)
+	}
+}
+
+func TestRTCUIPAssertedDuringUpdateCycle(t *testing.T) {
+	clock := newFakeClock()
+	r := NewRTCDevice(clock)
+
+	rtcWrite(t, r, regB, regBSet|regBHour24|regBDM)
+	rtcWrite(t, r, regYear, 26)
+	rtcWrite(t, r, regB, regBHour24|regBDM)
+
+	if got := rtcRead(t, r, regA); got&regAUIP == 0 {
+		t.Errorf("regA = %#x, want UIP set immediately after commit", got)
+	}
+
+	clock.Advance(5 * time.Millisecond)
+
+	if got := rtcRead(t, r, regA); got&regAUIP != 0 {
+		t.Errorf("regA = %#x, want UIP clear after update cycle", got)
+	}
+}
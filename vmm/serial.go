@@ -0,0 +1,640 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Serial port assignments (16550A-compatible UART). This models the
+// classic PC/AT COM1/COM2 layout: two independent instances, each with
+// its own registers, backend, and IRQ line — construct a second one with
+// NewSerialPortDeviceAt(comPort2Base, ...) and wire it to comPort2Irq with
+// SetIrqRaiser.
+const (
+	comPort1Base uint16 = 0x3f8
+	comPort1Irq  int    = 4
+
+	comPort2Base uint16 = 0x2f8
+	comPort2Irq  int    = 3
+)
+
+// Register offsets relative to the port's base address.
+const (
+	uartRegDataOrDivisorLo = 0 // RBR/THR, or DLL when LCR.DLAB is set
+	uartRegIERorDivisorHi  = 1 // IER, or DLM when LCR.DLAB is set
+	uartRegIIRorFCR        = 2 // IIR on read, FCR on write
+	uartRegLCR             = 3
+	uartRegMCR             = 4
+	uartRegLSR             = 5
+	uartRegMSR             = 6
+	uartRegSCR             = 7
+)
+
+// IER (Interrupt Enable Register) bits.
+const (
+	ierRxAvailable = 1 << 0 // received data available / character timeout
+	ierThrEmpty    = 1 << 1
+)
+
+// FCR (FIFO Control Register, write-only at uartRegIIRorFCR) bits.
+const (
+	fcrFIFOEnable  = 1 << 0
+	fcrRxFIFOReset = 1 << 1
+	fcrTxFIFOReset = 1 << 2
+	fcrRxTriggerLo = 1 << 6
+	fcrRxTriggerHi = 1 << 7
+)
+
+// IIR (Interrupt Identification Register, read-only at uartRegIIRorFCR)
+// values. Bit 0 is inverted (0 means an interrupt is pending); bits 6-7
+// report whether the FIFOs are enabled and are ORed in by iirValue.
+const (
+	iirNoInterrupt    = 0x01
+	iirThrEmpty       = 0x02
+	iirRxAvailable    = 0x04
+	iirCharTimeout    = 0x0c
+	iirFIFOEnabledBit = 0xc0
+)
+
+// LCR (Line Control Register) bits.
+const (
+	lcrDLAB = 1 << 7 // Divisor Latch Access Bit
+)
+
+// LSR (Line Status Register) bits this device models. The overrun,
+// parity, framing and break bits are never set: an RX overrun (see
+// ReceiveByte) drops the byte without flagging it, and nothing in this
+// package injects the other line errors.
+const (
+	lsrDataReady       = 1 << 0
+	lsrTHREmpty        = 1 << 5
+	lsrTransmitterIdle = 1 << 6
+)
+
+// uartFIFOCapacity is the depth of a 16550A's RX/TX FIFOs.
+const uartFIFOCapacity = 16
+
+// Output batching parameters: guests that print one character per OUT
+// (kernel boot logs, in particular) would otherwise cost one out.Write
+// call per byte. outputFlushThreshold and outputFlushInterval bound how
+// long bytes may sit unflushed; a newline always flushes immediately, as
+// does any LSR read, so a guest polling for THRE/TEMT still sees
+// consistent output ordering.
+const (
+	outputFlushThreshold = 256
+	outputFlushInterval  = 20 * time.Millisecond
+)
+
+// uartRxTriggerLevels maps the FCR's two trigger-level bits to a byte
+// count, in the order the hardware encodes them (00, 01, 10, 11).
+var uartRxTriggerLevels = [4]int{1, 4, 8, 14}
+
+// SerialPortDevice emulates a 16550A-compatible UART. It has no backing
+// terminal of its own: transmitted bytes are written to out, and
+// ReceiveByte feeds host-side input into the RX FIFO the same way
+// NE2000Device.ReceiveFrame feeds inbound packets.
+//
+// Output to out is batched rather than one Write call per THR byte —
+// see outputFlushThreshold/outputFlushInterval — unless SetStrictMode
+// disables batching for writers that need byte-at-a-time delivery.
+//
+// FIFO timing is computed lazily from clock.Now(), the same pattern
+// PITDevice and RTCDevice use, rather than scheduled callbacks: there is
+// no background goroutine, so a character-timeout interrupt (or a timed
+// output flush) only becomes visible the next time a register is read.
+type SerialPortDevice struct {
+	mu sync.Mutex
+
+	base  uint16
+	clock Clock
+
+	out        io.Writer
+	strictMode bool
+
+	outBuf      []byte
+	outBufSince time.Time
+
+	ier uint8
+	fcr uint8
+	lcr uint8
+	mcr uint8
+	scr uint8
+
+	divisorLo uint8
+	divisorHi uint8
+
+	rxFIFO []uint8
+
+	// rxIdleSince marks when the FIFO last transitioned from empty to
+	// non-empty, or was last read from; the character-timeout interrupt
+	// fires once 4 character times have elapsed since without reaching
+	// the trigger level.
+	rxIdleSince time.Time
+
+	// logger receives diagnostics (e.g. a dropped byte on RX overrun).
+	// It never writes to out: out is guest data, logger is for the host
+	// operator.
+	logger Logger
+
+	// raiser and irq, set by SetIrqRaiser, are where this UART asserts
+	// its interrupt line when it has one to report; irqAsserted tracks
+	// whether it's currently asserted so RaiseIRQ/LowerIRQ are each
+	// called exactly once per transition, matching the reference-counted
+	// contract PICDevice.RaiseIRQ/LowerIRQ expect.
+	raiser      IrqRaiser
+	irq         int
+	irqAsserted bool
+}
+
+// SetLogger installs l to receive this device's diagnostics. Passing nil
+// restores the default no-op logger.
+func (s *SerialPortDevice) SetLogger(l Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l == nil {
+		l = noopLogger{}
+	}
+	s.logger = l
+}
+
+// NewSerialPortDevice returns a COM1 (0x3f8) UART with FIFOs disabled, as
+// a real 16550A powers on. Bytes written to THR are batched and flushed
+// to out; out may be nil to discard transmitted bytes.
+func NewSerialPortDevice(clock Clock, out io.Writer) *SerialPortDevice {
+	return NewSerialPortDeviceAt(comPort1Base, clock, out)
+}
+
+// NewSerialPortDeviceAt is NewSerialPortDevice for a UART at a base other
+// than COM1, e.g. comPort2Base for a second serial port.
+func NewSerialPortDeviceAt(base uint16, clock Clock, out io.Writer) *SerialPortDevice {
+	return &SerialPortDevice{
+		base:      base,
+		clock:     clock,
+		out:       out,
+		divisorLo: 1, // 115200 baud, the 16550's power-on-equivalent default this model uses
+		logger:    noopLogger{},
+	}
+}
+
+// SetIrqRaiser wires this UART to raiser's irq line: whenever iirValueLocked
+// reports a pending, IER-enabled interrupt, RaiseIRQ(irq) is called once,
+// followed by a matching LowerIRQ(irq) once none remains pending. Call it
+// once after construction; leaving it unset (the default) means this
+// UART's interrupt condition is only visible by polling IIR, as before
+// this existed.
+func (s *SerialPortDevice) SetIrqRaiser(raiser IrqRaiser, irq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.raiser = raiser
+	s.irq = irq
+}
+
+// updateIrqLocked asserts or deasserts this UART's interrupt line to
+// match its current IIR pending status, a no-op if SetIrqRaiser was
+// never called.
+func (s *SerialPortDevice) updateIrqLocked() {
+	if s.raiser == nil {
+		return
+	}
+	pending := s.iirValueLocked()&iirNoInterrupt == 0
+	if pending && !s.irqAsserted {
+		s.irqAsserted = true
+		s.raiser.RaiseIRQ(s.irq)
+	} else if !pending && s.irqAsserted {
+		s.irqAsserted = false
+		s.raiser.LowerIRQ(s.irq)
+	}
+}
+
+// SetStrictMode toggles output batching. With strict mode on, every THR
+// write is flushed to out immediately, one byte per Write call — the
+// behavior a writer that can't tolerate reordered or delayed bytes (e.g.
+// a byte-oriented protocol analyzer) needs.
+func (s *SerialPortDevice) SetStrictMode(strict bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strictMode = strict
+}
+
+// Flush writes any batched THR output to out immediately.
+func (s *SerialPortDevice) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+// Ports implements PioDevice.
+func (s *SerialPortDevice) Ports() []uint16 {
+	ports := make([]uint16, 8)
+	for i := range ports {
+		ports[i] = s.base + uint16(i)
+	}
+	return ports
+}
+
+// Reset implements PioDevice, returning the UART to power-on state: FIFOs
+// disabled and empty, no interrupts enabled. Any output batched but not
+// yet flushed is written out first, so a reset never silently drops
+// bytes the guest already transmitted.
+func (s *SerialPortDevice) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+	s.ier, s.fcr, s.lcr, s.mcr, s.scr = 0, 0, 0, 0, 0
+	s.divisorLo, s.divisorHi = 1, 0
+	s.rxFIFO = nil
+	s.updateIrqLocked()
+}
+
+// Name implements PioDevice.
+func (s *SerialPortDevice) Name() string { return "serial" }
+
+// HandleIO implements PioDevice.
+func (s *SerialPortDevice) HandleIO(port uint16, data []byte, write bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := s.maybeFlushOnTimerLocked(); err != nil {
+		return err
+	}
+
+	switch port - s.base {
+	case uartRegDataOrDivisorLo:
+		if s.lcr&lcrDLAB != 0 {
+			if write {
+				s.divisorLo = data[0]
+			} else {
+				data[0] = s.divisorLo
+			}
+			return nil
+		}
+		if write {
+			return s.writeByteLocked(data[0])
+		}
+		data[0] = s.popRxByteLocked()
+	case uartRegIERorDivisorHi:
+		if s.lcr&lcrDLAB != 0 {
+			if write {
+				s.divisorHi = data[0]
+			} else {
+				data[0] = s.divisorHi
+			}
+			return nil
+		}
+		if write {
+			s.ier = data[0] & 0x0f
+		} else {
+			data[0] = s.ier
+		}
+	case uartRegIIRorFCR:
+		if write {
+			s.writeFCRLocked(data[0])
+		} else {
+			data[0] = s.iirValueLocked()
+		}
+	case uartRegLCR:
+		if write {
+			s.lcr = data[0]
+		} else {
+			data[0] = s.lcr
+		}
+	case uartRegMCR:
+		if write {
+			s.mcr = data[0] & 0x1f
+		} else {
+			data[0] = s.mcr
+		}
+	case uartRegLSR:
+		if !write {
+			// Flush unconditionally, not just when the timer/threshold
+			// would have: a guest polling LSR for THRE/TEMT must see
+			// output already delivered, not sitting in outBuf.
+			if err := s.flushLocked(); err != nil {
+				return err
+			}
+			data[0] = s.lsrValueLocked()
+		}
+	case uartRegMSR:
+		if !write {
+			data[0] = 0 // no modem control lines are wired up to anything
+		}
+	case uartRegSCR:
+		if write {
+			s.scr = data[0]
+		} else {
+			data[0] = s.scr
+		}
+	}
+	s.updateIrqLocked()
+	return nil
+}
+
+// writeFCRLocked applies a write to the FIFO Control Register: enabling
+// or disabling FIFO mode, resetting the RX FIFO, and/or selecting a new
+// RX trigger level. TX FIFO reset is accepted but is a no-op: outBuf is
+// an output batching buffer, not a hardware TX FIFO a guest can inspect.
+func (s *SerialPortDevice) writeFCRLocked(val uint8) {
+	s.fcr = val &^ (fcrRxFIFOReset | fcrTxFIFOReset) // reset bits self-clear
+	if val&fcrRxFIFOReset != 0 {
+		s.rxFIFO = nil
+	}
+	if val&fcrFIFOEnable == 0 {
+		// Falling back to 16450 mode: only one byte of RX buffering.
+		if len(s.rxFIFO) > 1 {
+			s.rxFIFO = s.rxFIFO[:1]
+		}
+	}
+}
+
+// fifoEnabled reports whether FCR's FIFO-enable bit is set.
+func (s *SerialPortDevice) fifoEnabled() bool {
+	return s.fcr&fcrFIFOEnable != 0
+}
+
+// triggerLevelLocked returns the RX FIFO depth, in bytes, at which an RDA
+// interrupt is raised. With FIFOs disabled, any byte triggers it.
+func (s *SerialPortDevice) triggerLevelLocked() int {
+	if !s.fifoEnabled() {
+		return 1
+	}
+	idx := 0
+	if s.fcr&fcrRxTriggerLo != 0 {
+		idx |= 1
+	}
+	if s.fcr&fcrRxTriggerHi != 0 {
+		idx |= 2
+	}
+	return uartRxTriggerLevels[idx]
+}
+
+// fifoCapacityLocked returns how many bytes ReceiveByte will buffer
+// before dropping incoming data.
+func (s *SerialPortDevice) fifoCapacityLocked() int {
+	if !s.fifoEnabled() {
+		return 1
+	}
+	return uartFIFOCapacity
+}
+
+// charDurationLocked estimates the time it takes to shift one character
+// at the programmed baud rate, assuming the common 1 start + 8 data + 1
+// stop bit framing. It is used only to pace the character-timeout
+// interrupt, so LCR's word-length/parity/stop-bit fields aren't factored
+// in beyond that approximation.
+func (s *SerialPortDevice) charDurationLocked() time.Duration {
+	divisor := uint16(s.divisorLo) | uint16(s.divisorHi)<<8
+	if divisor == 0 {
+		divisor = 1
+	}
+	baud := uartBaseClockHz / (16 * int(divisor))
+	if baud <= 0 {
+		baud = 115200
+	}
+	const bitsPerChar = 10
+	return time.Second * bitsPerChar / time.Duration(baud)
+}
+
+// uartBaseClockHz is the 16550's standard 1.8432MHz reference oscillator;
+// divided by 16 and the programmed divisor, it yields the baud rate.
+const uartBaseClockHz = 1843200
+
+// ReceiveByte feeds one host-side byte into the RX FIFO, as if it had
+// just arrived on the wire. Bytes received while the FIFO is already at
+// capacity are dropped, mirroring a real UART's overrun behavior (this
+// model logs the drop but doesn't set LSR's overrun-error bit).
+func (s *SerialPortDevice) ReceiveByte(b byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.rxFIFO) >= s.fifoCapacityLocked() {
+		s.logger.Warnf("vmm: serial: RX FIFO full at %d bytes, dropping incoming byte %#02x", len(s.rxFIFO), b)
+		return
+	}
+	if len(s.rxFIFO) == 0 {
+		s.rxIdleSince = s.clock.Now()
+	}
+	s.rxFIFO = append(s.rxFIFO, b)
+	s.updateIrqLocked()
+}
+
+// popRxByteLocked pops and returns the oldest buffered byte, or 0 if the
+// FIFO is empty. Reading RBR resets the character-timeout window, the
+// same way a real UART's timeout timer restarts on every RBR access.
+func (s *SerialPortDevice) popRxByteLocked() uint8 {
+	if len(s.rxFIFO) == 0 {
+		return 0
+	}
+	b := s.rxFIFO[0]
+	s.rxFIFO = s.rxFIFO[1:]
+	s.rxIdleSince = s.clock.Now()
+	return b
+}
+
+// timedOutLocked reports whether the RX FIFO holds fewer bytes than the
+// trigger level, but has held at least one for 4 character times without
+// reaching it — the condition IIR's character-timeout indication (0xc)
+// signals in FIFO mode.
+func (s *SerialPortDevice) timedOutLocked() bool {
+	if !s.fifoEnabled() || len(s.rxFIFO) == 0 || len(s.rxFIFO) >= s.triggerLevelLocked() {
+		return false
+	}
+	return s.clock.Now().Sub(s.rxIdleSince) >= 4*s.charDurationLocked()
+}
+
+// iirValueLocked computes the current Interrupt Identification Register
+// value: the highest-priority pending, IER-enabled interrupt source, or
+// iirNoInterrupt if none is pending, with the FIFO-enabled status bits
+// ORed in.
+func (s *SerialPortDevice) iirValueLocked() uint8 {
+	id := uint8(iirNoInterrupt)
+	switch {
+	case s.ier&ierRxAvailable != 0 && len(s.rxFIFO) >= s.triggerLevelLocked():
+		id = iirRxAvailable
+	case s.ier&ierRxAvailable != 0 && s.timedOutLocked():
+		id = iirCharTimeout
+	case s.ier&ierThrEmpty != 0:
+		id = iirThrEmpty
+	}
+	if s.fifoEnabled() {
+		id |= iirFIFOEnabledBit
+	}
+	return id
+}
+
+// writeByteLocked handles one THR write: in strict mode it's flushed to
+// out immediately, one byte per Write call, matching pre-batching
+// behavior exactly; otherwise it's appended to outBuf, which flushes on
+// a newline or once outputFlushThreshold bytes have accumulated.
+func (s *SerialPortDevice) writeByteLocked(b byte) error {
+	if s.strictMode {
+		return s.writeOutLocked([]byte{b})
+	}
+
+	if len(s.outBuf) == 0 {
+		s.outBufSince = s.clock.Now()
+	}
+	s.outBuf = append(s.outBuf, b)
+	if b == '\n' || len(s.outBuf) >= outputFlushThreshold {
+		return s.flushLocked()
+	}
+	return nil
+}
+
+// maybeFlushOnTimerLocked flushes outBuf if it's held bytes for at least
+// outputFlushInterval, so a guest that stops writing mid-line (no
+// trailing newline, buffer short of the threshold) doesn't have its
+// output stuck indefinitely. Like the character-timeout check, this is
+// evaluated lazily on the next register access rather than through a
+// scheduled callback.
+func (s *SerialPortDevice) maybeFlushOnTimerLocked() error {
+	if len(s.outBuf) == 0 {
+		return nil
+	}
+	if s.clock.Now().Sub(s.outBufSince) < outputFlushInterval {
+		return nil
+	}
+	return s.flushLocked()
+}
+
+// flushLocked writes any batched output to out and clears outBuf. It's a
+// no-op when there's nothing to flush or out is nil.
+func (s *SerialPortDevice) flushLocked() error {
+	if len(s.outBuf) == 0 {
+		return nil
+	}
+	buf := s.outBuf
+	s.outBuf = nil
+	return s.writeOutLocked(buf)
+}
+
+// writeOutLocked writes b to out, if set.
+func (s *SerialPortDevice) writeOutLocked(b []byte) error {
+	if s.out == nil {
+		return nil
+	}
+	_, err := s.out.Write(b)
+	return err
+}
+
+// lsrValueLocked computes the current Line Status Register value: DR
+// reflects the RX FIFO being non-empty, and THRE/TEMT are always set —
+// by the time this is called, HandleIO has already flushed outBuf, so
+// there is never a batched-but-undelivered byte for a guest to observe.
+func (s *SerialPortDevice) lsrValueLocked() uint8 {
+	lsr := uint8(lsrTHREmpty | lsrTransmitterIdle)
+	if len(s.rxFIFO) > 0 {
+		lsr |= lsrDataReady
+	}
+	return lsr
+}
+
+// serialFixedState is SerialPortDevice's fixed-size SaveState/LoadState
+// fields; outBuf and rxFIFO are variable-length and are framed separately.
+// outBufSince and rxIdleSince are stored as offsets from clock.Now() at
+// save time, since a restored VM's clock may be a fresh instance with a
+// different epoch; each has its own validity flag since their zero values
+// can't be told apart from a legitimate zero offset.
+type serialFixedState struct {
+	Base       uint16
+	StrictMode bool
+
+	IER, FCR, LCR, MCR, SCR uint8
+	DivisorLo, DivisorHi    uint8
+
+	OutBufSinceValid       bool
+	OutBufSinceOffsetNanos int64
+
+	RxIdleSinceValid       bool
+	RxIdleSinceOffsetNanos int64
+}
+
+// StateName implements StatefulDevice.
+func (s *SerialPortDevice) StateName() string { return "serial" }
+
+// StateVersion implements StatefulDevice.
+func (s *SerialPortDevice) StateVersion() uint32 { return 1 }
+
+// SaveState implements StatefulDevice. Any output batched but not yet
+// flushed is preserved as-is, not flushed: the point of a snapshot taken
+// mid-I/O-sequence is that the restored device resumes identically,
+// including still holding those bytes unflushed.
+func (s *SerialPortDevice) SaveState(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	st := serialFixedState{
+		Base:       s.base,
+		StrictMode: s.strictMode,
+		IER:        s.ier,
+		FCR:        s.fcr,
+		LCR:        s.lcr,
+		MCR:        s.mcr,
+		SCR:        s.scr,
+		DivisorLo:  s.divisorLo,
+		DivisorHi:  s.divisorHi,
+	}
+	if !s.outBufSince.IsZero() {
+		st.OutBufSinceValid = true
+		st.OutBufSinceOffsetNanos = int64(s.outBufSince.Sub(now))
+	}
+	if !s.rxIdleSince.IsZero() {
+		st.RxIdleSinceValid = true
+		st.RxIdleSinceOffsetNanos = int64(s.rxIdleSince.Sub(now))
+	}
+	if err := binary.Write(w, binary.LittleEndian, st); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, s.outBuf); err != nil {
+		return err
+	}
+	return writeSnapshotBytes(w, s.rxFIFO)
+}
+
+// LoadState implements StatefulDevice.
+func (s *SerialPortDevice) LoadState(r io.Reader, version uint32) error {
+	if version != s.StateVersion() {
+		return fmt.Errorf("vmm: serial: unsupported state version %d (want %d)", version, s.StateVersion())
+	}
+	var st serialFixedState
+	if err := binary.Read(r, binary.LittleEndian, &st); err != nil {
+		return fmt.Errorf("vmm: serial: %w", err)
+	}
+	outBuf, err := readSnapshotBytes(r)
+	if err != nil {
+		return fmt.Errorf("vmm: serial: reading outBuf: %w", err)
+	}
+	rxFIFO, err := readSnapshotBytes(r)
+	if err != nil {
+		return fmt.Errorf("vmm: serial: reading rxFIFO: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.base = st.Base
+	s.strictMode = st.StrictMode
+	s.ier, s.fcr, s.lcr, s.mcr, s.scr = st.IER, st.FCR, st.LCR, st.MCR, st.SCR
+	s.divisorLo, s.divisorHi = st.DivisorLo, st.DivisorHi
+	if st.OutBufSinceValid {
+		s.outBufSince = now.Add(time.Duration(st.OutBufSinceOffsetNanos))
+	} else {
+		s.outBufSince = time.Time{}
+	}
+	if st.RxIdleSinceValid {
+		s.rxIdleSince = now.Add(time.Duration(st.RxIdleSinceOffsetNanos))
+	} else {
+		s.rxIdleSince = time.Time{}
+	}
+	s.outBuf = outBuf
+	s.rxFIFO = rxFIFO
+	return nil
+}
@@ -0,0 +1,341 @@
+package vmm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func serialReadReg(t *testing.T, s *SerialPortDevice, reg uint16) uint8 {
+	t.Helper()
+	buf := make([]byte, 1)
+	if err := s.HandleIO(comPort1Base+reg, buf, false); err != nil {
+		t.Fatalf("read register %d: %v", reg, err)
+	}
+	return buf[0]
+}
+
+func serialWriteReg(t *testing.T, s *SerialPortDevice, reg uint16, val uint8) {
+	t.Helper()
+	if err := s.HandleIO(comPort1Base+reg, []byte{val}, true); err != nil {
+		t.Fatalf("write register %d: %v", reg, err)
+	}
+}
+
+// fakeIrqRaiser records RaiseIRQ/LowerIRQ calls, standing in for a
+// PICDevice or InKernelIrqChip in tests that only care which line was
+// asserted, not how it's ultimately delivered.
+type fakeIrqRaiser struct {
+	raised  []int
+	lowered []int
+}
+
+func (f *fakeIrqRaiser) RaiseIRQ(irq int) { f.raised = append(f.raised, irq) }
+func (f *fakeIrqRaiser) LowerIRQ(irq int) { f.lowered = append(f.lowered, irq) }
+
+// TestTwoSerialPortsHaveIndependentStateAndIrqLines checks that COM1 and
+// COM2 instances, registered at their conventional bases and IRQs, keep
+// entirely independent registers, backends, and interrupt lines: writing
+// to one never becomes visible on the other, and each raises only its own
+// IRQ.
+func TestTwoSerialPortsHaveIndependentStateAndIrqLines(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	clock := NewManualClock(time.Unix(0, 0))
+
+	com1 := NewSerialPortDevice(clock, &out1)
+	raiser := &fakeIrqRaiser{}
+	com1.SetIrqRaiser(raiser, comPort1Irq)
+
+	com2 := NewSerialPortDeviceAt(comPort2Base, clock, &out2)
+	com2.SetIrqRaiser(raiser, comPort2Irq)
+
+	// A byte written to COM1's THR must reach only out1, and a byte
+	// received on COM2 must reach only out2's backend's RX FIFO.
+	for _, b := range []byte("com1\n") {
+		if err := com1.HandleIO(comPort1Base+uartRegDataOrDivisorLo, []byte{b}, true); err != nil {
+			t.Fatalf("com1 write: %v", err)
+		}
+	}
+	for _, b := range []byte("com2\n") {
+		if err := com2.HandleIO(comPort2Base+uartRegDataOrDivisorLo, []byte{b}, true); err != nil {
+			t.Fatalf("com2 write: %v", err)
+		}
+	}
+	if out1.String() != "com1\n" {
+		t.Errorf("out1 = %q, want %q", out1.String(), "com1\n")
+	}
+	if out2.String() != "com2\n" {
+		t.Errorf("out2 = %q, want %q", out2.String(), "com2\n")
+	}
+
+	// Enable RX-available interrupts on both, then feed a byte only to
+	// COM2: only IRQ3 should be raised, never IRQ4.
+	if err := com1.HandleIO(comPort1Base+uartRegIERorDivisorHi, []byte{ierRxAvailable}, true); err != nil {
+		t.Fatalf("com1 IER write: %v", err)
+	}
+	if err := com2.HandleIO(comPort2Base+uartRegIERorDivisorHi, []byte{ierRxAvailable}, true); err != nil {
+		t.Fatalf("com2 IER write: %v", err)
+	}
+	com2.ReceiveByte('x')
+
+	if len(raiser.raised) != 1 || raiser.raised[0] != comPort2Irq {
+		t.Errorf("raised = %v, want exactly [%d]", raiser.raised, comPort2Irq)
+	}
+
+	// Reading COM2's data register clears its condition and lowers IRQ3;
+	// COM1 must remain untouched throughout.
+	buf := []byte{0}
+	if err := com2.HandleIO(comPort2Base+uartRegDataOrDivisorLo, buf, false); err != nil {
+		t.Fatalf("com2 read: %v", err)
+	}
+	if buf[0] != 'x' {
+		t.Errorf("com2 read byte = %q, want 'x'", buf[0])
+	}
+	if len(raiser.lowered) != 1 || raiser.lowered[0] != comPort2Irq {
+		t.Errorf("lowered = %v, want exactly [%d]", raiser.lowered, comPort2Irq)
+	}
+	if got := serialReadReg(t, com1, uartRegLSR); got&lsrDataReady != 0 {
+		t.Errorf("com1 LSR data-ready = %#x, want 0 (COM2's RX byte must not cross over)", got)
+	}
+}
+
+// countingWriter counts Write calls and total bytes, standing in for a
+// network connection or other writer where syscall count matters.
+type countingWriter struct {
+	writes int
+	bytes  int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.bytes += len(p)
+	return len(p), nil
+}
+
+func TestSerialPortTransmitFlushesOnNewline(t *testing.T) {
+	var out bytes.Buffer
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), &out)
+
+	serialWriteReg(t, s, uartRegDataOrDivisorLo, 'h')
+	serialWriteReg(t, s, uartRegDataOrDivisorLo, 'i')
+	if out.Len() != 0 {
+		t.Fatalf("output = %q, want nothing flushed before a newline", out.String())
+	}
+
+	serialWriteReg(t, s, uartRegDataOrDivisorLo, '\n')
+	if out.String() != "hi\n" {
+		t.Errorf("output = %q, want %q", out.String(), "hi\n")
+	}
+	if lsr := serialReadReg(t, s, uartRegLSR); lsr&lsrTHREmpty == 0 {
+		t.Errorf("LSR = %#x, want THRE set", lsr)
+	}
+}
+
+func TestSerialPortFlushesOnSizeThreshold(t *testing.T) {
+	cw := &countingWriter{}
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), cw)
+
+	for i := 0; i < outputFlushThreshold; i++ {
+		serialWriteReg(t, s, uartRegDataOrDivisorLo, 'x')
+	}
+	if cw.writes != 1 || cw.bytes != outputFlushThreshold {
+		t.Errorf("writes=%d bytes=%d, want a single write of %d bytes", cw.writes, cw.bytes, outputFlushThreshold)
+	}
+}
+
+func TestSerialPortFlushesOnTimer(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cw := &countingWriter{}
+	s := NewSerialPortDevice(clock, cw)
+
+	serialWriteReg(t, s, uartRegDataOrDivisorLo, 'x') // no newline, below the size threshold
+	if cw.writes != 0 {
+		t.Fatalf("writes = %d, want 0 before the flush interval elapses", cw.writes)
+	}
+
+	clock.Advance(outputFlushInterval)
+	serialReadReg(t, s, uartRegSCR) // any register access re-checks the timer; SCR isolates it from LSR's unconditional flush
+	if cw.writes != 1 {
+		t.Errorf("writes = %d, want 1 once the flush interval has elapsed", cw.writes)
+	}
+}
+
+func TestSerialPortStrictModeFlushesEveryByte(t *testing.T) {
+	cw := &countingWriter{}
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), cw)
+	s.SetStrictMode(true)
+
+	for _, b := range []byte("hi") {
+		serialWriteReg(t, s, uartRegDataOrDivisorLo, b)
+	}
+	if cw.writes != 2 {
+		t.Errorf("writes = %d, want 2 (one per byte in strict mode)", cw.writes)
+	}
+}
+
+func TestSerialPortInterleavedLSRPollingPreservesOrder(t *testing.T) {
+	var out bytes.Buffer
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), &out)
+
+	want := []byte("hello, kernel boot log")
+	for i, b := range want {
+		serialWriteReg(t, s, uartRegDataOrDivisorLo, b)
+		if i%3 == 0 {
+			serialReadReg(t, s, uartRegLSR) // a guest polling TEMT mid-stream
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if out.String() != string(want) {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestSerialPortRxTriggerLevelRaisesInterrupt(t *testing.T) {
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), nil)
+	serialWriteReg(t, s, uartRegIERorDivisorHi, ierRxAvailable)
+	serialWriteReg(t, s, uartRegIIRorFCR, fcrFIFOEnable|fcrRxTriggerHi) // trigger level 8
+
+	for i := 0; i < 7; i++ {
+		s.ReceiveByte(byte('a' + i))
+	}
+	if iir := serialReadReg(t, s, uartRegIIRorFCR); iir&0x0f == iirRxAvailable {
+		t.Fatalf("IIR = %#x, RDA interrupt fired before reaching the trigger level", iir)
+	}
+
+	s.ReceiveByte('h') // 8th byte reaches the trigger level
+
+	iir := serialReadReg(t, s, uartRegIIRorFCR)
+	if id := iir & 0x0f; id != iirRxAvailable {
+		t.Errorf("IIR interrupt id = %#x, want %#x (RDA)", id, iirRxAvailable)
+	}
+	if iir&iirFIFOEnabledBit != iirFIFOEnabledBit {
+		t.Errorf("IIR = %#x, want FIFO-enabled bits set", iir)
+	}
+	if lsr := serialReadReg(t, s, uartRegLSR); lsr&lsrDataReady == 0 {
+		t.Errorf("LSR = %#x, want DR set with bytes queued", lsr)
+	}
+}
+
+func TestSerialPortCharacterTimeoutWithManualClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewSerialPortDevice(clock, nil)
+	serialWriteReg(t, s, uartRegIERorDivisorHi, ierRxAvailable)
+	serialWriteReg(t, s, uartRegIIRorFCR, fcrFIFOEnable|fcrRxTriggerHi) // trigger level 8
+
+	s.ReceiveByte('x') // one byte, well below the trigger level of 8
+
+	if iir := serialReadReg(t, s, uartRegIIRorFCR); iir&0x0f == iirCharTimeout {
+		t.Fatalf("IIR = %#x, character timeout fired before 4 character times elapsed", iir)
+	}
+
+	s.mu.Lock()
+	charTime := s.charDurationLocked()
+	s.mu.Unlock()
+	clock.Advance(4 * charTime)
+
+	iir := serialReadReg(t, s, uartRegIIRorFCR)
+	if id := iir & 0x0f; id != iirCharTimeout {
+		t.Errorf("IIR interrupt id = %#x, want %#x (character timeout)", id, iirCharTimeout)
+	}
+
+	// Reading RBR drains the FIFO and resets the timeout window.
+	if b := serialReadReg(t, s, uartRegDataOrDivisorLo); b != 'x' {
+		t.Errorf("RBR = %q, want 'x'", b)
+	}
+	if iir := serialReadReg(t, s, uartRegIIRorFCR); iir&0x0f != iirNoInterrupt {
+		t.Errorf("IIR interrupt id = %#x, want %#x (none) once the FIFO is drained", iir&0x0f, iirNoInterrupt)
+	}
+}
+
+func TestSerialPortFIFODisabledUsesSingleByteBuffering(t *testing.T) {
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), nil)
+
+	s.ReceiveByte('a')
+	s.ReceiveByte('b') // dropped: FIFO disabled means a capacity of 1
+
+	if iir := serialReadReg(t, s, uartRegIIRorFCR); iir&iirFIFOEnabledBit != 0 {
+		t.Errorf("IIR = %#x, want FIFO-enabled bits clear", iir)
+	}
+	if b := serialReadReg(t, s, uartRegDataOrDivisorLo); b != 'a' {
+		t.Errorf("RBR = %q, want 'a'", b)
+	}
+}
+
+func TestSerialPortDivisorLatchRoundTrips(t *testing.T) {
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), nil)
+
+	serialWriteReg(t, s, uartRegLCR, lcrDLAB)
+	serialWriteReg(t, s, uartRegDataOrDivisorLo, 0x01)
+	serialWriteReg(t, s, uartRegIERorDivisorHi, 0x02)
+	serialWriteReg(t, s, uartRegLCR, 0) // clear DLAB
+
+	serialWriteReg(t, s, uartRegLCR, lcrDLAB)
+	if got := serialReadReg(t, s, uartRegDataOrDivisorLo); got != 0x01 {
+		t.Errorf("DLL = %#x, want 0x01", got)
+	}
+	if got := serialReadReg(t, s, uartRegIERorDivisorHi); got != 0x02 {
+		t.Errorf("DLM = %#x, want 0x02", got)
+	}
+}
+
+func TestVirtualMachineCloseFlushesSerialOutput(t *testing.T) {
+	var out bytes.Buffer
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), &out)
+
+	vm := &VirtualMachine{IOBus: NewIOBus()}
+	if err := vm.RegisterDevice(s); err != nil {
+		t.Fatalf("RegisterDevice: %v", err)
+	}
+
+	serialWriteReg(t, s, uartRegDataOrDivisorLo, 'x') // no newline: stays batched
+	if out.Len() != 0 {
+		t.Fatalf("output = %q, want nothing flushed yet", out.String())
+	}
+
+	if err := vm.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if out.String() != "x" {
+		t.Errorf("output = %q, want %q after Close", out.String(), "x")
+	}
+}
+
+// bootLogLine is representative of one line of guest kernel boot output,
+// the workload BenchmarkSerialPort{Batched,Strict}Writes measure.
+const bootLogLine = "[    0.123456] initializing subsystem foo: ok\n"
+
+func BenchmarkSerialPortBatchedWrites(b *testing.B) {
+	cw := &countingWriter{}
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), cw)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(bootLogLine); j++ {
+			if err := s.HandleIO(comPort1Base+uartRegDataOrDivisorLo, []byte{bootLogLine[j]}, true); err != nil {
+				b.Fatalf("HandleIO: %v", err)
+			}
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(cw.writes)/float64(b.N), "writes/op")
+}
+
+func BenchmarkSerialPortStrictWrites(b *testing.B) {
+	cw := &countingWriter{}
+	s := NewSerialPortDevice(NewManualClock(time.Unix(0, 0)), cw)
+	s.SetStrictMode(true)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(bootLogLine); j++ {
+			if err := s.HandleIO(comPort1Base+uartRegDataOrDivisorLo, []byte{bootLogLine[j]}, true); err != nil {
+				b.Fatalf("HandleIO: %v", err)
+			}
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(cw.writes)/float64(b.N), "writes/op")
+}
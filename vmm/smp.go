@@ -0,0 +1,93 @@
+package vmm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// apState tracks which of this VM's non-BSP vCPUs are still parked,
+// awaiting StartAP. The BSP (vcpu index 0) is never parked.
+type apState struct {
+	mu     sync.Mutex
+	parked []bool // indexed by vcpu ID; parked[0] is always false
+}
+
+// newAPState returns an apState with every AP (vcpu index 1 and above)
+// parked, matching real hardware: only the BSP begins executing at
+// power-on, and every AP waits for INIT/SIPI. A single-vCPU VM has no
+// entries, so it's unaffected.
+func newAPState(numVCPUs int) *apState {
+	parked := make([]bool, numVCPUs)
+	for i := 1; i < numVCPUs; i++ {
+		parked[i] = true
+	}
+	return &apState{parked: parked}
+}
+
+// APParked reports whether vcpuID is still parked awaiting StartAP. It is
+// always false for the BSP (vcpu 0) and for an out-of-range vcpuID. A
+// caller's per-vCPU run loop should check this before calling KVM_RUN for
+// a given vCPU, so an AP doesn't execute from whatever state
+// KVM_CREATE_VCPU happened to leave it in before StartAP has run.
+func (vm *VirtualMachine) APParked(vcpuID int) bool {
+	if vm.apState == nil || vcpuID <= 0 || vcpuID >= len(vm.apState.parked) {
+		return false
+	}
+	vm.apState.mu.Lock()
+	defer vm.apState.mu.Unlock()
+	return vm.apState.parked[vcpuID]
+}
+
+// StartAP releases the parked AP at vcpuID (1-based; the BSP is 0 and is
+// never parked), first setting its CS:IP the way a real Startup IPI does:
+// CS.Selector = startVector<<8 and CS.Base = startVector<<12, with RIP/IP
+// at 0, so the AP begins executing at physical address startVector*0x1000
+// in real mode. It's exposed directly here — rather than only reachable
+// through APIC ICR emulation — so it can be driven by a future local
+// APIC's Startup delivery mode or called directly by a boot loader/test
+// that doesn't need one.
+func (vm *VirtualMachine) StartAP(vcpuID int, startVector uint8) error {
+	if vm.apState == nil || vcpuID <= 0 || vcpuID >= len(vm.apState.parked) {
+		return fmt.Errorf("vmm: StartAP: vcpu %d out of range (BSP is 0, %d vcpus total)", vcpuID, len(vm.vcpus))
+	}
+
+	vm.apState.mu.Lock()
+	defer vm.apState.mu.Unlock()
+	if !vm.apState.parked[vcpuID] {
+		return fmt.Errorf("vmm: StartAP: vcpu %d is not parked (already started)", vcpuID)
+	}
+
+	vcpu := vm.vcpus[vcpuID]
+	if err := setSIPIStateLocked(vcpu, startVector); err != nil {
+		return fmt.Errorf("vmm: StartAP: %w", err)
+	}
+	vm.apState.parked[vcpuID] = false
+	return nil
+}
+
+// setSIPIStateLocked applies the SIPI vector convention to vcpu: CS
+// selector and base point at segment startVector, and RIP is 0, so guest
+// execution resumes at physical address uint64(startVector)<<12.
+func setSIPIStateLocked(vcpu hypervisor.VCPU, startVector uint8) error {
+	sregs, err := vcpu.GetSregs()
+	if err != nil {
+		return fmt.Errorf("GetSregs: %w", err)
+	}
+	sregs.CS.Selector = uint16(startVector) << 8
+	sregs.CS.Base = uint64(startVector) << 12
+	if err := vcpu.SetSregs(sregs); err != nil {
+		return fmt.Errorf("SetSregs: %w", err)
+	}
+
+	regs, err := vcpu.GetRegs()
+	if err != nil {
+		return fmt.Errorf("GetRegs: %w", err)
+	}
+	regs.RIP = 0
+	if err := vcpu.SetRegs(regs); err != nil {
+		return fmt.Errorf("SetRegs: %w", err)
+	}
+	return nil
+}
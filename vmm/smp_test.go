@@ -0,0 +1,91 @@
+package vmm
+
+import (
+	"testing"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+func newSMPTestVM(numVCPUs int) (*VirtualMachine, []*hypervisor.FakeVCPU) {
+	vcpus := make([]hypervisor.VCPU, numVCPUs)
+	fakes := make([]*hypervisor.FakeVCPU, numVCPUs)
+	for i := range vcpus {
+		f := &hypervisor.FakeVCPU{}
+		vcpus[i] = f
+		fakes[i] = f
+	}
+	return NewVirtualMachine(vcpus, nil), fakes
+}
+
+// TestSingleVCPUBSPIsNeverParked checks that NumVCPUs==1 leaves the BSP
+// unparked, so single-vCPU behavior is unchanged by this scaffolding.
+func TestSingleVCPUBSPIsNeverParked(t *testing.T) {
+	vm, _ := newSMPTestVM(1)
+	if vm.APParked(0) {
+		t.Error("APParked(0) = true for the BSP, want false")
+	}
+}
+
+// TestAPsStartParkedAndReleaseOnStartAP checks the AP park/release state
+// machine: every AP begins parked, StartAP releases exactly the one it's
+// called on, and it sets that AP's CS:IP per the SIPI vector convention.
+func TestAPsStartParkedAndReleaseOnStartAP(t *testing.T) {
+	vm, fakes := newSMPTestVM(3)
+
+	if vm.APParked(0) {
+		t.Error("APParked(0) = true for the BSP, want false")
+	}
+	if !vm.APParked(1) || !vm.APParked(2) {
+		t.Errorf("APParked(1)=%v APParked(2)=%v, want both true before StartAP", vm.APParked(1), vm.APParked(2))
+	}
+
+	if err := vm.StartAP(2, 0x12); err != nil {
+		t.Fatalf("StartAP: %v", err)
+	}
+
+	if vm.APParked(2) {
+		t.Error("APParked(2) = true after StartAP, want false")
+	}
+	if !vm.APParked(1) {
+		t.Error("APParked(1) = false, want true (StartAP must not release other APs)")
+	}
+
+	sregs, err := fakes[2].GetSregs()
+	if err != nil {
+		t.Fatalf("GetSregs: %v", err)
+	}
+	if sregs.CS.Selector != 0x12<<8 {
+		t.Errorf("CS.Selector = %#x, want %#x", sregs.CS.Selector, uint16(0x12)<<8)
+	}
+	if sregs.CS.Base != 0x12<<12 {
+		t.Errorf("CS.Base = %#x, want %#x", sregs.CS.Base, uint64(0x12)<<12)
+	}
+	regs, err := fakes[2].GetRegs()
+	if err != nil {
+		t.Fatalf("GetRegs: %v", err)
+	}
+	if regs.RIP != 0 {
+		t.Errorf("RIP = %#x, want 0", regs.RIP)
+	}
+}
+
+// TestStartAPRejectsBSPAndAlreadyStarted checks StartAP refuses to target
+// the BSP and refuses to release an AP that isn't parked.
+func TestStartAPRejectsBSPAndAlreadyStarted(t *testing.T) {
+	vm, _ := newSMPTestVM(2)
+
+	if err := vm.StartAP(0, 0x10); err == nil {
+		t.Error("StartAP(0, ...) = nil error, want one (BSP can't be started)")
+	}
+
+	if err := vm.StartAP(1, 0x10); err != nil {
+		t.Fatalf("StartAP: %v", err)
+	}
+	if err := vm.StartAP(1, 0x10); err == nil {
+		t.Error("second StartAP(1, ...) = nil error, want one (already started)")
+	}
+
+	if err := vm.StartAP(5, 0x10); err == nil {
+		t.Error("StartAP with an out-of-range vcpu ID = nil error, want one")
+	}
+}
@@ -0,0 +1,78 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// StatefulDevice is implemented by devices whose emulated register state
+// must survive a VirtualMachine.Snapshot/RestoreSnapshot round trip. A
+// device that only holds derived or wiring state (e.g. a host TAP fd, or
+// a PCI BAR that's reprogrammed identically on every boot) has no need to
+// implement it.
+type StatefulDevice interface {
+	PioDevice
+
+	// StateName identifies this device's state in a snapshot's manifest,
+	// e.g. "pit" or "ne2000". It should stay stable across code changes
+	// even if the underlying Go type is renamed, since it's what
+	// RestoreSnapshot matches a stream's saved state back to a live
+	// device by.
+	StateName() string
+
+	// StateVersion is the wire format version SaveState currently writes
+	// and LoadState currently accepts. Bump it whenever SaveState's
+	// output shape changes, so RestoreSnapshot can refuse a snapshot
+	// written by an incompatible build instead of misinterpreting it.
+	StateVersion() uint32
+
+	// SaveState writes this device's state to w, in StateVersion's
+	// format.
+	SaveState(w io.Writer) error
+
+	// LoadState replaces this device's state with what SaveState wrote,
+	// reading exactly the bytes RestoreSnapshot frames for it. version is
+	// the version the snapshot was written with, already checked by the
+	// caller against StateVersion().
+	LoadState(r io.Reader, version uint32) error
+}
+
+// writeSnapshotBytes writes a length-prefixed byte slice, for a device's
+// variable-length state (e.g. a FIFO or output buffer whose length isn't
+// fixed by the wire format).
+func writeSnapshotBytes(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readSnapshotBytes reads back what writeSnapshotBytes wrote.
+func readSnapshotBytes(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeSnapshotString writes a length-prefixed string, used for a
+// snapshot's per-device name.
+func writeSnapshotString(w io.Writer, s string) error {
+	return writeSnapshotBytes(w, []byte(s))
+}
+
+// readSnapshotString reads back what writeSnapshotString wrote.
+func readSnapshotString(r io.Reader) (string, error) {
+	b, err := readSnapshotBytes(r)
+	if err != nil {
+		return "", fmt.Errorf("vmm: reading snapshot string: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,384 @@
+package vmm
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats accumulates per-VM counters cheaply enough to update on hot
+// paths: IOBus dispatch, the NE2000 TX/RX paths, and (once a caller
+// decodes kvm_run) the VCPU exit loop. Every counter is bumped with
+// sync/atomic rather than a mutex; a sync.Map only takes its internal
+// lock the first time a given key (exit reason, port) is seen, not on
+// every subsequent increment. A nil *Stats silently discards every
+// Record call, so devices can hold one unconditionally.
+type Stats struct {
+	exitReasons sync.Map // uint32 exit reason -> *int64
+	ioAccesses  sync.Map // uint16 port -> *int64
+
+	unhandledIO   sync.Map // UnhandledAccessKey -> *int64
+	unhandledMMIO sync.Map // UnhandledAccessKey -> *int64
+
+	nicTxPackets int64
+	nicTxBytes   int64
+	nicRxPackets int64
+	nicRxBytes   int64
+
+	reclaimedRanges int64
+	reclaimedBytes  int64
+
+	// maxTimerLatenessNanos and ticksCoalesced are CatchUpTimer's drift
+	// observations: the worst lateness any timer has reported firing
+	// with, and the total number of periods that were folded into a
+	// single callback (CatchUpSkip) or dropped by a burst cap
+	// (CatchUpBurst) rather than each getting their own callback.
+	maxTimerLatenessNanos int64
+	ticksCoalesced        int64
+
+	// Clock times the in-guest/handling-exits split RecordVCPURunStart and
+	// RecordVCPUExit maintain per VCPU. Nil (the default) uses RealClock;
+	// tests wanting a deterministic split set it to a ManualClock instead.
+	Clock Clock
+
+	vcpuStats sync.Map // int vcpuIndex -> *vcpuCounters
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats { return &Stats{} }
+
+func (s *Stats) clock() Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+	return RealClock{}
+}
+
+func bumpKeyedCounter(m *sync.Map, key any) {
+	if v, ok := m.Load(key); ok {
+		atomic.AddInt64(v.(*int64), 1)
+		return
+	}
+	n := new(int64)
+	*n = 1
+	if actual, loaded := m.LoadOrStore(key, n); loaded {
+		atomic.AddInt64(actual.(*int64), 1)
+	}
+}
+
+// RecordExit counts one VCPU exit of the given kvm_run exit_reason.
+func (s *Stats) RecordExit(reason uint32) {
+	if s == nil {
+		return
+	}
+	bumpKeyedCounter(&s.exitReasons, reason)
+}
+
+// RecordIOAccess counts one IOBus dispatch to port.
+func (s *Stats) RecordIOAccess(port uint16) {
+	if s == nil {
+		return
+	}
+	bumpKeyedCounter(&s.ioAccesses, port)
+}
+
+// RecordUnhandledIOAccess counts one IOBus.Dispatch call that found no
+// device registered for port/write, independently of whether IOBus's
+// rate-limited logging (SetUnhandledLogPolicy) is enabled.
+func (s *Stats) RecordUnhandledIOAccess(port uint16, write bool) {
+	if s == nil {
+		return
+	}
+	bumpKeyedCounter(&s.unhandledIO, UnhandledAccessKey{Addr: uint64(port), Write: write})
+}
+
+// RecordUnhandledMMIOAccess counts one MmioBus.Dispatch call that found no
+// device registered for addr/write, independently of whether MmioBus's
+// rate-limited logging (SetUnhandledLogPolicy) is enabled.
+func (s *Stats) RecordUnhandledMMIOAccess(addr uint64, write bool) {
+	if s == nil {
+		return
+	}
+	bumpKeyedCounter(&s.unhandledMMIO, UnhandledAccessKey{Addr: addr, Write: write})
+}
+
+// RecordNICTx counts one transmitted frame of n bytes.
+func (s *Stats) RecordNICTx(n int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.nicTxPackets, 1)
+	atomic.AddInt64(&s.nicTxBytes, int64(n))
+}
+
+// RecordNICRx counts one received frame of n bytes.
+func (s *Stats) RecordNICRx(n int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.nicRxPackets, 1)
+	atomic.AddInt64(&s.nicRxBytes, int64(n))
+}
+
+// RecordReclaim counts one VirtualMachine.ReclaimRange call that freed n
+// bytes of guest memory back to the host kernel.
+func (s *Stats) RecordReclaim(n int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.reclaimedRanges, 1)
+	atomic.AddInt64(&s.reclaimedBytes, n)
+}
+
+// RecordTimerDrift records one CatchUpTimer firing: lateness is how far
+// past its ideal deadline the callback ran, and coalesced is how many
+// periods it folded into that single firing instead of delivering
+// individually. Only the maximum lateness ever observed is kept;
+// coalesced periods accumulate.
+func (s *Stats) RecordTimerDrift(lateness time.Duration, coalesced int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.ticksCoalesced, coalesced)
+	for {
+		cur := atomic.LoadInt64(&s.maxTimerLatenessNanos)
+		if int64(lateness) <= cur {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&s.maxTimerLatenessNanos, cur, int64(lateness)) {
+			return
+		}
+	}
+}
+
+// vcpuCounters is one VCPU's CPU-time bookkeeping: how many times it has
+// entered KVM_RUN, how long it has spent inside the guest versus handling
+// the exit that interrupted it, and a histogram of exit reasons. Like
+// vcpuWatchState, its fields are only ever touched from the single
+// goroutine driving that VCPU's KVM_RUN loop, except for mu, which
+// guards it against a concurrent VCPUSnapshot call.
+type vcpuCounters struct {
+	mu sync.Mutex
+
+	runCount    int64
+	exitReasons map[uint32]int64
+
+	inGuestTime       time.Duration
+	handlingExitsTime time.Duration
+
+	phaseStarted time.Time
+	havePhase    bool
+}
+
+func (s *Stats) vcpuCountersFor(vcpuIndex int) *vcpuCounters {
+	v, ok := s.vcpuStats.Load(vcpuIndex)
+	if !ok {
+		v, _ = s.vcpuStats.LoadOrStore(vcpuIndex, &vcpuCounters{})
+	}
+	return v.(*vcpuCounters)
+}
+
+// RecordVCPURunStart marks vcpuIndex as having just entered KVM_RUN,
+// counting one more KVM_RUN invocation and, if this isn't the VCPU's
+// first phase transition, crediting the time since its last exit to
+// HandlingExitsTime.
+func (s *Stats) RecordVCPURunStart(vcpuIndex int) {
+	if s == nil {
+		return
+	}
+	now := s.clock().Now()
+	c := s.vcpuCountersFor(vcpuIndex)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runCount++
+	if c.havePhase {
+		c.handlingExitsTime += now.Sub(c.phaseStarted)
+	}
+	c.phaseStarted = now
+	c.havePhase = true
+}
+
+// RecordVCPUExit counts one exit of the given kvm_run exit_reason for
+// vcpuIndex, crediting the time since its last RecordVCPURunStart call to
+// InGuestTime. Calling it without a preceding RecordVCPURunStart (e.g.
+// before the VCPU has ever run) is harmless: it just starts the
+// handling-exits phase with no in-guest time credited.
+func (s *Stats) RecordVCPUExit(vcpuIndex int, reason uint32) {
+	if s == nil {
+		return
+	}
+	now := s.clock().Now()
+	c := s.vcpuCountersFor(vcpuIndex)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.havePhase {
+		c.inGuestTime += now.Sub(c.phaseStarted)
+	}
+	if c.exitReasons == nil {
+		c.exitReasons = make(map[uint32]int64)
+	}
+	c.exitReasons[reason]++
+	c.phaseStarted = now
+	c.havePhase = true
+}
+
+// VCPUStats is a point-in-time copy of one VCPU's CPU-time counters, safe
+// to read without further synchronization.
+type VCPUStats struct {
+	RunCount          int64
+	ExitReasons       map[uint32]int64
+	InGuestTime       time.Duration
+	HandlingExitsTime time.Duration
+}
+
+// VCPUSnapshot copies out vcpuIndex's current counters. A VCPU that has
+// never run (RecordVCPURunStart/RecordVCPUExit not yet called for it)
+// reports the zero value.
+func (s *Stats) VCPUSnapshot(vcpuIndex int) VCPUStats {
+	snap := VCPUStats{ExitReasons: map[uint32]int64{}}
+	if s == nil {
+		return snap
+	}
+	v, ok := s.vcpuStats.Load(vcpuIndex)
+	if !ok {
+		return snap
+	}
+	c := v.(*vcpuCounters)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap.RunCount = c.runCount
+	snap.InGuestTime = c.inGuestTime
+	snap.HandlingExitsTime = c.handlingExitsTime
+	for k, v := range c.exitReasons {
+		snap.ExitReasons[k] = v
+	}
+	return snap
+}
+
+// StatsSnapshot is a point-in-time copy of a Stats, safe to read without
+// further synchronization.
+type StatsSnapshot struct {
+	ExitReasons     map[uint32]int64
+	IOAccesses      map[uint16]int64
+	UnhandledIO     map[UnhandledAccessKey]int64
+	UnhandledMMIO   map[UnhandledAccessKey]int64
+	NICTxPackets    int64
+	NICTxBytes      int64
+	NICRxPackets    int64
+	NICRxBytes      int64
+	ReclaimedRanges int64
+	ReclaimedBytes  int64
+
+	MaxTimerLateness time.Duration
+	TicksCoalesced   int64
+}
+
+// Snapshot copies out the current counter values.
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		ExitReasons:   map[uint32]int64{},
+		IOAccesses:    map[uint16]int64{},
+		UnhandledIO:   map[UnhandledAccessKey]int64{},
+		UnhandledMMIO: map[UnhandledAccessKey]int64{},
+	}
+	if s == nil {
+		return snap
+	}
+	s.exitReasons.Range(func(k, v any) bool {
+		snap.ExitReasons[k.(uint32)] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	s.ioAccesses.Range(func(k, v any) bool {
+		snap.IOAccesses[k.(uint16)] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	s.unhandledIO.Range(func(k, v any) bool {
+		snap.UnhandledIO[k.(UnhandledAccessKey)] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	s.unhandledMMIO.Range(func(k, v any) bool {
+		snap.UnhandledMMIO[k.(UnhandledAccessKey)] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	snap.NICTxPackets = atomic.LoadInt64(&s.nicTxPackets)
+	snap.NICTxBytes = atomic.LoadInt64(&s.nicTxBytes)
+	snap.NICRxPackets = atomic.LoadInt64(&s.nicRxPackets)
+	snap.NICRxBytes = atomic.LoadInt64(&s.nicRxBytes)
+	snap.ReclaimedRanges = atomic.LoadInt64(&s.reclaimedRanges)
+	snap.ReclaimedBytes = atomic.LoadInt64(&s.reclaimedBytes)
+	snap.MaxTimerLateness = time.Duration(atomic.LoadInt64(&s.maxTimerLatenessNanos))
+	snap.TicksCoalesced = atomic.LoadInt64(&s.ticksCoalesced)
+	return snap
+}
+
+// sortedUnhandled returns m's entries ordered by address then direction,
+// so WriteProm's output is stable across runs.
+func sortedUnhandled(m map[UnhandledAccessKey]int64) []UnhandledAccessStats {
+	out := make([]UnhandledAccessStats, 0, len(m))
+	for k, v := range m {
+		out = append(out, UnhandledAccessStats{Key: k, Count: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Key.Addr != out[j].Key.Addr {
+			return out[i].Key.Addr < out[j].Key.Addr
+		}
+		return !out[i].Key.Write && out[j].Key.Write
+	})
+	return out
+}
+
+// WriteProm renders snap in Prometheus text exposition format.
+func (snap StatsSnapshot) WriteProm(w http.ResponseWriter) {
+	fmt.Fprintln(w, "# TYPE vmm_vcpu_exits_total counter")
+	reasons := make([]uint32, 0, len(snap.ExitReasons))
+	for r := range snap.ExitReasons {
+		reasons = append(reasons, r)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	for _, r := range reasons {
+		fmt.Fprintf(w, "vmm_vcpu_exits_total{reason=\"%d\"} %d\n", r, snap.ExitReasons[r])
+	}
+
+	fmt.Fprintln(w, "# TYPE vmm_io_accesses_total counter")
+	ports := make([]uint16, 0, len(snap.IOAccesses))
+	for p := range snap.IOAccesses {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	for _, p := range ports {
+		fmt.Fprintf(w, "vmm_io_accesses_total{port=\"%#x\"} %d\n", p, snap.IOAccesses[p])
+	}
+
+	fmt.Fprintln(w, "# TYPE vmm_unhandled_io_accesses_total counter")
+	for _, rec := range sortedUnhandled(snap.UnhandledIO) {
+		dir := "in"
+		if rec.Key.Write {
+			dir = "out"
+		}
+		fmt.Fprintf(w, "vmm_unhandled_io_accesses_total{port=\"%#x\",dir=\"%s\"} %d\n", rec.Key.Addr, dir, rec.Count)
+	}
+
+	fmt.Fprintln(w, "# TYPE vmm_unhandled_mmio_accesses_total counter")
+	for _, rec := range sortedUnhandled(snap.UnhandledMMIO) {
+		dir := "load"
+		if rec.Key.Write {
+			dir = "store"
+		}
+		fmt.Fprintf(w, "vmm_unhandled_mmio_accesses_total{addr=\"%#x\",dir=\"%s\"} %d\n", rec.Key.Addr, dir, rec.Count)
+	}
+
+	fmt.Fprintf(w, "# TYPE vmm_nic_tx_packets_total counter\nvmm_nic_tx_packets_total %d\n", snap.NICTxPackets)
+	fmt.Fprintf(w, "# TYPE vmm_nic_tx_bytes_total counter\nvmm_nic_tx_bytes_total %d\n", snap.NICTxBytes)
+	fmt.Fprintf(w, "# TYPE vmm_nic_rx_packets_total counter\nvmm_nic_rx_packets_total %d\n", snap.NICRxPackets)
+	fmt.Fprintf(w, "# TYPE vmm_nic_rx_bytes_total counter\nvmm_nic_rx_bytes_total %d\n", snap.NICRxBytes)
+	fmt.Fprintf(w, "# TYPE vmm_reclaimed_ranges_total counter\nvmm_reclaimed_ranges_total %d\n", snap.ReclaimedRanges)
+	fmt.Fprintf(w, "# TYPE vmm_reclaimed_bytes_total counter\nvmm_reclaimed_bytes_total %d\n", snap.ReclaimedBytes)
+	fmt.Fprintf(w, "# TYPE vmm_timer_max_lateness_seconds gauge\nvmm_timer_max_lateness_seconds %g\n", snap.MaxTimerLateness.Seconds())
+	fmt.Fprintf(w, "# TYPE vmm_timer_ticks_coalesced_total counter\nvmm_timer_ticks_coalesced_total %d\n", snap.TicksCoalesced)
+}
@@ -0,0 +1,127 @@
+package vmm
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatsRecordIOAccessViaIOBusDispatch(t *testing.T) {
+	stats := NewStats()
+	bus := NewIOBus()
+	bus.SetStats(stats)
+
+	dev := newTestNE2000(nil)
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	port := dev.base + ne2000RegISR
+	for i := 0; i < 3; i++ {
+		buf := []byte{0}
+		if err := bus.Dispatch(port, buf, false); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+	}
+
+	snap := stats.Snapshot()
+	if got, want := snap.IOAccesses[port], int64(3); got != want {
+		t.Errorf("IOAccesses[%#x] = %d, want %d", port, got, want)
+	}
+}
+
+func TestStatsRecordIOAccessCountsUnhandledPorts(t *testing.T) {
+	stats := NewStats()
+	bus := NewIOBus()
+	bus.SetStats(stats)
+
+	buf := []byte{0}
+	_ = bus.Dispatch(0x1234, buf, false)
+
+	snap := stats.Snapshot()
+	if got, want := snap.IOAccesses[0x1234], int64(1); got != want {
+		t.Errorf("IOAccesses[0x1234] = %d, want %d", got, want)
+	}
+}
+
+func TestStatsRecordNICTxViaNE2000Transmit(t *testing.T) {
+	stats := NewStats()
+	tap := &fakeTap{}
+	d := newTestNE2000(tap)
+	d.SetStats(stats)
+	ne2000Write(t, d, ne2000RegTCR, tcrCRC) // isolate padding from CRC appending
+
+	ne2000Write(t, d, ne2000RegCR, crSTP|crRD1) // remote write, page 0
+	ne2000Write(t, d, ne2000RegRSAR0, uint8(uint16(d.tpsr)*ne2000PageSize))
+	ne2000Write(t, d, ne2000RegRSAR1, uint8(uint16(d.tpsr)*ne2000PageSize>>8))
+	frame := []byte{0xde, 0xad, 0xbe, 0xef}
+	ne2000Write(t, d, ne2000RegRBCR0, uint8(len(frame)))
+	ne2000Write(t, d, ne2000RegRBCR1, 0)
+	for _, b := range frame {
+		ne2000Write(t, d, ne2000RegData, b)
+	}
+	ne2000Write(t, d, ne2000RegCR, crSTP|crTXP)
+
+	// frame is shorter than ne2000MinFrameSize, so the default padding
+	// behavior pads it up to 60 bytes on the wire before counting it.
+	snap := stats.Snapshot()
+	if got, want := snap.NICTxPackets, int64(1); got != want {
+		t.Errorf("NICTxPackets = %d, want %d", got, want)
+	}
+	if got, want := snap.NICTxBytes, int64(ne2000MinFrameSize); got != want {
+		t.Errorf("NICTxBytes = %d, want %d", got, want)
+	}
+}
+
+func TestStatsRecordExit(t *testing.T) {
+	stats := NewStats()
+	stats.RecordExit(1)
+	stats.RecordExit(1)
+	stats.RecordExit(2)
+
+	snap := stats.Snapshot()
+	if got, want := snap.ExitReasons[1], int64(2); got != want {
+		t.Errorf("ExitReasons[1] = %d, want %d", got, want)
+	}
+	if got, want := snap.ExitReasons[2], int64(1); got != want {
+		t.Errorf("ExitReasons[2] = %d, want %d", got, want)
+	}
+}
+
+func TestStatsNilReceiverIsANoOp(t *testing.T) {
+	var stats *Stats
+	stats.RecordExit(1)
+	stats.RecordIOAccess(0x60)
+	stats.RecordNICTx(4)
+	stats.RecordNICRx(4)
+
+	snap := stats.Snapshot()
+	if len(snap.ExitReasons) != 0 || len(snap.IOAccesses) != 0 {
+		t.Errorf("nil Stats snapshot should be empty, got %+v", snap)
+	}
+}
+
+func TestStatsSnapshotWriteProm(t *testing.T) {
+	stats := NewStats()
+	stats.RecordExit(2)
+	stats.RecordIOAccess(0x60)
+	stats.RecordNICTx(64)
+	stats.RecordNICRx(128)
+
+	rec := httptest.NewRecorder()
+	stats.Snapshot().WriteProm(rec)
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`vmm_vcpu_exits_total{reason="2"} 1`,
+		`vmm_io_accesses_total{port="0x60"} 1`,
+		"vmm_nic_tx_packets_total 1",
+		"vmm_nic_tx_bytes_total 64",
+		"vmm_nic_rx_packets_total 1",
+		"vmm_nic_rx_bytes_total 128",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("WriteProm output missing %q, got:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package vmm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// tunDevicePath is the Linux TUN/TAP control device every TAP interface
+// is created or attached through.
+const tunDevicePath = "/dev/net/tun"
+
+// TUNSETIFF and the if_tun.h flags needed to request a tap (Ethernet
+// frame, not IP packet) interface without the 4-byte packet-information
+// header Linux prepends by default. Values are taken from
+// linux/if_tun.h; this package avoids the golang.org/x/sys/unix
+// dependency in favor of the same raw syscall package the hypervisor
+// package uses for its ioctls.
+const (
+	iffTap        = 0x0002
+	iffNoPI       = 0x1000
+	iffMultiQueue = 0x0100
+	tunSetIff     = 0x400454ca // _IOW('T', 202, int)
+)
+
+// ifReq mirrors the part of Linux's struct ifreq that TUNSETIFF reads:
+// the interface name followed by a flags word, padded out to the
+// kernel's 40-byte struct ifreq so the ioctl doesn't read past the end
+// of a smaller buffer.
+type ifReq struct {
+	Name  [16]byte
+	Flags uint16
+	_     [22]byte
+}
+
+// TapDevice bridges an emulated NIC to a host TAP interface: WritePacket
+// implements HostNetInterface for the transmit side, and
+// ReadPacket/ReadPacketContext/StartRxLoop cover the receive side.
+type TapDevice struct {
+	f *os.File
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newTapDeviceFromFile wraps an already-open file descriptor as a
+// TapDevice, without going through the TUNSETIFF dance NewTapDevice
+// does. It exists so tests can substitute a socketpair fd for a real
+// tap interface, which requires a kernel driver and CAP_NET_ADMIN this
+// sandbox doesn't have.
+func newTapDeviceFromFile(f *os.File) *TapDevice {
+	return &TapDevice{f: f}
+}
+
+// NewTapDevice opens /dev/net/tun and attaches to (creating if
+// necessary) the host TAP interface named ifaceName.
+func NewTapDevice(ifaceName string) (*TapDevice, error) {
+	f, err := openTapQueue(ifaceName, iffTap|iffNoPI)
+	if err != nil {
+		return nil, err
+	}
+	return newTapDeviceFromFile(f), nil
+}
+
+// openTapQueue opens one /dev/net/tun file descriptor and attaches it to
+// ifaceName via TUNSETIFF with the given flags. NewTapDevice and
+// NewMultiQueueTapDevice both build on this; the only difference between
+// a single-queue and a multi-queue TAP is IFF_MULTI_QUEUE and how many
+// times it's called.
+func openTapQueue(ifaceName string, flags uint16) (*os.File, error) {
+	f, err := os.OpenFile(tunDevicePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("vmm: open %s: %w", tunDevicePath, err)
+	}
+
+	var req ifReq
+	copy(req.Name[:], ifaceName)
+	req.Flags = flags
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), tunSetIff, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("vmm: TUNSETIFF %s: %w", ifaceName, errno)
+	}
+	return f, nil
+}
+
+// WritePacket implements HostNetInterface.
+func (t *TapDevice) WritePacket(pkt []byte) error {
+	_, err := t.f.Write(pkt)
+	return err
+}
+
+// ReadPacket blocks until one frame arrives from the host interface.
+// Callers that need to be able to cancel a pending read should use
+// ReadPacketContext instead.
+func (t *TapDevice) ReadPacket() ([]byte, error) {
+	buf := make([]byte, 65536)
+	n, err := t.f.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// SetNonblock puts the underlying fd into non-blocking mode. It must be
+// called before ReadPacketContext, whose select/read loop relies on
+// reads returning EAGAIN rather than blocking when nothing is ready.
+func (t *TapDevice) SetNonblock() error {
+	return syscall.SetNonblock(int(t.f.Fd()), true)
+}
+
+// ReadPacketContext reads one frame, returning ctx.Err() promptly if ctx
+// is canceled before a frame arrives, instead of blocking forever inside
+// a raw Read the way ReadPacket does. It polls the fd with a short
+// timeout via syscall.Select, rather than blocking indefinitely, so a
+// canceled context is noticed between polls instead of waited out behind
+// one.
+func (t *TapDevice) ReadPacketContext(ctx context.Context) ([]byte, error) {
+	fd := int(t.f.Fd())
+	buf := make([]byte, 65536)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var readFDs syscall.FdSet
+		readFDs.Bits[fd/64] |= 1 << (uint(fd) % 64)
+		timeout := syscall.Timeval{Sec: 0, Usec: 100000} // 100ms: how promptly a cancellation is noticed
+		n, err := syscall.Select(fd+1, &readFDs, nil, nil, &timeout)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, fmt.Errorf("vmm: select on tap fd: %w", err)
+		}
+		if n == 0 {
+			continue // timed out with nothing ready; loop back to recheck ctx
+		}
+
+		nread, err := syscall.Read(fd, buf)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return nil, err
+		}
+		if nread == 0 {
+			return nil, io.EOF
+		}
+		return buf[:nread], nil
+	}
+}
+
+// StartRxLoop launches a goroutine that reads frames from the host
+// interface, delivering each to onFrame, until StopRxLoop is called.
+// Calling StartRxLoop again before StopRxLoop replaces the running loop.
+func (t *TapDevice) StartRxLoop(onFrame func([]byte)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	t.mu.Lock()
+	t.cancel = cancel
+	t.done = done
+	t.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			pkt, err := t.ReadPacketContext(ctx)
+			if err != nil {
+				return
+			}
+			onFrame(pkt)
+		}
+	}()
+}
+
+// StopRxLoop cancels the running RX loop started by StartRxLoop and
+// waits for its goroutine to exit before returning, so ReadPacketContext
+// unblocks the reader instead of leaving it stuck behind a blocking
+// Read. It's a no-op if StartRxLoop was never called.
+func (t *TapDevice) StopRxLoop() {
+	t.mu.Lock()
+	cancel, done := t.cancel, t.done
+	t.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Close closes the underlying file descriptor.
+func (t *TapDevice) Close() error {
+	return t.f.Close()
+}
+
+// MultiQueueTap is a TAP interface opened with several queues, each its
+// own file descriptor the kernel load-balances packets across. A single
+// TapDevice's RX path is one goroutine reading one fd; under load that
+// goroutine is the bottleneck, so MultiQueueTap lets a caller run one RX
+// goroutine per queue instead.
+type MultiQueueTap struct {
+	queues []*TapDevice
+}
+
+// NewMultiQueueTapDevice opens queues file descriptors against ifaceName
+// with IFF_MULTI_QUEUE set, so the kernel treats them as one interface
+// with multiple independent queues rather than queues separate taps. If
+// any queue fails to open, the ones already opened are closed before
+// returning the error.
+func NewMultiQueueTapDevice(ifaceName string, queues int) (*MultiQueueTap, error) {
+	if queues <= 0 {
+		return nil, fmt.Errorf("vmm: NewMultiQueueTapDevice: queues must be positive, got %d", queues)
+	}
+
+	mq := &MultiQueueTap{}
+	for i := 0; i < queues; i++ {
+		f, err := openTapQueue(ifaceName, iffTap|iffNoPI|iffMultiQueue)
+		if err != nil {
+			mq.Close()
+			return nil, fmt.Errorf("vmm: open queue %d of %d: %w", i, queues, err)
+		}
+		mq.queues = append(mq.queues, newTapDeviceFromFile(f))
+	}
+	return mq, nil
+}
+
+// Queues returns one TapDevice per queue, in the order they were opened.
+// Each implements HostNetInterface for transmit and can be read from
+// independently, so callers can run one RX goroutine per queue.
+func (mq *MultiQueueTap) Queues() []*TapDevice {
+	return append([]*TapDevice(nil), mq.queues...)
+}
+
+// Close closes every queue, returning the first error encountered (if
+// any) after attempting to close them all.
+func (mq *MultiQueueTap) Close() error {
+	var firstErr error
+	for _, q := range mq.queues {
+		if err := q.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
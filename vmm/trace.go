@@ -0,0 +1,306 @@
+package vmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// traceMagic identifies an IOBus trace file; traceVersion lets the record
+// format evolve without breaking readers of older recordings.
+const (
+	traceMagic   = "VDBTRC"
+	traceVersion = 1
+)
+
+// TraceRecord is one IOBus.Dispatch call, either captured live or decoded
+// back out of a trace file.
+type TraceRecord struct {
+	TimestampNanos int64
+	Port           uint16
+	Write          bool
+	Data           []byte
+	Device         string // dev.Name(); empty for an unhandled port
+	Err            string // dev.HandleIO's error, stringified; empty on success
+}
+
+// ioTraceWriter streams TraceRecords to an io.Writer as they're recorded,
+// rather than buffering a whole session in memory. The header is written
+// lazily, on the first record, so enabling a trace that never sees a
+// Dispatch call produces an empty file instead of a bare header.
+type ioTraceWriter struct {
+	w           io.Writer
+	clock       Clock
+	wroteHeader bool
+}
+
+func newIOTraceWriter(w io.Writer, clock Clock) *ioTraceWriter {
+	return &ioTraceWriter{w: w, clock: clock}
+}
+
+// Record appends rec to the trace.
+func (t *ioTraceWriter) Record(rec TraceRecord) error {
+	if !t.wroteHeader {
+		if _, err := t.w.Write(append([]byte(traceMagic), traceVersion)); err != nil {
+			return fmt.Errorf("vmm: writing trace header: %w", err)
+		}
+		t.wroteHeader = true
+	}
+
+	payload := encodeTraceRecord(rec)
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(payload)))
+	if _, err := t.w.Write(lenPrefix[:]); err != nil {
+		return fmt.Errorf("vmm: writing trace record length: %w", err)
+	}
+	if _, err := t.w.Write(payload); err != nil {
+		return fmt.Errorf("vmm: writing trace record: %w", err)
+	}
+	return nil
+}
+
+// encodeTraceRecord lays out rec as: 8-byte timestamp, 2-byte port,
+// 1-byte write flag, 1-byte data length + data, 1-byte device-name
+// length + name, 2-byte error length + error text. Every variable-length
+// field is prefixed by its own length so a reader never has to guess
+// where the next one starts.
+func encodeTraceRecord(rec TraceRecord) []byte {
+	buf := make([]byte, 0, 13+len(rec.Data)+len(rec.Device)+len(rec.Err))
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(rec.TimestampNanos))
+	buf = append(buf, ts[:]...)
+
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], rec.Port)
+	buf = append(buf, port[:]...)
+
+	var writeFlag byte
+	if rec.Write {
+		writeFlag = 1
+	}
+	buf = append(buf, writeFlag)
+
+	buf = append(buf, uint8(len(rec.Data)))
+	buf = append(buf, rec.Data...)
+
+	buf = append(buf, uint8(len(rec.Device)))
+	buf = append(buf, rec.Device...)
+
+	var errLen [2]byte
+	binary.BigEndian.PutUint16(errLen[:], uint16(len(rec.Err)))
+	buf = append(buf, errLen[:]...)
+	buf = append(buf, rec.Err...)
+
+	return buf
+}
+
+func decodeTraceRecord(payload []byte) (TraceRecord, error) {
+	var rec TraceRecord
+	r := bytes.NewReader(payload)
+
+	var ts [8]byte
+	if _, err := io.ReadFull(r, ts[:]); err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record timestamp: %w", err)
+	}
+	rec.TimestampNanos = int64(binary.BigEndian.Uint64(ts[:]))
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record port: %w", err)
+	}
+	rec.Port = binary.BigEndian.Uint16(port[:])
+
+	writeFlag, err := r.ReadByte()
+	if err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record write flag: %w", err)
+	}
+	rec.Write = writeFlag != 0
+
+	dataLen, err := r.ReadByte()
+	if err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record data length: %w", err)
+	}
+	rec.Data = make([]byte, dataLen)
+	if _, err := io.ReadFull(r, rec.Data); err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record data: %w", err)
+	}
+
+	deviceLen, err := r.ReadByte()
+	if err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record device length: %w", err)
+	}
+	device := make([]byte, deviceLen)
+	if _, err := io.ReadFull(r, device); err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record device: %w", err)
+	}
+	rec.Device = string(device)
+
+	var errLen [2]byte
+	if _, err := io.ReadFull(r, errLen[:]); err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record error length: %w", err)
+	}
+	errText := make([]byte, binary.BigEndian.Uint16(errLen[:]))
+	if _, err := io.ReadFull(r, errText); err != nil {
+		return rec, fmt.Errorf("vmm: decoding trace record error text: %w", err)
+	}
+	rec.Err = string(errText)
+
+	return rec, nil
+}
+
+// traceDecoder reads TraceRecords back out of a stream written by
+// ioTraceWriter.
+type traceDecoder struct {
+	r io.Reader
+}
+
+// newTraceDecoder validates the trace header and returns a decoder
+// positioned at the first record.
+func newTraceDecoder(r io.Reader) (*traceDecoder, error) {
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("vmm: reading trace header: %w", err)
+	}
+	if string(header[:len(traceMagic)]) != traceMagic {
+		return nil, fmt.Errorf("vmm: not an IOBus trace file")
+	}
+	if version := header[len(traceMagic)]; version != traceVersion {
+		return nil, fmt.Errorf("vmm: unsupported trace version %d", version)
+	}
+	return &traceDecoder{r: r}, nil
+}
+
+// Next decodes the following record, or returns io.EOF once the stream
+// is exhausted between records. A file truncated mid-record surfaces as
+// io.ErrUnexpectedEOF rather than a silently short read.
+func (d *traceDecoder) Next() (TraceRecord, error) {
+	var lenPrefix [4]byte
+	if _, err := io.ReadFull(d.r, lenPrefix[:]); err != nil {
+		return TraceRecord{}, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(lenPrefix[:]))
+	if _, err := io.ReadFull(d.r, payload); err != nil {
+		return TraceRecord{}, err
+	}
+	return decodeTraceRecord(payload)
+}
+
+// RecordTrace starts recording bus's I/O trace to w, in the same format
+// ReplayTrace and ReplayBus read back. It's a thin, more discoverable
+// wrapper over EnableTrace for callers who only ever record for the
+// bus's whole lifetime and never need DisableTrace.
+func RecordTrace(bus *IOBus, w io.Writer) {
+	bus.EnableTrace(w)
+}
+
+// ReplayTrace decodes every record in r and replays it directly against
+// dev's HandleIO, without needing an IOBus or dev to be registered on
+// one. This is the single-device counterpart to ReplayBus, for
+// regression-testing one device against a trace captured from a real
+// guest session instead of driving it through KVM. It stops at the first
+// divergence between a recorded IN result and what dev now returns for
+// the same access, reported as a *ReplayDivergence; a decode error
+// (including a partially-written trailing record) is returned as-is.
+func ReplayTrace(r io.Reader, dev PioDevice) error {
+	dec, err := newTraceDecoder(r)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		rec, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		buf := append([]byte(nil), rec.Data...)
+		if err := dev.HandleIO(rec.Port, buf, rec.Write); err != nil {
+			return fmt.Errorf("vmm: ReplayTrace: record %d: %w", i, err)
+		}
+		if !rec.Write && !bytes.Equal(buf, rec.Data) {
+			return &ReplayDivergence{RecordIndex: i, Port: rec.Port, Want: rec.Data, Got: buf}
+		}
+	}
+}
+
+// ReplayDivergence describes the first point where a replayed IN
+// operation didn't return what was recorded.
+type ReplayDivergence struct {
+	RecordIndex int
+	Port        uint16
+	Want        []byte
+	Got         []byte
+}
+
+func (d *ReplayDivergence) Error() string {
+	return fmt.Sprintf("vmm: replay diverged at record %d, port %#04x: want % x, got % x",
+		d.RecordIndex, d.Port, d.Want, d.Got)
+}
+
+// ReplayResult summarizes a completed Replay call.
+type ReplayResult struct {
+	RecordsReplayed int
+	Divergence      *ReplayDivergence
+}
+
+// ReplayBus feeds a recorded IOBus trace back into a live IOBus: OUT
+// records are written verbatim, and IN records are read back and
+// compared against what was recorded, so a driver regression that
+// changes device behavior shows up as a divergence instead of silently
+// passing.
+type ReplayBus struct {
+	bus *IOBus
+}
+
+// NewReplayBus returns a ReplayBus that drives bus.
+func NewReplayBus(bus *IOBus) *ReplayBus {
+	return &ReplayBus{bus: bus}
+}
+
+// Replay decodes every record in r and dispatches it against the bus.
+// It stops at the first divergence between a recorded IN result and what
+// the current device state returns, reporting it in ReplayResult; a
+// decode error (including a partially-written trailing record) is
+// returned as the second value.
+func (rb *ReplayBus) Replay(r io.Reader) (ReplayResult, error) {
+	dec, err := newTraceDecoder(r)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+
+	for i := 0; ; i++ {
+		rec, err := dec.Next()
+		if err == io.EOF {
+			return ReplayResult{RecordsReplayed: i}, nil
+		}
+		if err != nil {
+			return ReplayResult{RecordsReplayed: i}, err
+		}
+
+		buf := append([]byte(nil), rec.Data...)
+		if rec.Write {
+			_ = rb.bus.Dispatch(rec.Port, buf, true)
+			continue
+		}
+
+		if err := rb.bus.Dispatch(rec.Port, buf, false); err != nil {
+			continue // an unhandled port isn't a data divergence to report
+		}
+		if !bytes.Equal(buf, rec.Data) {
+			return ReplayResult{
+				RecordsReplayed: i + 1,
+				Divergence: &ReplayDivergence{
+					RecordIndex: i,
+					Port:        rec.Port,
+					Want:        rec.Data,
+					Got:         buf,
+				},
+			}, nil
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package vmm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// recordSyntheticNE2000Session writes a short trace: select page 1,
+// read back all six PAR registers, then return to page 0.
+func recordSyntheticNE2000Session(t *testing.T, mac [6]byte) []byte {
+	t.Helper()
+
+	dev := NewNE2000Device(mac, nil)
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var trace bytes.Buffer
+	bus.EnableTrace(&trace)
+
+	base := dev.base
+	if err := bus.Dispatch(base+ne2000RegCR, []byte{crSTP | crPS0}, true); err != nil {
+		t.Fatalf("Dispatch CR: %v", err)
+	}
+	for i := uint16(0); i < 6; i++ {
+		buf := []byte{0}
+		if err := bus.Dispatch(base+ne2000RegPAR0+i, buf, false); err != nil {
+			t.Fatalf("Dispatch PAR%d: %v", i, err)
+		}
+	}
+	if err := bus.Dispatch(base+ne2000RegCR, []byte{crSTP}, true); err != nil {
+		t.Fatalf("Dispatch CR: %v", err)
+	}
+
+	bus.DisableTrace()
+	return trace.Bytes()
+}
+
+func TestReplayBusMatchesIdenticalDeviceState(t *testing.T) {
+	mac := [6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	trace := recordSyntheticNE2000Session(t, mac)
+
+	replayDev := NewNE2000Device(mac, nil)
+	replayBus := NewIOBus()
+	if err := replayBus.Register(replayDev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	result, err := NewReplayBus(replayBus).Replay(bytes.NewReader(trace))
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if result.Divergence != nil {
+		t.Fatalf("unexpected divergence: %v", result.Divergence)
+	}
+	if result.RecordsReplayed != 8 {
+		t.Errorf("RecordsReplayed = %d, want 8", result.RecordsReplayed)
+	}
+}
+
+func TestReplayBusDetectsDivergentDeviceState(t *testing.T) {
+	recordedMAC := [6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	trace := recordSyntheticNE2000Session(t, recordedMAC)
+
+	perturbedMAC := [6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x99}
+	replayDev := NewNE2000Device(perturbedMAC, nil)
+	replayBus := NewIOBus()
+	if err := replayBus.Register(replayDev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	result, err := NewReplayBus(replayBus).Replay(bytes.NewReader(trace))
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if result.Divergence == nil {
+		t.Fatal("expected a divergence, got none")
+	}
+	// PAR5 is the last of six PAR reads, preceded by the page-select
+	// write, so it's record index 6 (0-based).
+	if result.Divergence.RecordIndex != 6 {
+		t.Errorf("RecordIndex = %d, want 6", result.Divergence.RecordIndex)
+	}
+	if got, want := result.Divergence.Got[0], perturbedMAC[5]; got != want {
+		t.Errorf("divergent byte = %#x, want %#x", got, want)
+	}
+}
+
+func TestTraceRoundTripsThroughEncodeDecode(t *testing.T) {
+	rec := TraceRecord{
+		TimestampNanos: 123456789,
+		Port:           0x300,
+		Write:          true,
+		Data:           []byte{0xde, 0xad},
+		Device:         "*vmm.NE2000Device",
+	}
+	got, err := decodeTraceRecord(encodeTraceRecord(rec))
+	if err != nil {
+		t.Fatalf("decodeTraceRecord: %v", err)
+	}
+	if got.TimestampNanos != rec.TimestampNanos || got.Port != rec.Port || got.Write != rec.Write ||
+		!bytes.Equal(got.Data, rec.Data) || got.Device != rec.Device || got.Err != rec.Err {
+		t.Errorf("round trip = %+v, want %+v", got, rec)
+	}
+}
+
+// recordPITProgrammingTrace records, via bus (so it's actually captured),
+// the same channel-0 programming sequence programPITChannel0 performs
+// directly. advance, if nonzero, elapses on clock between programming
+// and reading it back, so the recorded read reflects a decremented
+// count instead of the bare reload value.
+func recordPITProgrammingTrace(t *testing.T, bus *IOBus, clock *ManualClock, w io.Writer, reload uint16, advance time.Duration) {
+	t.Helper()
+	RecordTrace(bus, w)
+	defer bus.DisableTrace()
+
+	cmd := uint8(pitAccessLoByteHiByte<<4) | uint8(2<<1) // mode 2, lobyte/hibyte
+	if err := bus.Dispatch(pitPortCommand, []byte{cmd}, true); err != nil {
+		t.Fatalf("Dispatch command: %v", err)
+	}
+	if err := bus.Dispatch(pitPortCounter0, []byte{byte(reload)}, true); err != nil {
+		t.Fatalf("Dispatch lo byte: %v", err)
+	}
+	if err := bus.Dispatch(pitPortCounter0, []byte{byte(reload >> 8)}, true); err != nil {
+		t.Fatalf("Dispatch hi byte: %v", err)
+	}
+	clock.Advance(advance)
+	if err := bus.Dispatch(pitPortCounter0, []byte{0}, false); err != nil {
+		t.Fatalf("Dispatch read lo byte: %v", err)
+	}
+	if err := bus.Dispatch(pitPortCounter0, []byte{0}, false); err != nil {
+		t.Fatalf("Dispatch read hi byte: %v", err)
+	}
+}
+
+// TestReplayTraceMatchesIdenticalPITState checks that a PIT programming
+// sequence recorded with RecordTrace and replayed with ReplayTrace
+// against a fresh PIT, with no IOBus involved on the replay side,
+// reproduces identical final counter state.
+func TestReplayTraceMatchesIdenticalPITState(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	source := NewPITDevice(clock)
+	bus := NewIOBus()
+	if err := bus.Register(source); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var trace bytes.Buffer
+	recordPITProgrammingTrace(t, bus, clock, &trace, 1234, 0)
+
+	replay := NewPITDevice(NewManualClock(time.Unix(0, 0)))
+	if err := ReplayTrace(bytes.NewReader(trace.Bytes()), replay); err != nil {
+		t.Fatalf("ReplayTrace: %v", err)
+	}
+
+	if got, want := readPITChannel0(t, replay), readPITChannel0(t, source); got != want {
+		t.Errorf("replayed channel 0 count = %d, want %d (matching the recorded device)", got, want)
+	}
+}
+
+// TestReplayTraceDetectsDivergence checks ReplayTrace reports a
+// *ReplayDivergence when a replayed IN doesn't match what was recorded.
+// The recording elapses time between programming the PIT and reading it
+// back, so the recorded count is already decremented from the bare
+// reload; replaying the identical commands against a device whose clock
+// never advances leaves it holding the undecremented reload instead,
+// diverging on the read.
+func TestReplayTraceDetectsDivergence(t *testing.T) {
+	sourceClock := NewManualClock(time.Unix(0, 0))
+	source := NewPITDevice(sourceClock)
+	bus := NewIOBus()
+	if err := bus.Register(source); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var trace bytes.Buffer
+	recordPITProgrammingTrace(t, bus, sourceClock, &trace, 1234, 500*pitTickDuration)
+
+	diverged := NewPITDevice(NewManualClock(time.Unix(0, 0)))
+	err := ReplayTrace(bytes.NewReader(trace.Bytes()), diverged)
+	if _, ok := err.(*ReplayDivergence); !ok {
+		t.Fatalf("ReplayTrace error = %v (%T), want *ReplayDivergence", err, err)
+	}
+}
+
+func TestReplayBusRejectsTruncatedTrace(t *testing.T) {
+	trace := recordSyntheticNE2000Session(t, [6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56})
+	truncated := trace[:len(trace)-2] // chop off the last record's tail
+
+	dev := NewNE2000Device([6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}, nil)
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	result, err := NewReplayBus(bus).Replay(bytes.NewReader(truncated))
+	if err == nil {
+		t.Fatalf("expected a decode error for a truncated trace, replayed %d records", result.RecordsReplayed)
+	}
+}
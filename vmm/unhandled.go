@@ -0,0 +1,99 @@
+package vmm
+
+import (
+	"sync"
+	"time"
+)
+
+// UnhandledAccessKey identifies one address that has generated unhandled
+// accesses: a port number for IOBus, or a guest physical address for
+// MmioBus, alongside the access direction.
+type UnhandledAccessKey struct {
+	Addr  uint64
+	Write bool
+}
+
+// UnhandledAccessStats is one DumpUnhandled entry: how many times Key has
+// been accessed with no device claiming it.
+type UnhandledAccessStats struct {
+	Key   UnhandledAccessKey
+	Count int64
+}
+
+// unhandledAccessTracker counts accesses to addresses no device claims and
+// decides when a caller should actually log one, so a guest sweeping
+// hundreds of unimplemented ports or MMIO addresses produces one line per
+// address instead of one per access. The first access to a given key
+// always logs; after that, LogEvery and MinInterval each independently
+// suppress further callbacks until they allow one through.
+type unhandledAccessTracker struct {
+	// LogEvery, if positive, allows the callback again every LogEvery
+	// occurrences after the first. Zero means "never log a repeat".
+	LogEvery int64
+	// MinInterval, if positive, additionally withholds a repeat callback
+	// until at least this long has passed since the last one for that
+	// key, regardless of LogEvery. Zero disables this check.
+	MinInterval time.Duration
+	// Clock times MinInterval. Nil defaults to RealClock.
+	Clock Clock
+
+	mu    sync.Mutex
+	state map[UnhandledAccessKey]*unhandledAccessCount
+}
+
+// unhandledAccessCount is one key's running total and the time it last
+// triggered a callback.
+type unhandledAccessCount struct {
+	count   int64
+	lastLog time.Time
+}
+
+func (t *unhandledAccessTracker) clock() Clock {
+	if t.Clock != nil {
+		return t.Clock
+	}
+	return RealClock{}
+}
+
+// Note records one access to key and reports the new total count and
+// whether the caller should log it now.
+func (t *unhandledAccessTracker) Note(key UnhandledAccessKey) (count int64, shouldLog bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == nil {
+		t.state = make(map[UnhandledAccessKey]*unhandledAccessCount)
+	}
+	c, ok := t.state[key]
+	if !ok {
+		c = &unhandledAccessCount{}
+		t.state[key] = c
+	}
+	c.count++
+	count = c.count
+
+	shouldLog = count == 1 || (t.LogEvery > 0 && count%t.LogEvery == 0)
+	if shouldLog && t.MinInterval > 0 {
+		now := t.clock().Now()
+		if !c.lastLog.IsZero() && now.Sub(c.lastLog) < t.MinInterval {
+			shouldLog = false
+		}
+	}
+	if shouldLog {
+		c.lastLog = t.clock().Now()
+	}
+	return count, shouldLog
+}
+
+// Dump returns a snapshot of every key this tracker has seen and its
+// count, in no particular order.
+func (t *unhandledAccessTracker) Dump() []UnhandledAccessStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]UnhandledAccessStats, 0, len(t.state))
+	for k, c := range t.state {
+		out = append(out, UnhandledAccessStats{Key: k, Count: c.count})
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+)
+
+// TestIOBusUnhandledLogPolicyBoundsCallbacksUnderHeavyProbing hammers an
+// unregistered port 10,000 times and checks that the log callback fires a
+// bounded number of times (the first access, then one every 1,000) while
+// DumpUnhandled still reports the true total.
+func TestIOBusUnhandledLogPolicyBoundsCallbacksUnderHeavyProbing(t *testing.T) {
+	bus := NewIOBus()
+	bus.SetStats(NewStats())
+
+	var calls int
+	bus.SetUnhandledLogPolicy(1000, 0, func(port uint16, write bool, count int64) {
+		calls++
+	})
+
+	const accesses = 10000
+	for i := 0; i < accesses; i++ {
+		err := bus.Dispatch(0x3e8, []byte{0}, false)
+		if _, ok := err.(*ErrUnhandledPort); !ok {
+			t.Fatalf("Dispatch: err = %v, want *ErrUnhandledPort", err)
+		}
+	}
+
+	// One at count 1, then one every 1000 through 10000: 1, 1000, 2000,
+	// ..., 10000 -> 11 calls.
+	if want := 11; calls != want {
+		t.Errorf("callback fired %d times, want %d", calls, want)
+	}
+
+	dump := bus.DumpUnhandled()
+	if len(dump) != 1 {
+		t.Fatalf("DumpUnhandled() = %+v, want a single entry", dump)
+	}
+	if dump[0].Key.Addr != 0x3e8 || dump[0].Key.Write || dump[0].Count != accesses {
+		t.Errorf("DumpUnhandled()[0] = %+v, want {Addr: 0x3e8, Write: false, Count: %d}", dump[0], accesses)
+	}
+}
+
+// TestIOBusUnhandledLogPolicyDisabledMatchesDefaultBehavior checks that
+// without SetUnhandledLogPolicy, Dispatch behaves exactly as before it
+// existed: no callback, and DumpUnhandled reports nothing, even though
+// the access still failed with ErrUnhandledPort and was still counted in
+// Stats.
+func TestIOBusUnhandledLogPolicyDisabledMatchesDefaultBehavior(t *testing.T) {
+	stats := NewStats()
+	bus := NewIOBus()
+	bus.SetStats(stats)
+
+	if err := bus.Dispatch(0x3e8, []byte{0}, false); err == nil {
+		t.Fatal("Dispatch: got nil error, want ErrUnhandledPort")
+	}
+
+	if dump := bus.DumpUnhandled(); dump != nil {
+		t.Errorf("DumpUnhandled() = %+v, want nil with no policy configured", dump)
+	}
+	snap := stats.Snapshot()
+	if got := snap.UnhandledIO[UnhandledAccessKey{Addr: 0x3e8, Write: false}]; got != 1 {
+		t.Errorf("Stats UnhandledIO[0x3e8] = %d, want 1", got)
+	}
+}
+
+// TestIOBusUnhandledLogPolicyMinIntervalSuppressesRepeats checks that a
+// configured MinInterval withholds the callback until the manual clock
+// advances past it, independent of LogEvery.
+func TestIOBusUnhandledLogPolicyMinIntervalSuppressesRepeats(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	bus := NewIOBus()
+	bus.SetStats(NewStats())
+	bus.SetClock(clock)
+
+	var calls int
+	bus.SetUnhandledLogPolicy(1, time.Second, func(uint16, bool, int64) {
+		calls++
+	})
+
+	_ = bus.Dispatch(0x80, nil, false) // count 1: always logs
+	_ = bus.Dispatch(0x80, nil, false) // count 2: LogEvery allows it, but MinInterval blocks it
+	if calls != 1 {
+		t.Fatalf("calls = %d after two immediate accesses, want 1", calls)
+	}
+
+	clock.Advance(2 * time.Second)
+	_ = bus.Dispatch(0x80, nil, false) // count 3: interval has passed
+	if calls != 2 {
+		t.Errorf("calls = %d after the clock advanced, want 2", calls)
+	}
+}
+
+// dummyMmioDevice is a minimal MmioDevice used to exercise MmioBus
+// alongside an unhandled address.
+type dummyMmioDevice struct {
+	base, size uint64
+}
+
+func (d *dummyMmioDevice) Base() uint64                          { return d.base }
+func (d *dummyMmioDevice) Size() uint64                          { return d.size }
+func (d *dummyMmioDevice) HandleMMIO(uint64, []byte, bool) error { return nil }
+func (d *dummyMmioDevice) Reset()                                {}
+func (d *dummyMmioDevice) Name() string                          { return "dummy-mmio" }
+
+// TestMmioBusUnhandledLogPolicyBoundsCallbacks mirrors the IOBus test for
+// MmioBus.Dispatch's unhandled path.
+func TestMmioBusUnhandledLogPolicyBoundsCallbacks(t *testing.T) {
+	bus := NewMmioBus()
+	stats := NewStats()
+	bus.SetStats(stats)
+	if err := bus.Register(&dummyMmioDevice{base: 0x1000, size: 0x1000}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var calls int
+	bus.SetUnhandledLogPolicy(1000, 0, func(addr uint64, write bool, count int64) {
+		calls++
+	})
+
+	const accesses = 10000
+	for i := 0; i < accesses; i++ {
+		err := bus.Dispatch(0xfee00000, []byte{0}, true)
+		if _, ok := err.(*ErrUnhandledMmio); !ok {
+			t.Fatalf("Dispatch: err = %v, want *ErrUnhandledMmio", err)
+		}
+	}
+
+	if want := 11; calls != want {
+		t.Errorf("callback fired %d times, want %d", calls, want)
+	}
+
+	dump := bus.DumpUnhandled()
+	if len(dump) != 1 || dump[0].Key.Addr != 0xfee00000 || !dump[0].Key.Write || dump[0].Count != accesses {
+		t.Errorf("DumpUnhandled() = %+v, want a single {0xfee00000, write, %d} entry", dump, accesses)
+	}
+
+	snap := stats.Snapshot()
+	if got := snap.UnhandledMMIO[UnhandledAccessKey{Addr: 0xfee00000, Write: true}]; got != accesses {
+		t.Errorf("Stats UnhandledMMIO[0xfee00000] = %d, want %d", got, accesses)
+	}
+}
@@ -0,0 +1,147 @@
+package vmm
+
+import "sync"
+
+// Standard VGA text-mode framebuffer: 80x25 characters, 2 bytes each
+// (character, attribute), memory-mapped at 0xb8000.
+const (
+	vgaFramebufferBase = 0xb8000
+	vgaColumns         = 80
+	vgaRows            = 25
+	vgaFramebufferSize = vgaColumns * vgaRows * 2
+)
+
+// CRTC index/data ports.
+const (
+	crtcPortIndex = 0x3d4
+	crtcPortData  = 0x3d5
+)
+
+// CRTC register indices used for the hardware text cursor.
+const (
+	crtcCursorLocHigh = 0x0e
+	crtcCursorLocLow  = 0x0f
+)
+
+// VGATextDevice models the 0xb8000 text framebuffer plus the CRTC ports
+// (0x3d4/0x3d5) needed to move the cursor. It implements both PioDevice
+// (for the CRTC ports) and a small MMIO-style accessor the memory
+// subsystem can route 0xb8000 reads/writes through.
+type VGATextDevice struct {
+	mu sync.Mutex
+
+	fb [vgaFramebufferSize]byte
+
+	crtcIndex uint8
+	cursorPos uint16 // character offset, row*80+col
+}
+
+// NewVGATextDevice returns a blank (space, light-grey-on-black) text
+// screen with the cursor at the top left.
+func NewVGATextDevice() *VGATextDevice {
+	v := &VGATextDevice{}
+	v.Reset()
+	return v
+}
+
+// Ports implements PioDevice for the CRTC index/data ports.
+func (v *VGATextDevice) Ports() []uint16 {
+	return []uint16{crtcPortIndex, crtcPortData}
+}
+
+// HandleIO implements PioDevice.
+func (v *VGATextDevice) HandleIO(port uint16, data []byte, write bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch port {
+	case crtcPortIndex:
+		if write && len(data) > 0 {
+			v.crtcIndex = data[0]
+		} else if len(data) > 0 {
+			data[0] = v.crtcIndex
+		}
+	case crtcPortData:
+		if write && len(data) > 0 {
+			v.writeCRTC(data[0])
+		} else if len(data) > 0 {
+			data[0] = v.readCRTC()
+		}
+	}
+	return nil
+}
+
+func (v *VGATextDevice) writeCRTC(val uint8) {
+	switch v.crtcIndex {
+	case crtcCursorLocHigh:
+		v.cursorPos = (v.cursorPos & 0x00ff) | uint16(val)<<8
+	case crtcCursorLocLow:
+		v.cursorPos = (v.cursorPos & 0xff00) | uint16(val)
+	}
+}
+
+func (v *VGATextDevice) readCRTC() uint8 {
+	switch v.crtcIndex {
+	case crtcCursorLocHigh:
+		return uint8(v.cursorPos >> 8)
+	case crtcCursorLocLow:
+		return uint8(v.cursorPos)
+	}
+	return 0
+}
+
+// CursorPosition returns the current hardware cursor's (row, col).
+func (v *VGATextDevice) CursorPosition() (row, col int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return int(v.cursorPos) / vgaColumns, int(v.cursorPos) % vgaColumns
+}
+
+// WriteFramebuffer writes data into the text framebuffer at guest
+// physical offset addr-0xb8000, covering the 0xb8000-0xb8fa0 range and
+// ignoring a write that starts past it. Nothing in this package routes
+// guest memory accesses to it; callers invoke it directly.
+func (v *VGATextDevice) WriteFramebuffer(addr uint64, data []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	off := addr - vgaFramebufferBase
+	if off >= vgaFramebufferSize {
+		return
+	}
+	copy(v.fb[off:], data)
+}
+
+// ScrapeText renders the current framebuffer as vgaRows lines of
+// vgaColumns characters, ignoring attribute bytes, for host-side
+// debugging/testing.
+func (v *VGATextDevice) ScrapeText() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	lines := make([]string, vgaRows)
+	for r := 0; r < vgaRows; r++ {
+		line := make([]byte, vgaColumns)
+		for c := 0; c < vgaColumns; c++ {
+			line[c] = v.fb[(r*vgaColumns+c)*2]
+		}
+		lines[r] = string(line)
+	}
+	return lines
+}
+
+// Reset implements PioDevice.
+func (v *VGATextDevice) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for i := 0; i < vgaFramebufferSize; i += 2 {
+		v.fb[i] = ' '
+		v.fb[i+1] = 0x07 // light grey on black
+	}
+	v.crtcIndex = 0
+	v.cursorPos = 0
+}
+
+// Name implements PioDevice.
+func (v *VGATextDevice) Name() string { return "vga" }
@@ -0,0 +1,28 @@
+package vmm
+
+import "testing"
+
+func TestVGATextCursorAndFramebuffer(t *testing.T) {
+	v := NewVGATextDevice()
+
+	must := func(err error) {
+		if err != nil {
+			t.Fatalf("HandleIO: %v", err)
+		}
+	}
+	must(v.HandleIO(crtcPortIndex, []byte{crtcCursorLocHigh}, true))
+	must(v.HandleIO(crtcPortData, []byte{0x00}, true))
+	must(v.HandleIO(crtcPortIndex, []byte{crtcCursorLocLow}, true))
+	must(v.HandleIO(crtcPortData, []byte{85}, true)) // row 1, col 5
+
+	row, col := v.CursorPosition()
+	if row != 1 || col != 5 {
+		t.Errorf("cursor = (%d,%d), want (1,5)", row, col)
+	}
+
+	v.WriteFramebuffer(vgaFramebufferBase, []byte{'H', 0x07, 'i', 0x07})
+	line := v.ScrapeText()[0]
+	if line[0] != 'H' || line[1] != 'i' {
+		t.Errorf("scraped line[0:2] = %q, want \"Hi\"", line[0:2])
+	}
+}
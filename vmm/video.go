@@ -0,0 +1,178 @@
+package vmm
+
+import (
+	"strings"
+	"sync"
+)
+
+// Text-mode video MMIO window: the well-known CGA/MDA/EGA/VGA text page
+// address every real-mode BIOS text-output guest writes to directly,
+// bypassing any INT 10h call. The full 32KB window covers every page a
+// color adapter can bank-switch between; this model only backs page 0
+// (the first 4000 bytes, an 80x25 grid of 2-byte cells), the same as a
+// guest that never reprograms the CRTC's start-address registers would
+// ever see.
+const (
+	videoBase = 0xb8000
+	videoSize = 0x8000
+
+	videoCols      = 80
+	videoRows      = 25
+	videoCellBytes = 2 // character byte, then attribute byte
+)
+
+// CRTC (6845-compatible) index/data ports, used here only for the cursor
+// location registers; every other CRTC register is accepted but ignored,
+// since nothing in this model reads timing/sync registers back. The
+// ports themselves (crtcPortIndex/crtcPortData) are declared in vga.go.
+
+// CRTC register indices for the cursor location, split high/low across
+// two 8-bit register accesses the same way the real 6845 exposes it.
+const (
+	crtcRegCursorHigh = 0x0e
+	crtcRegCursorLow  = 0x0f
+)
+
+// TextModeVideoDevice emulates a CGA/MDA-style text-mode video adapter:
+// an MmioDevice backing the 0xB8000 character/attribute buffer, plus a
+// PioDevice for the CRTC's cursor-position registers. It has no display
+// of its own; Render dumps the buffer's current contents as text, for a
+// host operator (or a test) to observe a text-mode guest without a
+// graphical front-end.
+type TextModeVideoDevice struct {
+	mu sync.Mutex
+
+	buf [videoSize]byte
+
+	crtcIndex  uint8
+	cursorHigh uint8
+	cursorLow  uint8
+}
+
+// NewTextModeVideoDevice returns a video device with a blank (all-zero,
+// i.e. all-space) buffer and the cursor parked at 0,0.
+func NewTextModeVideoDevice() *TextModeVideoDevice {
+	return &TextModeVideoDevice{}
+}
+
+// Base implements MmioDevice.
+func (v *TextModeVideoDevice) Base() uint64 { return videoBase }
+
+// Size implements MmioDevice.
+func (v *TextModeVideoDevice) Size() uint64 { return videoSize }
+
+// Name implements MmioDevice and PioDevice.
+func (v *TextModeVideoDevice) Name() string { return "video" }
+
+// Reset implements MmioDevice and PioDevice, blanking the buffer and
+// parking the CRTC index/cursor registers, as if the guest had just been
+// (re)booted into text mode.
+func (v *TextModeVideoDevice) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.buf = [videoSize]byte{}
+	v.crtcIndex = 0
+	v.cursorHigh = 0
+	v.cursorLow = 0
+}
+
+// HandleMMIO implements MmioDevice.
+func (v *TextModeVideoDevice) HandleMMIO(addr uint64, data []byte, write bool) error {
+	off := addr - videoBase
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i := range data {
+		pos := off + uint64(i)
+		if pos >= videoSize {
+			break
+		}
+		if write {
+			v.buf[pos] = data[i]
+		} else {
+			data[i] = v.buf[pos]
+		}
+	}
+	return nil
+}
+
+// Ports implements PioDevice, for the CRTC's index/data register pair.
+func (v *TextModeVideoDevice) Ports() []uint16 {
+	return []uint16{crtcPortIndex, crtcPortData}
+}
+
+// HandleIO implements PioDevice. Only the cursor-location registers are
+// backed; every other CRTC register index is accepted on a data write
+// (matching a guest that always writes index then data) but otherwise
+// ignored, and reads back as 0.
+func (v *TextModeVideoDevice) HandleIO(port uint16, data []byte, write bool) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch port {
+	case crtcPortIndex:
+		if write {
+			v.crtcIndex = data[0]
+		} else {
+			data[0] = v.crtcIndex
+		}
+	case crtcPortData:
+		switch v.crtcIndex {
+		case crtcRegCursorHigh:
+			if write {
+				v.cursorHigh = data[0]
+			} else {
+				data[0] = v.cursorHigh
+			}
+		case crtcRegCursorLow:
+			if write {
+				v.cursorLow = data[0]
+			} else {
+				data[0] = v.cursorLow
+			}
+		default:
+			if !write {
+				data[0] = 0
+			}
+		}
+	}
+	return nil
+}
+
+// CursorPosition returns the guest-programmed cursor location as it was
+// last written to the CRTC's cursor registers: a linear offset in cells
+// from the top-left of the 80x25 grid.
+func (v *TextModeVideoDevice) CursorPosition() uint16 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return uint16(v.cursorHigh)<<8 | uint16(v.cursorLow)
+}
+
+// Render dumps the buffer's current 80x25 grid as text: each cell's
+// character byte, attribute bytes discarded, rows separated by newlines,
+// with an all-zero cell rendered as a space (the way a real adapter's
+// blank/uninitialized cell displays).
+func (v *TextModeVideoDevice) Render() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var sb strings.Builder
+	for row := 0; row < videoRows; row++ {
+		if row > 0 {
+			sb.WriteByte('\n')
+		}
+		for col := 0; col < videoCols; col++ {
+			off := (row*videoCols + col) * videoCellBytes
+			ch := v.buf[off]
+			if ch == 0 {
+				ch = ' '
+			}
+			sb.WriteByte(ch)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,102 @@
+package vmm
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTextModeVideoRenderReflectsWrittenCharacters checks that characters
+// written to the MMIO buffer at their cell offsets show up at the right
+// position in Render's dump, with untouched cells rendered as spaces.
+func TestTextModeVideoRenderReflectsWrittenCharacters(t *testing.T) {
+	v := NewTextModeVideoDevice()
+
+	msg := "HELLO"
+	for i, c := range []byte(msg) {
+		off := uint64(i * videoCellBytes)
+		if err := v.HandleMMIO(videoBase+off, []byte{c, 0x07}, true); err != nil {
+			t.Fatalf("HandleMMIO char %d: %v", i, err)
+		}
+	}
+
+	lines := strings.Split(v.Render(), "\n")
+	if len(lines) != videoRows {
+		t.Fatalf("Render produced %d lines, want %d", len(lines), videoRows)
+	}
+	if got := lines[0][:len(msg)]; got != msg {
+		t.Errorf("first line = %q, want it to start with %q", lines[0], msg)
+	}
+	if rest := lines[0][len(msg):]; strings.TrimSpace(rest) != "" {
+		t.Errorf("first line after %q = %q, want blanks", msg, rest)
+	}
+	for i, line := range lines[1:] {
+		if strings.TrimSpace(line) != "" {
+			t.Errorf("line %d = %q, want blank", i+1, line)
+		}
+	}
+}
+
+// TestTextModeVideoMMIOReadReflectsWrites checks that a load returns
+// exactly what an earlier store wrote to the same offset.
+func TestTextModeVideoMMIOReadReflectsWrites(t *testing.T) {
+	v := NewTextModeVideoDevice()
+
+	if err := v.HandleMMIO(videoBase+42, []byte{'X', 0x1f}, true); err != nil {
+		t.Fatalf("HandleMMIO write: %v", err)
+	}
+	buf := make([]byte, 2)
+	if err := v.HandleMMIO(videoBase+42, buf, false); err != nil {
+		t.Fatalf("HandleMMIO read: %v", err)
+	}
+	if buf[0] != 'X' || buf[1] != 0x1f {
+		t.Errorf("read back %v, want ['X', 0x1f]", buf)
+	}
+}
+
+// TestTextModeVideoMMIOIgnoresOutOfRangeAccess checks that an access
+// reaching past the end of the backing buffer is truncated rather than
+// panicking, mirroring how real hardware simply doesn't decode addresses
+// outside its window.
+func TestTextModeVideoMMIOIgnoresOutOfRangeAccess(t *testing.T) {
+	v := NewTextModeVideoDevice()
+	if err := v.HandleMMIO(videoBase+videoSize-1, []byte{'Z', 'Z'}, true); err != nil {
+		t.Fatalf("HandleMMIO: %v", err)
+	}
+}
+
+// TestTextModeVideoCRTCCursorRegisters checks that writing the CRTC's
+// cursor-location high/low registers through the index/data port pair is
+// reflected in CursorPosition.
+func TestTextModeVideoCRTCCursorRegisters(t *testing.T) {
+	v := NewTextModeVideoDevice()
+
+	writeCRTC := func(index, value uint8) {
+		t.Helper()
+		if err := v.HandleIO(crtcPortIndex, []byte{index}, true); err != nil {
+			t.Fatalf("HandleIO index: %v", err)
+		}
+		if err := v.HandleIO(crtcPortData, []byte{value}, true); err != nil {
+			t.Fatalf("HandleIO data: %v", err)
+		}
+	}
+	writeCRTC(crtcRegCursorHigh, 0x01)
+	writeCRTC(crtcRegCursorLow, 0x2c)
+
+	if got, want := v.CursorPosition(), uint16(0x012c); got != want {
+		t.Errorf("CursorPosition = %#x, want %#x", got, want)
+	}
+}
+
+// TestTextModeVideoResetBlanksBuffer checks that Reset clears any
+// previously written characters back to blank.
+func TestTextModeVideoResetBlanksBuffer(t *testing.T) {
+	v := NewTextModeVideoDevice()
+	if err := v.HandleMMIO(videoBase, []byte{'A', 0x07}, true); err != nil {
+		t.Fatalf("HandleMMIO: %v", err)
+	}
+	v.Reset()
+
+	if got, want := v.Render()[0], byte(' '); got != want {
+		t.Errorf("first rendered byte after Reset = %q, want %q", got, want)
+	}
+}
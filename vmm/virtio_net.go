@@ -0,0 +1,583 @@
+package vmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Legacy (virtio 0.9.5) I/O BAR register offsets, common to every device
+// type: feature negotiation, queue configuration/notification, and
+// status/interrupt handling. Device-specific configuration (the MAC, for
+// virtio-net) starts right after them at virtioNetRegConfig.
+const (
+	virtioRegDeviceFeatures = 0x00 // 32-bit, RO
+	virtioRegGuestFeatures  = 0x04 // 32-bit, RW
+	virtioRegQueueAddress   = 0x08 // 32-bit, RW: queue's guest PFN, or 0 to read back the selected queue's PFN
+	virtioRegQueueSize      = 0x0c // 16-bit, RO
+	virtioRegQueueSelect    = 0x0e // 16-bit, RW
+	virtioRegQueueNotify    = 0x10 // 16-bit, WO
+	virtioRegDeviceStatus   = 0x12 // 8-bit, RW
+	virtioRegISRStatus      = 0x13 // 8-bit, RO, read-to-clear
+
+	virtioNetRegConfig = 0x14 // device-specific config: the 6-byte MAC
+)
+
+// Device status register bits, written by the driver as it works through
+// virtio's initialization sequence.
+const (
+	virtioStatusAcknowledge = 1 << 0
+	virtioStatusDriver      = 1 << 1
+	virtioStatusDriverOK    = 1 << 2
+	virtioStatusFailed      = 1 << 7
+)
+
+// ISR status register bits.
+const (
+	virtioISRQueue = 1 << 0
+)
+
+// VirtioNetFMAC is the only feature this device advertises: that
+// virtioNetRegConfig holds a driver-usable MAC address.
+const VirtioNetFMAC = 1 << 5
+
+// virtioNetQueueSize is the fixed number of descriptors in each of this
+// device's virtqueues; legacy virtio has no mechanism for the driver to
+// ask for anything smaller, so it's the value virtioRegQueueSize always
+// reports regardless of virtioRegQueueSelect.
+const virtioNetQueueSize = 256
+
+// virtioNetRXQueue and virtioNetTXQueue are the only two queue indices
+// this device exposes, matching every virtio-net device before
+// multiqueue: 0 for buffers the driver posts for incoming frames, 1 for
+// frames the driver has staged for transmission.
+const (
+	virtioNetRXQueue   = 0
+	virtioNetTXQueue   = 1
+	virtioNetNumQueues = 2
+)
+
+// virtioNetHdrLen is sizeof(struct virtio_net_hdr): flags, gso_type,
+// hdr_len, gso_size, csum_start, csum_offset. Neither checksum offload
+// nor GSO is negotiable here, so every header this device reads or
+// writes is all zero, but it still has to be there for the driver to
+// skip over.
+const virtioNetHdrLen = 10
+
+// virtioQueueAlign is the byte alignment the used ring is padded up to
+// past the end of the descriptor table and available ring, per the
+// legacy virtio queue layout.
+const virtioQueueAlign = dirtyPageSize
+
+// virtioDescLen is the size of one split-ring descriptor: addr (u64), len
+// (u32), flags (u16), next (u16).
+const virtioDescLen = 16
+
+// Descriptor flags.
+const (
+	virtioDescFNext = 1 << 0
+)
+
+// virtioQueue is one split-ring virtqueue's negotiated configuration:
+// where its three rings live in guest memory, and how far this device
+// has drained the available ring so far.
+type virtioQueue struct {
+	pfn          uint32
+	lastAvailIdx uint16
+}
+
+func (q *virtioQueue) addr() uint64 { return uint64(q.pfn) * dirtyPageSize }
+
+// descTableAddr, availRingAddr and usedRingAddr lay out one queue's three
+// rings, following the legacy virtio spec: the descriptor table, then the
+// available ring immediately after it, then the used ring padded up to
+// virtioQueueAlign.
+func (q *virtioQueue) descTableAddr() uint64 { return q.addr() }
+
+func (q *virtioQueue) availRingAddr() uint64 {
+	return q.descTableAddr() + virtioNetQueueSize*virtioDescLen
+}
+
+func (q *virtioQueue) usedRingAddr() uint64 {
+	availEnd := q.availRingAddr() + 4 + 2*virtioNetQueueSize
+	return (availEnd + virtioQueueAlign - 1) &^ (virtioQueueAlign - 1)
+}
+
+// VirtioNetDevice is a legacy (virtio 0.9.5), transitional virtio-net
+// device exposed over a PCI I/O BAR: a split-ring RX/TX queue pair backed
+// by a HostNetInterface, in place of the byte-at-a-time NE2000Device for
+// guests with a virtio driver. Queue processing runs on its own
+// goroutine, woken by a write to virtioRegQueueNotify, so a slow or
+// blocking HostNetInterface.WritePacket never stalls register accesses
+// the way NE2000Device.HandleIO takes care to avoid too.
+type VirtioNetDevice struct {
+	mu sync.Mutex
+
+	base uint16
+	mac  [6]byte
+	tap  HostNetInterface
+
+	guestMem GuestMemoryAccessor
+
+	deviceFeatures uint32
+	guestFeatures  uint32
+	status         uint8
+	isr            uint8
+	queueSelect    uint16
+	queues         [virtioNetNumQueues]virtioQueue
+
+	stats  *Stats
+	logger Logger
+
+	raiser      IrqRaiser
+	irq         int
+	irqAsserted bool
+
+	notify  chan uint16
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+	started bool
+
+	// ioEnabled gates whether HandleIO actually services this device's
+	// ports, mirroring the PCI Command register's I/O Space Enable bit
+	// once this device is exposed via VirtioNetPCIFunction (see
+	// SetIOEnabled). Defaults to enabled.
+	ioEnabled bool
+}
+
+// NewVirtioNetDevice returns a device with the given MAC, bridged to tap
+// for outbound traffic and GuestMemoryAccessor mem for reading and
+// writing queue contents. tap may be nil, in which case transmitted
+// frames are dropped (useful for register-level tests); mem must not be
+// nil, since every queue operation dereferences guest memory.
+func NewVirtioNetDevice(mac [6]byte, tap HostNetInterface, mem GuestMemoryAccessor) *VirtioNetDevice {
+	d := &VirtioNetDevice{
+		base:           ne2000DefaultBase,
+		mac:            mac,
+		tap:            tap,
+		guestMem:       mem,
+		deviceFeatures: VirtioNetFMAC,
+		logger:         noopLogger{},
+		notify:         make(chan uint16, virtioNetNumQueues),
+		closeCh:        make(chan struct{}),
+		ioEnabled:      true,
+	}
+	d.Reset()
+	d.startWorker()
+	return d
+}
+
+// SetIOEnabled gates whether HandleIO services this device's ports at
+// all: with it clear, a read returns the floating-bus value (every byte
+// 0xff) and a write is silently dropped, as if nothing were listening at
+// these ports, without this device actually being unregistered from the
+// IOBus. VirtioNetPCIFunction calls this to mirror the PCI Command
+// register's I/O Space Enable bit. Defaults to enabled.
+func (d *VirtioNetDevice) SetIOEnabled(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ioEnabled = enabled
+}
+
+// startWorker launches the background goroutine that drains d.notify;
+// called once, from the constructor. Close stops the worker; Reset
+// leaves it running.
+func (d *VirtioNetDevice) startWorker() {
+	d.wg.Add(1)
+	d.started = true
+	go d.worker()
+}
+
+// Close stops this device's queue-processing goroutine. It does not
+// close tap; the caller owns that (see VirtualMachine.Close, which closes
+// a wired-in HostNetInterface itself).
+func (d *VirtioNetDevice) Close() error {
+	d.mu.Lock()
+	if !d.started {
+		d.mu.Unlock()
+		return nil
+	}
+	d.started = false
+	d.mu.Unlock()
+
+	close(d.closeCh)
+	d.wg.Wait()
+	return nil
+}
+
+func (d *VirtioNetDevice) worker() {
+	defer d.wg.Done()
+	for {
+		select {
+		case idx := <-d.notify:
+			if idx == virtioNetTXQueue {
+				d.processTXQueue()
+			}
+		case <-d.closeCh:
+			return
+		}
+	}
+}
+
+// SetLogger installs l to receive this device's diagnostics. Passing nil
+// restores the default no-op logger.
+func (d *VirtioNetDevice) SetLogger(l Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if l == nil {
+		l = noopLogger{}
+	}
+	d.logger = l
+}
+
+// SetStats wires stats to be updated by this device's TX/RX paths.
+// Passing nil (the default) disables recording.
+func (d *VirtioNetDevice) SetStats(stats *Stats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats = stats
+}
+
+// SetIrqRaiser wires this device to raiser's irq line: whenever ISR is
+// nonzero, RaiseIRQ(irq) is called once, followed by a matching
+// LowerIRQ(irq) once the driver has read ISR back to zero (legacy virtio
+// clears ISR as a side effect of the read). Leaving it unset (the
+// default) means the interrupt condition is only visible by polling ISR.
+func (d *VirtioNetDevice) SetIrqRaiser(raiser IrqRaiser, irq int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.raiser = raiser
+	d.irq = irq
+	d.updateIrqLocked()
+}
+
+// setBase reprograms the device's I/O port base, as issued by a PCI BAR
+// write. Callers must re-register the device on the IOBus at the new
+// ports; this only updates the address this device answers to.
+func (d *VirtioNetDevice) setBase(base uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.base = base
+}
+
+// Ports implements PioDevice.
+func (d *VirtioNetDevice) Ports() []uint16 {
+	n := virtioNetRegConfig + 6
+	ports := make([]uint16, n)
+	for i := range ports {
+		ports[i] = d.base + uint16(i)
+	}
+	return ports
+}
+
+// HandleIO implements PioDevice.
+func (d *VirtioNetDevice) HandleIO(port uint16, data []byte, write bool) error {
+	off := int(port - d.base)
+
+	d.mu.Lock()
+	if !d.ioEnabled {
+		if !write {
+			for i := range data {
+				data[i] = 0xff
+			}
+		}
+		d.mu.Unlock()
+		return nil
+	}
+	var notifyQueue uint16
+	var notifyPending bool
+	switch {
+	case off == virtioRegDeviceFeatures:
+		d.access32Locked(&d.deviceFeatures, data, false)
+	case off == virtioRegGuestFeatures:
+		d.access32Locked(&d.guestFeatures, data, write)
+	case off == virtioRegQueueAddress:
+		q := &d.queues[d.queueSelect%virtioNetNumQueues]
+		d.access32Locked(&q.pfn, data, write)
+	case off == virtioRegQueueSize:
+		if !write && len(data) >= 2 {
+			binary.LittleEndian.PutUint16(data, virtioNetQueueSize)
+		}
+	case off == virtioRegQueueSelect:
+		d.accessQueueSelectLocked(data, write)
+	case off == virtioRegQueueNotify:
+		if write && len(data) >= 2 {
+			notifyQueue = binary.LittleEndian.Uint16(data) % virtioNetNumQueues
+			notifyPending = true
+		}
+	case off == virtioRegDeviceStatus:
+		if write && len(data) > 0 {
+			d.status = data[0]
+			if d.status == 0 {
+				d.resetLocked()
+			}
+		} else if len(data) > 0 {
+			data[0] = d.status
+		}
+	case off == virtioRegISRStatus:
+		if !write && len(data) > 0 {
+			data[0] = d.isr
+			d.isr = 0
+			d.updateIrqLocked()
+		}
+	case off >= virtioNetRegConfig && off < virtioNetRegConfig+6:
+		i := off - virtioNetRegConfig
+		if write && len(data) > 0 {
+			d.mac[i] = data[0]
+		} else if len(data) > 0 {
+			data[0] = d.mac[i]
+		}
+	}
+	d.mu.Unlock()
+
+	if notifyPending {
+		select {
+		case d.notify <- notifyQueue:
+		default:
+			// A full channel means a notification for this queue is
+			// already queued; the worker will see the ring's current
+			// state once it gets to it, so dropping this wakeup is safe.
+		}
+	}
+	return nil
+}
+
+func (d *VirtioNetDevice) access32Locked(reg *uint32, data []byte, write bool) {
+	if write {
+		if len(data) >= 4 {
+			*reg = binary.LittleEndian.Uint32(data)
+		}
+	} else if len(data) >= 4 {
+		binary.LittleEndian.PutUint32(data, *reg)
+	}
+}
+
+func (d *VirtioNetDevice) accessQueueSelectLocked(data []byte, write bool) {
+	if write {
+		if len(data) >= 2 {
+			d.queueSelect = binary.LittleEndian.Uint16(data)
+		}
+	} else if len(data) >= 2 {
+		binary.LittleEndian.PutUint16(data, d.queueSelect)
+	}
+}
+
+// resetLocked clears negotiated queue state when the driver writes 0 to
+// virtioRegDeviceStatus, the legacy reset sequence.
+func (d *VirtioNetDevice) resetLocked() {
+	d.guestFeatures = 0
+	d.queueSelect = 0
+	d.isr = 0
+	for i := range d.queues {
+		d.queues[i] = virtioQueue{}
+	}
+	d.updateIrqLocked()
+}
+
+// updateIrqLocked asserts or deasserts this device's interrupt line to
+// match ISR, a no-op if SetIrqRaiser was never called.
+func (d *VirtioNetDevice) updateIrqLocked() {
+	if d.raiser == nil {
+		return
+	}
+	pending := d.isr != 0
+	if pending && !d.irqAsserted {
+		d.irqAsserted = true
+		d.raiser.RaiseIRQ(d.irq)
+	} else if !pending && d.irqAsserted {
+		d.irqAsserted = false
+		d.raiser.LowerIRQ(d.irq)
+	}
+}
+
+// nextAvailDescLocked returns the head descriptor index of the next
+// not-yet-consumed entry in q's available ring, advancing
+// q.lastAvailIdx, or ok=false if the driver hasn't posted anything new.
+func (d *VirtioNetDevice) nextAvailDescLocked(q *virtioQueue) (head uint16, ok bool) {
+	availBase := q.availRingAddr()
+	hdr, err := d.guestMem.GuestSlice(availBase, 4)
+	if err != nil {
+		return 0, false
+	}
+	avail := binary.LittleEndian.Uint16(hdr[2:4])
+	if avail == q.lastAvailIdx {
+		return 0, false
+	}
+
+	ringSlot := q.lastAvailIdx % virtioNetQueueSize
+	entry, err := d.guestMem.GuestSlice(availBase+4+uint64(ringSlot)*2, 2)
+	if err != nil {
+		return 0, false
+	}
+	head = binary.LittleEndian.Uint16(entry)
+	q.lastAvailIdx++
+	return head, true
+}
+
+// readDescLocked reads descriptor index i out of q's descriptor table.
+func (d *VirtioNetDevice) readDescLocked(q *virtioQueue, index uint16) (addr uint64, length uint32, flags, next uint16, err error) {
+	off := q.descTableAddr() + uint64(index)*virtioDescLen
+	raw, err := d.guestMem.GuestSlice(off, virtioDescLen)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	addr = binary.LittleEndian.Uint64(raw[0:8])
+	length = binary.LittleEndian.Uint32(raw[8:12])
+	flags = binary.LittleEndian.Uint16(raw[12:14])
+	next = binary.LittleEndian.Uint16(raw[14:16])
+	return addr, length, flags, next, nil
+}
+
+// writeUsedLocked appends {head, len} to q's used ring and bumps its idx,
+// the driver-visible record of one descriptor chain the device has
+// finished with.
+func (d *VirtioNetDevice) writeUsedLocked(q *virtioQueue, head uint16, length uint32) error {
+	usedBase := q.usedRingAddr()
+	hdr, err := d.guestMem.GuestSlice(usedBase, 4)
+	if err != nil {
+		return err
+	}
+	usedIdx := binary.LittleEndian.Uint16(hdr[2:4])
+
+	slot := usedIdx % virtioNetQueueSize
+	entry, err := d.guestMem.GuestSlice(usedBase+4+uint64(slot)*8, 8)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(entry[0:4], uint32(head))
+	binary.LittleEndian.PutUint32(entry[4:8], length)
+
+	binary.LittleEndian.PutUint16(hdr[2:4], usedIdx+1)
+	return nil
+}
+
+// processTXQueue drains every descriptor chain the driver has posted to
+// the TX queue since it was last drained: each chain's payload, minus its
+// leading virtioNetHdrLen-byte virtio_net_hdr, is one Ethernet frame to
+// hand to tap.
+func (d *VirtioNetDevice) processTXQueue() {
+	d.mu.Lock()
+	q := &d.queues[virtioNetTXQueue]
+
+	var toSend [][]byte
+	var heads []uint16
+	for {
+		head, ok := d.nextAvailDescLocked(q)
+		if !ok {
+			break
+		}
+
+		var frame []byte
+		index := head
+		for {
+			addr, length, flags, next, err := d.readDescLocked(q, index)
+			if err != nil {
+				d.logger.Warnf("vmm: virtio-net: TX descriptor %d: %v", index, err)
+				break
+			}
+			buf, err := d.guestMem.GuestSlice(addr, int(length))
+			if err != nil {
+				d.logger.Warnf("vmm: virtio-net: TX descriptor %d data at %#x: %v", index, addr, err)
+				break
+			}
+			frame = append(frame, buf...)
+			if flags&virtioDescFNext == 0 {
+				break
+			}
+			index = next
+		}
+		if len(frame) > virtioNetHdrLen {
+			frame = frame[virtioNetHdrLen:]
+		} else {
+			frame = nil
+		}
+
+		toSend = append(toSend, frame)
+		heads = append(heads, head)
+	}
+
+	for i, head := range heads {
+		if err := d.writeUsedLocked(q, head, uint32(len(toSend[i]))+virtioNetHdrLen); err != nil {
+			d.logger.Warnf("vmm: virtio-net: writing TX used entry: %v", err)
+		}
+	}
+	if len(heads) > 0 {
+		d.isr |= virtioISRQueue
+		d.updateIrqLocked()
+	}
+	stats := d.stats
+	tap := d.tap
+	d.mu.Unlock()
+
+	for _, frame := range toSend {
+		if len(frame) == 0 {
+			continue
+		}
+		stats.RecordNICTx(len(frame))
+		if tap != nil {
+			_ = tap.WritePacket(frame)
+		}
+	}
+}
+
+// ReceiveFrame delivers one Ethernet frame from the host side into the
+// next buffer the driver has posted on the RX queue, prepending the
+// all-zero virtio_net_hdr every legacy virtio-net frame carries. If the
+// driver hasn't posted a buffer (or the posted buffer is too small), the
+// frame is dropped, mirroring NE2000Device.ReceiveFrame's drop-on-full
+// behavior rather than blocking or erroring.
+func (d *VirtioNetDevice) ReceiveFrame(pkt []byte) error {
+	d.mu.Lock()
+	q := &d.queues[virtioNetRXQueue]
+
+	head, ok := d.nextAvailDescLocked(q)
+	if !ok {
+		d.mu.Unlock()
+		return nil
+	}
+
+	addr, length, _, _, err := d.readDescLocked(q, head)
+	if err != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("vmm: virtio-net: RX descriptor %d: %w", head, err)
+	}
+	total := virtioNetHdrLen + len(pkt)
+	if int(length) < total {
+		d.logger.Warnf("vmm: virtio-net: RX buffer %d bytes too small for a %d-byte frame, dropping", length, total)
+		d.mu.Unlock()
+		return nil
+	}
+
+	buf, err := d.guestMem.GuestSlice(addr, total)
+	if err != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("vmm: virtio-net: RX buffer at %#x: %w", addr, err)
+	}
+	for i := 0; i < virtioNetHdrLen; i++ {
+		buf[i] = 0
+	}
+	copy(buf[virtioNetHdrLen:], pkt)
+
+	if err := d.writeUsedLocked(q, head, uint32(total)); err != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("vmm: virtio-net: writing RX used entry: %w", err)
+	}
+	d.isr |= virtioISRQueue
+	d.updateIrqLocked()
+	stats := d.stats
+	d.mu.Unlock()
+
+	stats.RecordNICRx(len(pkt))
+	return nil
+}
+
+// Reset implements PioDevice.
+func (d *VirtioNetDevice) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = 0
+	d.resetLocked()
+}
+
+// Name implements PioDevice.
+func (d *VirtioNetDevice) Name() string { return "virtio-net" }
@@ -0,0 +1,78 @@
+package vmm
+
+// VirtioNetPCIFunction exposes a VirtioNetDevice as a legacy
+// (transitional) virtio-net PCI function: the vendor/device IDs the
+// virtio spec reserves for it, an I/O BAR0 programmable by guest
+// firmware, and class code 0x020000 (Ethernet controller), the same
+// class NE2000PCIFunction reports. bus is used to move dev's port claim
+// whenever BAR0 is reprogrammed; irqRaiser (may be nil) is where dev's
+// interrupt line goes once firmware routes it via the Interrupt Line
+// register.
+type VirtioNetPCIFunction struct {
+	dev       *VirtioNetDevice
+	bus       *IOBus
+	irqRaiser IrqRaiser
+
+	command       uint16
+	bar0          uint32
+	interruptLine uint8
+}
+
+const (
+	virtioNetPCIVendorID = 0x1af4
+	virtioNetPCIDeviceID = 0x1000
+)
+
+// NewVirtioNetPCIFunction wraps dev for attachment to a PCIBus via
+// PCIBus.RegisterFunction. dev must already be registered on bus at its
+// current Ports() — the Command register's I/O Space Enable bit powers
+// on clear, matching real hardware, so dev's ports won't actually answer
+// until ConfigWrite enables them.
+func NewVirtioNetPCIFunction(dev *VirtioNetDevice, bus *IOBus, irqRaiser IrqRaiser) *VirtioNetPCIFunction {
+	dev.SetIOEnabled(false)
+	return &VirtioNetPCIFunction{dev: dev, bus: bus, irqRaiser: irqRaiser, bar0: uint32(dev.base) | 0x1} // bit 0 set: I/O space BAR
+}
+
+// ConfigRead implements PCIFunction.
+func (f *VirtioNetPCIFunction) ConfigRead(off uint8, size int) uint32 {
+	switch off {
+	case 0x00:
+		return uint32(virtioNetPCIDeviceID)<<16 | virtioNetPCIVendorID
+	case 0x04:
+		return uint32(f.command)
+	case 0x08:
+		return 0x02000000 // class=network, subclass=ethernet
+	case 0x10:
+		return f.bar0
+	case pciRegInterruptLine:
+		return uint32(f.interruptLine)
+	default:
+		return 0
+	}
+}
+
+// ConfigWrite implements PCIFunction. BAR0, the command register, and the
+// interrupt line register are writable; guests use BAR0 to relocate the
+// device's I/O ports, the command register to enable I/O space, and the
+// interrupt line register to tell the device which IRQ it's been routed
+// to.
+func (f *VirtioNetPCIFunction) ConfigWrite(off uint8, size int, val uint32) {
+	switch off {
+	case 0x04:
+		f.command = uint16(val)
+		f.dev.SetIOEnabled(f.command&pciCommandIOSpace != 0)
+	case 0x10:
+		oldPorts := f.dev.Ports()
+		f.bar0 = (val &^ 0x3) | 0x1
+		f.dev.setBase(uint16(f.bar0 &^ 0x3))
+		// A guest that relocates BAR0 onto a range another device
+		// already owns gets what real hardware would too: an
+		// unresolvable resource conflict. There's nowhere in this
+		// interface to report that, so it's left for the guest (or the
+		// firmware that assigned the overlapping ranges) to sort out.
+		_ = f.bus.Reregister(f.dev, oldPorts)
+	case pciRegInterruptLine:
+		f.interruptLine = uint8(val)
+		f.dev.SetIrqRaiser(f.irqRaiser, int(f.interruptLine))
+	}
+}
@@ -0,0 +1,134 @@
+package vmm
+
+import "testing"
+
+func TestVirtioNetPCIFunctionReportsVirtioIdentity(t *testing.T) {
+	dev := newTestVirtioNet(nil, &fakeGuestMemoryAccessor{mem: make([]byte, 4096)})
+	defer dev.Close()
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	fn := NewVirtioNetPCIFunction(dev, bus, nil)
+
+	pciBus := NewPCIBus()
+	pciBus.RegisterFunction(0, 5, 0, fn)
+
+	addrBuf := make([]byte, 4)
+	encodeLE(addrBuf, pciConfigAddr(0, 5, 0, 0x00))
+	if err := pciBus.HandleIO(pciPortConfigAddress, addrBuf, true); err != nil {
+		t.Fatalf("write address: %v", err)
+	}
+	data := make([]byte, 4)
+	if err := pciBus.HandleIO(pciPortConfigData, data, false); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	want := uint32(virtioNetPCIDeviceID)<<16 | virtioNetPCIVendorID
+	if got := decodeLE(data); got != want {
+		t.Errorf("vendor/device = %#x, want %#x", got, want)
+	}
+}
+
+// TestVirtioNetPCIFunctionBAR0RelocatesDeviceOnIOBus checks that writing
+// BAR0 doesn't just update dev.base: the old ports actually stop
+// answering on the IOBus, and the new ports actually take over, once
+// I/O space is enabled via the command register.
+func TestVirtioNetPCIFunctionBAR0RelocatesDeviceOnIOBus(t *testing.T) {
+	dev := newTestVirtioNet(nil, &fakeGuestMemoryAccessor{mem: make([]byte, 4096)})
+	defer dev.Close()
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	fn := NewVirtioNetPCIFunction(dev, bus, nil)
+	fn.ConfigWrite(0x04, 2, pciCommandIOSpace)
+
+	oldBase := dev.base
+	if err := bus.Dispatch(oldBase+virtioRegDeviceStatus, []byte{0}, false); err != nil {
+		t.Fatalf("Dispatch at old base before relocation: %v", err)
+	}
+
+	fn.ConfigWrite(0x10, 4, 0x0340)
+	if dev.base != 0x340 {
+		t.Fatalf("dev.base = %#x, want 0x340", dev.base)
+	}
+
+	if err := bus.Dispatch(oldBase+virtioRegDeviceStatus, []byte{0}, false); err == nil {
+		t.Errorf("Dispatch at old base %#x after relocation: got nil error, want ErrUnhandledPort", oldBase)
+	}
+	if err := bus.Dispatch(dev.base+virtioRegDeviceStatus, []byte{0}, false); err != nil {
+		t.Errorf("Dispatch at new base %#x after relocation: %v", dev.base, err)
+	}
+}
+
+// TestVirtioNetPCIFunctionCommandRegisterGatesIOSpace checks that
+// clearing the command register's I/O Space Enable bit makes the
+// device's ports stop responding without unregistering them, and that a
+// read yields the floating-bus value (0xff).
+func TestVirtioNetPCIFunctionCommandRegisterGatesIOSpace(t *testing.T) {
+	dev := newTestVirtioNet(nil, &fakeGuestMemoryAccessor{mem: make([]byte, 4096)})
+	defer dev.Close()
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	fn := NewVirtioNetPCIFunction(dev, bus, nil)
+
+	data := []byte{0xaa}
+	if err := bus.Dispatch(dev.base+virtioRegDeviceStatus, data, false); err != nil {
+		t.Fatalf("Dispatch with I/O space disabled: %v", err)
+	}
+	if data[0] != 0xff {
+		t.Errorf("read with I/O space disabled = %#x, want 0xff (floating bus)", data[0])
+	}
+
+	fn.ConfigWrite(0x04, 2, pciCommandIOSpace)
+	data[0] = 0xaa
+	if err := bus.Dispatch(dev.base+virtioRegDeviceStatus, data, false); err != nil {
+		t.Fatalf("Dispatch with I/O space enabled: %v", err)
+	}
+	if data[0] == 0xff {
+		t.Errorf("read with I/O space enabled still returned the floating-bus value")
+	}
+}
+
+// TestVirtioNetPCIFunctionInterruptLineRoutesToPIC checks that writing
+// the Interrupt Line register wires the device to raise that IRQ on the
+// configured PIC.
+func TestVirtioNetPCIFunctionInterruptLineRoutesToPIC(t *testing.T) {
+	mem := &fakeGuestMemoryAccessor{mem: make([]byte, 64*1024)}
+	dev := newTestVirtioNet(nil, mem)
+	defer dev.Close()
+	bus := NewIOBus()
+	if err := bus.Register(dev); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	pic := NewMasterPIC()
+	fn := NewVirtioNetPCIFunction(dev, bus, pic)
+	fn.ConfigWrite(0x04, 2, pciCommandIOSpace)
+	fn.ConfigWrite(pciRegInterruptLine, 1, 5)
+
+	if got := fn.ConfigRead(pciRegInterruptLine, 1); got != 5 {
+		t.Errorf("Interrupt Line readback = %d, want 5", got)
+	}
+
+	// Unmask IRQ5 so the PIC actually latches it (see TestPICStateRoundTrips).
+	if err := pic.HandleIO(picMasterDataPort, []byte{^uint8(1 << 5)}, true); err != nil {
+		t.Fatalf("unmask IRQ5: %v", err)
+	}
+
+	q := virtioQueue{pfn: 1}
+	rxBufAddr := uint64(32 * 1024)
+	writeDesc(t, mem, &q, 0, rxBufAddr, 2048, 0, 0)
+	postAvail(t, mem, &q, 0)
+	virtioWrite16(t, dev, virtioRegQueueSelect, virtioNetRXQueue)
+	virtioWrite32(t, dev, virtioRegQueueAddress, q.pfn)
+
+	if err := dev.ReceiveFrame([]byte{0x01, 0x02, 0x03}); err != nil {
+		t.Fatalf("ReceiveFrame: %v", err)
+	}
+
+	if _, ok := pic.GetInterruptVector(); !ok {
+		t.Error("PIC did not latch a vector after the device raised its routed IRQ")
+	}
+}
@@ -0,0 +1,638 @@
+package vmm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// ShutdownReason describes why VirtualMachine.Run returned.
+type ShutdownReason int
+
+const (
+	// ShutdownReasonError means Run returned because a VCPU step
+	// reported an unrecoverable error; the accompanying error holds
+	// details.
+	ShutdownReasonError ShutdownReason = iota
+	// ShutdownReasonPoweroff means the guest requested power-off.
+	ShutdownReasonPoweroff
+	// ShutdownReasonReset means Run returned after a guest-requested
+	// reset could not be completed transparently and the caller must
+	// restart the run loop (e.g. after a reload failure).
+	ShutdownReasonReset
+	// ShutdownReasonContext means RunContext returned because its
+	// context was cancelled or its deadline passed; the accompanying
+	// error is ctx.Err().
+	ShutdownReasonContext
+)
+
+func (r ShutdownReason) String() string {
+	switch r {
+	case ShutdownReasonPoweroff:
+		return "poweroff"
+	case ShutdownReasonReset:
+		return "reset"
+	case ShutdownReasonContext:
+		return "context"
+	default:
+		return "error"
+	}
+}
+
+// RunResult is returned by VirtualMachine.Run.
+type RunResult struct {
+	Reason ShutdownReason
+	Err    error
+}
+
+// VirtualMachine owns the devices attached to a guest and coordinates
+// guest-initiated lifecycle transitions (reset, power-off) with the VCPU
+// run loop.
+type VirtualMachine struct {
+	IOBus *IOBus
+
+	// reload re-applies a fresh boot state (registers, GDT, page tables)
+	// to a VCPU as part of a reset. It is supplied by the caller because
+	// the boot image and load addresses live outside this package.
+	reload func(hypervisor.VCPU) error
+	vcpus  []hypervisor.VCPU
+
+	// injectInterrupt delivers a single interrupt vector to a VCPU, e.g.
+	// via the KVM_INTERRUPT ioctl. It is supplied by the caller, the same
+	// as reload, because the ioctl plumbing lives outside this package;
+	// see SetInterruptInjector.
+	injectInterrupt func(vector uint8) error
+
+	// kick interrupts a VCPU thread that may be blocked in a real KVM_RUN
+	// call, e.g. via hypervisor.VCPUKicker.Kick. It is supplied by the
+	// caller, the same as reload and injectInterrupt, since the OS thread
+	// running KVM_RUN lives outside this package. Nil (the default) means
+	// RequestReset/RequestPoweroff/InjectInterrupt take effect only at the
+	// step function's next natural return, same as before this existed.
+	kick func() error
+
+	mu                sync.Mutex
+	devices           []PioDevice
+	resetRequested    bool
+	poweroffRequested bool
+
+	stats *Stats
+
+	// memory is set by SetMemoryLayout and read by DumpMemory, LoadMemory,
+	// and DumpCore.
+	memory *MemoryLayout
+
+	// pauseMu is held around each step() call in Run, and by
+	// DumpMemory/LoadMemory/DumpCore before they touch guest memory, so a
+	// dump or reload always sees a consistent snapshot instead of racing a
+	// VCPU that's still running.
+	pauseMu sync.Mutex
+
+	// snapshotCompressor, if set, compresses the memory image Snapshot
+	// writes (and decompresses it in RestoreSnapshot). Nil means the
+	// memory image is stored uncompressed.
+	snapshotCompressor Compressor
+
+	// dirtyLog is set by SetDirtyLogReader and read by GetDirtyPages and
+	// SnapshotIncremental.
+	dirtyLog DirtyLogReader
+
+	// irqChip, if set by SetInKernelIrqchip, means this VM routes
+	// interrupt lines through KVM's in-kernel PIC/PIT instead of
+	// emulating them with PICDevice/PITDevice on the IOBus.
+	irqChip *InKernelIrqChip
+
+	// apState tracks which non-BSP vcpus are still parked awaiting
+	// StartAP; see APParked/StartAP.
+	apState *apState
+
+	// watchdog, if set by SetWatchdog, is consulted by NoteVCPUExit for
+	// every exit an exit loop reports.
+	watchdog *Watchdog
+
+	// gdbTarget is set by SetGDBTarget and driven by StartGDBServer.
+	gdbTarget GDBTarget
+
+	// coreDumpMSRs is set by SetCoreDumpMSRs and read by DumpCore.
+	coreDumpMSRs []uint32
+
+	// logger receives crash reports built by BuildCrashReport/
+	// NoteShutdownExit. Defaults to noopLogger; see SetLogger.
+	logger Logger
+
+	// netIface is the VM's host networking transport, if any, set by
+	// SetNetworkInterface. Nil means no networking is attached; Close
+	// closes it if it implements io.Closer.
+	netIface HostNetInterface
+}
+
+// NewVirtualMachine returns a VirtualMachine with no devices registered.
+// reload may be nil if the caller never intends to call RequestReset.
+func NewVirtualMachine(vcpus []hypervisor.VCPU, reload func(hypervisor.VCPU) error) *VirtualMachine {
+	stats := NewStats()
+	bus := NewIOBus()
+	bus.SetStats(stats)
+	return &VirtualMachine{
+		IOBus:   bus,
+		vcpus:   vcpus,
+		reload:  reload,
+		stats:   stats,
+		apState: newAPState(len(vcpus)),
+		logger:  noopLogger{},
+	}
+}
+
+// SetLogger installs l to receive crash reports (see BuildCrashReport
+// and NoteShutdownExit). Passing nil restores the default, which
+// discards them.
+func (vm *VirtualMachine) SetLogger(l Logger) {
+	if l == nil {
+		l = noopLogger{}
+	}
+	vm.logger = l
+}
+
+// Stats returns a point-in-time snapshot of this VM's counters.
+func (vm *VirtualMachine) Stats() StatsSnapshot {
+	return vm.stats.Snapshot()
+}
+
+// VCPUStats returns a point-in-time snapshot of vcpuIndex's CPU-time
+// counters: total KVM_RUN invocations, time spent in-guest versus
+// handling exits, and a histogram of exit reasons. A vcpuIndex that has
+// never run reports the zero value.
+func (vm *VirtualMachine) VCPUStats(vcpuIndex int) VCPUStats {
+	return vm.stats.VCPUSnapshot(vcpuIndex)
+}
+
+// SetStatsClock times the in-guest/handling-exits split VCPUStats
+// reports. Call it once before Run, the same as SetWatchdog; leaving it
+// unset (the default) uses RealClock.
+func (vm *VirtualMachine) SetStatsClock(c Clock) {
+	vm.stats.Clock = c
+}
+
+// RecordExit lets the caller's VCPU exit loop count one exit of the
+// given kvm_run exit_reason, since decoding kvm_run itself lives outside
+// this package.
+func (vm *VirtualMachine) RecordExit(reason uint32) {
+	vm.stats.RecordExit(reason)
+}
+
+// SetWatchdog wires w to receive every exit reported through
+// NoteVCPUExit and every hang check through CheckVCPUHang. Call it once
+// before Run, the same as SetMemoryLayout. Leaving it unset (the
+// default) means NoteVCPUExit only updates Stats, as RecordExit always
+// has, and CheckVCPUHang is a no-op.
+func (vm *VirtualMachine) SetWatchdog(w *Watchdog) {
+	vm.watchdog = w
+}
+
+// NoteVCPUExit records one vmexit the same way RecordExit does, tallies
+// it against vcpuIndex's per-VCPU exit histogram (see VCPUStats), and
+// additionally runs it past this VM's Watchdog if SetWatchdog was
+// called. vcpuIndex identifies which of vm's VCPUs exited; unhandled
+// should be true when the caller's device dispatch could not service the
+// exit (e.g. IOBus.Dispatch returned *ErrUnhandledPort). vcpu is used to
+// fetch a register dump, but only if a watchdog threshold actually trips.
+//
+// It returns WatchdogActionIgnore, a safe no-op, if no watchdog is set;
+// otherwise the caller's step function is expected to act on the
+// returned WatchdogAction (e.g. returning an error on
+// WatchdogActionKill).
+func (vm *VirtualMachine) NoteVCPUExit(vcpuIndex int, reason uint32, unhandled bool, vcpu hypervisor.VCPU) WatchdogAction {
+	vm.stats.RecordExit(reason)
+	vm.stats.RecordVCPUExit(vcpuIndex, reason)
+	if vm.watchdog == nil {
+		return WatchdogActionIgnore
+	}
+	return vm.watchdog.NoteExit(vcpuIndex, reason, unhandled, func() (hypervisor.KvmRegs, error) {
+		if vcpu == nil {
+			return hypervisor.KvmRegs{}, errNoVCPUForRegsDump
+		}
+		return vcpu.GetRegs()
+	})
+}
+
+// NoteVCPURunStart marks vcpuIndex as having just entered KVM_RUN,
+// counting one more KVM_RUN invocation towards VCPUStats and, for a
+// separately-polled CheckVCPUHang to time against, updating the watchdog
+// if one is set. Call it immediately before issuing KVM_RUN.
+func (vm *VirtualMachine) NoteVCPURunStart(vcpuIndex int) {
+	vm.stats.RecordVCPURunStart(vcpuIndex)
+	if vm.watchdog != nil {
+		vm.watchdog.NoteRunStart(vcpuIndex)
+	}
+}
+
+// CheckVCPUHang reports whether vcpuIndex has been inside KVM_RUN longer
+// than this VM's Watchdog.MaxRunDuration allows. It's a no-op (false)
+// with no watchdog set; see Watchdog.CheckHang.
+func (vm *VirtualMachine) CheckVCPUHang(vcpuIndex int) (WatchdogEvent, bool) {
+	if vm.watchdog == nil {
+		return WatchdogEvent{}, false
+	}
+	return vm.watchdog.CheckHang(vcpuIndex)
+}
+
+// ServeMetrics starts an HTTP server on addr exposing this VM's stats in
+// Prometheus text exposition format at /metrics. It blocks until the
+// server stops (mirroring http.ListenAndServe), so callers typically run
+// it in its own goroutine.
+func (vm *VirtualMachine) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		vm.Stats().WriteProm(w)
+	})
+	return http.ListenAndServe(addr, mux)
+}
+
+// EnableTrace starts recording every IOBus dispatch this VM makes to w,
+// in the format ReplayBus reads back. Call DisableTrace to stop.
+func (vm *VirtualMachine) EnableTrace(w io.Writer) {
+	vm.IOBus.EnableTrace(w)
+}
+
+// DisableTrace stops recording, if EnableTrace was called.
+func (vm *VirtualMachine) DisableTrace() {
+	vm.IOBus.DisableTrace()
+}
+
+// SetMemoryLayout attaches mem so DumpMemory, LoadMemory, and DumpCore can
+// reach guest physical memory. Call it once before Run, the same as
+// IOBus.SetStats/SetClock.
+func (vm *VirtualMachine) SetMemoryLayout(mem *MemoryLayout) {
+	vm.memory = mem
+}
+
+// ReclaimRange releases the host pages backing guest physical range
+// [gpa, gpa+length) back to the kernel (see MemoryLayout.ReclaimRange),
+// then records the reclaimed bytes in Stats. The VM is paused for the
+// duration, the same as DumpMemory, so a VCPU running on another
+// goroutine can't be reading the range while it's punched out from under
+// it.
+func (vm *VirtualMachine) ReclaimRange(gpa, length uint64) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: ReclaimRange: no memory layout installed (call SetMemoryLayout first)")
+	}
+
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+	if err := vm.memory.ReclaimRange(gpa, length); err != nil {
+		return err
+	}
+	vm.stats.RecordReclaim(int64(length))
+	return nil
+}
+
+// PopulateRange prefaults the host pages backing guest physical range
+// [gpa, gpa+length) (see MemoryLayout.PopulateRange), typically to undo a
+// prior ReclaimRange before resuming latency-sensitive guest work. The VM
+// is paused for the duration, the same as ReclaimRange.
+func (vm *VirtualMachine) PopulateRange(gpa, length uint64) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: PopulateRange: no memory layout installed (call SetMemoryLayout first)")
+	}
+
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+	return vm.memory.PopulateRange(gpa, length)
+}
+
+// GuestSlice returns the length-byte sub-slice of guest physical memory
+// at [addr, addr+length) (see MemoryLayout.Slice), aliasing the backing
+// slot's array directly rather than copying it. It's meant for
+// DMA-capable devices (a busmaster IDE controller, an 8237, or NE2000 if
+// it ever DMAs to guest RAM) that need to read or write guest memory in
+// place; a write through the returned slice is visible to guest code and
+// to a later ReadGuestMemory exactly as if the guest itself had made it.
+// As with MemoryLayout.ReadAt/WriteAt, the whole range must be covered by
+// a single memory slot — a request spanning a memory hole or slot
+// boundary fails rather than returning a partial view. The slice is only
+// valid as long as the memory layout it came from isn't replaced with a
+// different one via SetMemoryLayout.
+func (vm *VirtualMachine) GuestSlice(addr uint64, length int) ([]byte, error) {
+	if vm.memory == nil {
+		return nil, fmt.Errorf("vmm: GuestSlice: no memory layout installed (call SetMemoryLayout first)")
+	}
+	return vm.memory.Slice(addr, length)
+}
+
+// ReadGuestMemory copies len(dst) bytes of guest physical memory
+// starting at addr into dst (see MemoryLayout.ReadAt), for a caller that
+// wants a private copy rather than GuestSlice's aliased view.
+func (vm *VirtualMachine) ReadGuestMemory(dst []byte, addr uint64) error {
+	if vm.memory == nil {
+		return fmt.Errorf("vmm: ReadGuestMemory: no memory layout installed (call SetMemoryLayout first)")
+	}
+	return vm.memory.ReadAt(dst, addr)
+}
+
+// SetSnapshotCompressor attaches c so Snapshot/RestoreSnapshot compress
+// and decompress the guest memory image through it. Call it once before
+// Snapshot, the same as SetMemoryLayout. Passing nil (the default) stores
+// the memory image uncompressed.
+func (vm *VirtualMachine) SetSnapshotCompressor(c Compressor) {
+	vm.snapshotCompressor = c
+}
+
+// SetDirtyLogReader attaches src so GetDirtyPages and SnapshotIncremental
+// can query which pages a guest has dirtied. Call it once before either,
+// the same as SetMemoryLayout.
+func (vm *VirtualMachine) SetDirtyLogReader(src DirtyLogReader) {
+	vm.dirtyLog = src
+}
+
+// SetInterruptInjector wires fn as the target of InjectInterrupt. Call it
+// once before Run, the same as SetMemoryLayout; a device that raises
+// interrupts itself (e.g. a local APIC's timer) is constructed with a
+// reference to InjectInterrupt, not to fn directly, so it keeps working
+// if the injector is swapped later.
+func (vm *VirtualMachine) SetInterruptInjector(fn func(vector uint8) error) {
+	vm.injectInterrupt = fn
+}
+
+// InjectInterrupt delivers vector to the guest via the injector set with
+// SetInterruptInjector. It is a no-op returning nil if none is set, e.g.
+// when a device that can raise interrupts is being exercised in
+// isolation by a test. It also kicks the VCPU thread (see SetVCPUKicker),
+// so a busy-looping guest sees the interrupt promptly instead of only at
+// its next unrelated exit.
+func (vm *VirtualMachine) InjectInterrupt(vector uint8) error {
+	if vm.injectInterrupt == nil {
+		return nil
+	}
+	if err := vm.injectInterrupt(vector); err != nil {
+		return err
+	}
+	vm.doKick()
+	return nil
+}
+
+// SetVCPUKicker wires fn as the target of the kick issued by
+// RequestReset, RequestPoweroff, and InjectInterrupt. fn should interrupt
+// the OS thread running the caller's KVM_RUN loop — typically
+// hypervisor.VCPUKicker.Kick — so those state changes take effect within
+// microseconds of being requested rather than waiting for the guest to
+// exit on its own. Call it once before Run, the same as
+// SetInterruptInjector; leaving it unset (the default) is safe and simply
+// means those requests take effect at the step function's next natural
+// return.
+func (vm *VirtualMachine) SetVCPUKicker(fn func() error) {
+	vm.kick = fn
+}
+
+// doKick calls the kicker set with SetVCPUKicker, if any, discarding its
+// error: a failed kick just means the guest is noticed a little later,
+// at the step function's next natural return, not that the request
+// itself failed.
+func (vm *VirtualMachine) doKick() {
+	if vm.kick != nil {
+		vm.kick()
+	}
+}
+
+// SetInKernelIrqchip switches this VM's interrupt-line routing to chip
+// instead of a userspace PICDevice. chip is typically constructed after
+// issuing KVM_CREATE_IRQCHIP and KVM_CREATE_PIT2 on the VM file
+// descriptor (see hypervisor.CreateIrqChip/CreatePIT2) and wrapping
+// hypervisor.SetIrqLine as its setIrqLine func.
+//
+// Call it once, before constructing or registering any interrupt devices
+// — see IrqRaiser, RegisterUserspacePIC, and RegisterUserspacePIT, which
+// branch on it. Not calling it at all (the default) keeps the userspace
+// path, so the fake-hypervisor tests keep working. It also means the
+// caller's VCPU exit loop should stop polling PICDevice.HasPendingInterrupt
+// and calling InjectInterrupt: with an in-kernel irqchip, KVM delivers
+// interrupts to the VCPU itself, without an exit into this package.
+//
+// The tradeoff: PICDevice and PITDevice's state (IMR, ISR, in-service
+// vector, current countdown) is directly inspectable Go state, useful
+// for tests and for a debugger frontend. An in-kernel irqchip's
+// equivalent state lives in the kernel and isn't exposed by this
+// package, so anything built on top of that introspection (device_state
+// snapshots for the userspace PIC/PIT, for instance) simply won't see
+// it while UseInKernelIrqchip is true. Pick the userspace devices when
+// that introspection matters more than the avoided vmexits.
+func (vm *VirtualMachine) SetInKernelIrqchip(chip *InKernelIrqChip) {
+	vm.irqChip = chip
+}
+
+// UseInKernelIrqchip reports whether SetInKernelIrqchip has been called.
+func (vm *VirtualMachine) UseInKernelIrqchip() bool {
+	return vm.irqChip != nil
+}
+
+// IrqRaiser returns the interrupt-line router devices that raise
+// interrupts should be constructed with: this VM's in-kernel chip if
+// UseInKernelIrqchip, otherwise pic. This is the one place
+// device-construction code needs to branch on the mode.
+func (vm *VirtualMachine) IrqRaiser(pic *PICDevice) IrqRaiser {
+	if vm.irqChip != nil {
+		return vm.irqChip
+	}
+	return pic
+}
+
+// RegisterUserspacePIC registers pic on the IOBus, unless this VM uses an
+// in-kernel irqchip (see SetInKernelIrqchip), in which case it's a no-op:
+// KVM delivers PIC interrupts itself, without a vmexit into this package.
+func (vm *VirtualMachine) RegisterUserspacePIC(pic *PICDevice) error {
+	if vm.irqChip != nil {
+		return nil
+	}
+	return vm.RegisterDevice(pic)
+}
+
+// RegisterUserspacePIT registers pit on the IOBus, unless this VM uses an
+// in-kernel irqchip (see SetInKernelIrqchip), in which case it's a no-op:
+// KVM_CREATE_PIT2 already gave the VM an in-kernel PIT.
+func (vm *VirtualMachine) RegisterUserspacePIT(pit *PITDevice) error {
+	if vm.irqChip != nil {
+		return nil
+	}
+	return vm.RegisterDevice(pit)
+}
+
+// RegisterDevice adds dev to the bus and to the set of devices reset on
+// VM reset.
+func (vm *VirtualMachine) RegisterDevice(dev PioDevice) error {
+	if err := vm.IOBus.Register(dev); err != nil {
+		return err
+	}
+	vm.devices = append(vm.devices, dev)
+	return nil
+}
+
+// flusher is implemented by devices that batch output and need a chance
+// to deliver it before shutdown, e.g. SerialPortDevice.
+type flusher interface {
+	Flush() error
+}
+
+// Close flushes every registered device that batches output, so bytes
+// buffered but not yet due for a timed or threshold flush aren't lost
+// when the VM shuts down, closes the host networking transport set by
+// SetNetworkInterface (if any, and if it implements io.Closer -- a nil
+// interface, i.e. no networking, is a no-op), then munmaps any host
+// memory this VM's MemoryLayout allocated (see MemoryLayout.Close;
+// memory installed through SetMemoryLayout that this VM doesn't own is
+// left untouched). It returns the first error encountered, after
+// attempting every step.
+func (vm *VirtualMachine) Close() error {
+	vm.mu.Lock()
+	devices := append([]PioDevice(nil), vm.devices...)
+	netIface := vm.netIface
+	vm.mu.Unlock()
+
+	var firstErr error
+	for _, dev := range devices {
+		f, ok := dev.(flusher)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if closer, ok := netIface.(io.Closer); ok {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if vm.memory != nil {
+		if err := vm.memory.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RequestReset asks the run loop to reset all devices and reload every
+// VCPU's boot state at the next opportunity. Safe to call from any
+// goroutine, including from within a PioDevice.HandleIO callback. It also
+// kicks the VCPU thread (see SetVCPUKicker), so a guest spinning in
+// KVM_RUN sees the request within microseconds rather than at its next
+// unrelated exit.
+func (vm *VirtualMachine) RequestReset() {
+	vm.mu.Lock()
+	vm.resetRequested = true
+	vm.mu.Unlock()
+	vm.doKick()
+}
+
+// RequestPoweroff asks the run loop to stop and return
+// ShutdownReasonPoweroff at the next opportunity. It also kicks the VCPU
+// thread, the same as RequestReset.
+func (vm *VirtualMachine) RequestPoweroff() {
+	vm.mu.Lock()
+	vm.poweroffRequested = true
+	vm.mu.Unlock()
+	vm.doKick()
+}
+
+// takeRequests atomically reads and clears the pending reset/poweroff
+// flags.
+func (vm *VirtualMachine) takeRequests() (reset, poweroff bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	reset, poweroff = vm.resetRequested, vm.poweroffRequested
+	vm.resetRequested = false
+	vm.poweroffRequested = false
+	return reset, poweroff
+}
+
+// doReset resets every registered device and reloads every VCPU's boot
+// state.
+func (vm *VirtualMachine) doReset() error {
+	for _, dev := range vm.devices {
+		dev.Reset()
+	}
+	if vm.reload == nil {
+		return nil
+	}
+	for _, vcpu := range vm.vcpus {
+		if err := vm.reload(vcpu); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset synchronously resets every registered device to its power-on
+// state and reloads every VCPU's boot state, pausing the VM for the
+// duration. Prefer RequestReset when called from inside a running guest
+// (e.g. a device's own HandleIO), since it defers the reset to a point
+// between VCPU steps instead of racing them; use Reset directly when the
+// caller isn't driving the Run loop at all, e.g. scripting a reboot from
+// a test or a management console.
+func (vm *VirtualMachine) Reset() error {
+	vm.pauseMu.Lock()
+	defer vm.pauseMu.Unlock()
+	return vm.doReset()
+}
+
+// Run drives the guest by calling step once per iteration; step is
+// expected to execute one KVM_RUN pass over all VCPUs and dispatch any
+// resulting port I/O through vm.IOBus. Run returns when step reports an
+// error, when a device has called RequestPoweroff, or when a
+// RequestReset's VCPU reload fails (ShutdownReasonReset); a reset that
+// reloads successfully is carried out in place and the loop keeps
+// running. It is a thin wrapper around
+// RunContext with context.Background(), for callers that have no
+// external deadline to tie the run loop to.
+func (vm *VirtualMachine) Run(step func() error) RunResult {
+	return vm.RunContext(context.Background(), step)
+}
+
+// RunContext behaves like Run, except it also stops the guest and
+// returns early, with Reason ShutdownReasonContext and Err set to
+// ctx.Err(), if ctx is cancelled or its deadline passes first. It stops
+// the guest the same way a device does, via RequestPoweroff, so the step
+// loop gets the same chance to unwind between VCPU steps rather than
+// being torn down out from under step.
+func (vm *VirtualMachine) RunContext(ctx context.Context, step func() error) RunResult {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vm.RequestPoweroff()
+		case <-stop:
+		}
+	}()
+
+	result := vm.runLoop(step)
+	if result.Reason == ShutdownReasonPoweroff {
+		if err := ctx.Err(); err != nil {
+			return RunResult{Reason: ShutdownReasonContext, Err: err}
+		}
+	}
+	return result
+}
+
+// runLoop is the guest run loop shared by Run and RunContext.
+func (vm *VirtualMachine) runLoop(step func() error) RunResult {
+	for {
+		vm.pauseMu.Lock()
+		err := step()
+		vm.pauseMu.Unlock()
+		if err != nil {
+			return RunResult{Reason: ShutdownReasonError, Err: err}
+		}
+
+		reset, poweroff := vm.takeRequests()
+		if poweroff {
+			return RunResult{Reason: ShutdownReasonPoweroff}
+		}
+		if reset {
+			if err := vm.doReset(); err != nil {
+				return RunResult{Reason: ShutdownReasonReset, Err: err}
+			}
+		}
+	}
+}
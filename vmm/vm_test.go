@@ -0,0 +1,322 @@
+package vmm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BigBossBoolingB/VDATABPro/hostmem"
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+func TestVirtualMachineResetReloadsDevicesAndVCPUs(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+	reloaded := 0
+	reload := func(v hypervisor.VCPU) error {
+		reloaded++
+		return v.SetSregs(hypervisor.KvmSregs{CR3: pml4Base})
+	}
+
+	vm := NewVirtualMachine([]hypervisor.VCPU{vcpu}, reload)
+
+	rtc := NewRTCDevice(newFakeClock())
+	rtcWrite(t, rtc, regB, regBSet|regBHour24|regBDM)
+	rtcWrite(t, rtc, regYear, 30)
+	rtcWrite(t, rtc, regB, regBHour24|regBDM)
+	if err := vm.RegisterDevice(rtc); err != nil {
+		t.Fatalf("RegisterDevice: %v", err)
+	}
+
+	steps := 0
+	result := vm.Run(func() error {
+		steps++
+		if steps == 1 {
+			vm.RequestReset()
+		}
+		if steps == 2 {
+			vm.RequestPoweroff()
+		}
+		return nil
+	})
+
+	if result.Reason != ShutdownReasonPoweroff {
+		t.Fatalf("Reason = %v, want poweroff", result.Reason)
+	}
+	if reloaded != 1 {
+		t.Errorf("reload called %d times, want 1", reloaded)
+	}
+	if got, want := rtcRead(t, rtc, regYear), uint8(26); got != want {
+		t.Errorf("RTC year after reset = %d, want %d (back to reflecting host time)", got, want)
+	}
+}
+
+func TestVirtualMachineSyncResetReloadsDevicesAndVCPUs(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{}
+	reloaded := 0
+	reload := func(v hypervisor.VCPU) error {
+		reloaded++
+		return v.SetSregs(hypervisor.KvmSregs{CR3: pml4Base})
+	}
+
+	vm := NewVirtualMachine([]hypervisor.VCPU{vcpu}, reload)
+
+	rtc := NewRTCDevice(newFakeClock())
+	rtcWrite(t, rtc, regB, regBSet|regBHour24|regBDM)
+	rtcWrite(t, rtc, regYear, 30)
+	rtcWrite(t, rtc, regB, regBHour24|regBDM)
+	if err := vm.RegisterDevice(rtc); err != nil {
+		t.Fatalf("RegisterDevice: %v", err)
+	}
+
+	if err := vcpu.SetSregs(hypervisor.KvmSregs{CR3: 0xdeadbeef}); err != nil {
+		t.Fatalf("SetSregs: %v", err)
+	}
+
+	if err := vm.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if reloaded != 1 {
+		t.Errorf("reload called %d times, want 1", reloaded)
+	}
+	gotSregs, err := vcpu.GetSregs()
+	if err != nil {
+		t.Fatalf("GetSregs: %v", err)
+	}
+	if gotSregs.CR3 != pml4Base {
+		t.Errorf("CR3 after Reset = %#x, want %#x", gotSregs.CR3, pml4Base)
+	}
+	if got, want := rtcRead(t, rtc, regYear), uint8(26); got != want {
+		t.Errorf("RTC year after Reset = %d, want %d (back to reflecting host time)", got, want)
+	}
+}
+
+func TestVirtualMachineRunPropagatesStepError(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	wantErr := errors.New("kvm exit failure")
+
+	result := vm.Run(func() error { return wantErr })
+
+	if result.Reason != ShutdownReasonError || result.Err != wantErr {
+		t.Errorf("result = %+v, want error %v", result, wantErr)
+	}
+}
+
+// TestVirtualMachineRunContextCancelledReturnsPromptly checks that
+// RunContext stops the run loop and reports ctx.Err() once its context is
+// cancelled, instead of running until step itself returns an error.
+func TestVirtualMachineRunContextCancelledReturnsPromptly(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	result := vm.RunContext(ctx, func() error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("RunContext took %s to return after cancellation", elapsed)
+	}
+	if result.Reason != ShutdownReasonContext {
+		t.Errorf("Reason = %v, want %v", result.Reason, ShutdownReasonContext)
+	}
+	if result.Err != context.Canceled {
+		t.Errorf("Err = %v, want %v", result.Err, context.Canceled)
+	}
+}
+
+// TestVCPUKickerFiresOnResetPoweroffAndInterrupt checks that RequestReset,
+// RequestPoweroff, and InjectInterrupt all kick the VCPU thread via the
+// function set with SetVCPUKicker, so a guest stuck in a real KVM_RUN
+// call is interrupted promptly rather than only noticed at its next
+// unrelated exit.
+func TestVCPUKickerFiresOnResetPoweroffAndInterrupt(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+
+	kicks := 0
+	vm.SetVCPUKicker(func() error {
+		kicks++
+		return nil
+	})
+
+	vm.RequestReset()
+	if kicks != 1 {
+		t.Errorf("kicks after RequestReset = %d, want 1", kicks)
+	}
+
+	vm.RequestPoweroff()
+	if kicks != 2 {
+		t.Errorf("kicks after RequestPoweroff = %d, want 2", kicks)
+	}
+
+	injected := 0
+	vm.SetInterruptInjector(func(vector uint8) error {
+		injected = int(vector)
+		return nil
+	})
+	if err := vm.InjectInterrupt(7); err != nil {
+		t.Fatalf("InjectInterrupt: %v", err)
+	}
+	if injected != 7 {
+		t.Errorf("injected vector = %d, want 7", injected)
+	}
+	if kicks != 3 {
+		t.Errorf("kicks after InjectInterrupt = %d, want 3", kicks)
+	}
+}
+
+// TestVCPUKickerUnsetIsHarmless checks that RequestReset, RequestPoweroff,
+// and InjectInterrupt all still work when no kicker has been set.
+func TestVCPUKickerUnsetIsHarmless(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	vm.RequestReset()
+	vm.RequestPoweroff()
+	if err := vm.InjectInterrupt(1); err != nil {
+		t.Fatalf("InjectInterrupt with no injector or kicker set: %v", err)
+	}
+}
+
+func TestResetControlDeviceTriggersReset(t *testing.T) {
+	var resetCount int
+	dev := NewResetControlDevice(func() { resetCount++ })
+
+	if err := dev.HandleIO(resetControlPort, []byte{0x06}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if err := dev.HandleIO(resetControlPort, []byte{0x0e}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if resetCount != 2 {
+		t.Errorf("resetCount = %d, want 2", resetCount)
+	}
+}
+
+func TestKeyboardControllerCommand0xFETriggersReset(t *testing.T) {
+	var resetCount int
+	kbc := NewKeyboardControllerDevice(func() { resetCount++ }, NewA20Gate())
+
+	if err := kbc.HandleIO(kbcPortStatus, []byte{0xfe}, true); err != nil {
+		t.Fatalf("HandleIO: %v", err)
+	}
+	if resetCount != 1 {
+		t.Errorf("resetCount = %d, want 1", resetCount)
+	}
+}
+
+// TestVCPUStatsRecordsRunLoopExits drives a boot-loader-shaped run loop —
+// alternating IO exits (the guest polling a device port) with a final
+// HLT — through NoteVCPURunStart/NoteVCPUExit exactly as a real KVM_RUN
+// exit loop would, and checks the resulting per-VCPU histogram and run
+// count VCPUStats reports.
+func TestVCPUStatsRecordsRunLoopExits(t *testing.T) {
+	vm := NewVirtualMachine([]hypervisor.VCPU{&hypervisor.FakeVCPU{}}, nil)
+
+	const vcpuIndex = 0
+	exits := []uint32{hypervisor.KVM_EXIT_IO, hypervisor.KVM_EXIT_IO, hypervisor.KVM_EXIT_HLT}
+	i := 0
+	result := vm.Run(func() error {
+		vm.NoteVCPURunStart(vcpuIndex)
+		reason := exits[i]
+		i++
+		vm.NoteVCPUExit(vcpuIndex, reason, false, nil)
+		if reason == hypervisor.KVM_EXIT_HLT {
+			vm.RequestPoweroff()
+		}
+		return nil
+	})
+
+	if result.Reason != ShutdownReasonPoweroff {
+		t.Fatalf("Reason = %v, want poweroff", result.Reason)
+	}
+
+	snap := vm.VCPUStats(vcpuIndex)
+	if snap.RunCount != 3 {
+		t.Errorf("RunCount = %d, want 3", snap.RunCount)
+	}
+	if got, want := snap.ExitReasons[hypervisor.KVM_EXIT_IO], int64(2); got != want {
+		t.Errorf("ExitReasons[IO] = %d, want %d", got, want)
+	}
+	if got, want := snap.ExitReasons[hypervisor.KVM_EXIT_HLT], int64(1); got != want {
+		t.Errorf("ExitReasons[HLT] = %d, want %d", got, want)
+	}
+
+	// A VCPU that has never run reports the zero value, not a shared or
+	// stale snapshot.
+	if other := vm.VCPUStats(1); other.RunCount != 0 || len(other.ExitReasons) != 0 {
+		t.Errorf("VCPUStats(1) = %+v, want zero value", other)
+	}
+}
+
+func TestVirtualMachineReclaimRangeRecordsStats(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	mem := NewMemoryLayout()
+	if _, err := mem.AddHostMemSlot(0, 2*dirtyPageSize, hostmem.Options{}); err != nil {
+		t.Fatalf("AddHostMemSlot: %v", err)
+	}
+	vm.SetMemoryLayout(mem)
+	defer mem.Close()
+
+	if err := vm.ReclaimRange(dirtyPageSize, dirtyPageSize); err != nil {
+		t.Fatalf("ReclaimRange: %v", err)
+	}
+
+	snap := vm.Stats()
+	if snap.ReclaimedRanges != 1 {
+		t.Errorf("ReclaimedRanges = %d, want 1", snap.ReclaimedRanges)
+	}
+	if snap.ReclaimedBytes != dirtyPageSize {
+		t.Errorf("ReclaimedBytes = %d, want %d", snap.ReclaimedBytes, dirtyPageSize)
+	}
+}
+
+// TestVirtualMachineGuestSliceWriteVisibleThroughReadGuestMemory writes
+// through the slice GuestSlice returns, the way a DMA-capable device
+// would, and confirms the write is visible to a subsequent
+// ReadGuestMemory call, the way it would be to the guest itself.
+func TestVirtualMachineGuestSliceWriteVisibleThroughReadGuestMemory(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	mem := NewMemoryLayout()
+	if _, err := mem.AddHostMemSlot(0, dirtyPageSize, hostmem.Options{}); err != nil {
+		t.Fatalf("AddHostMemSlot: %v", err)
+	}
+	vm.SetMemoryLayout(mem)
+	defer mem.Close()
+
+	s, err := vm.GuestSlice(0x100, 4)
+	if err != nil {
+		t.Fatalf("GuestSlice: %v", err)
+	}
+	copy(s, []byte{0xde, 0xad, 0xbe, 0xef})
+
+	got := make([]byte, 4)
+	if err := vm.ReadGuestMemory(got, 0x100); err != nil {
+		t.Fatalf("ReadGuestMemory: %v", err)
+	}
+	if want := []byte{0xde, 0xad, 0xbe, 0xef}; !bytes.Equal(got, want) {
+		t.Errorf("ReadGuestMemory = %v, want %v", got, want)
+	}
+}
+
+func TestVirtualMachineGuestSliceWithoutMemoryLayoutErrors(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	if _, err := vm.GuestSlice(0, 4); err == nil {
+		t.Error("GuestSlice with no memory layout: got nil error, want one")
+	}
+	if err := vm.ReadGuestMemory(make([]byte, 4), 0); err == nil {
+		t.Error("ReadGuestMemory with no memory layout: got nil error, want one")
+	}
+}
+
+func TestVirtualMachineReclaimRangeWithoutMemoryLayoutErrors(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	if err := vm.ReclaimRange(0, dirtyPageSize); err == nil {
+		t.Error("ReclaimRange with no memory layout: got nil error, want one")
+	}
+	if err := vm.PopulateRange(0, dirtyPageSize); err == nil {
+		t.Error("PopulateRange with no memory layout: got nil error, want one")
+	}
+}
@@ -0,0 +1,330 @@
+package vmm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+// WatchdogDiagnosis names which pathological condition a Watchdog
+// detected.
+type WatchdogDiagnosis int
+
+const (
+	// WatchdogDiagnosisUnhandledExitLoop means a VCPU has reported the
+	// same unhandled kvm_run exit reason too many times in a row, the
+	// signature of a guest (or a device handler) stuck spinning at 100%
+	// CPU with no forward progress.
+	WatchdogDiagnosisUnhandledExitLoop WatchdogDiagnosis = iota
+	// WatchdogDiagnosisHang means a VCPU has not exited KVM_RUN for
+	// longer than MaxRunDuration: a possible real hang, e.g. the guest
+	// spinning in code with no vmexit-triggering instruction at all.
+	WatchdogDiagnosisHang
+)
+
+func (d WatchdogDiagnosis) String() string {
+	switch d {
+	case WatchdogDiagnosisHang:
+		return "hang"
+	default:
+		return "unhandled-exit-loop"
+	}
+}
+
+// WatchdogAction is a WatchdogPolicy's decision for a tripped condition.
+type WatchdogAction int
+
+const (
+	// WatchdogActionIgnore takes no action: the VCPU keeps running
+	// exactly as it would without a watchdog.
+	WatchdogActionIgnore WatchdogAction = iota
+	// WatchdogActionPause blocks the calling goroutine — expected to be
+	// the same goroutine driving this VCPU's KVM_RUN loop — until
+	// Watchdog.Resume is called, giving an operator a chance to inspect
+	// the guest before it burns any more CPU.
+	WatchdogActionPause
+	// WatchdogActionKill tells the caller to stop running this VCPU;
+	// NoteExit only ever returns this from a policy's explicit choice,
+	// so the caller's step function can return an error and end
+	// VirtualMachine.Run with ShutdownReasonError.
+	WatchdogActionKill
+)
+
+// WatchdogEvent is the diagnostic snapshot passed to a WatchdogPolicy (and
+// logged regardless of policy) when a threshold trips.
+type WatchdogEvent struct {
+	VCPUIndex int
+	Diagnosis WatchdogDiagnosis
+
+	// ExitReason and Consecutive are meaningful for
+	// WatchdogDiagnosisUnhandledExitLoop.
+	ExitReason  uint32
+	Consecutive int64
+
+	// Histogram is this VCPU's unhandled-exit-reason counts accumulated
+	// since its last reset, captured at the moment the threshold
+	// tripped.
+	Histogram map[uint32]int64
+
+	// SinceLastExit is meaningful for WatchdogDiagnosisHang: how long
+	// the VCPU has been inside KVM_RUN.
+	SinceLastExit time.Duration
+
+	// Regs is a best-effort register dump, taken only once a threshold
+	// trips; nil if no VCPU was available to read it from.
+	Regs *hypervisor.KvmRegs
+}
+
+// WatchdogPolicy decides what to do about a tripped WatchdogEvent.
+// Leaving Watchdog.Policy unset applies the default: WatchdogActionPause.
+type WatchdogPolicy func(WatchdogEvent) WatchdogAction
+
+// errNoVCPUForRegsDump is returned by the getRegs closure NoteVCPUExit
+// builds when it has no VCPU to read registers from.
+var errNoVCPUForRegsDump = errors.New("vmm: watchdog: no vcpu available for a register dump")
+
+// vcpuWatchState is one VCPU's watchdog bookkeeping. NoteExit and
+// NoteRunStart are expected to be called only from the single goroutine
+// driving that VCPU's KVM_RUN loop, so the fields below are protected by
+// mu purely against CheckHang, which is meant to be polled from a
+// separate goroutine.
+type vcpuWatchState struct {
+	mu sync.Mutex
+
+	haveLastReason bool
+	lastReason     uint32
+	consecutive    int64
+	histogram      map[uint32]int64
+
+	haveRunStarted bool
+	runStarted     time.Time
+}
+
+// Watchdog detects two pathological VCPU conditions cheaply enough to
+// check on every vmexit: a VCPU stuck returning the same unhandled exit
+// reason over and over, and a VCPU that hasn't exited KVM_RUN for an
+// unexpectedly long time. Per-exit bookkeeping (NoteExit) is a handful of
+// integer comparisons; histogram snapshots and register dumps only
+// happen once a threshold actually trips.
+//
+// The zero value has both thresholds disabled; set
+// MaxIdenticalUnhandledExits and/or MaxRunDuration (and optionally
+// Policy/Clock/Logger) before wiring a Watchdog to a VirtualMachine with
+// SetWatchdog.
+type Watchdog struct {
+	// MaxIdenticalUnhandledExits is how many consecutive unhandled exits
+	// with the same reason a VCPU may report before NoteExit trips
+	// WatchdogDiagnosisUnhandledExitLoop. Zero disables this check.
+	MaxIdenticalUnhandledExits int64
+
+	// MaxRunDuration is how long a VCPU may stay inside KVM_RUN before
+	// CheckHang reports WatchdogDiagnosisHang. Zero disables this check.
+	MaxRunDuration time.Duration
+
+	// Policy is consulted whenever MaxIdenticalUnhandledExits trips. Nil
+	// applies the default policy: always WatchdogActionPause.
+	// WatchdogDiagnosisHang is reported (see CheckHang) but never
+	// consults Policy, since a hang should only ever be observed and
+	// reported, not acted on.
+	Policy WatchdogPolicy
+
+	// Clock times MaxRunDuration. Nil defaults to RealClock.
+	Clock Clock
+
+	// Logger receives a one-line diagnostic whenever a threshold trips.
+	// Nil discards it.
+	Logger Logger
+
+	mu     sync.Mutex
+	vcpus  map[int]*vcpuWatchState
+	resume chan struct{} // non-nil while at least one VCPU is paused; closed by Resume
+}
+
+// NewWatchdog returns a Watchdog with both thresholds disabled; set
+// fields on the result before calling VirtualMachine.SetWatchdog.
+func NewWatchdog() *Watchdog {
+	return &Watchdog{}
+}
+
+func (w *Watchdog) stateFor(vcpuIndex int) *vcpuWatchState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.vcpus == nil {
+		w.vcpus = make(map[int]*vcpuWatchState)
+	}
+	st, ok := w.vcpus[vcpuIndex]
+	if !ok {
+		st = &vcpuWatchState{}
+		w.vcpus[vcpuIndex] = st
+	}
+	return st
+}
+
+func (w *Watchdog) now() time.Time {
+	if w.Clock != nil {
+		return w.Clock.Now()
+	}
+	return RealClock{}.Now()
+}
+
+func (w *Watchdog) logger() Logger {
+	if w.Logger != nil {
+		return w.Logger
+	}
+	return noopLogger{}
+}
+
+// NoteExit records one unhandled-or-not vmexit for vcpuIndex and returns
+// the action the caller's step function should take. A handled exit
+// (unhandled == false) always resets the consecutive-identical streak,
+// since it means the run loop made forward progress even if the same
+// exit reason keeps recurring (e.g. a guest legitimately polling a
+// device port in a tight loop).
+//
+// getRegs, if non-nil, is called to attach a register dump to a tripped
+// WatchdogEvent; it's invoked only when the threshold actually trips.
+func (w *Watchdog) NoteExit(vcpuIndex int, reason uint32, unhandled bool, getRegs func() (hypervisor.KvmRegs, error)) WatchdogAction {
+	st := w.stateFor(vcpuIndex)
+
+	st.mu.Lock()
+	st.haveRunStarted = false // no longer inside KVM_RUN
+
+	if !unhandled {
+		st.haveLastReason = false
+		st.consecutive = 0
+		st.mu.Unlock()
+		return WatchdogActionIgnore
+	}
+
+	if st.histogram == nil {
+		st.histogram = make(map[uint32]int64)
+	}
+	st.histogram[reason]++
+
+	if st.haveLastReason && reason == st.lastReason {
+		st.consecutive++
+	} else {
+		st.lastReason = reason
+		st.haveLastReason = true
+		st.consecutive = 1
+	}
+	consecutive := st.consecutive
+
+	var histogram map[uint32]int64
+	threshold := w.MaxIdenticalUnhandledExits
+	if threshold > 0 && consecutive >= threshold {
+		histogram = make(map[uint32]int64, len(st.histogram))
+		for k, v := range st.histogram {
+			histogram[k] = v
+		}
+		// Reset so the very next identical exit doesn't immediately
+		// re-trip, e.g. after a policy decides WatchdogActionIgnore.
+		st.consecutive = 0
+		st.haveLastReason = false
+		st.histogram = make(map[uint32]int64)
+	}
+	st.mu.Unlock()
+
+	if histogram == nil {
+		return WatchdogActionIgnore
+	}
+
+	event := WatchdogEvent{
+		VCPUIndex:   vcpuIndex,
+		Diagnosis:   WatchdogDiagnosisUnhandledExitLoop,
+		ExitReason:  reason,
+		Consecutive: consecutive,
+		Histogram:   histogram,
+	}
+	if getRegs != nil {
+		if regs, err := getRegs(); err == nil {
+			event.Regs = &regs
+		}
+	}
+	return w.trip(event)
+}
+
+// NoteRunStart marks vcpuIndex as having just entered KVM_RUN, for
+// CheckHang to time against. Call it immediately before issuing KVM_RUN;
+// NoteExit clears it again on that VCPU's next reported exit.
+func (w *Watchdog) NoteRunStart(vcpuIndex int) {
+	st := w.stateFor(vcpuIndex)
+	st.mu.Lock()
+	st.runStarted = w.now()
+	st.haveRunStarted = true
+	st.mu.Unlock()
+}
+
+// CheckHang reports whether vcpuIndex has been inside KVM_RUN (per the
+// most recent NoteRunStart, with no NoteExit since) for longer than
+// MaxRunDuration. It's meant to be polled from a goroutine other than the
+// one driving that VCPU, since a genuinely hung VCPU's own thread is, by
+// definition, not calling back into this package. Unlike NoteExit, a
+// tripped hang is only logged and returned — never passed to Policy —
+// since a hang should be reported for an operator to investigate, not
+// acted on automatically.
+func (w *Watchdog) CheckHang(vcpuIndex int) (WatchdogEvent, bool) {
+	if w.MaxRunDuration <= 0 {
+		return WatchdogEvent{}, false
+	}
+	st := w.stateFor(vcpuIndex)
+	st.mu.Lock()
+	started, have := st.runStarted, st.haveRunStarted
+	st.mu.Unlock()
+	if !have {
+		return WatchdogEvent{}, false
+	}
+
+	elapsed := w.now().Sub(started)
+	if elapsed < w.MaxRunDuration {
+		return WatchdogEvent{}, false
+	}
+
+	event := WatchdogEvent{
+		VCPUIndex:     vcpuIndex,
+		Diagnosis:     WatchdogDiagnosisHang,
+		SinceLastExit: elapsed,
+	}
+	w.logger().Warnf("vmm: watchdog: vcpu %d: %s (running for %s)", vcpuIndex, event.Diagnosis, elapsed)
+	return event, true
+}
+
+// trip logs event and applies Policy (or the default, always-Pause
+// policy if unset), blocking the caller if the result is
+// WatchdogActionPause.
+func (w *Watchdog) trip(event WatchdogEvent) WatchdogAction {
+	w.logger().Warnf("vmm: watchdog: vcpu %d: %s (reason=%#x consecutive=%d)", event.VCPUIndex, event.Diagnosis, event.ExitReason, event.Consecutive)
+
+	policy := w.Policy
+	if policy == nil {
+		policy = func(WatchdogEvent) WatchdogAction { return WatchdogActionPause }
+	}
+	action := policy(event)
+	if action == WatchdogActionPause {
+		w.waitForResume()
+	}
+	return action
+}
+
+func (w *Watchdog) waitForResume() {
+	w.mu.Lock()
+	if w.resume == nil {
+		w.resume = make(chan struct{})
+	}
+	ch := w.resume
+	w.mu.Unlock()
+	<-ch
+}
+
+// Resume unblocks every VCPU currently paused by a WatchdogActionPause
+// decision. It's a no-op if none are paused.
+func (w *Watchdog) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.resume != nil {
+		close(w.resume)
+		w.resume = nil
+	}
+}
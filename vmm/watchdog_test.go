@@ -0,0 +1,279 @@
+package vmm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BigBossBoolingB/VDATABPro/hypervisor"
+)
+
+const unhandledExitReason = 0xdead
+
+func TestWatchdogTripsOnIdenticalUnhandledExitLoop(t *testing.T) {
+	var events []WatchdogEvent
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 5
+	w.Policy = func(e WatchdogEvent) WatchdogAction {
+		events = append(events, e)
+		return WatchdogActionKill
+	}
+
+	var lastAction WatchdogAction
+	for i := 0; i < 5; i++ {
+		lastAction = w.NoteExit(0, unhandledExitReason, true, nil)
+	}
+
+	if lastAction != WatchdogActionKill {
+		t.Fatalf("action on the 5th identical unhandled exit = %v, want WatchdogActionKill", lastAction)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Policy invoked %d times, want 1", len(events))
+	}
+
+	event := events[0]
+	if event.Diagnosis != WatchdogDiagnosisUnhandledExitLoop {
+		t.Errorf("Diagnosis = %v, want WatchdogDiagnosisUnhandledExitLoop", event.Diagnosis)
+	}
+	if event.ExitReason != unhandledExitReason {
+		t.Errorf("ExitReason = %#x, want %#x", event.ExitReason, unhandledExitReason)
+	}
+	if event.Consecutive != 5 {
+		t.Errorf("Consecutive = %d, want 5", event.Consecutive)
+	}
+	if got, want := event.Histogram[unhandledExitReason], int64(5); got != want {
+		t.Errorf("Histogram[%#x] = %d, want %d", unhandledExitReason, got, want)
+	}
+}
+
+func TestWatchdogIgnoresBelowThreshold(t *testing.T) {
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 5
+	w.Policy = func(WatchdogEvent) WatchdogAction {
+		t.Fatal("Policy invoked before the threshold was reached")
+		return WatchdogActionIgnore
+	}
+
+	for i := 0; i < 4; i++ {
+		if action := w.NoteExit(0, unhandledExitReason, true, nil); action != WatchdogActionIgnore {
+			t.Fatalf("exit %d: action = %v, want WatchdogActionIgnore", i, action)
+		}
+	}
+}
+
+func TestWatchdogHandledExitResetsStreak(t *testing.T) {
+	tripped := false
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 3
+	w.Policy = func(WatchdogEvent) WatchdogAction {
+		tripped = true
+		return WatchdogActionIgnore
+	}
+
+	w.NoteExit(0, unhandledExitReason, true, nil)
+	w.NoteExit(0, unhandledExitReason, true, nil)
+	w.NoteExit(0, unhandledExitReason, false, nil) // a handled exit interrupts the streak
+	w.NoteExit(0, unhandledExitReason, true, nil)
+	w.NoteExit(0, unhandledExitReason, true, nil)
+
+	if tripped {
+		t.Error("watchdog tripped despite the streak being interrupted by a handled exit")
+	}
+}
+
+func TestWatchdogDifferentExitReasonsDoNotAccumulate(t *testing.T) {
+	tripped := false
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 3
+	w.Policy = func(WatchdogEvent) WatchdogAction {
+		tripped = true
+		return WatchdogActionIgnore
+	}
+
+	for i := 0; i < 10; i++ {
+		reason := uint32(i % 2) // alternates, never 3 identical in a row
+		w.NoteExit(0, reason, true, nil)
+	}
+
+	if tripped {
+		t.Error("watchdog tripped on an alternating exit reason sequence")
+	}
+}
+
+func TestWatchdogTracksVCPUsIndependently(t *testing.T) {
+	trippedVCPU := -1
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 3
+	w.Policy = func(e WatchdogEvent) WatchdogAction {
+		trippedVCPU = e.VCPUIndex
+		return WatchdogActionIgnore
+	}
+
+	// VCPU 1 accumulates a streak of its own without affecting VCPU 0.
+	w.NoteExit(0, unhandledExitReason, true, nil)
+	w.NoteExit(1, unhandledExitReason, true, nil)
+	w.NoteExit(0, unhandledExitReason, true, nil)
+	w.NoteExit(1, unhandledExitReason, true, nil)
+	w.NoteExit(1, unhandledExitReason, true, nil)
+
+	if trippedVCPU != 1 {
+		t.Fatalf("tripped vcpu = %d, want 1", trippedVCPU)
+	}
+}
+
+func TestWatchdogResetAfterTripAllowsFreshAccumulation(t *testing.T) {
+	trips := 0
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 2
+	w.Policy = func(WatchdogEvent) WatchdogAction {
+		trips++
+		return WatchdogActionIgnore
+	}
+
+	for i := 0; i < 4; i++ {
+		w.NoteExit(0, unhandledExitReason, true, nil)
+	}
+
+	if trips != 2 {
+		t.Errorf("trips = %d, want 2 (the streak resets after each trip)", trips)
+	}
+}
+
+func TestWatchdogDefaultPolicyPausesUntilResume(t *testing.T) {
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 1
+
+	done := make(chan WatchdogAction, 1)
+	go func() {
+		done <- w.NoteExit(0, unhandledExitReason, true, nil)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("NoteExit returned before Resume was called")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w.Resume()
+
+	select {
+	case action := <-done:
+		if action != WatchdogActionPause {
+			t.Errorf("action = %v, want WatchdogActionPause", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("NoteExit did not return after Resume")
+	}
+}
+
+func TestWatchdogNoteExitAttachesRegsOnlyWhenTripped(t *testing.T) {
+	calls := 0
+	getRegs := func() (hypervisor.KvmRegs, error) {
+		calls++
+		return hypervisor.KvmRegs{RIP: 0x1234}, nil
+	}
+
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 3
+	var captured WatchdogEvent
+	w.Policy = func(e WatchdogEvent) WatchdogAction {
+		captured = e
+		return WatchdogActionIgnore
+	}
+
+	w.NoteExit(0, unhandledExitReason, true, getRegs)
+	w.NoteExit(0, unhandledExitReason, true, getRegs)
+	if calls != 0 {
+		t.Fatalf("getRegs called %d times before the threshold tripped, want 0", calls)
+	}
+	w.NoteExit(0, unhandledExitReason, true, getRegs)
+
+	if calls != 1 {
+		t.Errorf("getRegs called %d times, want 1", calls)
+	}
+	if captured.Regs == nil || captured.Regs.RIP != 0x1234 {
+		t.Errorf("captured.Regs = %+v, want RIP 0x1234", captured.Regs)
+	}
+}
+
+func TestWatchdogCheckHangReportsLongRunningVCPU(t *testing.T) {
+	clock := newFakeClock()
+	w := NewWatchdog()
+	w.MaxRunDuration = 5 * time.Second
+	w.Clock = clock
+
+	w.NoteRunStart(0)
+
+	if _, hung := w.CheckHang(0); hung {
+		t.Fatal("CheckHang reported a hang immediately after NoteRunStart")
+	}
+
+	clock.Advance(6 * time.Second)
+
+	event, hung := w.CheckHang(0)
+	if !hung {
+		t.Fatal("CheckHang did not report a hang after exceeding MaxRunDuration")
+	}
+	if event.Diagnosis != WatchdogDiagnosisHang {
+		t.Errorf("Diagnosis = %v, want WatchdogDiagnosisHang", event.Diagnosis)
+	}
+	if event.SinceLastExit < 6*time.Second {
+		t.Errorf("SinceLastExit = %v, want at least 6s", event.SinceLastExit)
+	}
+
+	// A subsequent exit clears the in-run marker, so the hang stops
+	// being reported until the next NoteRunStart.
+	w.NoteExit(0, 0, false, nil)
+	if _, hung := w.CheckHang(0); hung {
+		t.Error("CheckHang still reported a hang after NoteExit")
+	}
+}
+
+func TestVirtualMachineNoteVCPUExitAppliesWatchdogAndRecordsStats(t *testing.T) {
+	vcpu := &hypervisor.FakeVCPU{Regs: hypervisor.KvmRegs{RIP: 0xabc}}
+	vm := NewVirtualMachine([]hypervisor.VCPU{vcpu}, nil)
+
+	w := NewWatchdog()
+	w.MaxIdenticalUnhandledExits = 2
+	var captured WatchdogEvent
+	w.Policy = func(e WatchdogEvent) WatchdogAction {
+		captured = e
+		return WatchdogActionKill
+	}
+	vm.SetWatchdog(w)
+
+	vm.NoteVCPUExit(0, unhandledExitReason, true, vcpu)
+	action := vm.NoteVCPUExit(0, unhandledExitReason, true, vcpu)
+
+	if action != WatchdogActionKill {
+		t.Fatalf("action = %v, want WatchdogActionKill", action)
+	}
+	if captured.Regs == nil || captured.Regs.RIP != 0xabc {
+		t.Errorf("captured.Regs = %+v, want RIP 0xabc", captured.Regs)
+	}
+	if got := vm.Stats().ExitReasons[unhandledExitReason]; got != 2 {
+		t.Errorf("Stats ExitReasons[%#x] = %d, want 2", unhandledExitReason, got)
+	}
+}
+
+func TestVirtualMachineNoteVCPUExitWithoutWatchdogIsHarmless(t *testing.T) {
+	vm := NewVirtualMachine(nil, nil)
+	if action := vm.NoteVCPUExit(0, unhandledExitReason, true, nil); action != WatchdogActionIgnore {
+		t.Errorf("action = %v, want WatchdogActionIgnore", action)
+	}
+}
+
+func TestVirtualMachineCheckVCPUHangDelegatesToWatchdog(t *testing.T) {
+	clock := newFakeClock()
+	vm := NewVirtualMachine(nil, nil)
+	w := NewWatchdog()
+	w.MaxRunDuration = time.Second
+	w.Clock = clock
+	vm.SetWatchdog(w)
+
+	vm.NoteVCPURunStart(0)
+	clock.Advance(2 * time.Second)
+
+	if _, hung := vm.CheckVCPUHang(0); !hung {
+		t.Error("CheckVCPUHang did not report a hang delegated from the watchdog")
+	}
+}